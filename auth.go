@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512" // registers SHA-384 and SHA-512 used by HS384, HS512, RS384 and RS512
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrCredentialsInvalid is returned when API key or bearer token is unknown, malformed, expired or forged.
+	ErrCredentialsInvalid = errors.New("Auth: invalid credentials")
+)
+
+type userIDCtxKey struct{}
+
+// ContextWithUserID binds ID of the authenticated user to the context.
+func ContextWithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userIDCtxKey{}, userID)
+}
+
+// UserIDFromContext returns ID of the authenticated user bound to the context.
+// False is returned for unauthenticated requests.
+func UserIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(userIDCtxKey{}).(string)
+	return id, ok && id != ""
+}
+
+type authEnforcedCtxKey struct{}
+
+// ContextWithAuthEnforced marks the context of request which passed through AuthMiddleware.
+// Changes made later on behalf of unauthenticated caller, e.g. over WebSocket connection, must be rejected then.
+func ContextWithAuthEnforced(ctx context.Context) context.Context {
+	return context.WithValue(ctx, authEnforcedCtxKey{}, true)
+}
+
+// AuthEnforced reports whether request with the context passed through AuthMiddleware.
+func AuthEnforced(ctx context.Context) bool {
+	v, _ := ctx.Value(authEnforcedCtxKey{}).(bool)
+	return v
+}
+
+// -- section: API keys
+
+// APIKeys maps API keys onto IDs of users they authenticate.
+// Keys are kept hashed, so lookup time does not depend on how much of the key was guessed.
+type APIKeys struct {
+	users map[[sha256.Size]byte]string
+}
+
+// ParseAPIKeys creates key set from entries in format: userID:key.
+func ParseAPIKeys(entries []string) (*APIKeys, error) {
+	ks := &APIKeys{users: make(map[[sha256.Size]byte]string)}
+	for _, e := range entries {
+		i := strings.Index(e, ":")
+		if i < 1 || i == len(e)-1 {
+			return nil, fmt.Errorf("auth: invalid API key entry, expected userID:key")
+		}
+		ks.Add(e[i+1:], e[:i])
+	}
+	return ks, nil
+}
+
+// Add makes key authenticate user with given ID.
+func (ks *APIKeys) Add(key, userID string) {
+	ks.users[sha256.Sum256([]byte(key))] = userID
+}
+
+// Authenticate returns ID of the user owning the key. ErrCredentialsInvalid is returned for unknown key.
+func (ks *APIKeys) Authenticate(key string) (string, error) {
+	id, found := ks.users[sha256.Sum256([]byte(key))]
+	if !found {
+		return "", ErrCredentialsInvalid
+	}
+	return id, nil
+}
+
+// -- section: JWT
+
+// jwtLeeway is a tolerance for clock skew applied to exp and nbf claims.
+const jwtLeeway = 30 * time.Second
+
+// jwtAlgs maps supported signature algorithms onto their hash functions.
+var jwtAlgs = map[string]crypto.Hash{
+	"HS256": crypto.SHA256,
+	"HS384": crypto.SHA384,
+	"HS512": crypto.SHA512,
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+}
+
+// JWTClaims are registered claims of the token checked by JWTVerifier.
+type JWTClaims struct {
+	// Subject is an ID of the user the token was issued for.
+	Subject   string      `json:"sub"`
+	Issuer    string      `json:"iss,omitempty"`
+	Audience  jwtAudience `json:"aud,omitempty"`
+	ExpiresAt int64       `json:"exp"`
+	NotBefore int64       `json:"nbf,omitempty"`
+	IssuedAt  int64       `json:"iat,omitempty"`
+}
+
+// jwtAudience decodes aud claim, which is either a single string or an array of them.
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = jwtAudience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a jwtAudience) contains(v string) bool {
+	for _, aud := range a {
+		if aud == v {
+			return true
+		}
+	}
+	return false
+}
+
+// JWTVerifier checks signed bearer tokens (JWS compact serialization).
+// HS* tokens are verified with HMAC secret and RS* with RSA public key. Algorithm without configured key is rejected,
+// so token signed with HMAC using public RSA key as a secret is never accepted. Unsigned tokens ("none") are rejected.
+type JWTVerifier struct {
+	// Issuer, if set, must match iss claim.
+	Issuer string
+
+	// Audience, if set, must be listed in aud claim.
+	Audience string
+
+	// TimeNow is testing helper for time sensitive tests. It defaults to time.Now function.
+	TimeNow func() time.Time
+
+	hmacKey []byte
+	rsaKey  *rsa.PublicKey
+}
+
+func NewJWTVerifier() *JWTVerifier {
+	return &JWTVerifier{TimeNow: time.Now}
+}
+
+// SetHMACKey enables HS256, HS384 and HS512 tokens signed with secret.
+func (v *JWTVerifier) SetHMACKey(secret []byte) {
+	v.hmacKey = secret
+}
+
+// SetRSAKey enables RS256, RS384 and RS512 tokens, verified with public key in PEM format.
+// PKIX ("PUBLIC KEY"), PKCS #1 ("RSA PUBLIC KEY") and certificate blocks are accepted.
+func (v *JWTVerifier) SetRSAKey(pemBytes []byte) error {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return errors.New("auth: no PEM block found in RSA key")
+	}
+
+	var pub interface{}
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			pub = cert.PublicKey
+		}
+	default:
+		return fmt.Errorf("auth: unsupported PEM block: %s", block.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	rk, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("auth: not an RSA public key")
+	}
+	v.rsaKey = rk
+	return nil
+}
+
+// Verify checks signature and claims of the token and returns its claims.
+// Token must carry sub and exp claims. ErrCredentialsInvalid is returned on any failure.
+func (v *JWTVerifier) Verify(token string) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrCredentialsInvalid
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := jwtDecodeSegment(parts[0], &header); err != nil {
+		return nil, ErrCredentialsInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrCredentialsInvalid
+	}
+	if !v.verifySignature(header.Alg, parts[0]+"."+parts[1], sig) {
+		return nil, ErrCredentialsInvalid
+	}
+
+	var claims JWTClaims
+	if err := jwtDecodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrCredentialsInvalid
+	}
+	if !v.validClaims(&claims) {
+		return nil, ErrCredentialsInvalid
+	}
+	return &claims, nil
+}
+
+func (v *JWTVerifier) verifySignature(alg, signed string, sig []byte) bool {
+	hash, found := jwtAlgs[alg]
+	if !found {
+		return false
+	}
+	switch alg[:2] {
+	case "HS":
+		if v.hmacKey == nil {
+			return false
+		}
+		mac := hmac.New(hash.New, v.hmacKey)
+		mac.Write([]byte(signed))
+		return hmac.Equal(mac.Sum(nil), sig)
+	case "RS":
+		if v.rsaKey == nil {
+			return false
+		}
+		h := hash.New()
+		h.Write([]byte(signed))
+		return rsa.VerifyPKCS1v15(v.rsaKey, hash, h.Sum(nil), sig) == nil
+	}
+	return false
+}
+
+func (v *JWTVerifier) validClaims(c *JWTClaims) bool {
+	now := v.TimeNow()
+	switch {
+	case c.Subject == "":
+		return false
+	case c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0).Add(jwtLeeway)):
+		return false
+	case c.NotBefore != 0 && now.Before(time.Unix(c.NotBefore, 0).Add(-jwtLeeway)):
+		return false
+	case v.Issuer != "" && c.Issuer != v.Issuer:
+		return false
+	case v.Audience != "" && !c.Audience.contains(v.Audience):
+		return false
+	}
+	return true
+}
+
+func jwtDecodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+// tfJWTSecret is HMAC secret used to sign test tokens.
+var tfJWTSecret = []byte("top-secret")
+
+// tfJWTNow is a time at which test tokens are verified.
+var tfJWTNow = time.Date(2016, 10, 1, 12, 0, 0, 0, time.UTC)
+
+// tsJWTSign creates token with given header algorithm, signed with HMAC secret ([]byte) or RSA private key.
+// Signature is empty for nil key.
+func tsJWTSign(t *testing.T, alg string, key interface{}, claims interface{}) string {
+	hb, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	ar.NoError(t, err)
+	cb, err := json.Marshal(claims)
+	ar.NoError(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(hb) + "." + base64.RawURLEncoding.EncodeToString(cb)
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(jwtAlgs[alg].New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		h := jwtAlgs[alg].New()
+		h.Write([]byte(signed))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, jwtAlgs[alg], h.Sum(nil))
+		ar.NoError(t, err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// tsJWTClaims returns claims of the token for UserA valid at tfJWTNow.
+func tsJWTClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub": tfUserA.ID,
+		"iss": "messenger-test",
+		"aud": []string{"messenger", "other"},
+		"exp": tfJWTNow.Add(time.Hour).Unix(),
+		"iat": tfJWTNow.Add(-time.Minute).Unix(),
+	}
+}
+
+func tsRSAKeySetup(t *testing.T) (*rsa.PrivateKey, []byte) {
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	ar.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&k.PublicKey)
+	ar.NoError(t, err)
+	return k, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func Test_Auth_UserIDContext(t *testing.T) {
+	_, ok := UserIDFromContext(context.Background())
+	a.False(t, ok, "user found in empty context")
+
+	id, ok := UserIDFromContext(ContextWithUserID(context.Background(), tfUserA.ID))
+	a.True(t, ok, "user not found in context")
+	a.Equal(t, tfUserA.ID, id, "user ID mismatch")
+}
+
+func Test_APIKeys_Authenticate(t *testing.T) {
+	ks, err := ParseAPIKeys([]string{tfUserA.ID + ":keyA", tfUserB.ID + ":key:B"})
+	ar.NoError(t, err)
+
+	id, err := ks.Authenticate("keyA")
+	a.NoError(t, err, "known key rejected")
+	a.Equal(t, tfUserA.ID, id, "user ID mismatch")
+
+	id, err = ks.Authenticate("key:B")
+	a.NoError(t, err, "known key with separator rejected")
+	a.Equal(t, tfUserB.ID, id, "user ID mismatch")
+
+	_, err = ks.Authenticate("keyX")
+	a.Equal(t, ErrCredentialsInvalid, err, "unknown key accepted")
+}
+
+func Test_APIKeys_Parse_Failure(t *testing.T) {
+	for _, e := range []string{"", "keyOnly", ":keyA", tfUserA.ID + ":"} {
+		_, err := ParseAPIKeys([]string{e})
+		a.Error(t, err, "[%s] invalid entry accepted", e)
+	}
+}
+
+func Test_JWTVerifier_Verify_Success(t *testing.T) {
+	rk, rPEM := tsRSAKeySetup(t)
+
+	tests := map[string]struct {
+		alg string
+		key interface{}
+	}{
+		"HS256": {alg: "HS256", key: tfJWTSecret},
+		"HS384": {alg: "HS384", key: tfJWTSecret},
+		"HS512": {alg: "HS512", key: tfJWTSecret},
+		"RS256": {alg: "RS256", key: rk},
+		"RS512": {alg: "RS512", key: rk},
+	}
+
+	for sym, tc := range tests {
+		// GIVEN: verifier accepting both kinds of keys
+		v := NewJWTVerifier()
+		v.TimeNow = func() time.Time { return tfJWTNow }
+		v.Issuer = "messenger-test"
+		v.Audience = "messenger"
+		v.SetHMACKey(tfJWTSecret)
+		ar.NoError(t, v.SetRSAKey(rPEM), "[%s] RSA key rejected", sym)
+
+		claims, err := v.Verify(tsJWTSign(t, tc.alg, tc.key, tsJWTClaims()))
+
+		// THEN:
+		ar.NoError(t, err, "[%s] valid token rejected", sym)
+		a.Equal(t, tfUserA.ID, claims.Subject, "[%s] subject mismatch", sym)
+	}
+}
+
+func Test_JWTVerifier_Verify_Failure(t *testing.T) {
+	rk, rPEM := tsRSAKeySetup(t)
+
+	tests := map[string]struct {
+		alg    string
+		key    interface{}
+		change func(c map[string]interface{})
+		token  string
+	}{
+		"malformed": {
+			token: "not-a-token",
+		},
+		"unsigned": {
+			token: tsJWTSign(t, "none", nil, tsJWTClaims()),
+		},
+		"unknown algorithm": {
+			token: tsJWTSign(t, "ES256", nil, tsJWTClaims()),
+		},
+		"wrong HMAC secret": {
+			alg: "HS256",
+			key: []byte("guessed"),
+		},
+		"HMAC signed with RSA public key": {
+			alg: "HS256",
+			key: rPEM,
+		},
+		"expired": {
+			alg:    "RS256",
+			key:    rk,
+			change: func(c map[string]interface{}) { c["exp"] = tfJWTNow.Add(-time.Minute).Unix() },
+		},
+		"no expiry": {
+			alg:    "HS256",
+			key:    tfJWTSecret,
+			change: func(c map[string]interface{}) { delete(c, "exp") },
+		},
+		"not valid yet": {
+			alg:    "HS256",
+			key:    tfJWTSecret,
+			change: func(c map[string]interface{}) { c["nbf"] = tfJWTNow.Add(time.Minute).Unix() },
+		},
+		"no subject": {
+			alg:    "HS256",
+			key:    tfJWTSecret,
+			change: func(c map[string]interface{}) { delete(c, "sub") },
+		},
+		"issuer mismatch": {
+			alg:    "HS256",
+			key:    tfJWTSecret,
+			change: func(c map[string]interface{}) { c["iss"] = "someone-else" },
+		},
+		"audience mismatch": {
+			alg:    "HS256",
+			key:    tfJWTSecret,
+			change: func(c map[string]interface{}) { c["aud"] = "other" },
+		},
+	}
+
+	for sym, tc := range tests {
+		// GIVEN: verifier accepting both kinds of keys
+		v := NewJWTVerifier()
+		v.TimeNow = func() time.Time { return tfJWTNow }
+		v.Issuer = "messenger-test"
+		v.Audience = "messenger"
+		v.SetHMACKey(tfJWTSecret)
+		ar.NoError(t, v.SetRSAKey(rPEM), "[%s] RSA key rejected", sym)
+
+		token := tc.token
+		if token == "" {
+			claims := tsJWTClaims()
+			if tc.change != nil {
+				tc.change(claims)
+			}
+			token = tsJWTSign(t, tc.alg, tc.key, claims)
+		}
+
+		_, err := v.Verify(token)
+
+		// THEN:
+		a.Equal(t, ErrCredentialsInvalid, err, "[%s] invalid token accepted", sym)
+	}
+}
+
+func Test_JWTVerifier_Verify_Failure_KeyNotConfigured(t *testing.T) {
+	rk, _ := tsRSAKeySetup(t)
+
+	// GIVEN: verifier accepting HMAC tokens only
+	v := NewJWTVerifier()
+	v.TimeNow = func() time.Time { return tfJWTNow }
+	v.SetHMACKey(tfJWTSecret)
+
+	_, err := v.Verify(tsJWTSign(t, "RS256", rk, tsJWTClaims()))
+
+	// THEN:
+	a.Equal(t, ErrCredentialsInvalid, err, "token signed with RSA accepted")
+}
+
+func Test_JWTVerifier_SetRSAKey(t *testing.T) {
+	rk, _ := tsRSAKeySetup(t)
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rk.PublicKey)})
+
+	v := NewJWTVerifier()
+	a.NoError(t, v.SetRSAKey(pkcs1), "PKCS #1 key rejected")
+	a.Error(t, v.SetRSAKey([]byte("not a PEM")), "invalid PEM accepted")
+	priv := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rk)})
+	a.Error(t, v.SetRSAKey(priv), "private key accepted")
+}
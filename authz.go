@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// Permission is an operation on content of other users, guarded by Authorizer.
+// Changes of own messages and own name need no permission.
+type Permission string
+
+const (
+	// PermMsgChangeAny allows changes and removal of messages authored by other users.
+	PermMsgChangeAny Permission = "messages:change:any"
+
+	// PermUserChangeAny allows renaming of other users.
+	PermUserChangeAny Permission = "users:change:any"
+
+	// PermUserDelete allows removal of users.
+	PermUserDelete Permission = "users:delete"
+
+	// PermRolesManage allows granting and revoking roles.
+	PermRolesManage Permission = "roles:manage"
+
+	// PermWebhooksManage allows registration of webhooks and inspection of their deliveries.
+	PermWebhooksManage Permission = "webhooks:manage"
+)
+
+// rolePermissions lists permissions held by each role.
+var rolePermissions = map[Role][]Permission{
+	RoleModerator: {PermMsgChangeAny},
+	RoleAdmin:     {PermMsgChangeAny, PermUserChangeAny, PermUserDelete, PermRolesManage, PermWebhooksManage},
+}
+
+// Authorizer decides whether authenticated user is allowed to perform operation, based on granted roles.
+type Authorizer struct {
+	Storer UserStorer
+
+	// Admins lists IDs of users holding RoleAdmin regardless of stored roles.
+	// It's used to bootstrap the first admin, who grants roles to others.
+	Admins map[string]bool
+}
+
+func NewAuthorizer(st UserStorer, admins ...string) *Authorizer {
+	a := &Authorizer{
+		Storer: st,
+		Admins: make(map[string]bool, len(admins)),
+	}
+	for _, id := range admins {
+		a.Admins[id] = true
+	}
+	return a
+}
+
+// Caller loads the user bound to the context by AuthMiddleware. Nil user is returned for unauthenticated request.
+// ErrElementNotFound is returned if user was removed after credentials were issued.
+func (a *Authorizer) Caller(ctx context.Context) (*User, error) {
+	userID, ok := UserIDFromContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	return a.Storer.UserLoad(ctx, userID)
+}
+
+// Can reports whether user holds permission through any of the roles.
+func (a *Authorizer) Can(u *User, p Permission) bool {
+	roles := u.Roles
+	if a.Admins[u.ID] {
+		roles = append([]Role{RoleAdmin}, roles...)
+	}
+	for _, r := range roles {
+		for _, rp := range rolePermissions[r] {
+			if rp == p {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authorize makes sure caller of the request is the user with ownerID or holds permission p.
+// Empty ownerID is owned by nobody. Unauthenticated requests are allowed only if allowAnonymous is set,
+// which keeps the service open when authentication is disabled, see AuthMiddleware.
+// Response is written and false returned on failure.
+func (a *Authorizer) Authorize(w http.ResponseWriter, r *http.Request, p Permission, ownerID string, allowAnonymous bool) bool {
+	caller, err := a.Caller(r.Context())
+	switch err {
+	case nil:
+	case ErrElementNotFound:
+		writeUnauthorized(w, "authenticated user does not exist")
+		return false
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return false
+	}
+
+	switch {
+	case caller == nil && allowAnonymous:
+		return true
+	case caller == nil:
+		writeProblem(w, newProblem(http.StatusForbidden, problemForbidden, "authentication is required"))
+		return false
+	case ownerID != "" && caller.ID == ownerID:
+		return true
+	case a.Can(caller, p):
+		return true
+	}
+	writeProblem(w, newProblem(http.StatusForbidden, problemForbidden, "missing permission: "+string(p)))
+	return false
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+func Test_Authorizer_Can(t *testing.T) {
+	tests := map[string]struct {
+		roles  []Role
+		admins []string
+		exp    map[Permission]bool
+	}{
+		"user": {
+			exp: map[Permission]bool{},
+		},
+		"moderator": {
+			roles: []Role{RoleModerator},
+			exp:   map[Permission]bool{PermMsgChangeAny: true},
+		},
+		"admin": {
+			roles: []Role{RoleAdmin},
+			exp:   map[Permission]bool{PermMsgChangeAny: true, PermUserChangeAny: true, PermUserDelete: true, PermRolesManage: true, PermWebhooksManage: true},
+		},
+		"admin from config": {
+			admins: []string{tfUserA.ID},
+			exp:    map[Permission]bool{PermMsgChangeAny: true, PermUserChangeAny: true, PermUserDelete: true, PermRolesManage: true, PermWebhooksManage: true},
+		},
+		"unknown role": {
+			roles: []Role{"root"},
+			exp:   map[Permission]bool{},
+		},
+	}
+
+	for sym, tc := range tests {
+		authz := NewAuthorizer(NewMemoryStorage(), tc.admins...)
+		user := tfUserA
+		user.Roles = tc.roles
+
+		for _, p := range []Permission{PermMsgChangeAny, PermUserChangeAny, PermUserDelete, PermRolesManage, PermWebhooksManage} {
+			a.Equal(t, tc.exp[p], authz.Can(&user, p), "[%s] mismatch on permission %s", sym, p)
+		}
+	}
+}
+
+func Test_Authorizer_Authorize(t *testing.T) {
+	tests := map[string]struct {
+		userID         string
+		ownerID        string
+		allowAnonymous bool
+		ulErr          error
+		resStatus      int
+	}{
+		"owner": {
+			userID:    tfUserA.ID,
+			ownerID:   tfUserA.ID,
+			resStatus: http.StatusOK,
+		},
+		"not an owner": {
+			userID:    tfUserB.ID,
+			ownerID:   tfUserA.ID,
+			resStatus: http.StatusForbidden,
+		},
+		"owned by nobody": {
+			userID:    tfUserB.ID,
+			resStatus: http.StatusForbidden,
+		},
+		"anonymous, allowed": {
+			allowAnonymous: true,
+			resStatus:      http.StatusOK,
+		},
+		"anonymous, not allowed": {
+			resStatus: http.StatusForbidden,
+		},
+		"user removed": {
+			userID:    "UserX-ID",
+			ownerID:   "UserX-ID",
+			resStatus: http.StatusUnauthorized,
+		},
+		"UserLoad error": {
+			userID:    tfUserA.ID,
+			ownerID:   tfUserA.ID,
+			ulErr:     errors.New("some kind of DB error"),
+			resStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for sym, tc := range tests {
+		st := NewTmMemoryStorageMock()
+		for _, u := range []User{tfUserA, tfUserB} {
+			uC := u
+			ar.NoError(t, st.UserSave(context.Background(), &uC), "case: %s", sym)
+		}
+		st.outUserLoadErr = tc.ulErr
+
+		req, err := http.NewRequest(http.MethodDelete, "/", nil)
+		ar.NoError(t, err)
+		if tc.userID != "" {
+			req = req.WithContext(ContextWithUserID(req.Context(), tc.userID))
+		}
+		rr := httptest.NewRecorder()
+
+		allowed := NewAuthorizer(st).Authorize(rr, req, PermUserDelete, tc.ownerID, tc.allowAnonymous)
+
+		// THEN:
+		a.Equal(t, tc.resStatus == http.StatusOK, allowed, "[%s] mismatch on decision", sym)
+		a.Equal(t, tc.resStatus, rr.Code, "[%s] mismatch on response code", sym)
+		if !allowed {
+			tsAssertProblem(t, rr.Code, rr.Header(), rr.Body, sym)
+		}
+	}
+}
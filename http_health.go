@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Statuses reported by health endpoints.
+const (
+	healthStatusOK       = "ok"
+	healthStatusFailing  = "failing"
+	healthStatusDraining = "draining"
+)
+
+// healthCheckTimeoutDefault limits time of readiness check of single component.
+const healthCheckTimeoutDefault = 2 * time.Second
+
+// HealthOut represents result of the health check.
+type HealthOut struct {
+	// Status is "ok" when service is healthy, "failing" or "draining" otherwise.
+	Status string `json:"status"`
+
+	// Components lists statuses of checked dependencies, keyed by component name.
+	Components map[string]ComponentHealthOut `json:"components,omitempty"`
+}
+
+// ComponentHealthOut represents status of single dependency of the service.
+type ComponentHealthOut struct {
+	Status string `json:"status"`
+
+	// Error describes the failure, it's empty when component is healthy.
+	Error string `json:"error,omitempty"`
+}
+
+// healthHandler serves liveness (/healthz) and readiness (/readyz) checks.
+// Both fail with 503 once draining is started, so orchestrator stops routing traffic to the instance.
+type healthHandler struct {
+	Storer Pinger
+
+	// Timeout limits time given to the storage to respond to ping.
+	Timeout time.Duration
+
+	// draining is set to 1 once graceful shutdown is started.
+	draining int32
+}
+
+func NewHealthHandler(st Pinger) *healthHandler {
+	return &healthHandler{
+		Storer:  st,
+		Timeout: healthCheckTimeoutDefault,
+	}
+}
+
+// SetDraining makes both checks fail. It's called when graceful shutdown is started.
+func (h *healthHandler) SetDraining() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+func (h *healthHandler) isDraining() bool {
+	return atomic.LoadInt32(&h.draining) == 1
+}
+
+func (h *healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		handleMethodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/healthz":
+		h.handleLiveness(w, r)
+	case "/readyz":
+		h.handleReadiness(w, r)
+	default:
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
+	}
+}
+
+// handleLiveness reports whether process is alive. Dependencies are not checked,
+// so failing storage does not make orchestrator restart the instance.
+func (h *healthHandler) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	if h.isDraining() {
+		writeHealth(w, http.StatusServiceUnavailable, HealthOut{Status: healthStatusDraining})
+		return
+	}
+	writeHealth(w, http.StatusOK, HealthOut{Status: healthStatusOK})
+}
+
+// handleReadiness reports whether instance is able to serve requests, checking storage.
+func (h *healthHandler) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	if h.isDraining() {
+		writeHealth(w, http.StatusServiceUnavailable, HealthOut{Status: healthStatusDraining})
+		return
+	}
+
+	out := HealthOut{
+		Status:     healthStatusOK,
+		Components: make(map[string]ComponentHealthOut),
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.Timeout)
+	defer cancel()
+	storage := ComponentHealthOut{Status: healthStatusOK}
+	if err := h.Storer.Ping(ctx); err != nil {
+		storage = ComponentHealthOut{Status: healthStatusFailing, Error: err.Error()}
+		out.Status = healthStatusFailing
+	}
+	out.Components["storage"] = storage
+
+	status := http.StatusOK
+	if out.Status != healthStatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, out)
+}
+
+func writeHealth(w http.ResponseWriter, status int, out HealthOut) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+func tsHealthCheck(t *testing.T, h http.Handler, path string) (int, HealthOut) {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+	a.Equal(t, "application/json", rr.Header().Get("Content-Type"), "[%s] incorrect content type", path)
+
+	var out HealthOut
+	ar.NoError(t, json.NewDecoder(rr.Body).Decode(&out), "[%s] invalid body", path)
+	return rr.Code, out
+}
+
+func Test_HealthHandler_Factory(t *testing.T) {
+	st := NewTmMemoryStorageMock()
+	h := NewHealthHandler(st)
+
+	ar.NotNil(t, h, "empty element returned")
+	a.Equal(t, st, h.Storer, "Storer is not attached")
+	a.Equal(t, healthCheckTimeoutDefault, h.Timeout, "Timeout not initialised")
+}
+
+func Test_HealthHandler_Success(t *testing.T) {
+	st := NewTmMemoryStorageMock()
+	h := NewHealthHandler(st)
+
+	status, out := tsHealthCheck(t, h, "/healthz")
+	a.Equal(t, http.StatusOK, status)
+	a.Equal(t, HealthOut{Status: healthStatusOK}, out)
+	a.False(t, st.inPingCalled, "liveness should not check storage")
+
+	status, out = tsHealthCheck(t, h, "/readyz")
+	a.Equal(t, http.StatusOK, status)
+	a.Equal(t, HealthOut{
+		Status:     healthStatusOK,
+		Components: map[string]ComponentHealthOut{"storage": {Status: healthStatusOK}},
+	}, out)
+	a.True(t, st.inPingCalled, "storage not checked")
+}
+
+func Test_HealthHandler_Readiness_StorageFailure(t *testing.T) {
+	st := NewTmMemoryStorageMock()
+	st.outPingErr = errors.New("connection refused")
+	h := NewHealthHandler(st)
+
+	status, out := tsHealthCheck(t, h, "/readyz")
+	a.Equal(t, http.StatusServiceUnavailable, status)
+	a.Equal(t, HealthOut{
+		Status: healthStatusFailing,
+		Components: map[string]ComponentHealthOut{
+			"storage": {Status: healthStatusFailing, Error: "connection refused"},
+		},
+	}, out)
+
+	// AND: process is still alive
+	status, _ = tsHealthCheck(t, h, "/healthz")
+	a.Equal(t, http.StatusOK, status)
+}
+
+func Test_HealthHandler_Readiness_StorageClosed(t *testing.T) {
+	s, closer := tsFileStorageSetup(t, 0)
+	defer closer()
+	h := NewHealthHandler(s)
+
+	status, _ := tsHealthCheck(t, h, "/readyz")
+	a.Equal(t, http.StatusOK, status)
+
+	ar.NoError(t, s.Close())
+
+	status, out := tsHealthCheck(t, h, "/readyz")
+	a.Equal(t, http.StatusServiceUnavailable, status)
+	a.Equal(t, ErrStorageClosed.Error(), out.Components["storage"].Error)
+}
+
+func Test_HealthHandler_Draining(t *testing.T) {
+	st := NewTmMemoryStorageMock()
+	h := NewHealthHandler(st)
+
+	h.SetDraining()
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		status, out := tsHealthCheck(t, h, path)
+		a.Equal(t, http.StatusServiceUnavailable, status, "[%s] incorrect status", path)
+		a.Equal(t, HealthOut{Status: healthStatusDraining}, out, "[%s] incorrect body", path)
+	}
+}
+
+func Test_HealthHandler_DrainingOnShutdown(t *testing.T) {
+	h := NewHealthHandler(NewTmMemoryStorageMock())
+	s := &http.Server{Handler: http.NotFoundHandler()}
+	s.RegisterOnShutdown(h.SetDraining)
+
+	ar.NoError(t, s.Shutdown(context.Background()))
+
+	// hooks are run in separate goroutines
+	for i := 0; i < 100 && !h.isDraining(); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	status, _ := tsHealthCheck(t, h, "/healthz")
+	a.Equal(t, http.StatusServiceUnavailable, status)
+}
+
+func Test_HealthHandler_MethodNotAllowed(t *testing.T) {
+	h := NewHealthHandler(NewTmMemoryStorageMock())
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/readyz", nil))
+
+	a.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	tsAssertProblem(t, rr.Code, rr.Header(), rr.Body, "POST /readyz")
+	a.Equal(t, "GET, HEAD, OPTIONS", rr.Header().Get("Allow"))
+}
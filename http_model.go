@@ -1,7 +1,22 @@
 package main
 
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
 var (
+	// user name length is measured in characters as perceived by the user, see textLen
 	userNameLengthMin = 2
+	userNameLengthMax = 64
+
+	webhookSecretLengthMin = 16
 )
 
 // UserIn represents transport level model for single user submitted into the HTTP handler.
@@ -10,49 +25,177 @@ type UserIn struct {
 	//
 	// required: true
 	// min length: 2
+	// max length: 64
 	Name string `json:"name"`
 }
 
+// Normalize returns the user with name in Unicode normalisation form C.
+func (u UserIn) Normalize() UserIn {
+	u.Name = textNormalize(u.Name)
+	return u
+}
+
 // Validate validates the User and returns error on failure.
 func (u UserIn) Validate() error {
 	if u.Name == "" {
-		return NewValidationError("Name missing")
+		return NewValidationError(FieldError{Field: "name", Code: FieldErrRequired, Msg: "Name missing"})
 	}
-	if len(u.Name) < userNameLengthMin {
-		return NewValidationError("Name too short")
+	if r, found := textInvalidRune(u.Name); found {
+		return NewValidationError(FieldError{Field: "name", Code: FieldErrInvalid, Msg: fmt.Sprintf("Name has invalid character %U", r)})
+	}
+	n := textLen(u.Name)
+	if n < userNameLengthMin {
+		return NewValidationError(FieldError{Field: "name", Code: FieldErrTooShort, Msg: "Name too short"})
+	}
+	if n > userNameLengthMax {
+		return NewValidationError(FieldError{Field: "name", Code: FieldErrTooLong, Msg: "Name too long"})
 	}
 	return nil
 }
 
 // MessageIn represents transport level model for single message sent by user to the system.
 type MessageIn struct {
-	// Body represents the actual message
+	// Body represents the actual message.
+	// Hashtags in it, e.g. #deploy, are attached after the tags and users mentioned in it, e.g. @alice, find it among their mentions.
 	//
 	// required: true
 	Body string `json:"body"`
 
-	// Author is an Name of the user who authored message
-	//
-	// required: true
+	// Author is an Name of the user who authored message.
+	// It's optional for authenticated requests, the authenticated user is the author.
 	Author string `json:"author"`
 
-	// Tag is a tag attached to a message
+	// Tag is a single tag attached to a message. It's kept for backward compatibility, Tags shall be used instead.
+	// It's attached before Tags when both are given. Either Tag or Tags is required.
 	//
-	// required: true
 	// min length: 2
-	Tag Tag `json:"tag"`
+	Tag Tag `json:"tag,omitempty"`
+
+	// Tags attached to a message, duplicates are ignored. Either Tag or Tags is required.
+	//
+	// max items: 10
+	Tags []Tag `json:"tags,omitempty"`
+}
+
+// AllTags returns tags given in Tag and Tags fields, in order and without duplicates.
+func (m MessageIn) AllTags() []Tag {
+	return msgTagsMerge(m.Tag, m.Tags)
 }
 
 // Validate validates the Message and returns error on failure.
+// All invalid fields are reported.
 func (m MessageIn) Validate() error {
+	var errs []interface{}
 	if m.Body == "" {
-		return NewValidationError("missing Body")
+		errs = append(errs, FieldError{Field: "body", Code: FieldErrRequired, Msg: "missing Body"})
+	}
+	if m.Author == "" {
+		errs = append(errs, FieldError{Field: "author", Code: FieldErrRequired, Msg: "missing Author"})
+	}
+	errs = append(errs, msgTagsValidate(m.Tag, m.Tags)...)
+	if len(errs) > 0 {
+		return NewValidationError(errs...)
+	}
+	return nil
+}
+
+// msgTagsMerge puts single tag in front of the list and drops duplicates. Empty single tag is skipped.
+// Tags are normalised and the ones differing only by case are duplicates, the first of them is kept.
+func msgTagsMerge(tag Tag, tags []Tag) []Tag {
+	var out []Tag
+	seen := make(map[string]bool, len(tags)+1)
+	if tag != "" {
+		tag = tag.Normalize()
+		seen[tag.Key()] = true
+		out = append(out, tag)
+	}
+	for _, t := range tags {
+		t = t.Normalize()
+		if !seen[t.Key()] {
+			seen[t.Key()] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// msgTagsValidate validates tags given in single tag and list fields of the message.
+// At least one tag is required, missing tags are reported on the single tag field.
+func msgTagsValidate(tag Tag, tags []Tag) []interface{} {
+	var errs []interface{}
+	if tag != "" || len(tags) == 0 {
+		if err := tag.Validate(); err != nil {
+			errs = append(errs, NewValidationError("invalid Tag", err).InField("tag"))
+		}
+	}
+	for i, t := range tags {
+		if err := t.Validate(); err != nil {
+			errs = append(errs, NewValidationError("invalid Tag", err).InField("tags."+strconv.Itoa(i)))
+		}
+	}
+	if n := len(msgTagsMerge(tag, tags)); n > msgTagsMax {
+		errs = append(errs, FieldError{Field: "tags", Code: FieldErrTooLong, Msg: fmt.Sprintf("too many Tags, up to %d are allowed", msgTagsMax)})
+	}
+	return errs
+}
+
+// MessagePatchIn represents transport level model for partial change of the message.
+// Fields which are not set are left unchanged.
+type MessagePatchIn struct {
+	// Body represents the actual message
+	Body *string `json:"body,omitempty"`
+
+	// Author is an Name of the user who authored message.
+	// It's optional for authenticated requests, the authenticated user is the author.
+	Author string `json:"author"`
+
+	// Tag replaces all tags attached to a message with single one. It's kept for backward compatibility, Tags shall be used instead.
+	//
+	// min length: 2
+	Tag *Tag `json:"tag,omitempty"`
+
+	// Tags replace all tags attached to a message, duplicates are ignored. Tag is attached before them when both are given.
+	//
+	// max items: 10
+	Tags []Tag `json:"tags,omitempty"`
+}
+
+// ChangesTags reports whether patch replaces tags of the message.
+func (m MessagePatchIn) ChangesTags() bool {
+	return m.Tag != nil || m.Tags != nil
+}
+
+// AllTags returns tags given in Tag and Tags fields, in order and without duplicates.
+func (m MessagePatchIn) AllTags() []Tag {
+	var tag Tag
+	if m.Tag != nil {
+		tag = *m.Tag
 	}
+	return msgTagsMerge(tag, m.Tags)
+}
+
+// Validate validates the patch and returns error on failure.
+// All invalid fields are reported.
+func (m MessagePatchIn) Validate() error {
+	var errs []interface{}
 	if m.Author == "" {
-		return NewValidationError("missing Author")
+		errs = append(errs, FieldError{Field: "author", Code: FieldErrRequired, Msg: "missing Author"})
 	}
-	if err := m.Tag.Validate(); err != nil {
-		return NewValidationError("invalid Tag", err)
+	if m.Body == nil && !m.ChangesTags() {
+		errs = append(errs, "nothing to change")
+	}
+	if m.Body != nil && *m.Body == "" {
+		errs = append(errs, FieldError{Field: "body", Code: FieldErrRequired, Msg: "empty Body"})
+	}
+	if m.ChangesTags() {
+		var tag Tag
+		if m.Tag != nil {
+			tag = *m.Tag
+		}
+		errs = append(errs, msgTagsValidate(tag, m.Tags)...)
+	}
+	if len(errs) > 0 {
+		return NewValidationError(errs...)
 	}
 	return nil
 }
@@ -61,7 +204,7 @@ func (m MessageIn) Validate() error {
 //
 // This is used for operations that want the ID of an message in the path
 //
-// swagger:parameters MessageRead
+// swagger:parameters MessageRead MessageRevisions MessageUpdate MessagePatch MessageDelete
 type MessageID struct {
 	// ID represents the unique identifier for the message
 	//
@@ -86,10 +229,550 @@ type MessageOut struct {
 	// required: true
 	Author string `json:"author"`
 
-	// Tag is a tag attached to a message
+	// Tag is the first tag attached to a message. It's kept for backward compatibility, Tags shall be used instead.
 	//
 	// required: true
 	Tag Tag `json:"tag"`
+
+	// Tags attached to a message, in order given by the author
+	//
+	// required: true
+	Tags []Tag `json:"tags"`
+
+	// CreatedAt is a point in time when message was submitted
+	//
+	// required: true
+	CreatedAt time.Time `json:"createdAt"`
+
+	// ModifiedAt is a point in time when message was last changed
+	//
+	// required: true
+	ModifiedAt time.Time `json:"modifiedAt"`
 }
 
 type MessagesCollectionOut []MessageOut
+
+// MessagesPageOut represents single page of messages, ordered from the newest to the oldest.
+type MessagesPageOut struct {
+	// Messages on the page
+	//
+	// required: true
+	Messages MessagesCollectionOut `json:"messages"`
+
+	// Next is an opaque cursor pointing at the next page.
+	// It's empty on the last page.
+	Next string `json:"next,omitempty"`
+}
+
+// SearchPageOut represents single page of messages found, ordered by their relevance to the query.
+type SearchPageOut struct {
+	// Results on the page
+	//
+	// required: true
+	Results []SearchResultOut `json:"results"`
+
+	// Next is an opaque cursor pointing at the next page.
+	// It's empty on the last page.
+	Next string `json:"next,omitempty"`
+}
+
+// SearchResultOut represents message found along with the fragment of its body matching the query.
+type SearchResultOut struct {
+	// required: true
+	Message MessageOut `json:"message"`
+
+	// Score tells how relevant the message is to the words of the query, the higher the better.
+	// It's zero when query has only filters.
+	//
+	// required: true
+	Score float64 `json:"score"`
+
+	// required: true
+	Snippet SnippetOut `json:"snippet"`
+}
+
+// SnippetOut represents fragment of the message body with words matching the query highlighted.
+type SnippetOut struct {
+	// Text of the fragment. Body cut on either side is marked with "…".
+	//
+	// required: true
+	Text string `json:"text"`
+
+	// Highlights are ranges of the words matching the query, in characters (Unicode code points) of the text.
+	//
+	// required: true
+	Highlights []HighlightOut `json:"highlights"`
+}
+
+// HighlightOut represents range of characters of the text, from Start up to but not including End.
+type HighlightOut struct {
+	// required: true
+	Start int `json:"start"`
+
+	// required: true
+	End int `json:"end"`
+}
+
+// Commands sent by the client over WebSocket connection.
+const (
+	socketCmdSubscribe   = "subscribe"
+	socketCmdUnsubscribe = "unsubscribe"
+	socketCmdPost        = "post"
+)
+
+// Replies sent by the server over WebSocket connection. Events are sent with the type of the change, see MsgEventType.
+const (
+	socketReplyAck   = "ack"
+	socketReplyError = "error"
+)
+
+// SocketIn represents command sent by the client over WebSocket connection, as single text message.
+type SocketIn struct {
+	// Type of the command: subscribe, unsubscribe or post
+	Type string `json:"type"`
+
+	// ID is chosen by the client and repeated in the reply, so they can be matched
+	ID string `json:"id,omitempty"`
+
+	// Tags to subscribe or unsubscribe
+	Tags []Tag `json:"tags,omitempty"`
+
+	// Message to post
+	Message *MessageIn `json:"message,omitempty"`
+}
+
+// SocketOut represents reply to the command or event pushed by the server over WebSocket connection.
+type SocketOut struct {
+	// Type is "ack" or "error" for replies, or type of the change for events: created, updated or deleted
+	Type string `json:"type"`
+
+	// ID of the command the reply is for
+	ID string `json:"id,omitempty"`
+
+	// Tags followed after subscribe or unsubscribe
+	Tags []Tag `json:"tags,omitempty"`
+
+	// Message posted or changed
+	Message *MessageOut `json:"message,omitempty"`
+
+	// Problem describes failure of the command
+	Problem *ProblemOut `json:"problem,omitempty"`
+}
+
+var errMsgCursorInvalid = errors.New("invalid cursor")
+
+// encodeMsgCursor serialises cursor into opaque, URL safe string.
+// Zero time (messages created before it was tracked) is encoded as 0.
+func encodeMsgCursor(c MsgCursor) string {
+	var ns int64
+	if !c.CreatedAt.IsZero() {
+		ns = c.CreatedAt.UnixNano()
+	}
+	raw := strconv.FormatInt(ns, 10) + ":" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeMsgCursor restores cursor serialised with encodeMsgCursor.
+func decodeMsgCursor(s string) (MsgCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return MsgCursor{}, errMsgCursorInvalid
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return MsgCursor{}, errMsgCursorInvalid
+	}
+	ns, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return MsgCursor{}, errMsgCursorInvalid
+	}
+	c := MsgCursor{ID: parts[1]}
+	if ns != 0 {
+		c.CreatedAt = time.Unix(0, ns).UTC()
+	}
+	return c, nil
+}
+
+// encodeSearchCursor serialises position in the search results into opaque, URL safe string.
+func encodeSearchCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("search:" + strconv.Itoa(offset)))
+}
+
+// decodeSearchCursor restores position serialised with encodeSearchCursor.
+func decodeSearchCursor(s string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || !strings.HasPrefix(string(raw), "search:") {
+		return 0, errMsgCursorInvalid
+	}
+	offset, err := strconv.Atoi(strings.TrimPrefix(string(raw), "search:"))
+	if err != nil || offset < 0 {
+		return 0, errMsgCursorInvalid
+	}
+	return offset, nil
+}
+
+// A UserID parameter model.
+//
+// This is used for operations that want the ID of an user in the path
+//
+// swagger:parameters UserRead UserRename UserDelete UserRoleGrant UserRoleRevoke UserMentions
+type UserID struct {
+	// ID represents the unique identifier for the user
+	//
+	// in: path
+	// required: true
+	ID string `json:"id"` // json tag is used to modify the swagger naming
+}
+
+type UserOut struct {
+	// ID represents the unique identifier for the user
+	//
+	// required: true
+	ID string `json:"id"`
+
+	// Name represents the user to the outside world.
+	//
+	// required: true
+	Name string `json:"name"`
+
+	// Roles held by the user. Every user holds "user" role.
+	//
+	// required: true
+	Roles []Role `json:"roles"`
+}
+
+// UsersPageOut represents single page of users, ordered by ID.
+type UsersPageOut struct {
+	// Users on the page
+	//
+	// required: true
+	Users []UserOut `json:"users"`
+
+	// Next is an opaque cursor pointing at the next page.
+	// It's empty on the last page.
+	Next string `json:"next,omitempty"`
+}
+
+var errUserCursorInvalid = errors.New("invalid cursor")
+
+// encodeUserCursor serialises ID of the last user on the page into opaque, URL safe string.
+func encodeUserCursor(id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+// decodeUserCursor restores user ID serialised with encodeUserCursor.
+func decodeUserCursor(s string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(raw) == 0 {
+		return "", errUserCursorInvalid
+	}
+	return string(raw), nil
+}
+
+// WebhookIn represents transport level model for webhook registered by the client.
+type WebhookIn struct {
+	// URL receiving changes of messages, http or https
+	//
+	// required: true
+	URL string `json:"url"`
+
+	// Secret is a key of HMAC-SHA256 signature of the requests.
+	// It's generated when missing on creation and kept when missing on update.
+	//
+	// min length: 16
+	Secret string `json:"secret,omitempty"`
+
+	// Tags limits changes to messages associated with any of them. All tags match when it's empty.
+	Tags []Tag `json:"tags,omitempty"`
+
+	// Authors limits changes to messages authored by any of the users, by ID. All authors match when it's empty.
+	Authors []string `json:"authors,omitempty"`
+}
+
+// Validate validates the webhook and returns error on failure.
+// All invalid fields are reported.
+func (wh WebhookIn) Validate() error {
+	var errs []interface{}
+	if wh.URL == "" {
+		errs = append(errs, FieldError{Field: "url", Code: FieldErrRequired, Msg: "missing URL"})
+	} else if u, err := url.Parse(wh.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, FieldError{Field: "url", Code: FieldErrInvalid, Msg: "URL is not absolute http or https URL"})
+	}
+	if wh.Secret != "" && len(wh.Secret) < webhookSecretLengthMin {
+		errs = append(errs, FieldError{Field: "secret", Code: FieldErrTooShort, Msg: "Secret too short"})
+	}
+	for i, t := range wh.Tags {
+		if err := t.Validate(); err != nil {
+			errs = append(errs, NewValidationError("invalid Tag", err).InField("tags."+strconv.Itoa(i)))
+		}
+	}
+	for i, id := range wh.Authors {
+		if id == "" {
+			errs = append(errs, FieldError{Field: "authors." + strconv.Itoa(i), Code: FieldErrRequired, Msg: "empty Author"})
+		}
+	}
+	if len(errs) > 0 {
+		return NewValidationError(errs...)
+	}
+	return nil
+}
+
+// A WebhookID parameter model.
+//
+// This is used for operations that want the ID of a webhook in the path
+//
+// swagger:parameters WebhookRead WebhookUpdate WebhookDelete WebhookDeliveries WebhookDeadLetters
+type WebhookID struct {
+	// ID represents the unique identifier for the webhook
+	//
+	// in: path
+	// required: true
+	ID string `json:"id"` // json tag is used to modify the swagger naming
+}
+
+// WebhookOut represents transport level model for single webhook returned from system.
+type WebhookOut struct {
+	// ID represents the unique identifier for the webhook
+	//
+	// required: true
+	ID string `json:"id"`
+
+	// URL receiving changes of messages
+	//
+	// required: true
+	URL string `json:"url"`
+
+	// Secret is a key of HMAC-SHA256 signature of the requests. It's returned only on creation.
+	Secret string `json:"secret,omitempty"`
+
+	// Tags limiting changes to messages associated with any of them
+	//
+	// required: true
+	Tags []Tag `json:"tags"`
+
+	// Authors limiting changes to messages authored by any of the users, by ID
+	//
+	// required: true
+	Authors []string `json:"authors"`
+
+	// CreatedAt is a point in time when webhook was registered
+	//
+	// required: true
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhooksCollectionOut represents all webhooks, ordered by ID.
+type WebhooksCollectionOut struct {
+	// required: true
+	Webhooks []WebhookOut `json:"webhooks"`
+}
+
+// WebhookEventOut represents change of the message delivered to the webhook, as body of POST request.
+type WebhookEventOut struct {
+	// ID of the delivery, the same for all its attempts
+	//
+	// required: true
+	ID string `json:"id"`
+
+	// Type of the change: created, updated or deleted
+	//
+	// required: true
+	Type string `json:"type"`
+
+	// OccurredAt is a point in time when change was dispatched
+	//
+	// required: true
+	OccurredAt time.Time `json:"occurredAt"`
+
+	// Message after the change, or before removal
+	//
+	// required: true
+	Message MessageOut `json:"message"`
+
+	// PrevTags are tags detached from updated message by the change
+	PrevTags []Tag `json:"prevTags,omitempty"`
+}
+
+// WebhookDeliveryOut represents single attempt of delivery to the webhook.
+type WebhookDeliveryOut struct {
+	// DeliveryID is an ID of the delivery, the same for all its attempts
+	//
+	// required: true
+	DeliveryID string `json:"deliveryId"`
+
+	// Event is a type of the change: created, updated or deleted
+	//
+	// required: true
+	Event string `json:"event"`
+
+	// MessageID is an ID of the changed message
+	//
+	// required: true
+	MessageID string `json:"messageId"`
+
+	// Attempt is a number of the attempt, starting from 1
+	//
+	// required: true
+	Attempt int `json:"attempt"`
+
+	// At is a point in time when attempt started
+	//
+	// required: true
+	At time.Time `json:"at"`
+
+	// DurationMs is a time the attempt took, in milliseconds
+	//
+	// required: true
+	DurationMs float64 `json:"durationMs"`
+
+	// StatusCode of the response. It's missing when no response was received.
+	StatusCode int `json:"statusCode,omitempty"`
+
+	// Error describes the failure
+	Error string `json:"error,omitempty"`
+
+	// Outcome of the attempt
+	//
+	// required: true
+	// enum: delivered,retrying,failed
+	Outcome string `json:"outcome"`
+}
+
+// WebhookDeliveriesOut represents the latest attempts of deliveries to the webhook, from the newest.
+type WebhookDeliveriesOut struct {
+	// required: true
+	Deliveries []WebhookDeliveryOut `json:"deliveries"`
+}
+
+// WebhookDeadLetterOut represents delivery to the webhook given up on.
+type WebhookDeadLetterOut struct {
+	// DeliveryID is an ID of the delivery
+	//
+	// required: true
+	DeliveryID string `json:"deliveryId"`
+
+	// Event is a type of the change: created, updated or deleted
+	//
+	// required: true
+	Event string `json:"event"`
+
+	// MessageID is an ID of the changed message
+	//
+	// required: true
+	MessageID string `json:"messageId"`
+
+	// Attempts is a number of attempts made. It's 0 for delivery dropped as the queue was full.
+	//
+	// required: true
+	Attempts int `json:"attempts"`
+
+	// Error describes the last failure
+	//
+	// required: true
+	Error string `json:"error"`
+
+	// FailedAt is a point in time when delivery was given up on
+	//
+	// required: true
+	FailedAt time.Time `json:"failedAt"`
+
+	// Payload is the change which was not delivered
+	//
+	// required: true
+	Payload *WebhookEventOut `json:"payload"`
+}
+
+// WebhookDeadLettersOut represents the latest deliveries to the webhook given up on, from the newest.
+type WebhookDeadLettersOut struct {
+	// required: true
+	DeadLetters []WebhookDeadLetterOut `json:"deadLetters"`
+}
+
+// ProblemOut represents error response, as described in RFC 7807 (application/problem+json).
+type ProblemOut struct {
+	// Type is an URI identifying the problem type. It's always "about:blank", Code is used instead.
+	//
+	// required: true
+	Type string `json:"type"`
+
+	// Title is a short summary of the problem, equal to HTTP status text.
+	//
+	// required: true
+	Title string `json:"title"`
+
+	// Status is a HTTP status code.
+	//
+	// required: true
+	Status int `json:"status"`
+
+	// Detail is a human readable explanation of this occurrence of the problem.
+	Detail string `json:"detail,omitempty"`
+
+	// Code is a machine readable identifier of the problem.
+	//
+	// required: true
+	// enum: invalid_json,validation_failed,not_found,unauthorized,forbidden,name_taken,user_in_use,method_not_allowed,invalid_handshake,internal_error,not_implemented
+	Code string `json:"code"`
+
+	// Errors lists invalid fields when Code is validation_failed.
+	Errors []FieldErrorOut `json:"errors,omitempty"`
+}
+
+// FieldErrorOut represents validation failure of single field of the request.
+type FieldErrorOut struct {
+	// Field is a path to the invalid field, nested fields are separated with dot.
+	// Query parameters are reported by their names.
+	Field string `json:"field,omitempty"`
+
+	// Code is a machine readable reason of the failure.
+	//
+	// required: true
+	// enum: required,too_short,too_long,invalid,unknown
+	Code string `json:"code"`
+
+	// Message is a human readable description of the failure.
+	//
+	// required: true
+	Message string `json:"message"`
+}
+
+// Codes of problems reported in ProblemOut.
+// They are machine readable and shall never be changed once released.
+const (
+	problemInvalidJSON      = "invalid_json"
+	problemValidation       = "validation_failed"
+	problemNotFound         = "not_found"
+	problemUnauthorized     = "unauthorized"
+	problemForbidden        = "forbidden"
+	problemNameTaken        = "name_taken"
+	problemUserInUse        = "user_in_use"
+	problemMethodNotAllowed = "method_not_allowed"
+	problemInvalidHandshake = "invalid_handshake"
+	problemInternal         = "internal_error"
+	problemNotImplemented   = "not_implemented"
+)
+
+// problemContentType is a media type of error responses.
+const problemContentType = "application/problem+json"
+
+// newProblem creates problem with given status and code. Detail is optional.
+func newProblem(status int, code, detail string) ProblemOut {
+	return ProblemOut{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// newValidationProblem creates problem describing failed validation of the request.
+func newValidationProblem(err error) ProblemOut {
+	p := newProblem(http.StatusBadRequest, problemValidation, err.Error())
+	if ve, ok := err.(*ValidationError); ok {
+		for _, f := range ve.Fields() {
+			p.Errors = append(p.Errors, FieldErrorOut{Field: f.Field, Code: f.Code, Message: f.Msg})
+		}
+	}
+	return p
+}
@@ -10,6 +10,18 @@ var tfTrInUserB = UserIn{
 	Name: "UserB-Name",
 }
 
+var tfTrOutUserA = UserOut{
+	ID:    "UserA-ID",
+	Name:  "UserA-Name",
+	Roles: []Role{RoleUser},
+}
+
+var tfTrOutUserB = UserOut{
+	ID:    "UserB-ID",
+	Name:  "UserB-Name",
+	Roles: []Role{RoleUser},
+}
+
 // -- section: Message
 var tfTrInMsgAA = MessageIn{
 	Body:   "UserA_MessageA-Body",
@@ -39,27 +51,43 @@ var tfTrInMsgAXD_TagTooShort = MessageIn{
 	Tag:    tfTagXA_TooShort,
 }
 
-var tfTrOutMsgAA = MessageOut{
-	ID:     "UserA_MessageA-ID",
-	Body:   "UserA_MessageA-Body",
+var tfTrPatchMsgAA_Body = "UserA_MessageA-Body-Patched"
+var tfTrPatchMsgAA = MessagePatchIn{
+	Body:   &tfTrPatchMsgAA_Body,
 	Author: "UserA-Name",
-	Tag:    Tag("tagA"),
 }
+var tfTrPatchMsgAA_JSON = `{"body":"UserA_MessageA-Body-Patched","author":"UserA-Name"}`
 
-var tfTrOutMsgAA_JSON = `{"id":"UserA_MessageA-ID","body":"UserA_MessageA-Body","author":"UserA-Name","tag":"tagA"}`
+var tfTrOutMsgAA = MessageOut{
+	ID:         "UserA_MessageA-ID",
+	Body:       "UserA_MessageA-Body",
+	Author:     "UserA-Name",
+	Tag:        Tag("tagA"),
+	Tags:       []Tag{"tagA"},
+	CreatedAt:  tfMsgAA.CreatedAt,
+	ModifiedAt: tfMsgAA.ModifiedAt,
+}
+
+var tfTrOutMsgAA_JSON = `{"id":"UserA_MessageA-ID","body":"UserA_MessageA-Body","author":"UserA-Name","tag":"tagA","tags":["tagA"],"createdAt":"2016-10-01T12:00:00Z","modifiedAt":"2016-10-01T12:00:00Z"}`
 
 var tfTrOutMsgAB = MessageOut{
-	ID:     "UserA_MessageB-ID",
-	Body:   "UserA_MessageB-Body",
-	Author: "UserA-Name",
-	Tag:    Tag("tagA"),
+	ID:         "UserA_MessageB-ID",
+	Body:       "UserA_MessageB-Body",
+	Author:     "UserA-Name",
+	Tag:        Tag("tagA"),
+	Tags:       []Tag{"tagA"},
+	CreatedAt:  tfMsgAB.CreatedAt,
+	ModifiedAt: tfMsgAB.ModifiedAt,
 }
 
-var tfTrOutMsgAB_JSON = `{"id":"UserA_MessageB-ID","body":"UserA_MessageB-Body","author":"UserA-Name","tag":"tagA"}`
+var tfTrOutMsgAB_JSON = `{"id":"UserA_MessageB-ID","body":"UserA_MessageB-Body","author":"UserA-Name","tag":"tagA","tags":["tagA"],"createdAt":"2016-10-01T12:01:00Z","modifiedAt":"2016-10-01T12:01:00Z"}`
 
 var tfTrOutMsgBA = MessageOut{
-	ID:     "UserB_MessageA-ID",
-	Body:   "UserB_MessageA-Body",
-	Author: "UserB-Name",
-	Tag:    Tag("tagA"),
+	ID:         "UserB_MessageA-ID",
+	Body:       "UserB_MessageA-Body",
+	Author:     "UserB-Name",
+	Tag:        Tag("tagA"),
+	Tags:       []Tag{"tagA"},
+	CreatedAt:  tfMsgBA.CreatedAt,
+	ModifiedAt: tfMsgBA.ModifiedAt,
 }
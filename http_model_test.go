@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 
 	a "github.com/stretchr/testify/assert"
@@ -12,6 +13,10 @@ import (
 // -- section: User
 func Test_HTTPModel_TrInUser_Validate_Success(t *testing.T) {
 	a.NoError(t, tfTrInUserA.Validate())
+
+	// length is measured in characters, not bytes
+	a.NoError(t, UserIn{Name: strings.Repeat("ż", userNameLengthMax)}.Validate(), "non ASCII name")
+	a.NoError(t, UserIn{Name: "Zoë 👩\u200d💻"}.Validate(), "name with emoji")
 }
 
 func Test_HTTPModel_TrInUser_Validate_Failure(t *testing.T) {
@@ -19,8 +24,15 @@ func Test_HTTPModel_TrInUser_Validate_Failure(t *testing.T) {
 		obj  UserIn
 		eStr string
 	}{
-		"no Name":        {UserIn{}, "Name missing"},
-		"name too short": {UserIn{Name: "A"}, "Name too short"},
+		"no Name":           {UserIn{}, "Name missing"},
+		"name too short":    {UserIn{Name: "A"}, "Name too short"},
+		"name too long":     {UserIn{Name: strings.Repeat("ż", userNameLengthMax+1)}, "Name too long"},
+		"combining marks":   {UserIn{Name: "e\u0301"}, "Name too short"},
+		"control character": {UserIn{Name: "User\tA"}, "Name has invalid character U+0009"},
+		"zero width space":  {UserIn{Name: "User\u200bA"}, "Name has invalid character U+200B"},
+		"bidi override":     {UserIn{Name: "User\u202eA"}, "Name has invalid character U+202E"},
+		"no-break space":    {UserIn{Name: "User\u00a0A"}, "Name has invalid character U+00A0"},
+		"Hangul filler":     {UserIn{Name: "User\u3164A"}, "Name has invalid character U+3164"},
 	}
 
 	for s, tc := range tests {
@@ -28,6 +40,10 @@ func Test_HTTPModel_TrInUser_Validate_Failure(t *testing.T) {
 	}
 }
 
+func Test_HTTPModel_TrInUser_Normalize(t *testing.T) {
+	a.Equal(t, UserIn{Name: "Zoë"}, UserIn{Name: "Zoe\u0308"}.Normalize())
+}
+
 func Test_HTTPModel_TrInUser_JSONEncode(t *testing.T) {
 	enc, err := json.Marshal(&tfTrInUserA)
 	ar.NoError(t, err)
@@ -37,6 +53,19 @@ func Test_HTTPModel_TrInUser_JSONEncode(t *testing.T) {
 // -- section: Message
 func Test_HTTPModel_TrInMsg_Validate_Success(t *testing.T) {
 	a.NoError(t, tfTrInMsgAA.Validate())
+
+	tagsOnly := MessageIn{Body: "Body", Author: tfUserA.Name, Tags: []Tag{tfTagB, tfTagC}}
+	a.NoError(t, tagsOnly.Validate())
+}
+
+func Test_HTTPModel_TrInMsg_AllTags(t *testing.T) {
+	a.Equal(t, []Tag{tfTagA}, tfTrInMsgAA.AllTags(), "legacy tag")
+
+	m := MessageIn{Tag: tfTagB, Tags: []Tag{tfTagC, tfTagB, tfTagA, tfTagC}}
+	a.Equal(t, []Tag{tfTagB, tfTagC, tfTagA}, m.AllTags(), "tag goes first, duplicates dropped")
+
+	m = MessageIn{Tags: []Tag{"Cafe\u0301", "café", "CAFÉ", "go"}}
+	a.Equal(t, []Tag{"Café", "go"}, m.AllTags(), "tags normalised, duplicates up to case dropped")
 }
 
 func Test_HTTPModel_TrInMsg_Validate_Failure(t *testing.T) {
@@ -55,6 +84,64 @@ func Test_HTTPModel_TrInMsg_Validate_Failure(t *testing.T) {
 	}
 }
 
+func Test_HTTPModel_TrPatchMsg_Validate_Success(t *testing.T) {
+	a.NoError(t, tfTrPatchMsgAA.Validate())
+
+	tagOnly := MessagePatchIn{Author: tfUserA.Name, Tag: &tfTagB}
+	a.NoError(t, tagOnly.Validate())
+
+	tagsOnly := MessagePatchIn{Author: tfUserA.Name, Tags: []Tag{tfTagB, tfTagC}}
+	a.NoError(t, tagsOnly.Validate())
+	a.True(t, tagsOnly.ChangesTags(), "tags change not reported")
+}
+
+func Test_HTTPModel_TrPatchMsg_Validate_Failure(t *testing.T) {
+	bodyEmpty := ""
+	tests := map[string]struct {
+		obj  MessagePatchIn
+		eStr string
+	}{
+		"no Author":              {MessagePatchIn{Body: &tfTrPatchMsgAA_Body}, "missing Author"},
+		"no change":              {MessagePatchIn{Author: tfUserA.Name}, "nothing to change"},
+		"empty Body":             {MessagePatchIn{Author: tfUserA.Name, Body: &bodyEmpty}, "empty Body"},
+		"invalid Tag: too short": {MessagePatchIn{Author: tfUserA.Name, Tag: &tfTagXA_TooShort}, "invalid Tag: too short"},
+		"invalid Tags":           {MessagePatchIn{Author: tfUserA.Name, Tags: []Tag{tfTagA, tfTagXA_TooShort}}, "invalid Tag: too short"},
+	}
+
+	for s, tc := range tests {
+		a.EqualError(t, tc.obj.Validate(), fmt.Sprintf("validation failed: %s", tc.eStr), "case: %s", s)
+	}
+}
+
+func Test_HTTPModel_TrInWebhook_Validate_Success(t *testing.T) {
+	a.NoError(t, WebhookIn{URL: "http://localhost:8081/hook"}.Validate())
+	a.NoError(t, WebhookIn{URL: "https://hooks.example.com", Secret: tfWebhookA.Secret, Tags: []Tag{tfTagA}, Authors: []string{tfUserA.ID}}.Validate())
+}
+
+func Test_HTTPModel_TrInWebhook_Validate_Failure(t *testing.T) {
+	tests := map[string]struct {
+		obj   WebhookIn
+		field string
+		eStr  string
+	}{
+		"no URL":           {WebhookIn{}, "url", "missing URL"},
+		"relative URL":     {WebhookIn{URL: "/hook"}, "url", "URL is not absolute http or https URL"},
+		"other scheme":     {WebhookIn{URL: "ftp://hooks.example.com"}, "url", "URL is not absolute http or https URL"},
+		"no host":          {WebhookIn{URL: "http:///hook"}, "url", "URL is not absolute http or https URL"},
+		"secret too short": {WebhookIn{URL: "http://localhost", Secret: "short"}, "secret", "Secret too short"},
+		"invalid Tag":      {WebhookIn{URL: "http://localhost", Tags: []Tag{tfTagA, tfTagXA_TooShort}}, "tags.1", "invalid Tag: too short"},
+		"empty Author":     {WebhookIn{URL: "http://localhost", Authors: []string{""}}, "authors.0", "empty Author"},
+	}
+
+	for s, tc := range tests {
+		err := tc.obj.Validate()
+		a.EqualError(t, err, fmt.Sprintf("validation failed: %s", tc.eStr), "case: %s", s)
+		if ve, ok := err.(*ValidationError); a.True(t, ok, "case: %s", s) && a.Len(t, ve.Fields(), 1, "case: %s", s) {
+			a.Equal(t, tc.field, ve.Fields()[0].Field, "case: %s", s)
+		}
+	}
+}
+
 func Test_HTTPModel_TrInMsg_JSONEncode(t *testing.T) {
 	enc, err := json.Marshal(&tfTrInMsgAA)
 	ar.NoError(t, err)
@@ -66,3 +153,17 @@ func Test_HTTPModel_TrOutMsg_JSONEncode(t *testing.T) {
 	ar.NoError(t, err)
 	a.JSONEq(t, tfTrOutMsgAA_JSON, string(enc))
 }
+
+func Test_HTTPModel_MsgCursor_RoundTrip(t *testing.T) {
+	tests := map[string]MsgCursor{
+		"regular":   MsgCursorOf(&tfMsgAA),
+		"zero time": {ID: tfMsgAA.ID},
+	}
+
+	for sym, cExp := range tests {
+		cGot, err := decodeMsgCursor(encodeMsgCursor(cExp))
+		if a.NoError(t, err, "[%s] unexpected error on decode", sym) {
+			a.Equal(t, cExp, cGot, "[%s] cursor mismatch", sym)
+		}
+	}
+}
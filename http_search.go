@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// searchHandler is HTTP handler for full-text search of messages.
+type searchHandler struct {
+	// Msgs finds messages matching the query.
+	Msgs MsgStorer
+
+	// Users resolves authors given in the query and loads authors of messages found.
+	Users UserStorer
+}
+
+func NewSearchHandler(ms MsgStorer, us UserStorer) *searchHandler {
+	return &searchHandler{
+		Msgs:  ms,
+		Users: us,
+	}
+}
+
+func (h *searchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := StartSpan(r.Context(), "searchHandler", SpanKindInternal)
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	isCollection := r.URL.Path == "/v1/search" || r.URL.Path == "/v1/search/"
+
+	switch true {
+	case isCollection && r.Method == http.MethodGet:
+		// swagger:route GET /v1/search messages MessagesSearch
+		//
+		// Search messages by words of their bodies, ordered by relevance.
+		//
+		// Query is made of words, all of which must be in the body. Words are matched regardless of case and accents
+		// written as separate characters. "Double quotes" make a phrase of words following each other and * at the end
+		// of a word, e.g. depl*, matches words starting with it. Results are narrowed by filters tag:, author:, after:
+		// and before:, e.g. tag:ops author:UserA-Name after:2016-05-01 before:2016-05-31 (both days included).
+		// Messages are ranked with BM25, those ranked equally are ordered from the newest.
+		//
+		//     Responses:
+		//       200: SearchResponse
+		//       400: BadRequestError
+		//       500: InternalServerError
+		//       501: NotImplementedError
+		h.handleSearch(w, r)
+	case isCollection:
+		handleMethodNotAllowed(w, r, http.MethodGet)
+	default:
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
+	}
+}
+
+func (h *searchHandler) handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	sq, author, err := msgSearchParse(q.Get("q"))
+	if err != nil {
+		writeProblem(w, newValidationProblem(err))
+		return
+	}
+
+	limit, err := pageLimitParse(q.Get("limit"), msgsPageLimitDefault, msgsPageLimitMax)
+	if err != nil {
+		writeProblem(w, newValidationProblem(err))
+		return
+	}
+	offset := 0
+	if v := q.Get("cursor"); v != "" {
+		offset, err = decodeSearchCursor(v)
+		if err != nil {
+			writeProblem(w, newValidationProblem(NewValidationError(FieldError{Field: "cursor", Code: FieldErrInvalid, Msg: err.Error()})))
+			return
+		}
+	}
+
+	if author != "" {
+		u, err := h.Users.UserFindByName(r.Context(), author)
+		switch err {
+		case nil:
+			sq.AuthorID = u.ID
+		case ErrElementNotFound:
+			// nobody wrote anything as an unknown user
+			h.writePage(w, SearchPageOut{Results: []SearchResultOut{}})
+			return
+		default:
+			writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+			return
+		}
+	}
+
+	// one more is requested to find out if there is a next page
+	hits, err := h.Msgs.MsgsSearch(r.Context(), sq, offset, limit+1)
+	switch err {
+	case nil:
+	case ErrNotSupported:
+		writeProblem(w, newProblem(http.StatusNotImplemented, problemNotImplemented, "search is not supported by the storage"))
+		return
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+
+	trOut := SearchPageOut{Results: make([]SearchResultOut, 0, len(hits))}
+	if len(hits) > limit {
+		hits = hits[:limit]
+		trOut.Next = encodeSearchCursor(offset + limit)
+	}
+
+	ids := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		ids = append(ids, hit.ID)
+	}
+	msgs, err := h.Msgs.MsgLoadMany(r.Context(), ids)
+	if err != nil {
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+	authors, err := loadAuthors(r.Context(), h.Users, msgs)
+	if err != nil {
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+
+	for i, msg := range msgs {
+		text, ranges := msgSearchSnippet(msg.Body, sq.Terms)
+		snippet := SnippetOut{Text: text, Highlights: make([]HighlightOut, 0, len(ranges))}
+		for _, rg := range ranges {
+			snippet.Highlights = append(snippet.Highlights, HighlightOut{Start: rg.Start, End: rg.End})
+		}
+		trOut.Results = append(trOut.Results, SearchResultOut{
+			Message: msgToTransport(msg, authors[msg.AuthorID]),
+			Score:   hits[i].Score,
+			Snippet: snippet,
+		})
+	}
+
+	h.writePage(w, trOut)
+}
+
+func (h *searchHandler) writePage(w http.ResponseWriter, trOut SearchPageOut) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(trOut)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+// tsSearchSetup saves users A and B along with messages AA, AB and BA in the storage.
+func tsSearchSetup(t *testing.T, st Storer) {
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		ar.NoError(t, st.UserSave(context.Background(), &uC))
+	}
+	for _, m := range []Message{tfMsgAA, tfMsgAB, tfMsgBA} {
+		mC := m
+		ar.NoError(t, st.MsgSave(context.Background(), &mC))
+	}
+}
+
+// tsSearchGet requests the search and decodes the page returned.
+func tsSearchGet(t *testing.T, tsURL string, query url.Values) SearchPageOut {
+	res, err := http.Get(fmt.Sprintf("%s/v1/search?%s", tsURL, query.Encode()))
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	defer res.Body.Close()
+	ar.Equal(t, http.StatusOK, res.StatusCode, "mismatch on response code")
+	ar.Equal(t, "application/json", res.Header.Get("Content-Type"), "mismatch on response content encoding")
+
+	var resBodyGot SearchPageOut
+	ar.NoError(t, json.NewDecoder(res.Body).Decode(&resBodyGot), "unexpected error on response body read")
+	return resBodyGot
+}
+
+func Test_HTTPHandler_Search_Success(t *testing.T) {
+	st := NewMemoryStorage()
+	h := NewHTTPDefaultHandler(st)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// GIVEN
+	tsSearchSetup(t, st)
+
+	// WHEN: word of some of the bodies is searched for
+	pageGot := tsSearchGet(t, ts.URL, url.Values{"q": {"MessageA"}})
+
+	// THEN: equally relevant messages are returned from the newest, with the word highlighted
+	ar.Len(t, pageGot.Results, 2)
+	a.Empty(t, pageGot.Next, "unexpected next page")
+	a.Equal(t, tfTrOutMsgBA, pageGot.Results[0].Message)
+	a.Equal(t, tfTrOutMsgAA, pageGot.Results[1].Message)
+	for i, r := range pageGot.Results {
+		a.True(t, r.Score > 0, "[%d] message not scored", i)
+		a.Equal(t, SnippetOut{Text: r.Message.Body, Highlights: []HighlightOut{{Start: 6, End: 14}}}, r.Snippet, "[%d] mismatch on snippet", i)
+	}
+
+	// AND: author narrows the results
+	pageGot = tsSearchGet(t, ts.URL, url.Values{"q": {"messagea author:usera-name"}})
+	ar.Len(t, pageGot.Results, 1)
+	a.Equal(t, tfTrOutMsgAA, pageGot.Results[0].Message, "mismatch on message of the author")
+
+	// AND: filters alone select messages without scoring them
+	pageGot = tsSearchGet(t, ts.URL, url.Values{"q": {"tag:tagA author:UserA-Name"}})
+	ar.Len(t, pageGot.Results, 2)
+	a.Equal(t, tfTrOutMsgAB, pageGot.Results[0].Message)
+	a.Equal(t, tfTrOutMsgAA, pageGot.Results[1].Message)
+	a.Zero(t, pageGot.Results[0].Score, "message scored without words")
+	a.Equal(t, SnippetOut{Text: tfMsgAB.Body, Highlights: []HighlightOut{}}, pageGot.Results[0].Snippet, "mismatch on snippet without words")
+}
+
+func Test_HTTPHandler_Search_Success_Paging(t *testing.T) {
+	st := NewMemoryStorage()
+	h := NewHTTPDefaultHandler(st)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// GIVEN
+	tsSearchSetup(t, st)
+
+	// WHEN: pages are requested one after another
+	var pagesGot [][]MessageOut
+	cursor := ""
+	for i := 0; i < 5; i++ {
+		pageGot := tsSearchGet(t, ts.URL, url.Values{"q": {"body"}, "limit": {"2"}, "cursor": {cursor}})
+
+		var msgs []MessageOut
+		for _, r := range pageGot.Results {
+			msgs = append(msgs, r.Message)
+		}
+		pagesGot = append(pagesGot, msgs)
+		if pageGot.Next == "" {
+			break
+		}
+		cursor = pageGot.Next
+	}
+
+	// THEN
+	a.Equal(t, [][]MessageOut{
+		{tfTrOutMsgBA, tfTrOutMsgAB},
+		{tfTrOutMsgAA},
+	}, pagesGot)
+}
+
+func Test_HTTPHandler_Search_Success_AuthorUnknown(t *testing.T) {
+	st := NewTmMemoryStorageMock()
+	h := NewHTTPDefaultHandler(st)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// GIVEN
+	tsSearchSetup(t, st)
+
+	pageGot := tsSearchGet(t, ts.URL, url.Values{"q": {"body author:UserX-Name"}})
+
+	// THEN: nothing is found without searching
+	a.Equal(t, SearchPageOut{Results: []SearchResultOut{}}, pageGot)
+	a.True(t, st.inUserFindCalled, "UserFindByName function not called")
+	a.False(t, st.inMsgsSearchCalled, "MsgsSearch function called")
+}
+
+func Test_HTTPHandler_Search_Failure(t *testing.T) {
+	tests := map[string]struct {
+		query       string
+		ufErr       error // uf = UserFindByName
+		msCalledExp bool  // ms = MsgsSearch
+		msErr       error
+		mlErr       error // ml = MsgLoadMany
+		resStatus   int
+	}{
+		"query missing": {
+			query:     "",
+			resStatus: http.StatusBadRequest,
+		},
+		"query without words": {
+			query:     "q=-+!",
+			resStatus: http.StatusBadRequest,
+		},
+		"prefix too short": {
+			query:     "q=b*",
+			resStatus: http.StatusBadRequest,
+		},
+		"date invalid": {
+			query:     "q=body+after:yesterday",
+			resStatus: http.StatusBadRequest,
+		},
+		"limit invalid": {
+			query:     "q=body&limit=0",
+			resStatus: http.StatusBadRequest,
+		},
+		"cursor invalid": {
+			query:     "q=body&cursor=garbage",
+			resStatus: http.StatusBadRequest,
+		},
+		"UserFindByName error": {
+			query:     "q=body+author:UserA-Name",
+			ufErr:     errors.New("some kind of DB error"),
+			resStatus: http.StatusInternalServerError,
+		},
+		"MsgsSearch error": {
+			query:       "q=body",
+			msCalledExp: true,
+			msErr:       errors.New("some kind of DB error"),
+			resStatus:   http.StatusInternalServerError,
+		},
+		"MsgsSearch not supported": {
+			query:       "q=body",
+			msCalledExp: true,
+			msErr:       ErrNotSupported,
+			resStatus:   http.StatusNotImplemented,
+		},
+		"MsgLoadMany error": {
+			query:       "q=body",
+			msCalledExp: true,
+			mlErr:       errors.New("some kind of DB error"),
+			resStatus:   http.StatusInternalServerError,
+		},
+	}
+
+	for sym, tc := range tests {
+		st := NewTmMemoryStorageMock()
+		h := NewHTTPDefaultHandler(st)
+
+		// GIVEN
+		tsSearchSetup(t, st)
+		st.outUserFindErr = tc.ufErr
+		st.outMsgsSearchErr = tc.msErr
+		st.outMsgLoadManyErr = tc.mlErr
+
+		// WHEN
+		req, err := http.NewRequest(http.MethodGet, "/v1/search?"+tc.query, nil)
+		ar.NoError(t, err)
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+
+		// THEN
+		a.Equal(t, tc.resStatus, res.Code, "[%s] mismatch on response code", sym)
+		tsAssertProblem(t, res.Code, res.Header(), res.Body, sym)
+		a.Equal(t, tc.msCalledExp, st.inMsgsSearchCalled, "[%s] MsgsSearch function call status mismatch", sym)
+	}
+}
+
+func Test_HTTPHandler_Search_MethodNotAllowed(t *testing.T) {
+	h := NewHTTPDefaultHandler(NewMemoryStorage())
+
+	req, err := http.NewRequest(http.MethodPost, "/v1/search?q=body", nil)
+	ar.NoError(t, err)
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	a.Equal(t, http.StatusMethodNotAllowed, res.Code, "mismatch on response code")
+	a.Equal(t, "GET, OPTIONS", res.Header().Get("Allow"), "mismatch on allowed methods")
+	tsAssertProblem(t, res.Code, res.Header(), res.Body, "POST")
+}
@@ -1,32 +1,113 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/satori/go.uuid"
 )
 
 // UserStorer is storage interface for User related operations
 type UserStorer interface {
-	UserSave(u *User) error
-	UserLoad(id string) (*User, error)
-	UserFindByName(name string) (*User, error)
+	// UserSave persists single user.
+	// ErrElementDuplicated is returned if other user with the same name, up to the key (see userNameKey), exists.
+	UserSave(ctx context.Context, u *User) error
+	UserLoad(ctx context.Context, id string) (*User, error)
+	UserFindByName(ctx context.Context, name string) (*User, error)
+
+	// UserLoadMany retrieves users by IDs in a single call.
+	// Users are returned in the order of ids. ErrElementNotFound is returned if any of them is missing.
+	UserLoadMany(ctx context.Context, ids []string) ([]*User, error)
+
+	// UserUpdate replaces existing user. ErrElementNotFound is returned if user is missing.
+	// ErrElementDuplicated is returned if other user with the same name, up to the key (see userNameKey), exists.
+	UserUpdate(ctx context.Context, u *User) error
+
+	// UserDelete removes user. Authored messages are handled according to the policy.
+	// ErrElementNotFound is returned if user is missing.
+	// ErrElementInUse is returned if user authored any message and policy is UserDeleteReject.
+	UserDelete(ctx context.Context, id string, policy UserDeletePolicy) error
+
+	// UsersList returns up to limit users ordered by ID, starting after the one with given ID.
+	// Empty after starts from the beginning. Non positive limit returns all of them.
+	UsersList(ctx context.Context, after string, limit int) ([]*User, error)
 }
 
 // MsgStorer is storage interface for Message related operations
 type MsgStorer interface {
-	MsgSave(m *Message) error
-	MsgLoad(id string) (*Message, error)
-	MsgsIDsFindByTag(tag Tag) ([]string, error)
+	MsgSave(ctx context.Context, m *Message) error
+	MsgLoad(ctx context.Context, id string) (*Message, error)
+
+	// MsgLoadMany retrieves messages by IDs in a single call.
+	// Messages are returned in the order of ids. ErrElementNotFound is returned if any of them is missing.
+	MsgLoadMany(ctx context.Context, ids []string) ([]*Message, error)
+
+	// MsgUpdate replaces existing message, keeping the previous version as its revision.
+	// Association to tags and mentioned users follows the change. ErrElementNotFound is returned if message is missing.
+	MsgUpdate(ctx context.Context, m *Message) error
+
+	// MsgDelete removes message along with its revisions and association to tags and mentioned users.
+	// ErrElementNotFound is returned if message is missing.
+	MsgDelete(ctx context.Context, id string) error
+
+	// MsgRevisions retrieves prior versions of the message, from the oldest.
+	// Version is kept each time message is saved over. Empty list is returned for message which was never edited.
+	// ErrElementNotFound is returned if message is missing.
+	MsgRevisions(ctx context.Context, id string) ([]*Message, error)
+
+	// MsgsIDsFindByTag returns up to limit IDs of messages associated with tag, listed after the cursor.
+	// Messages are ordered from the newest to the oldest, see MsgCursor. Non positive limit returns all of them.
+	// ErrElementNotFound is returned if no message is associated with the tag.
+	MsgsIDsFindByTag(ctx context.Context, tag Tag, after MsgCursor, limit int) ([]string, error)
+
+	// MsgsIDsFindByTags returns up to limit IDs of messages matching the query, listed after the cursor.
+	// Messages are ordered from the newest to the oldest, see MsgCursor. Non positive limit returns all of them.
+	// ErrElementNotFound is returned if no message is associated with any of All tags or with each of Any tags.
+	MsgsIDsFindByTags(ctx context.Context, q MsgTagQuery, after MsgCursor, limit int) ([]string, error)
+
+	// MsgsIDsFindByMention returns up to limit IDs of messages mentioning the user, listed after the cursor.
+	// Messages are ordered from the newest to the oldest, see MsgCursor. Non positive limit returns all of them.
+	// Empty list is returned if the user is not mentioned in any message.
+	MsgsIDsFindByMention(ctx context.Context, userID string, after MsgCursor, limit int) ([]string, error)
+
+	// MsgsIDsFindByAuthor returns up to limit IDs of messages authored by the user, listed after the cursor.
+	// Messages are ordered from the newest to the oldest, see MsgCursor. Non positive limit returns all of them.
+	// Empty list is returned if the user authored no message.
+	MsgsIDsFindByAuthor(ctx context.Context, authorID string, after MsgCursor, limit int) ([]string, error)
+
+	// MsgsSearch returns up to limit messages matching the query, skipping offset of them.
+	// Messages are ordered by relevance to the terms of the query, equally relevant ones from the newest to the oldest.
+	// Non positive limit returns all of them. ErrNotSupported is returned if storage has no full-text index.
+	MsgsSearch(ctx context.Context, q MsgSearchQuery, offset, limit int) ([]MsgSearchHit, error)
+}
+
+// Pinger is storage interface for health checks.
+type Pinger interface {
+	// Ping checks whether storage is able to serve requests, e.g. database is reachable.
+	// Error describing the problem is returned otherwise.
+	Ping(ctx context.Context) error
 }
 
-// Storer is an storage interface for users, messages and tags
+// Storer is an storage interface for users, messages and tags.
+// All operations take request scoped context as the first argument.
+// Implementations shall give up and return ctx.Err() once the context is cancelled or expired.
+// Legacy, context unaware implementations (StorerV1) can be used through NewStorerV1Adapter.
+//
+// Close flushes pending writes and releases resources held by the storage.
+// Storage shall not be used once it's closed.
 type Storer interface {
 	UserStorer
 	MsgStorer
+	Pinger
+	io.Closer
 }
 
 // NewHTTPServer creates new HTTP server for package submission.
@@ -37,233 +118,1368 @@ func NewHTTPServer(host string, port int, h http.Handler) *http.Server {
 	}
 }
 
+// HTTPHandlerConfig tunes behaviour of the handler built by NewHTTPHandler.
+type HTTPHandlerConfig struct {
+	// UserDeletePolicy decides what happens to messages of removed user.
+	// Zero value means UserDeleteReject.
+	UserDeletePolicy UserDeletePolicy
+
+	// Admins lists IDs of users holding admin role regardless of roles granted in the storage.
+	Admins []string
+
+	// Bus receives messages created through the handler and feeds their streams.
+	// Bus private to the handler is used when it's nil.
+	Bus *MsgBus
+
+	// Webhooks delivers changes published on Bus to webhooks managed through the handler.
+	// Webhooks are not supported when it's nil.
+	Webhooks *WebhookDispatcher
+}
+
 // NewHTTPDefaultHandler is a default handler factory.
 // It takes care of routing.
-// TODO: test me
 func NewHTTPDefaultHandler(st Storer) http.Handler {
+	return NewHTTPHandler(st, HTTPHandlerConfig{})
+}
+
+// NewHTTPHandler is a handler factory configured by cfg.
+// It takes care of routing.
+func NewHTTPHandler(st Storer, cfg HTTPHandlerConfig) http.Handler {
 	mux := http.NewServeMux()
 
-	// swagger:route POST /v1/users users UserCreate
-	//
-	// Create user.
-	//
-	//     Responses:
-	//       201: UserCreatedResponse
-	//       400: BadRequestError
-	//       500: InternalServerError
-	mux.Handle("/v1/users", &usersHandler{Storer: st})
-
-	mux.Handle("/v1/messages", &messagesHandler{Storer: st})
+	bus := cfg.Bus
+	if bus == nil {
+		bus = NewMsgBus()
+	}
+	st = NewPublishingStorer(st, bus)
+
+	authz := NewAuthorizer(st, cfg.Admins...)
+
+	uh := NewUsersHandler(st)
+	uh.Msgs = st
+	uh.Authz = authz
+	if cfg.UserDeletePolicy != "" {
+		uh.DeletePolicy = cfg.UserDeletePolicy
+	}
+	mux.Handle("/v1/users", uh)
+	// duplication needed to handle base path without redirection
+	mux.Handle("/v1/users/", uh)
+
+	mh := NewMessagesHandler(st)
+	mh.Authz = authz
+	mh.Bus = bus
+	mux.Handle("/v1/messages", mh)
+	// duplication needed to handle base path without redirection
+	mux.Handle("/v1/messages/", mh)
+
+	sh := NewSearchHandler(st, st)
+	mux.Handle("/v1/search", sh)
+	// duplication needed to handle base path without redirection
+	mux.Handle("/v1/search/", sh)
+
+	whh := NewWebhooksHandler(cfg.Webhooks, st)
+	whh.Authz = authz
+	mux.Handle("/v1/webhooks", whh)
 	// duplication needed to handle base path without redirection
-	mux.Handle("/v1/messages/", &messagesHandler{Storer: st})
+	mux.Handle("/v1/webhooks/", whh)
 
 	mux.Handle("/v1/swagger.json", &swaggerHandler{})
 
 	return mux
 }
 
+const (
+	// usersPageLimitDefault is a number of users on a page when client did not ask for specific one.
+	usersPageLimitDefault = 20
+
+	// usersPageLimitMax is a maximum number of users on a page.
+	usersPageLimitMax = 100
+)
+
 // usersHandler is HTTP handler for users related actions
 type usersHandler struct {
 	Storer UserStorer
-}
-
-func (h *usersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-
-	var trIn UserIn
-	err := json.NewDecoder(r.Body).Decode(&trIn)
-
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
 
-	if trIn.Validate() != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
+	// Msgs lists messages mentioning the user. Mentions are not supported when it's nil.
+	Msgs MsgStorer
 
-	if _, err := h.Storer.UserFindByName(trIn.Name); err != ErrElementNotFound {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
+	// Authz decides whether authenticated caller may change other users and manage roles.
+	Authz *Authorizer
 
-	user := User{
-		ID:   uuid.NewV1().String(),
-		Name: trIn.Name,
-	}
+	// DeletePolicy decides what happens to messages of removed user.
+	DeletePolicy UserDeletePolicy
+}
 
-	err = h.Storer.UserSave(&user)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+func NewUsersHandler(st UserStorer) *usersHandler {
+	return &usersHandler{
+		Storer:       st,
+		Authz:        NewAuthorizer(st),
+		DeletePolicy: UserDeleteReject,
 	}
-
-	w.WriteHeader(http.StatusCreated)
 }
 
-// messagesHandler is HTTP handler for messages related actions
-type messagesHandler struct {
-	Storer Storer
-}
+func (h *usersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := StartSpan(r.Context(), "usersHandler", SpanKindInternal)
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	isCollection := r.URL.Path == "/v1/users" || r.URL.Path == "/v1/users/"
+	isItem := rPathUser.MatchString(r.URL.Path)
+	isRole := rPathUserRole.MatchString(r.URL.Path)
+	isMentions := rPathUserMentions.MatchString(r.URL.Path)
 
-func (h *messagesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch true {
-	case r.Method == http.MethodPost:
-		// swagger:route POST /v1/messages messages MessageCreate
+	case isCollection && r.Method == http.MethodPost:
+		// swagger:route POST /v1/users users UserCreate
 		//
-		// Create message.
+		// Create user.
 		//
 		//     Responses:
-		//       201: MessageCreatedResponse
+		//       201: UserCreatedResponse
 		//       400: BadRequestError
+		//       409: ConflictError
 		//       500: InternalServerError
 		h.handleCreate(w, r)
-		return
-	case r.Method == http.MethodGet && (r.URL.Path == "/v1/messages" || r.URL.Path == "/v1/messages/"):
-		// swagger:route GET /v1/messages messages MessagesFind
+	case isCollection && r.Method == http.MethodGet:
+		// swagger:route GET /v1/users users UsersList
 		//
-		// Get collection of messages matching requested tag.
+		// Get page of users ordered by ID or, when name is given, the single user with that name.
 		//
 		//     Responses:
-		//       200: MessagesCollectionResponse
+		//       200: UsersCollectionResponse
+		//       400: BadRequestError
 		//       404: NotFoundError
 		//       500: InternalServerError
-		h.handleFind(w, r)
-		return
-	case r.Method == http.MethodGet:
-		// swagger:route GET /v1/messages/{id} messages MessageRead
+		//       501: NotImplementedError
+		h.handleList(w, r)
+	case isItem && r.Method == http.MethodGet:
+		// swagger:route GET /v1/users/{id} users UserRead
 		//
-		// Get details of single message by its ID.
+		// Get details of single user by its ID.
 		//
 		//     Responses:
-		//       200: MessageReadResponse
+		//       200: UserReadResponse
 		//       404: NotFoundError
 		//       500: InternalServerError
 		h.handleRead(w, r)
-		return
+	case isItem && r.Method == http.MethodPut:
+		// swagger:route PUT /v1/users/{id} users UserRename
+		//
+		// Change name of the user. ID of the user is kept. Users are allowed to rename themselves, admins anyone.
+		//
+		//     Security:
+		//       api_key:
+		//       bearer:
+		//
+		//     Responses:
+		//       200: UserReadResponse
+		//       400: BadRequestError
+		//       401: UnauthorizedError
+		//       403: ForbiddenError
+		//       404: NotFoundError
+		//       409: ConflictError
+		//       500: InternalServerError
+		h.handleRename(w, r)
+	case isItem && r.Method == http.MethodDelete:
+		// swagger:route DELETE /v1/users/{id} users UserDelete
+		//
+		// Delete the user. Messages of the user are removed, anonymised or prevent the removal, depending on server config.
+		// Only admins are allowed to do it.
+		//
+		//     Security:
+		//       api_key:
+		//       bearer:
+		//
+		//     Responses:
+		//       204: UserDeletedResponse
+		//       401: UnauthorizedError
+		//       403: ForbiddenError
+		//       404: NotFoundError
+		//       409: ConflictError
+		//       500: InternalServerError
+		//       501: NotImplementedError
+		h.handleDelete(w, r)
+	case isRole && r.Method == http.MethodPut:
+		// swagger:route PUT /v1/users/{id}/roles/{role} users UserRoleGrant
+		//
+		// Grant role to the user. Only admins are allowed to do it.
+		//
+		//     Security:
+		//       api_key:
+		//       bearer:
+		//
+		//     Responses:
+		//       200: UserReadResponse
+		//       400: BadRequestError
+		//       401: UnauthorizedError
+		//       403: ForbiddenError
+		//       404: NotFoundError
+		//       500: InternalServerError
+		h.handleRoleChange(w, r, User.WithRole)
+	case isRole && r.Method == http.MethodDelete:
+		// swagger:route DELETE /v1/users/{id}/roles/{role} users UserRoleRevoke
+		//
+		// Revoke role from the user. Only admins are allowed to do it.
+		//
+		//     Security:
+		//       api_key:
+		//       bearer:
+		//
+		//     Responses:
+		//       200: UserReadResponse
+		//       400: BadRequestError
+		//       401: UnauthorizedError
+		//       403: ForbiddenError
+		//       404: NotFoundError
+		//       500: InternalServerError
+		h.handleRoleChange(w, r, User.WithoutRole)
+	case isMentions && r.Method == http.MethodGet:
+		// swagger:route GET /v1/users/{id}/mentions users UserMentions
+		//
+		// Get page of messages mentioning the user, from the newest to the oldest.
+		//
+		// User is mentioned by @ followed by the name, e.g. @UserA-Name, anywhere in the body of the message.
+		//
+		//     Responses:
+		//       200: MessagesCollectionResponse
+		//       400: BadRequestError
+		//       404: NotFoundError
+		//       500: InternalServerError
+		//       501: NotImplementedError
+		h.handleMentions(w, r)
+	case isCollection:
+		handleMethodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+	case isItem:
+		handleMethodNotAllowed(w, r, http.MethodGet, http.MethodPut, http.MethodDelete)
+	case isRole:
+		handleMethodNotAllowed(w, r, http.MethodPut, http.MethodDelete)
+	case isMentions:
+		handleMethodNotAllowed(w, r, http.MethodGet)
+	default:
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
 	}
 }
 
-func (h *messagesHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
-	var trIn MessageIn
+func userToTransport(u *User) UserOut {
+	return UserOut{
+		ID:    u.ID,
+		Name:  u.Name,
+		Roles: append([]Role{RoleUser}, u.Roles...),
+	}
+}
+
+func (h *usersHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var trIn UserIn
 	err := json.NewDecoder(r.Body).Decode(&trIn)
+
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeProblem(w, newProblem(http.StatusBadRequest, problemInvalidJSON, err.Error()))
 		return
 	}
 
-	if trIn.Validate() != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	trIn = trIn.Normalize()
+	if err := trIn.Validate(); err != nil {
+		writeProblem(w, newValidationProblem(err))
 		return
 	}
 
-	author, err := h.Storer.UserFindByName(trIn.Author)
+	user := User{
+		ID:   uuid.NewV1().String(),
+		Name: trIn.Name,
+	}
+
+	// storage guards uniqueness of names up to their keys, so names differing only by case or look-alike characters
+	// are taken as well
+	err = h.Storer.UserSave(r.Context(), &user)
 	switch err {
 	case nil:
-	case ErrElementNotFound:
-		w.WriteHeader(http.StatusBadRequest)
+	case ErrElementDuplicated:
+		writeProblem(w, newProblem(http.StatusConflict, problemNameTaken, "user with this or similarly looking name already exists"))
 		return
 	default:
-		w.WriteHeader(http.StatusInternalServerError)
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
 		return
 	}
 
-	msg := Message{
-		ID:       uuid.NewV1().String(),
-		Body:     trIn.Body,
-		Tag:      trIn.Tag,
-		AuthorID: author.ID,
+	w.Header().Set("Location", "/v1/users/"+user.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(userToTransport(&user))
+}
+
+func (h *usersHandler) handleList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	// lookup by name is a filtered collection with at most one element
+	if name := q.Get("name"); name != "" {
+		user, err := h.Storer.UserFindByName(r.Context(), name)
+		switch err {
+		case nil:
+		case ErrElementNotFound:
+			writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
+			return
+		default:
+			writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(UsersPageOut{Users: []UserOut{userToTransport(user)}})
+		return
 	}
 
-	err = h.Storer.MsgSave(&msg)
+	limit, err := pageLimitParse(q.Get("limit"), usersPageLimitDefault, usersPageLimitMax)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeProblem(w, newValidationProblem(err))
 		return
 	}
 
-	w.Header().Set("Location", "/v1/messages/"+msg.ID)
-	w.WriteHeader(http.StatusCreated)
-}
+	var after string
+	if v := q.Get("cursor"); v != "" {
+		after, err = decodeUserCursor(v)
+		if err != nil {
+			writeProblem(w, newValidationProblem(NewValidationError(FieldError{Field: "cursor", Code: FieldErrInvalid, Msg: err.Error()})))
+			return
+		}
+	}
 
-func msgToTransport(msg *Message, author *User) MessageOut {
-	return MessageOut{
-		ID:     msg.ID,
-		Author: author.Name,
-		Body:   msg.Body,
-		Tag:    msg.Tag,
+	// one more is requested to find out if there is a next page
+	users, err := h.Storer.UsersList(r.Context(), after, limit+1)
+	switch err {
+	case nil:
+	case ErrNotSupported:
+		writeProblem(w, newProblem(http.StatusNotImplemented, problemNotImplemented, "operation is not supported by the storage"))
+		return
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+
+	hasNext := len(users) > limit
+	if hasNext {
+		users = users[:limit]
+	}
+
+	trOut := UsersPageOut{
+		Users: make([]UserOut, 0, len(users)),
+	}
+	for _, u := range users {
+		trOut.Users = append(trOut.Users, userToTransport(u))
 	}
+	if hasNext {
+		trOut.Next = encodeUserCursor(users[len(users)-1].ID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(trOut)
 }
 
-func (h *messagesHandler) handleFind(w http.ResponseWriter, r *http.Request) {
-	tag := r.URL.Query().Get("tag")
-	msgsIDs, err := h.Storer.MsgsIDsFindByTag(Tag(tag))
+// allowed chars in ID: 0-9a-zA-Z-_ (space is NOT allowed)
+var rPathUser = regexp.MustCompile(`^/v1/users/([\da-zA-Z\-_]+)/?$`)
+
+func (h *usersHandler) handleRead(w http.ResponseWriter, r *http.Request) {
+	// userID is on index 1, route is only taken on match
+	userID := rPathUser.FindStringSubmatch(r.URL.Path)[1]
 
+	user, err := h.Storer.UserLoad(r.Context(), userID)
 	switch err {
 	case nil:
 	case ErrElementNotFound:
-		w.WriteHeader(http.StatusNotFound)
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
 		return
 	default:
-		w.WriteHeader(http.StatusInternalServerError)
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
 		return
 	}
 
-	var trOut MessagesCollectionOut
-	for _, mID := range msgsIDs {
-		msg, err := h.Storer.MsgLoad(mID)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		author, err := h.Storer.UserLoad(msg.AuthorID)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(userToTransport(user))
+}
+
+func (h *usersHandler) handleRename(w http.ResponseWriter, r *http.Request) {
+	// userID is on index 1, route is only taken on match
+	userID := rPathUser.FindStringSubmatch(r.URL.Path)[1]
+
+	var trIn UserIn
+	if err := json.NewDecoder(r.Body).Decode(&trIn); err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, problemInvalidJSON, err.Error()))
+		return
+	}
+	trIn = trIn.Normalize()
+	if err := trIn.Validate(); err != nil {
+		writeProblem(w, newValidationProblem(err))
+		return
+	}
+	if !h.Authz.Authorize(w, r, PermUserChangeAny, userID, true) {
+		return
+	}
+
+	// stored user is shared, change is made on a copy keeping granted roles
+	current, err := h.Storer.UserLoad(r.Context(), userID)
+	switch err {
+	case nil:
+	case ErrElementNotFound:
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
+		return
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+	user := *current
+	user.Name = trIn.Name
 
-		trOut = append(trOut, msgToTransport(msg, author))
+	switch err := h.Storer.UserUpdate(r.Context(), &user); err {
+	case nil:
+	case ErrElementNotFound:
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
+		return
+	case ErrElementDuplicated:
+		writeProblem(w, newProblem(http.StatusConflict, problemNameTaken, "user with this or similarly looking name already exists"))
+		return
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(trOut)
+	json.NewEncoder(w).Encode(userToTransport(&user))
 }
 
-// allowed chars in ID: 0-9a-zA-Z-_ (space is NOT allowed)
-var rPathMsgRead = regexp.MustCompile(`^/v1/messages/([\da-zA-Z\-_]+)/?$`)
+func (h *usersHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	// userID is on index 1, route is only taken on match
+	userID := rPathUser.FindStringSubmatch(r.URL.Path)[1]
 
-func (h *messagesHandler) handleRead(w http.ResponseWriter, r *http.Request) {
-	matches := rPathMsgRead.FindStringSubmatch(r.URL.Path)
+	if !h.Authz.Authorize(w, r, PermUserDelete, "", true) {
+		return
+	}
 
-	// matches also have the source string on index 0
-	if len(matches) != 2 {
-		w.WriteHeader(http.StatusNotFound)
+	switch err := h.Storer.UserDelete(r.Context(), userID, h.DeletePolicy); err {
+	case nil:
+	case ErrElementNotFound:
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
+		return
+	case ErrElementInUse:
+		writeProblem(w, newProblem(http.StatusConflict, problemUserInUse, "user authored messages"))
+		return
+	case ErrNotSupported:
+		writeProblem(w, newProblem(http.StatusNotImplemented, problemNotImplemented, "operation is not supported by the storage"))
+		return
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
 		return
 	}
 
-	// msgID is on index 1
-	msg, err := h.Storer.MsgLoad(matches[1])
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allowed chars in role: a-z
+var rPathUserRole = regexp.MustCompile(`^/v1/users/([\da-zA-Z\-_]+)/roles/([a-z]+)/?$`)
+
+// handleRoleChange applies change of roles to the user pointed by request path and responds with the result.
+// Roles are managed by admins only, also when authentication is disabled.
+func (h *usersHandler) handleRoleChange(w http.ResponseWriter, r *http.Request, change func(u User, role Role) User) {
+	// userID is on index 1 and role on index 2, route is only taken on match
+	matches := rPathUserRole.FindStringSubmatch(r.URL.Path)
+	userID, role := matches[1], Role(matches[2])
+
+	if err := role.Validate(); err != nil {
+		writeProblem(w, newValidationProblem(NewValidationError(FieldError{Field: "role", Code: FieldErrInvalid, Msg: err.Error()})))
+		return
+	}
+	if role == RoleUser {
+		writeProblem(w, newValidationProblem(NewValidationError(FieldError{Field: "role", Code: FieldErrInvalid, Msg: "user role is held implicitly"})))
+		return
+	}
+	if !h.Authz.Authorize(w, r, PermRolesManage, "", false) {
+		return
+	}
+
+	current, err := h.Storer.UserLoad(r.Context(), userID)
 	switch err {
 	case nil:
 	case ErrElementNotFound:
-		w.WriteHeader(http.StatusNotFound)
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
+		return
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+
+	// stored user is shared, change returns a copy
+	user := change(*current, role)
+
+	switch err := h.Storer.UserUpdate(r.Context(), &user); err {
+	case nil:
+	case ErrElementNotFound:
+		// user was removed in the meantime
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
 		return
 	default:
-		w.WriteHeader(http.StatusInternalServerError)
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(userToTransport(&user))
+}
+
+var rPathUserMentions = regexp.MustCompile(`^/v1/users/([\da-zA-Z\-_]+)/mentions/?$`)
+
+func (h *usersHandler) handleMentions(w http.ResponseWriter, r *http.Request) {
+	// userID is on index 1, route is only taken on match
+	userID := rPathUserMentions.FindStringSubmatch(r.URL.Path)[1]
+
+	if h.Msgs == nil {
+		writeProblem(w, newProblem(http.StatusNotImplemented, problemNotImplemented, "mentions are not supported"))
 		return
 	}
 
-	author, err := h.Storer.UserLoad(msg.AuthorID)
+	limit, after, err := msgsPageParse(r.URL.Query())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeProblem(w, newValidationProblem(err))
 		return
 	}
 
-	trOut := msgToTransport(msg, author)
+	switch _, err := h.Storer.UserLoad(r.Context(), userID); err {
+	case nil:
+	case ErrElementNotFound:
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
+		return
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+
+	// one more is requested to find out if there is a next page
+	msgsIDs, err := h.Msgs.MsgsIDsFindByMention(r.Context(), userID, after, limit+1)
+	switch err {
+	case nil:
+	case ErrNotSupported:
+		writeProblem(w, newProblem(http.StatusNotImplemented, problemNotImplemented, "operation is not supported by the storage"))
+		return
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+
+	trOut, err := msgsPageLoad(r.Context(), h.Msgs, h.Storer, msgsIDs, limit)
+	if err != nil {
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(trOut)
+}
+
+const (
+	// msgsPageLimitDefault is a number of messages on a page when client did not ask for specific one.
+	msgsPageLimitDefault = 20
+
+	// msgsPageLimitMax is a maximum number of messages on a page.
+	msgsPageLimitMax = 100
+
+	// msgsFindTagsMax is a maximum number of tags in single query, over all of tag, any and not parameters.
+	msgsFindTagsMax = 20
+)
+
+// messagesHandler is HTTP handler for messages related actions
+type messagesHandler struct {
+	Storer Storer
+
+	// Authz decides whether authenticated caller may change messages of other users.
+	Authz *Authorizer
+
+	// Bus feeds streams and WebSocket connections with changes of messages. Neither is supported when it's nil.
+	Bus *MsgBus
+
+	// HeartbeatEvery is an interval of heartbeat comments sent over idle stream. Zero means streamHeartbeatDefault.
+	HeartbeatEvery time.Duration
+
+	// Socket tunes WebSocket connections.
+	Socket SocketConfig
+
+	// TimeNow is testing helper for time sensitive tests. It defaults to time.Now function.
+	TimeNow func() time.Time
+}
+
+func NewMessagesHandler(st Storer) *messagesHandler {
+	return &messagesHandler{
+		Storer:  st,
+		Authz:   NewAuthorizer(st),
+		TimeNow: time.Now,
+	}
+}
+
+func (h *messagesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := StartSpan(r.Context(), "messagesHandler", SpanKindInternal)
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	isCollection := r.URL.Path == "/v1/messages" || r.URL.Path == "/v1/messages/"
+	isStream := rPathMsgStream.MatchString(r.URL.Path)
+	isSocket := rPathMsgSocket.MatchString(r.URL.Path)
+	isRevisions := rPathMsgRevisions.MatchString(r.URL.Path)
+	// stream and socket paths would be taken for message ID otherwise
+	isItem := !isStream && !isSocket && rPathMsgRead.MatchString(r.URL.Path)
+
+	switch true {
+	case isCollection && r.Method == http.MethodPost:
+		// swagger:route POST /v1/messages messages MessageCreate
+		//
+		// Create message.
+		//
+		//     Security:
+		//       api_key:
+		//       bearer:
+		//
+		//     Responses:
+		//       201: MessageCreatedResponse
+		//       400: BadRequestError
+		//       401: UnauthorizedError
+		//       500: InternalServerError
+		h.handleCreate(w, r)
+	case isCollection && r.Method == http.MethodGet:
+		// swagger:route GET /v1/messages messages MessagesFind
+		//
+		// Get page of messages matching requested tags, from the newest to the oldest.
+		//
+		// Messages associated with all tag parameters, with at least one of any parameters and with none of not parameters are listed,
+		// e.g. tag=go&tag=perf&not=draft. At least one tag or any parameter is required. Unknown tag or any tags are not found.
+		//
+		//     Responses:
+		//       200: MessagesCollectionResponse
+		//       400: BadRequestError
+		//       404: NotFoundError
+		//       500: InternalServerError
+		h.handleFind(w, r)
+	case isStream && r.Method == http.MethodGet:
+		// swagger:route GET /v1/messages/stream messages MessagesStream
+		//
+		// Stream messages associated with any of requested tags as Server-Sent Events, as they are created.
+		//
+		// Each event has type "message", its data is the message and its ID is a cursor of the message.
+		// Stream resumed with Last-Event-ID header starts with up to 100 messages missed in the meantime, from the oldest.
+		// Heartbeat comments are sent while stream is idle.
+		//
+		//     Produces:
+		//     - text/event-stream
+		//
+		//     Responses:
+		//       200: MessagesStreamResponse
+		//       400: BadRequestError
+		//       500: InternalServerError
+		//       501: NotImplementedError
+		h.handleStream(w, r)
+	case isSocket && r.Method == http.MethodGet:
+		// swagger:route GET /v1/messages/ws messages MessagesSocket
+		//
+		// Upgrade the connection to WebSocket, for real-time messaging.
+		//
+		// Client sends commands as JSON text messages: {"type": "subscribe"|"unsubscribe", "id": "1", "tags": ["tagA"]}
+		// or {"type": "post", "id": "2", "message": MessageIn}. Each command gets "ack" or "error" reply with the same ID.
+		// Changes of messages associated with subscribed tags are pushed as {"type": "created"|"updated"|"deleted", "message": MessageOut}.
+		// Messages are posted on behalf of the user authenticated by the opening handshake.
+		//
+		//     Responses:
+		//       101: SocketSwitchingResponse
+		//       400: BadRequestError
+		//       500: InternalServerError
+		//       501: NotImplementedError
+		h.handleSocket(w, r)
+	case isRevisions && r.Method == http.MethodGet:
+		// swagger:route GET /v1/messages/{id}/revisions messages MessageRevisions
+		//
+		// Get all versions of single message, from the oldest. The last one is the current version.
+		//
+		//     Responses:
+		//       200: MessageRevisionsResponse
+		//       404: NotFoundError
+		//       500: InternalServerError
+		//       501: NotImplementedError
+		h.handleRevisions(w, r)
+	case isItem && r.Method == http.MethodGet:
+		// swagger:route GET /v1/messages/{id} messages MessageRead
+		//
+		// Get details of single message by its ID.
+		//
+		//     Responses:
+		//       200: MessageReadResponse
+		//       404: NotFoundError
+		//       500: InternalServerError
+		h.handleRead(w, r)
+	case isItem && r.Method == http.MethodPut:
+		// swagger:route PUT /v1/messages/{id} messages MessageUpdate
+		//
+		// Replace body and tags of the message. Only author of the message and moderators are allowed to do it.
+		//
+		//     Security:
+		//       api_key:
+		//       bearer:
+		//
+		//     Responses:
+		//       200: MessageReadResponse
+		//       400: BadRequestError
+		//       401: UnauthorizedError
+		//       403: ForbiddenError
+		//       404: NotFoundError
+		//       500: InternalServerError
+		h.handleUpdate(w, r)
+	case isItem && r.Method == http.MethodPatch:
+		// swagger:route PATCH /v1/messages/{id} messages MessagePatch
+		//
+		// Change body and/or tags of the message. Only author of the message and moderators are allowed to do it.
+		//
+		//     Security:
+		//       api_key:
+		//       bearer:
+		//
+		//     Responses:
+		//       200: MessageReadResponse
+		//       400: BadRequestError
+		//       401: UnauthorizedError
+		//       403: ForbiddenError
+		//       404: NotFoundError
+		//       500: InternalServerError
+		h.handlePatch(w, r)
+	case isItem && r.Method == http.MethodDelete:
+		// swagger:route DELETE /v1/messages/{id} messages MessageDelete
+		//
+		// Delete the message along with its history. Only author of the message and moderators are allowed to do it.
+		//
+		//     Security:
+		//       api_key:
+		//       bearer:
+		//
+		//     Responses:
+		//       204: MessageDeletedResponse
+		//       401: UnauthorizedError
+		//       403: ForbiddenError
+		//       404: NotFoundError
+		//       500: InternalServerError
+		//       501: NotImplementedError
+		h.handleDelete(w, r)
+	case isCollection:
+		handleMethodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+	case isStream, isSocket, isRevisions:
+		handleMethodNotAllowed(w, r, http.MethodGet)
+	case isItem:
+		handleMethodNotAllowed(w, r, http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete)
+	default:
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
+	}
+}
+
+// handleMethodNotAllowed responds to methods not handled by the resource.
+// Allowed methods are advertised in Allow header. OPTIONS is always allowed and succeeds.
+func handleMethodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(append(allowed, http.MethodOptions), ", "))
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	writeProblem(w, newProblem(http.StatusMethodNotAllowed, problemMethodNotAllowed, ""))
+}
+
+// writeProblem responds with error described as in RFC 7807.
+// Authentication challenge is sent along with 401 Unauthorized.
+func writeProblem(w http.ResponseWriter, p ProblemOut) {
+	if p.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", authChallenge)
+	}
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// pageLimitParse parses requested number of elements on a page.
+// Default is used when v is empty. ValidationError is returned if limit is not a number within [1, max].
+func pageLimitParse(v string, def, max int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, NewValidationError(FieldError{Field: "limit", Code: FieldErrInvalid, Msg: "limit is not a number"})
+	}
+	if limit < 1 || limit > max {
+		return 0, NewValidationError(FieldError{Field: "limit", Code: FieldErrInvalid, Msg: fmt.Sprintf("limit out of range [1, %d]", max)})
+	}
+	return limit, nil
+}
+
+func (h *messagesHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var trIn MessageIn
+	err := json.NewDecoder(r.Body).Decode(&trIn)
+	if err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, problemInvalidJSON, err.Error()))
+		return
+	}
+
+	msg, _, p := h.create(r.Context(), trIn)
+	if p != nil {
+		writeProblem(w, *p)
+		return
+	}
+
+	w.Header().Set("Location", "/v1/messages/"+msg.ID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// create validates message submitted on behalf of the caller and stores it.
+// It's shared by HTTP and WebSocket endpoints. Problem is returned if message was not created.
+func (h *messagesHandler) create(ctx context.Context, trIn MessageIn) (*Message, *User, *ProblemOut) {
+	name, p := h.resolveAuthor(ctx, trIn.Author)
+	if p != nil {
+		return nil, nil, p
+	}
+	trIn.Author = name
+	if err := trIn.Validate(); err != nil {
+		p := newValidationProblem(err)
+		return nil, nil, &p
+	}
+
+	author, err := h.Storer.UserFindByName(ctx, trIn.Author)
+	switch err {
+	case nil:
+	case ErrElementNotFound:
+		p := newValidationProblem(NewValidationError(FieldError{Field: "author", Code: FieldErrUnknown, Msg: "unknown Author"}))
+		return nil, nil, &p
+	default:
+		p := newProblem(http.StatusInternalServerError, problemInternal, "")
+		return nil, nil, &p
+	}
+
+	now := h.TimeNow().UTC()
+	msg := Message{
+		ID:         uuid.NewV1().String(),
+		Body:       trIn.Body,
+		Tags:       trIn.AllTags(),
+		AuthorID:   author.ID,
+		CreatedAt:  now,
+		ModifiedAt: now,
+	}
+	if err := h.annotate(ctx, &msg); err != nil {
+		p := newProblem(http.StatusInternalServerError, problemInternal, "")
+		return nil, nil, &p
+	}
+
+	if err := h.Storer.MsgSave(ctx, &msg); err != nil {
+		p := newProblem(http.StatusInternalServerError, problemInternal, "")
+		return nil, nil, &p
+	}
+	return &msg, author, nil
+}
+
+// annotate attaches hashtags found in the body of the message to its tags and resolves users mentioned in it.
+// Hashtags attached for the previous body are detached first, tags given explicitly are kept.
+// Names which do not belong to any user are not mentions, user mentioned by many names is mentioned once.
+// Storage error is returned on failure.
+func (h *messagesHandler) annotate(ctx context.Context, m *Message) error {
+	m.Tags, m.Hashtags = msgTagsWithHashtags(msgTagsWithoutHashtags(m.Tags, m.Hashtags), m.Body)
+
+	m.Mentions = nil
+	seen := make(map[string]bool)
+	for _, name := range msgMentionedNames(m.Body) {
+		u, err := h.Storer.UserFindByName(ctx, name)
+		switch err {
+		case nil:
+			// names differing by case or look-alike characters belong to the same user
+			if !seen[u.ID] {
+				seen[u.ID] = true
+				m.Mentions = append(m.Mentions, u.ID)
+			}
+		case ErrElementNotFound:
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// msgToTransport converts message into its transport model.
+// Author is nil for messages anonymised on removal of the user.
+func msgToTransport(msg *Message, author *User) MessageOut {
+	trOut := MessageOut{
+		ID:         msg.ID,
+		Body:       msg.Body,
+		Tags:       msg.Tags,
+		CreatedAt:  msg.CreatedAt,
+		ModifiedAt: msg.ModifiedAt,
+	}
+	if len(msg.Tags) > 0 {
+		trOut.Tag = msg.Tags[0]
+	} else {
+		trOut.Tags = []Tag{}
+	}
+	if author != nil {
+		trOut.Author = author.Name
+	}
+	return trOut
+}
+
+func (h *messagesHandler) handleFind(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	tq, err := msgTagQueryParse(q)
+	if err != nil {
+		writeProblem(w, newValidationProblem(err))
+		return
+	}
+
+	limit, after, err := msgsPageParse(q)
+	if err != nil {
+		writeProblem(w, newValidationProblem(err))
+		return
+	}
+
+	// one more is requested to find out if there is a next page
+	msgsIDs, err := h.Storer.MsgsIDsFindByTags(r.Context(), tq, after, limit+1)
+
+	switch err {
+	case nil:
+	case ErrElementNotFound:
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
+		return
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+
+	trOut, err := msgsPageLoad(r.Context(), h.Storer, h.Storer, msgsIDs, limit)
+	if err != nil {
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(trOut)
+}
+
+// msgsPageParse parses requested size of the page of messages and the cursor it starts after.
+// ValidationError is returned if either of them is invalid.
+func msgsPageParse(q url.Values) (int, MsgCursor, error) {
+	limit, err := pageLimitParse(q.Get("limit"), msgsPageLimitDefault, msgsPageLimitMax)
+	if err != nil {
+		return 0, MsgCursor{}, err
+	}
+
+	var after MsgCursor
+	if v := q.Get("cursor"); v != "" {
+		after, err = decodeMsgCursor(v)
+		if err != nil {
+			return 0, MsgCursor{}, NewValidationError(FieldError{Field: "cursor", Code: FieldErrInvalid, Msg: err.Error()})
+		}
+	}
+	return limit, after, nil
+}
+
+// msgsPageLoad loads messages of the page along with their authors.
+// IDs shall be found with limit+1, the one over the limit only tells that there is a next page.
+func msgsPageLoad(ctx context.Context, ms MsgStorer, us UserStorer, msgsIDs []string, limit int) (MessagesPageOut, error) {
+	hasNext := len(msgsIDs) > limit
+	if hasNext {
+		msgsIDs = msgsIDs[:limit]
+	}
+
+	msgs, err := ms.MsgLoadMany(ctx, msgsIDs)
+	if err != nil {
+		return MessagesPageOut{}, err
+	}
+
+	authors, err := loadAuthors(ctx, us, msgs)
+	if err != nil {
+		return MessagesPageOut{}, err
+	}
+
+	trOut := MessagesPageOut{
+		Messages: make(MessagesCollectionOut, 0, len(msgs)),
+	}
+	for _, msg := range msgs {
+		trOut.Messages = append(trOut.Messages, msgToTransport(msg, authors[msg.AuthorID]))
+	}
+	if hasNext {
+		trOut.Next = encodeMsgCursor(MsgCursorOf(msgs[len(msgs)-1]))
+	}
+	return trOut, nil
+}
+
+// msgTagQueryParse builds tag query of repeated tag (all of them), any (at least one of them) and not (none of them) parameters.
+// Empty values and duplicates are ignored. ValidationError is returned if neither tag nor any is given, or there are too many tags.
+func msgTagQueryParse(q url.Values) (MsgTagQuery, error) {
+	tq := MsgTagQuery{
+		All: msgTagsParam(q["tag"]),
+		Any: msgTagsParam(q["any"]),
+		Not: msgTagsParam(q["not"]),
+	}
+	if tq.IsEmpty() {
+		return tq, NewValidationError(FieldError{Field: "tag", Code: FieldErrRequired, Msg: "tag or any is required"})
+	}
+	if n := len(tq.All) + len(tq.Any) + len(tq.Not); n > msgsFindTagsMax {
+		return tq, NewValidationError(FieldError{Field: "tag", Code: FieldErrInvalid, Msg: fmt.Sprintf("too many tags, up to %d are allowed", msgsFindTagsMax)})
+	}
+	return tq, nil
+}
+
+// msgTagsParam converts values of query parameter into tags, dropping empty ones and duplicates.
+func msgTagsParam(vals []string) []Tag {
+	tags := make([]Tag, 0, len(vals))
+	for _, v := range vals {
+		if v != "" {
+			tags = append(tags, Tag(v))
+		}
+	}
+	return msgTagsMerge("", tags)
+}
+
+// loadAuthors retrieves authors of all messages at once.
+// Returned map is keyed by User.ID. Anonymised messages have no author in it, neither have messages whose author is gone,
+// e.g. message saved while its author was removed.
+func loadAuthors(ctx context.Context, st UserStorer, msgs []*Message) (map[string]*User, error) {
+	out := make(map[string]*User)
+	var ids []string
+	for _, msg := range msgs {
+		if _, found := out[msg.AuthorID]; found || msg.AuthorID == "" {
+			continue
+		}
+		out[msg.AuthorID] = nil
+		ids = append(ids, msg.AuthorID)
+	}
+
+	users, err := st.UserLoadMany(ctx, ids)
+	if err == ErrElementNotFound {
+		// batch fails as a whole, so authors are loaded one by one to tell which of them are gone
+		users, err = loadAuthorsFound(ctx, st, ids)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		out[u.ID] = u
+	}
+	return out, nil
+}
+
+// loadAuthorsFound retrieves users one by one, skipping those which are not found.
+func loadAuthorsFound(ctx context.Context, st UserStorer, ids []string) ([]*User, error) {
+	users := make([]*User, 0, len(ids))
+	for _, id := range ids {
+		switch u, err := st.UserLoad(ctx, id); err {
+		case nil:
+			users = append(users, u)
+		case ErrElementNotFound:
+		default:
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+var rPathMsgStream = regexp.MustCompile(`^/v1/messages/stream/?$`)
+
+var rPathMsgSocket = regexp.MustCompile(`^/v1/messages/ws/?$`)
+
+// allowed chars in ID: 0-9a-zA-Z-_ (space is NOT allowed)
+var rPathMsgRead = regexp.MustCompile(`^/v1/messages/([\da-zA-Z\-_]+)/?$`)
+
+func (h *messagesHandler) handleRead(w http.ResponseWriter, r *http.Request) {
+	matches := rPathMsgRead.FindStringSubmatch(r.URL.Path)
+
+	// matches also have the source string on index 0
+	if len(matches) != 2 {
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
+		return
+	}
+
+	// msgID is on index 1
+	msg, err := h.Storer.MsgLoad(r.Context(), matches[1])
+	switch err {
+	case nil:
+	case ErrElementNotFound:
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
+		return
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+
+	var author *User
+	if msg.AuthorID != "" {
+		// message whose author is gone is shown as anonymised one
+		author, err = h.Storer.UserLoad(r.Context(), msg.AuthorID)
+		if err != nil && err != ErrElementNotFound {
+			writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+			return
+		}
+	}
+
+	trOut := msgToTransport(msg, author)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(trOut)
+}
+
+func (h *messagesHandler) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	var trIn MessageIn
+	if err := json.NewDecoder(r.Body).Decode(&trIn); err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, problemInvalidJSON, err.Error()))
+		return
+	}
+	var ok bool
+	if trIn.Author, ok = h.actingAuthor(w, r, trIn.Author); !ok {
+		return
+	}
+	if err := trIn.Validate(); err != nil {
+		writeProblem(w, newValidationProblem(err))
+		return
+	}
+
+	h.update(w, r, trIn.Author, func(m *Message) {
+		m.Body = trIn.Body
+		m.Tags, m.Hashtags = trIn.AllTags(), nil
+	})
+}
+
+func (h *messagesHandler) handlePatch(w http.ResponseWriter, r *http.Request) {
+	var trIn MessagePatchIn
+	if err := json.NewDecoder(r.Body).Decode(&trIn); err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, problemInvalidJSON, err.Error()))
+		return
+	}
+	var ok bool
+	if trIn.Author, ok = h.actingAuthor(w, r, trIn.Author); !ok {
+		return
+	}
+	if err := trIn.Validate(); err != nil {
+		writeProblem(w, newValidationProblem(err))
+		return
+	}
+
+	h.update(w, r, trIn.Author, func(m *Message) {
+		// hashtags of the previous body are detached on annotation, tags given explicitly stay
+		if trIn.ChangesTags() {
+			m.Tags, m.Hashtags = trIn.AllTags(), nil
+		}
+		if trIn.Body != nil {
+			m.Body = *trIn.Body
+		}
+	})
+}
+
+// update applies change to the message pointed by request path on behalf of the author and responds with the result.
+// Hashtags and mentions are found in the body of changed message again.
+func (h *messagesHandler) update(w http.ResponseWriter, r *http.Request, authorName string, change func(m *Message)) {
+	msg, author, ok := h.loadOwned(w, r, authorName)
+	if !ok {
+		return
+	}
+
+	// stored message is shared, change is made on a copy
+	msgNew := *msg
+	change(&msgNew)
+	msgNew.ModifiedAt = h.TimeNow().UTC()
+	if err := h.annotate(r.Context(), &msgNew); err != nil {
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+
+	switch err := h.Storer.MsgUpdate(r.Context(), &msgNew); err {
+	case nil:
+	case ErrElementNotFound:
+		// message was removed in the meantime
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
+		return
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(msgToTransport(&msgNew, author))
+}
+
+func (h *messagesHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	authorName, ok := h.actingAuthor(w, r, r.URL.Query().Get("author"))
+	if !ok {
+		return
+	}
+	if authorName == "" {
+		writeProblem(w, newValidationProblem(NewValidationError(FieldError{Field: "author", Code: FieldErrRequired, Msg: "missing Author"})))
+		return
+	}
+
+	msg, _, ok := h.loadOwned(w, r, authorName)
+	if !ok {
+		return
+	}
+
+	switch err := h.Storer.MsgDelete(r.Context(), msg.ID); err {
+	case nil:
+	case ErrElementNotFound:
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
+		return
+	case ErrNotSupported:
+		writeProblem(w, newProblem(http.StatusNotImplemented, problemNotImplemented, "operation is not supported by the storage"))
+		return
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// actingAuthor returns name of the user on whose behalf the request is made, see resolveAuthor.
+// Response is written and false returned on failure.
+func (h *messagesHandler) actingAuthor(w http.ResponseWriter, r *http.Request, claimed string) (string, bool) {
+	name, p := h.resolveAuthor(r.Context(), claimed)
+	if p != nil {
+		writeProblem(w, *p)
+		return "", false
+	}
+	return name, true
+}
+
+// resolveAuthor returns name of the user on whose behalf the change is made.
+// Authenticated user is the author, claimed name is optional and must be the name of that user if given.
+// Claimed name is taken as is for unauthenticated requests, unless authentication is enforced, see AuthEnforced.
+// Problem is returned on failure.
+func (h *messagesHandler) resolveAuthor(ctx context.Context, claimed string) (string, *ProblemOut) {
+	var p ProblemOut
+	userID, ok := UserIDFromContext(ctx)
+	if !ok {
+		if AuthEnforced(ctx) {
+			p = newProblem(http.StatusUnauthorized, problemUnauthorized, "credentials required")
+			return "", &p
+		}
+		return claimed, nil
+	}
+
+	u, err := h.Storer.UserLoad(ctx, userID)
+	switch err {
+	case nil:
+	case ErrElementNotFound:
+		// user was removed after credentials were issued
+		p = newProblem(http.StatusUnauthorized, problemUnauthorized, "authenticated user does not exist")
+		return "", &p
+	default:
+		p = newProblem(http.StatusInternalServerError, problemInternal, "")
+		return "", &p
+	}
+
+	if claimed != "" && claimed != u.Name {
+		p = newProblem(http.StatusForbidden, problemForbidden, "author does not match authenticated user")
+		return "", &p
+	}
+	return u.Name, nil
+}
+
+// loadOwned retrieves message pointed by request path and makes sure it was authored by the user with given name.
+// Authenticated moderators are allowed to act on messages of others. Author of the message is returned,
+// it's nil for anonymised message. Response is written and false returned on failure.
+func (h *messagesHandler) loadOwned(w http.ResponseWriter, r *http.Request, authorName string) (*Message, *User, bool) {
+	// msgID is on index 1, route is only taken on match
+	msgID := rPathMsgRead.FindStringSubmatch(r.URL.Path)[1]
+
+	msg, err := h.Storer.MsgLoad(r.Context(), msgID)
+	switch err {
+	case nil:
+	case ErrElementNotFound:
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
+		return nil, nil, false
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return nil, nil, false
+	}
+
+	author, err := h.Storer.UserFindByName(r.Context(), authorName)
+	switch err {
+	case nil:
+	case ErrElementNotFound:
+		writeProblem(w, newProblem(http.StatusForbidden, problemForbidden, "only author of the message is allowed to change it"))
+		return nil, nil, false
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return nil, nil, false
+	}
+
+	if author.ID == msg.AuthorID {
+		return msg, author, true
+	}
+
+	// roles are taken into account for authenticated requests only, otherwise anyone could claim to be a moderator
+	if _, ok := UserIDFromContext(r.Context()); !ok || !h.Authz.Can(author, PermMsgChangeAny) {
+		writeProblem(w, newProblem(http.StatusForbidden, problemForbidden, "only author of the message is allowed to change it"))
+		return nil, nil, false
+	}
+
+	// moderator acts on behalf of the author, who may be gone already
+	if msg.AuthorID == "" {
+		return msg, nil, true
+	}
+	owner, err := h.Storer.UserLoad(r.Context(), msg.AuthorID)
+	switch err {
+	case nil:
+	case ErrElementNotFound:
+		owner = nil
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return nil, nil, false
+	}
+	return msg, owner, true
+}
+
+var rPathMsgRevisions = regexp.MustCompile(`^/v1/messages/([\da-zA-Z\-_]+)/revisions/?$`)
+
+func (h *messagesHandler) handleRevisions(w http.ResponseWriter, r *http.Request) {
+	// msgID is on index 1, route is only taken on match
+	msgID := rPathMsgRevisions.FindStringSubmatch(r.URL.Path)[1]
+
+	msg, err := h.Storer.MsgLoad(r.Context(), msgID)
+	switch err {
+	case nil:
+	case ErrElementNotFound:
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
+		return
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+
+	revs, err := h.Storer.MsgRevisions(r.Context(), msgID)
+	switch err {
+	case nil:
+	case ErrElementNotFound:
+		// message was removed in the meantime
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
+		return
+	case ErrNotSupported:
+		writeProblem(w, newProblem(http.StatusNotImplemented, problemNotImplemented, "operation is not supported by the storage"))
+		return
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+	revs = append(revs, msg)
+
+	authors, err := loadAuthors(r.Context(), h.Storer, revs)
+	if err != nil {
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+
+	trOut := make(MessagesCollectionOut, 0, len(revs))
+	for _, rev := range revs {
+		trOut = append(trOut, msgToTransport(rev, authors[rev.AuthorID]))
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
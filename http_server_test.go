@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	a "github.com/stretchr/testify/assert"
 	ar "github.com/stretchr/testify/require"
@@ -34,14 +38,39 @@ func Test_HTTPHandler_User_Create_Success(t *testing.T) {
 
 	// THEN: validate response
 	ar.NoError(t, err, "unexpected error from HTTP client")
-	a.EqualValues(t, 0, res.ContentLength, "non empty response body")
-	a.Equal(t, http.StatusCreated, res.StatusCode, "mismatch on response code")
+	ar.Equal(t, http.StatusCreated, res.StatusCode, "mismatch on response code")
 
 	// AND: validate storage
-	userGot, err := st.UserFindByName(tfTrInUserA.Name)
+	userGot, err := st.UserFindByName(context.Background(), tfTrInUserA.Name)
 	ar.NoError(t, err, "unexpected error on user seek")
 	a.Equal(t, tfTrInUserA.Name, userGot.Name, "User: mismatch in Name")
 	a.NotZero(t, userGot.ID, "User: zero ID")
+
+	// AND: new user is pointed and returned
+	a.Equal(t, "/v1/users/"+userGot.ID, res.Header.Get("Location"), "mismatch on Location header")
+	var trOut UserOut
+	ar.NoError(t, json.NewDecoder(res.Body).Decode(&trOut), "unexpected error on response decode")
+	a.Equal(t, UserOut{ID: userGot.ID, Name: tfTrInUserA.Name, Roles: []Role{RoleUser}}, trOut, "mismatch on response body")
+}
+
+func Test_HTTPHandler_User_Create_Success_Normalized(t *testing.T) {
+	st := NewMemoryStorage()
+	h := NewHTTPDefaultHandler(st)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// WHEN: name is given in decomposed form
+	res, err := http.Post(fmt.Sprintf("%s/v1/users", ts.URL), "application/json", strings.NewReader(`{"name": "Zoe\u0308"}`))
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	ar.Equal(t, http.StatusCreated, res.StatusCode, "mismatch on response code")
+
+	// THEN: it's stored in normalisation form C
+	var trOut UserOut
+	ar.NoError(t, json.NewDecoder(res.Body).Decode(&trOut), "unexpected error on response decode")
+	a.Equal(t, "Zo\u00eb", trOut.Name, "mismatch on name returned")
+	userGot, err := st.UserLoad(context.Background(), trOut.ID)
+	ar.NoError(t, err, "unexpected error on user load")
+	a.Equal(t, "Zo\u00eb", userGot.Name, "mismatch on name stored")
 }
 
 func Test_HTTPHandler_User_Create_Failure(t *testing.T) {
@@ -67,11 +96,28 @@ func Test_HTTPHandler_User_Create_Failure(t *testing.T) {
 			reqBody:   `{"name": "A"}`,
 			resStatus: http.StatusBadRequest,
 		},
-		"already exists": {
-			reqBody:   `{"name": "UserA-Name"}`,
-			dbUsers:   []User{tfUserA},
+		"validation: invisible character": {
+			reqBody:   `{"name": "User\u200bA"}`,
 			resStatus: http.StatusBadRequest,
 		},
+		"already exists": {
+			reqBody:     `{"name": "UserA-Name"}`,
+			dbUsers:     []User{tfUserA},
+			usCalledExp: true,
+			resStatus:   http.StatusConflict,
+		},
+		"already exists: case": {
+			reqBody:     `{"name": "usera-NAME"}`,
+			dbUsers:     []User{tfUserA},
+			usCalledExp: true,
+			resStatus:   http.StatusConflict,
+		},
+		"already exists: look-alike": {
+			reqBody:     `{"name": "UserА-Nаme"}`,
+			dbUsers:     []User{tfUserA},
+			usCalledExp: true,
+			resStatus:   http.StatusConflict,
+		},
 		"JSON: malformed": {
 			reqBody:   `NotA-JSON`,
 			resStatus: http.StatusBadRequest,
@@ -80,6 +126,12 @@ func Test_HTTPHandler_User_Create_Failure(t *testing.T) {
 			reqBody:   `[{"A":1},{"B":"123"}]`,
 			resStatus: http.StatusBadRequest,
 		},
+		"UserSave error: duplicated": {
+			reqBody:     `{"name": "ABCDE"}`,
+			usErr:       ErrElementDuplicated,
+			usCalledExp: true,
+			resStatus:   http.StatusConflict,
+		},
 		"UserSave error": {
 			reqBody:     `{"name": "ABCDE"}`,
 			usErr:       errors.New("some kind of DB error"),
@@ -98,7 +150,7 @@ func Test_HTTPHandler_User_Create_Failure(t *testing.T) {
 		// GIVEN: expected users are in DB
 		for _, u := range tc.dbUsers {
 			uC := u
-			ar.NoError(t, st.UserSave(&uC), "case: %s", sym)
+			ar.NoError(t, st.UserSave(context.Background(), &uC), "case: %s", sym)
 		}
 		st.inUserSaveCalled = false
 
@@ -107,7 +159,7 @@ func Test_HTTPHandler_User_Create_Failure(t *testing.T) {
 
 		// THEN: validate response
 		ar.NoError(t, err, "[%s] unexpected error from HTTP client", sym)
-		a.EqualValues(t, 0, res.ContentLength, "[%s] non empty response body", sym)
+		tsAssertProblem(t, res.StatusCode, res.Header, res.Body, sym)
 		a.Equal(t, tc.resStatus, res.StatusCode, "[%s] mismatch on response code", sym)
 
 		// AND: validate storage access
@@ -115,427 +167,2134 @@ func Test_HTTPHandler_User_Create_Failure(t *testing.T) {
 	}
 }
 
-func Test_HTTPHandler_Message_Create_Success(t *testing.T) {
+func Test_HTTPHandler_User_Create_ContextCancelled(t *testing.T) {
+	st := NewMemoryStorage()
+	h := NewHTTPDefaultHandler(st)
+
+	// GIVEN: client is gone before request is processed
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, err := http.NewRequest(http.MethodPost, "/v1/users", strings.NewReader(tfTrInUserA_JSON))
+	ar.NoError(t, err, "unexpected error from request creation")
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req.WithContext(ctx))
+
+	// THEN: user is not created
+	a.NotEqual(t, http.StatusCreated, res.Code, "mismatch on response code")
+	_, err = st.UserFindByName(context.Background(), tfTrInUserA.Name)
+	a.Equal(t, ErrElementNotFound, err, "user created with cancelled context")
+}
+
+func Test_HTTPHandler_User_Read_Success_Found(t *testing.T) {
 	st := NewMemoryStorage()
 	h := NewHTTPDefaultHandler(st)
 	ts := httptest.NewServer(h)
 	defer ts.Close()
 
-	// GIVEN: author match existing user
-	ar.NoError(t, st.UserSave(&tfUserA))
+	// GIVEN: user is in DB
+	user := tfUserA
+	ar.NoError(t, st.UserSave(context.Background(), &user))
 
-	bR := strings.NewReader(tfTrInMsgAA_JSON)
-	res, err := http.Post(fmt.Sprintf("%s/v1/messages", ts.URL), "application/json", bR)
+	res, err := http.Get(fmt.Sprintf("%s/v1/users/%s", ts.URL, user.ID))
 
 	// THEN: validate response
 	ar.NoError(t, err, "unexpected error from HTTP client")
-	a.EqualValues(t, 0, res.ContentLength, "non empty response body")
-	a.Equal(t, http.StatusCreated, res.StatusCode, "mismatch on response code")
+	a.Equal(t, http.StatusOK, res.StatusCode, "mismatch on response code")
+	a.Equal(t, "application/json", res.Header.Get("Content-Type"), "mismatch on response content encoding")
 
-	matches := rPathMsgRead.FindStringSubmatch(res.Header.Get("Location"))
-	// matches also have the source string on index 0
-	ar.Len(t, matches, 2, "response: location header does not point to message read action")
-	msgIDFromHeader := matches[1]
+	var resBodyGot UserOut
+	err = json.NewDecoder(res.Body).Decode(&resBodyGot)
+	res.Body.Close()
+	ar.NoError(t, err, "unexpected error on response body read")
 
-	// AND: validate tag association
-	msgsIDs, err := st.MsgsIDsFindByTag(tfTrInMsgAA.Tag)
-	ar.NoError(t, err, "unexpected error on tag seek")
-	ar.Len(t, msgsIDs, 1, "incorrect number of messages associated to tag returned")
+	a.Equal(t, tfTrOutUserA, resBodyGot)
+}
 
-	// AND: validate message stored
-	msgGot, err := st.MsgLoad(msgsIDs[0])
-	ar.NoError(t, err, "unexpected error on message load")
-	a.Equal(t, tfMsgAA.AuthorID, msgGot.AuthorID, "message AuthorID mismatch")
-	a.Equal(t, tfMsgAA.Body, msgGot.Body, "message Body mismatch")
-	a.Equal(t, tfMsgAA.Tag, msgGot.Tag, "message Tag mismatch")
-	a.Equal(t, msgIDFromHeader, msgGot.ID, "message ID mismatch")
+func Test_HTTPHandler_User_Read_Success_NotFound(t *testing.T) {
+	st := NewMemoryStorage()
+	h := NewHTTPDefaultHandler(st)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// GIVEN: user NOT in DB
+	res, err := http.Get(fmt.Sprintf("%s/v1/users/non-existing-123", ts.URL))
+
+	// THEN: validate response
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	a.Equal(t, http.StatusNotFound, res.StatusCode, "mismatch on response code")
+	tsAssertProblem(t, res.StatusCode, res.Header, res.Body, "user not found")
 }
 
-func Test_HTTPHandler_Message_Create_Failure(t *testing.T) {
-	var ts *httptest.Server
-	defer func() {
-		if ts != nil {
-			ts.Close()
-		}
-	}()
+func Test_HTTPHandler_User_List_Success_Paging(t *testing.T) {
+	st := NewMemoryStorage()
+	h := NewHTTPDefaultHandler(st)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// GIVEN: users are in DB
+	users := []User{tfUserA, tfUserB, {ID: "UserC-ID", Name: "UserC-Name"}}
+	for _, u := range users {
+		uC := u
+		ar.NoError(t, st.UserSave(context.Background(), &uC))
+	}
+
+	// WHEN: first page is requested
+	res, err := http.Get(fmt.Sprintf("%s/v1/users?limit=2", ts.URL))
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	ar.Equal(t, http.StatusOK, res.StatusCode, "mismatch on response code")
+
+	var page UsersPageOut
+	ar.NoError(t, json.NewDecoder(res.Body).Decode(&page), "unexpected error on response body read")
+	res.Body.Close()
+
+	// THEN: users are ordered by ID and next page is pointed
+	a.Equal(t, []UserOut{tfTrOutUserA, tfTrOutUserB}, page.Users, "first page mismatch")
+	ar.NotEmpty(t, page.Next, "missing cursor to the next page")
+
+	// WHEN: next page is requested
+	res, err = http.Get(fmt.Sprintf("%s/v1/users?limit=2&cursor=%s", ts.URL, page.Next))
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	ar.Equal(t, http.StatusOK, res.StatusCode, "mismatch on response code")
+
+	page = UsersPageOut{}
+	ar.NoError(t, json.NewDecoder(res.Body).Decode(&page), "unexpected error on response body read")
+	res.Body.Close()
+
+	// THEN: it's the last one
+	a.Equal(t, []UserOut{{ID: "UserC-ID", Name: "UserC-Name", Roles: []Role{RoleUser}}}, page.Users, "last page mismatch")
+	a.Empty(t, page.Next, "cursor on the last page")
+}
+
+func Test_HTTPHandler_User_List_Success_ByName(t *testing.T) {
+	st := NewMemoryStorage()
+	h := NewHTTPDefaultHandler(st)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// GIVEN: users are in DB
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		ar.NoError(t, st.UserSave(context.Background(), &uC))
+	}
+
+	res, err := http.Get(fmt.Sprintf("%s/v1/users?name=%s", ts.URL, tfUserB.Name))
+
+	// THEN: only the matching user is returned
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	ar.Equal(t, http.StatusOK, res.StatusCode, "mismatch on response code")
+
+	var page UsersPageOut
+	ar.NoError(t, json.NewDecoder(res.Body).Decode(&page), "unexpected error on response body read")
+	res.Body.Close()
+	a.Equal(t, UsersPageOut{Users: []UserOut{tfTrOutUserB}}, page, "page mismatch")
+
+	// AND: unknown name is not found
+	res, err = http.Get(fmt.Sprintf("%s/v1/users?name=UserX-Name", ts.URL))
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	a.Equal(t, http.StatusNotFound, res.StatusCode, "mismatch on response code")
+}
 
+func Test_HTTPHandler_User_List_Failure(t *testing.T) {
 	tests := map[string]struct {
-		reqBody     string
-		dbUsers     []User
-		ufErr       error // uf = UserFind
-		ufCalledExp bool
-		msErr       error // ms = MsgSave
-		msCalledExp bool
+		query       string
+		ulErr       error // ul = UsersList
+		ulCalledExp bool
 		resStatus   int
 	}{
-		"JSON: malformed": {
-			reqBody:   `NotA-JSON`,
-			resStatus: http.StatusBadRequest,
-		},
-		"JSON: type not matching": {
-			reqBody:   `[{"A":1},{"B":"123"}]`,
-			resStatus: http.StatusBadRequest,
-		},
-		"validation: empty object/name empty": {
-			reqBody:   `{}`,
-			resStatus: http.StatusBadRequest,
-		},
-		"unknown author": {
-			reqBody:     `{"author": "UserUnknown-Name","body":"qweasd","tag":"tagA"}`,
-			dbUsers:     []User{tfUserA},
-			ufCalledExp: true,
-			resStatus:   http.StatusBadRequest,
-		},
-		"MsgSave error": {
-			reqBody:     tfTrInMsgAA_JSON,
-			dbUsers:     []User{tfUserA},
-			ufCalledExp: true,
-			msErr:       errors.New("some kind of DB error"),
-			msCalledExp: true,
+		"limit: not a number": {query: "limit=abc", resStatus: http.StatusBadRequest},
+		"limit: zero":         {query: "limit=0", resStatus: http.StatusBadRequest},
+		"limit: too big":      {query: "limit=101", resStatus: http.StatusBadRequest},
+		"cursor: not base64":  {query: "cursor=%21%21", resStatus: http.StatusBadRequest},
+		"UsersList error": {
+			ulErr:       errors.New("some kind of DB error"),
+			ulCalledExp: true,
 			resStatus:   http.StatusInternalServerError,
 		},
-		"UserFind error": {
-			reqBody:     tfTrInMsgAA_JSON,
-			ufCalledExp: true,
-			ufErr:       errors.New("some kind of DB error"),
-			msCalledExp: false,
-			resStatus:   http.StatusInternalServerError,
+		"UsersList error: not supported": {
+			ulErr:       ErrNotSupported,
+			ulCalledExp: true,
+			resStatus:   http.StatusNotImplemented,
 		},
 	}
 
 	for sym, tc := range tests {
 		st := NewTmMemoryStorageMock()
-		st.outUserFindErr = tc.ufErr
-		st.outMsgSaveErr = tc.msErr
-
-		h := NewHTTPDefaultHandler(st)
-		ts = httptest.NewServer(h)
-
-		// GIVEN: expected users are in DB
-		for _, u := range tc.dbUsers {
-			uC := u
-			ar.NoError(t, st.UserSave(&uC), "case: %s", sym)
-		}
-		st.inUserSaveCalled = false
+		st.outUsersListErr = tc.ulErr
 
-		// WHEN: message create is called
-		res, err := http.Post(fmt.Sprintf("%s/v1/messages", ts.URL), "application/json", strings.NewReader(tc.reqBody))
+		req, err := http.NewRequest(http.MethodGet, "/v1/users?"+tc.query, nil)
+		ar.NoError(t, err)
+		res := httptest.NewRecorder()
+		NewHTTPDefaultHandler(st).ServeHTTP(res, req)
 
 		// THEN: validate response
-		ar.NoError(t, err, "[%s] unexpected error from HTTP client", sym)
-		a.EqualValues(t, 0, res.ContentLength, "[%s] non empty response body", sym)
-		a.Equal(t, tc.resStatus, res.StatusCode, "[%s] mismatch on response code", sym)
+		a.Equal(t, tc.resStatus, res.Code, "[%s] mismatch on response code", sym)
+		tsAssertProblem(t, res.Code, res.Header(), res.Body, sym)
 
 		// AND: validate storage access
-		a.Equal(t, tc.ufCalledExp, st.inUserFindCalled, "[%s] UserFind function call status mismatch", sym)
-		a.Equal(t, tc.msCalledExp, st.inMsgSaveCalled, "[%s] MsgSave function call status mismatch", sym)
+		a.Equal(t, tc.ulCalledExp, st.inUsersListCalled, "[%s] UsersList function call status mismatch", sym)
 	}
 }
 
-func Test_HTTPHandler_Message_Find_Success_Found(t *testing.T) {
-	var ts *httptest.Server
-	defer func() {
-		if ts != nil {
-			ts.Close()
-		}
-	}()
+func Test_HTTPHandler_User_Rename_Success(t *testing.T) {
+	st := NewMemoryStorage()
+	h := NewHTTPDefaultHandler(st)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// GIVEN: user with message is in DB
+	user := tfUserA
+	ar.NoError(t, st.UserSave(context.Background(), &user))
+	msg := tfMsgAA
+	ar.NoError(t, st.MsgSave(context.Background(), &msg))
 
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/v1/users/%s", ts.URL, user.ID), strings.NewReader(`{"name":"UserA-Renamed"}`))
+	ar.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+
+	// THEN: validate response
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	ar.Equal(t, http.StatusOK, res.StatusCode, "mismatch on response code")
+
+	var resBodyGot UserOut
+	ar.NoError(t, json.NewDecoder(res.Body).Decode(&resBodyGot), "unexpected error on response body read")
+	res.Body.Close()
+	a.Equal(t, UserOut{ID: user.ID, Name: "UserA-Renamed", Roles: []Role{RoleUser}}, resBodyGot, "mismatch on response body")
+
+	// AND: ID is kept, so messages are presented with the new name
+	res, err = http.Get(fmt.Sprintf("%s/v1/messages/%s", ts.URL, msg.ID))
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	var msgGot MessageOut
+	ar.NoError(t, json.NewDecoder(res.Body).Decode(&msgGot), "unexpected error on response body read")
+	res.Body.Close()
+	a.Equal(t, "UserA-Renamed", msgGot.Author, "message author not renamed")
+
+	// AND: old name is free
+	_, err = st.UserFindByName(context.Background(), tfUserA.Name)
+	a.Equal(t, ErrElementNotFound, err, "old name still in use")
+}
+
+func Test_HTTPHandler_User_Delete_Success(t *testing.T) {
 	tests := map[string]struct {
-		dbUsers []User
-		dbMsg   []Message
-		tag     Tag
-		exp     MessagesCollectionOut
+		policy       UserDeletePolicy
+		dbMsg        []Message
+		msgAuthorExp string // empty when message is expected to be removed
 	}{
-		"single": {
-			[]User{tfUserA},
-			[]Message{tfMsgAA},
-			tfTrInMsgAA.Tag,
-			MessagesCollectionOut{tfTrOutMsgAA},
+		"reject: no messages": {
+			policy: UserDeleteReject,
 		},
-		"two": {
-			[]User{tfUserA},
-			[]Message{tfMsgAA, tfMsgAB},
-			tfTrInMsgAA.Tag,
-			MessagesCollectionOut{tfTrOutMsgAA, tfTrOutMsgAB},
+		"cascade": {
+			policy: UserDeleteCascade,
+			dbMsg:  []Message{tfMsgAA, tfMsgAB},
 		},
-		"three, cross user, partial": {
-			[]User{tfUserA, tfUserB},
-			[]Message{tfMsgAA, tfMsgAB, tfMsgBA, tfMsgBB},
-			tfTrInMsgAA.Tag,
-			MessagesCollectionOut{tfTrOutMsgAA, tfTrOutMsgAB, tfTrOutMsgBA},
+		"anonymise": {
+			policy: UserDeleteAnonymise,
+			dbMsg:  []Message{tfMsgAA, tfMsgAB},
 		},
 	}
 
 	for sym, tc := range tests {
 		st := NewMemoryStorage()
-		h := NewHTTPDefaultHandler(st)
-		ts = httptest.NewServer(h)
+		h := NewHTTPHandler(st, HTTPHandlerConfig{UserDeletePolicy: tc.policy})
 
-		// GIVEN: user and message are in DB
-		for _, u := range tc.dbUsers {
-			uC := u
-			ar.NoError(t, st.UserSave(&uC), "case: %s", sym)
-		}
+		// GIVEN: user with messages is in DB
+		user := tfUserA
+		ar.NoError(t, st.UserSave(context.Background(), &user), "case: %s", sym)
 		for _, m := range tc.dbMsg {
 			mC := m
-			ar.NoError(t, st.MsgSave(&mC), "case: %s", sym)
+			ar.NoError(t, st.MsgSave(context.Background(), &mC), "case: %s", sym)
 		}
 
-		res, err := http.Get(fmt.Sprintf("%s/v1/messages?tag=%s", ts.URL, tc.tag))
+		// WHEN: user is removed
+		req, err := http.NewRequest(http.MethodDelete, "/v1/users/"+user.ID, nil)
+		ar.NoError(t, err)
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
 
 		// THEN: validate response
-		if !a.NoError(t, err, "unexpected error from HTTP client") {
-			ts.Close()
-			continue
-		}
-		a.Equal(t, http.StatusOK, res.StatusCode, "mismatch on response code")
-		a.Equal(t, "application/json", res.Header.Get("Content-Type"), "mismatch on response content encoding")
+		ar.Equal(t, http.StatusNoContent, res.Code, "[%s] mismatch on response code", sym)
 
-		var resBodyGot MessagesCollectionOut
-		err = json.NewDecoder(res.Body).Decode(&resBodyGot)
-		res.Body.Close()
-		if !a.NoError(t, err, "unexpected error on response body read") {
-			ts.Close()
-			continue
-		}
+		// AND: user is gone
+		_, err = st.UserLoad(context.Background(), user.ID)
+		a.Equal(t, ErrElementNotFound, err, "[%s] user not removed", sym)
 
-		// validate message collection
-		if !a.Len(t, resBodyGot, len(tc.exp), "mismatch on number of elements returned") {
-			ts.Close()
-			continue
+		// AND: messages are handled according to the policy
+		for _, m := range tc.dbMsg {
+			req, err := http.NewRequest(http.MethodGet, "/v1/messages/"+m.ID, nil)
+			ar.NoError(t, err)
+			res := httptest.NewRecorder()
+			h.ServeHTTP(res, req)
+
+			switch tc.policy {
+			case UserDeleteCascade:
+				a.Equal(t, http.StatusNotFound, res.Code, "[%s] message not removed", sym)
+			case UserDeleteAnonymise:
+				ar.Equal(t, http.StatusOK, res.Code, "[%s] message not kept", sym)
+				var msgGot MessageOut
+				ar.NoError(t, json.NewDecoder(res.Body).Decode(&msgGot), "[%s] unexpected error on response body read", sym)
+				a.Equal(t, "", msgGot.Author, "[%s] message not anonymised", sym)
+				a.Equal(t, m.Body, msgGot.Body, "[%s] message body changed", sym)
+			}
 		}
 
-		for _, resMsgExp := range tc.exp {
-			a.Contains(t, resBodyGot, resMsgExp)
+		// AND: anonymised messages are still listed
+		if tc.policy == UserDeleteAnonymise {
+			req, err := http.NewRequest(http.MethodGet, "/v1/messages?tag="+string(tfTagA), nil)
+			ar.NoError(t, err)
+			res := httptest.NewRecorder()
+			h.ServeHTTP(res, req)
+			a.Equal(t, http.StatusOK, res.Code, "[%s] anonymised messages not listed", sym)
 		}
-
-		ts.Close()
 	}
 }
 
-func Test_HTTPHandler_Message_Find_Success_NotFound(t *testing.T) {
-	st := NewMemoryStorage()
-	h := NewHTTPDefaultHandler(st)
-	ts := httptest.NewServer(h)
-	defer ts.Close()
-
-	// GIVEN: no matching messages are in DB
-	res, err := http.Get(fmt.Sprintf("%s/v1/messages?tag=%s", ts.URL, "NON_EXISTING_TAG"))
-
-	// THEN: validate response
-	ar.NoError(t, err, "unexpected error from HTTP client")
-	a.Equal(t, http.StatusNotFound, res.StatusCode, "mismatch on response code")
-	a.EqualValues(t, 0, res.ContentLength, "non empty response body")
-}
-
-func Test_HTTPHandler_Message_Find_Failure(t *testing.T) {
-	var ts *httptest.Server
-	defer func() {
-		if ts != nil {
-			ts.Close()
-		}
-	}()
-
+func Test_HTTPHandler_User_Change_Failure(t *testing.T) {
 	tests := map[string]struct {
-		dbUsers     []User
-		dbMsg       []Message
-		tag         Tag
-		mfCalledExp bool // mf = MsgFind
-		mfErr       error
+		method      string
+		path        string
+		reqBody     string
+		uuCalledExp bool // uu = UserUpdate
+		uuErr       error
+		ulErr       error // ul = UserLoad
+		udCalledExp bool  // ud = UserDelete
+		udErr       error
+		resStatus   int
+	}{
+		"PUT: invalid JSON": {
+			method:    http.MethodPut,
+			reqBody:   "{invalid",
+			resStatus: http.StatusBadRequest,
+		},
+		"PUT: validation failed": {
+			method:    http.MethodPut,
+			reqBody:   `{"name":"A"}`,
+			resStatus: http.StatusBadRequest,
+		},
+		"PUT: name taken by other user": {
+			method:      http.MethodPut,
+			reqBody:     `{"name":"UserB-Name"}`,
+			uuCalledExp: true,
+			resStatus:   http.StatusConflict,
+		},
+		"PUT: user not found": {
+			method:    http.MethodPut,
+			path:      "/v1/users/non-existing-123",
+			reqBody:   `{"name":"UserX-Name"}`,
+			resStatus: http.StatusNotFound,
+		},
+		"PUT: UserLoad error": {
+			method:    http.MethodPut,
+			reqBody:   `{"name":"UserX-Name"}`,
+			ulErr:     errors.New("some kind of DB error"),
+			resStatus: http.StatusInternalServerError,
+		},
+		"PUT: UserUpdate error, removed in the meantime": {
+			method:      http.MethodPut,
+			reqBody:     `{"name":"UserX-Name"}`,
+			uuCalledExp: true,
+			uuErr:       ErrElementNotFound,
+			resStatus:   http.StatusNotFound,
+		},
+		"PUT: UserUpdate error": {
+			method:      http.MethodPut,
+			reqBody:     `{"name":"UserX-Name"}`,
+			uuCalledExp: true,
+			uuErr:       errors.New("some kind of DB error"),
+			resStatus:   http.StatusInternalServerError,
+		},
+		"PUT: UserUpdate error, duplicated": {
+			method:      http.MethodPut,
+			reqBody:     `{"name":"UserX-Name"}`,
+			uuCalledExp: true,
+			uuErr:       ErrElementDuplicated,
+			resStatus:   http.StatusConflict,
+		},
+		"DELETE: user not found": {
+			method:      http.MethodDelete,
+			path:        "/v1/users/non-existing-123",
+			udCalledExp: true,
+			resStatus:   http.StatusNotFound,
+		},
+		"DELETE: user has messages": {
+			method:      http.MethodDelete,
+			udCalledExp: true,
+			resStatus:   http.StatusConflict,
+		},
+		"DELETE: UserDelete error": {
+			method:      http.MethodDelete,
+			udCalledExp: true,
+			udErr:       errors.New("some kind of DB error"),
+			resStatus:   http.StatusInternalServerError,
+		},
+		"DELETE: UserDelete error, not supported": {
+			method:      http.MethodDelete,
+			udCalledExp: true,
+			udErr:       ErrNotSupported,
+			resStatus:   http.StatusNotImplemented,
+		},
+	}
+
+	for sym, tc := range tests {
+		st := NewTmMemoryStorageMock()
+		st.outUserUpdateErr = tc.uuErr
+		st.outUserDeleteErr = tc.udErr
+		st.outUserLoadErr = tc.ulErr
+
+		// GIVEN: users and message are in DB
+		for _, u := range []User{tfUserA, tfUserB} {
+			uC := u
+			ar.NoError(t, st.UserSave(context.Background(), &uC), "case: %s", sym)
+		}
+		msg := tfMsgAA
+		ar.NoError(t, st.MsgSave(context.Background(), &msg), "case: %s", sym)
+
+		path := tc.path
+		if path == "" {
+			path = "/v1/users/" + tfUserA.ID
+		}
+		req, err := http.NewRequest(tc.method, path, strings.NewReader(tc.reqBody))
+		ar.NoError(t, err)
+		res := httptest.NewRecorder()
+		NewHTTPDefaultHandler(st).ServeHTTP(res, req)
+
+		// THEN: validate response
+		a.Equal(t, tc.resStatus, res.Code, "[%s] mismatch on response code", sym)
+		tsAssertProblem(t, res.Code, res.Header(), res.Body, sym)
+
+		// AND: validate storage access
+		a.Equal(t, tc.uuCalledExp, st.inUserUpdateCalled, "[%s] UserUpdate function call status mismatch", sym)
+		a.Equal(t, tc.udCalledExp, st.inUserDeleteCalled, "[%s] UserDelete function call status mismatch", sym)
+
+		// AND: user is left intact
+		userGot, err := st.memoryStorage.UserLoad(context.Background(), tfUserA.ID)
+		if a.NoError(t, err, "[%s] user removed", sym) {
+			a.Equal(t, &tfUserA, userGot, "[%s] user changed", sym)
+		}
+	}
+}
+
+func Test_HTTPHandler_User_Mentions_Success_Paging(t *testing.T) {
+	st := NewMemoryStorage()
+	h := NewHTTPDefaultHandler(st)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// GIVEN: user B is mentioned in some of the messages
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		ar.NoError(t, st.UserSave(context.Background(), &uC))
+	}
+	for _, m := range []Message{tfMsgAA, tfMsgAB, tfMsgBA, tfMsgBB} {
+		mC := m
+		if m.ID != tfMsgBB.ID {
+			mC.Mentions = []string{tfUserB.ID}
+		}
+		ar.NoError(t, st.MsgSave(context.Background(), &mC))
+	}
+
+	// WHEN: pages are requested one after another
+	var pagesGot []MessagesCollectionOut
+	cursor := ""
+	for i := 0; i < 5; i++ {
+		res, err := http.Get(fmt.Sprintf("%s/v1/users/%s/mentions?limit=2&cursor=%s", ts.URL, tfUserB.ID, cursor))
+		ar.NoError(t, err, "unexpected error from HTTP client")
+		ar.Equal(t, http.StatusOK, res.StatusCode, "mismatch on response code")
+
+		var resBodyGot MessagesPageOut
+		err = json.NewDecoder(res.Body).Decode(&resBodyGot)
+		res.Body.Close()
+		ar.NoError(t, err, "unexpected error on response body read")
+
+		pagesGot = append(pagesGot, resBodyGot.Messages)
+		if resBodyGot.Next == "" {
+			break
+		}
+		cursor = resBodyGot.Next
+	}
+
+	// THEN: messages mentioning the user are returned, newest first
+	a.Equal(t, []MessagesCollectionOut{
+		{tfTrOutMsgBA, tfTrOutMsgAB},
+		{tfTrOutMsgAA},
+	}, pagesGot)
+
+	// AND: user who is not mentioned gets empty page
+	res, err := http.Get(fmt.Sprintf("%s/v1/users/%s/mentions", ts.URL, tfUserA.ID))
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	ar.Equal(t, http.StatusOK, res.StatusCode, "mismatch on response code")
+	var resBodyGot MessagesPageOut
+	ar.NoError(t, json.NewDecoder(res.Body).Decode(&resBodyGot), "unexpected error on response body read")
+	res.Body.Close()
+	a.Equal(t, MessagesPageOut{Messages: MessagesCollectionOut{}}, resBodyGot, "mismatch on page of user not mentioned")
+}
+
+func Test_HTTPHandler_User_Mentions_Failure(t *testing.T) {
+	tests := map[string]struct {
+		query       string
+		ulErr       error // ul = UserLoad
+		mfCalledExp bool  // mf = MsgsIDsFindByMention
+		mfErr       error
+		resStatus   int
+	}{
+		"limit invalid": {
+			query:     "?limit=0",
+			resStatus: http.StatusBadRequest,
+		},
+		"cursor invalid": {
+			query:     "?cursor=garbage",
+			resStatus: http.StatusBadRequest,
+		},
+		"user not found": {
+			ulErr:     ErrElementNotFound,
+			resStatus: http.StatusNotFound,
+		},
+		"UserLoad error": {
+			ulErr:     errors.New("some kind of DB error"),
+			resStatus: http.StatusInternalServerError,
+		},
+		"MsgsIDsFindByMention error": {
+			mfCalledExp: true,
+			mfErr:       errors.New("some kind of DB error"),
+			resStatus:   http.StatusInternalServerError,
+		},
+		"MsgsIDsFindByMention not supported": {
+			mfCalledExp: true,
+			mfErr:       ErrNotSupported,
+			resStatus:   http.StatusNotImplemented,
+		},
+	}
+
+	for sym, tc := range tests {
+		st := NewTmMemoryStorageMock()
+		h := NewHTTPDefaultHandler(st)
+
+		// GIVEN: user is in DB
+		user := tfUserA
+		ar.NoError(t, st.UserSave(context.Background(), &user), "case: %s", sym)
+		st.outUserLoadErr = tc.ulErr
+		st.outMsgFindByMentionErr = tc.mfErr
+
+		// WHEN
+		req, err := http.NewRequest(http.MethodGet, "/v1/users/"+tfUserA.ID+"/mentions"+tc.query, nil)
+		ar.NoError(t, err)
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+
+		// THEN
+		a.Equal(t, tc.resStatus, res.Code, "[%s] mismatch on response code", sym)
+		tsAssertProblem(t, res.Code, res.Header(), res.Body, sym)
+		a.Equal(t, tc.mfCalledExp, st.inMsgFindByMentionCalled, "[%s] MsgsIDsFindByMention function call status mismatch", sym)
+	}
+}
+
+func Test_HTTPHandler_User_Mentions_NotSupported(t *testing.T) {
+	// GIVEN: users handler without message storage
+	st := NewMemoryStorage()
+	h := NewUsersHandler(st)
+	user := tfUserA
+	ar.NoError(t, st.UserSave(context.Background(), &user))
+
+	req, err := http.NewRequest(http.MethodGet, "/v1/users/"+tfUserA.ID+"/mentions", nil)
+	ar.NoError(t, err)
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	a.Equal(t, http.StatusNotImplemented, res.Code, "mismatch on response code")
+	tsAssertProblem(t, res.Code, res.Header(), res.Body, "no message storage")
+}
+
+func Test_HTTPHandler_Message_Create_Success(t *testing.T) {
+	st := NewMemoryStorage()
+	h := NewHTTPDefaultHandler(st)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// GIVEN: author match existing user
+	ar.NoError(t, st.UserSave(context.Background(), &tfUserA))
+
+	bR := strings.NewReader(tfTrInMsgAA_JSON)
+	res, err := http.Post(fmt.Sprintf("%s/v1/messages", ts.URL), "application/json", bR)
+
+	// THEN: validate response
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	a.EqualValues(t, 0, res.ContentLength, "non empty response body")
+	a.Equal(t, http.StatusCreated, res.StatusCode, "mismatch on response code")
+
+	matches := rPathMsgRead.FindStringSubmatch(res.Header.Get("Location"))
+	// matches also have the source string on index 0
+	ar.Len(t, matches, 2, "response: location header does not point to message read action")
+	msgIDFromHeader := matches[1]
+
+	// AND: validate tag association
+	msgsIDs, err := st.MsgsIDsFindByTag(context.Background(), tfTrInMsgAA.Tag, MsgCursor{}, 0)
+	ar.NoError(t, err, "unexpected error on tag seek")
+	ar.Len(t, msgsIDs, 1, "incorrect number of messages associated to tag returned")
+
+	// AND: validate message stored
+	msgGot, err := st.MsgLoad(context.Background(), msgsIDs[0])
+	ar.NoError(t, err, "unexpected error on message load")
+	a.Equal(t, tfMsgAA.AuthorID, msgGot.AuthorID, "message AuthorID mismatch")
+	a.Equal(t, tfMsgAA.Body, msgGot.Body, "message Body mismatch")
+	a.Equal(t, tfMsgAA.Tags, msgGot.Tags, "message Tags mismatch")
+	a.Equal(t, msgIDFromHeader, msgGot.ID, "message ID mismatch")
+	a.False(t, msgGot.CreatedAt.IsZero(), "message CreatedAt not set")
+}
+
+func Test_HTTPHandler_Message_Create_Success_Tags(t *testing.T) {
+	st := NewMemoryStorage()
+	h := NewHTTPDefaultHandler(st)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// GIVEN: author match existing user
+	ar.NoError(t, st.UserSave(context.Background(), &tfUserA))
+
+	// WHEN: message with many tags, one repeated, is created
+	bR := strings.NewReader(`{"body":"UserA_MessageX-Body","author":"UserA-Name","tags":["tagB","tagC","tagB"]}`)
+	res, err := http.Post(fmt.Sprintf("%s/v1/messages", ts.URL), "application/json", bR)
+
+	// THEN: validate response
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	ar.Equal(t, http.StatusCreated, res.StatusCode, "mismatch on response code")
+	matches := rPathMsgRead.FindStringSubmatch(res.Header.Get("Location"))
+	ar.Len(t, matches, 2, "response: location header does not point to message read action")
+
+	// AND: message is stored with tags in order given, without duplicates
+	msgGot, err := st.MsgLoad(context.Background(), matches[1])
+	ar.NoError(t, err, "unexpected error on message load")
+	a.Equal(t, []Tag{tfTagB, tfTagC}, msgGot.Tags, "message Tags mismatch")
+
+	// AND: message is associated with each of the tags
+	for _, tag := range []Tag{tfTagB, tfTagC} {
+		msgsIDs, err := st.MsgsIDsFindByTag(context.Background(), tag, MsgCursor{}, 0)
+		ar.NoError(t, err, "[%s] unexpected error on tag seek", tag)
+		a.Equal(t, []string{msgGot.ID}, msgsIDs, "[%s] message not associated to tag", tag)
+	}
+}
+
+func Test_HTTPHandler_Message_Create_Success_HashtagsMentions(t *testing.T) {
+	st := NewMemoryStorage()
+	h := NewHTTPDefaultHandler(st)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// GIVEN: author and mentioned user exist
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		ar.NoError(t, st.UserSave(context.Background(), &uC))
+	}
+
+	// WHEN: message with hashtags and mentions, one of an unknown user, is created
+	bR := strings.NewReader(`{"body":"#deploy done, @UserB-Name and @UserX-Name see #tagA","author":"UserA-Name","tag":"tagA"}`)
+	res, err := http.Post(fmt.Sprintf("%s/v1/messages", ts.URL), "application/json", bR)
+
+	// THEN: validate response
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	ar.Equal(t, http.StatusCreated, res.StatusCode, "mismatch on response code")
+	matches := rPathMsgRead.FindStringSubmatch(res.Header.Get("Location"))
+	ar.Len(t, matches, 2, "response: location header does not point to message read action")
+
+	// AND: hashtags are attached after the tag and known user is mentioned
+	msgGot, err := st.MsgLoad(context.Background(), matches[1])
+	ar.NoError(t, err, "unexpected error on message load")
+	a.Equal(t, []Tag{tfTagA, "deploy"}, msgGot.Tags, "message Tags mismatch")
+	a.Equal(t, []string{tfUserB.ID}, msgGot.Mentions, "message Mentions mismatch")
+
+	// AND: message is found by hashtag and by mention
+	msgsIDs, err := st.MsgsIDsFindByTag(context.Background(), "deploy", MsgCursor{}, 0)
+	ar.NoError(t, err, "unexpected error on tag seek")
+	a.Equal(t, []string{msgGot.ID}, msgsIDs, "message not associated to hashtag")
+	msgsIDs, err = st.MsgsIDsFindByMention(context.Background(), tfUserB.ID, MsgCursor{}, 0)
+	ar.NoError(t, err, "unexpected error on mention seek")
+	a.Equal(t, []string{msgGot.ID}, msgsIDs, "message not associated to mentioned user")
+}
+
+func Test_HTTPHandler_Message_Create_Success_MentionsSameUser(t *testing.T) {
+	st := NewMemoryStorage()
+	h := NewMessagesHandler(st)
+
+	// GIVEN: author and mentioned user exist
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		ar.NoError(t, st.UserSave(context.Background(), &uC))
+	}
+
+	// WHEN: user B is mentioned by names differing by case and by look-alike Cyrillic a
+	reqBody := `{"body":"@UserB-Name @userb-NAME @UserB-N\u0430me and @UserA-Name","author":"UserA-Name","tag":"tagA"}`
+	req, err := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(reqBody))
+	ar.NoError(t, err)
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	// THEN: each user is mentioned once
+	ar.Equal(t, http.StatusCreated, res.Code, "mismatch on response code")
+	matches := rPathMsgRead.FindStringSubmatch(res.Header().Get("Location"))
+	ar.Len(t, matches, 2, "response: location header does not point to message read action")
+	msgGot, err := st.MsgLoad(context.Background(), matches[1])
+	ar.NoError(t, err, "unexpected error on message load")
+	a.Equal(t, []string{tfUserB.ID, tfUserA.ID}, msgGot.Mentions, "message Mentions mismatch")
+}
+
+func Test_HTTPHandler_Messages_Factory(t *testing.T) {
+	st := NewMemoryStorage()
+	h := NewMessagesHandler(st)
+
+	ar.NotNil(t, h, "empty element returned")
+	a.NotNil(t, h.TimeNow, "TimeNow not initialised")
+	a.Equal(t, st, h.Storer, "Storer is not attached")
+}
+
+func Test_HTTPHandler_Message_Create_Timestamps(t *testing.T) {
+	st := NewMemoryStorage()
+	h := NewMessagesHandler(st)
+	timeExp := time.Date(2016, time.May, 29, 10, 11, 12, 13, time.FixedZone("UTC+2", 2*60*60))
+	h.TimeNow = func() time.Time {
+		return timeExp
+	}
+
+	// GIVEN: author match existing user
+	ar.NoError(t, st.UserSave(context.Background(), &tfUserA))
+
+	req, err := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(tfTrInMsgAA_JSON))
+	ar.NoError(t, err)
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	ar.Equal(t, http.StatusCreated, res.Code, "mismatch on response code")
+
+	// THEN: both timestamps are taken from the clock, in UTC
+	msgsIDs, err := st.MsgsIDsFindByTag(context.Background(), tfTrInMsgAA.Tag, MsgCursor{}, 0)
+	ar.NoError(t, err, "unexpected error on tag seek")
+	ar.Len(t, msgsIDs, 1, "incorrect number of messages associated to tag returned")
+	msgGot, err := st.MsgLoad(context.Background(), msgsIDs[0])
+	ar.NoError(t, err, "unexpected error on message load")
+	a.Equal(t, timeExp.UTC(), msgGot.CreatedAt, "message CreatedAt mismatch")
+	a.Equal(t, timeExp.UTC(), msgGot.ModifiedAt, "message ModifiedAt mismatch")
+}
+
+func Test_HTTPHandler_Message_Create_Failure(t *testing.T) {
+	var ts *httptest.Server
+	defer func() {
+		if ts != nil {
+			ts.Close()
+		}
+	}()
+
+	tests := map[string]struct {
+		reqBody     string
+		dbUsers     []User
+		ufErr       error // uf = UserFind
+		ufCalledExp bool
+		msErr       error // ms = MsgSave
+		msCalledExp bool
+		resStatus   int
+	}{
+		"JSON: malformed": {
+			reqBody:   `NotA-JSON`,
+			resStatus: http.StatusBadRequest,
+		},
+		"JSON: type not matching": {
+			reqBody:   `[{"A":1},{"B":"123"}]`,
+			resStatus: http.StatusBadRequest,
+		},
+		"validation: empty object/name empty": {
+			reqBody:   `{}`,
+			resStatus: http.StatusBadRequest,
+		},
+		"unknown author": {
+			reqBody:     `{"author": "UserUnknown-Name","body":"qweasd","tag":"tagA"}`,
+			dbUsers:     []User{tfUserA},
+			ufCalledExp: true,
+			resStatus:   http.StatusBadRequest,
+		},
+		"MsgSave error": {
+			reqBody:     tfTrInMsgAA_JSON,
+			dbUsers:     []User{tfUserA},
+			ufCalledExp: true,
+			msErr:       errors.New("some kind of DB error"),
+			msCalledExp: true,
+			resStatus:   http.StatusInternalServerError,
+		},
+		"UserFind error": {
+			reqBody:     tfTrInMsgAA_JSON,
+			ufCalledExp: true,
+			ufErr:       errors.New("some kind of DB error"),
+			msCalledExp: false,
+			resStatus:   http.StatusInternalServerError,
+		},
+	}
+
+	for sym, tc := range tests {
+		st := NewTmMemoryStorageMock()
+		st.outUserFindErr = tc.ufErr
+		st.outMsgSaveErr = tc.msErr
+
+		h := NewHTTPDefaultHandler(st)
+		ts = httptest.NewServer(h)
+
+		// GIVEN: expected users are in DB
+		for _, u := range tc.dbUsers {
+			uC := u
+			ar.NoError(t, st.UserSave(context.Background(), &uC), "case: %s", sym)
+		}
+		st.inUserSaveCalled = false
+
+		// WHEN: message create is called
+		res, err := http.Post(fmt.Sprintf("%s/v1/messages", ts.URL), "application/json", strings.NewReader(tc.reqBody))
+
+		// THEN: validate response
+		ar.NoError(t, err, "[%s] unexpected error from HTTP client", sym)
+		tsAssertProblem(t, res.StatusCode, res.Header, res.Body, sym)
+		a.Equal(t, tc.resStatus, res.StatusCode, "[%s] mismatch on response code", sym)
+
+		// AND: validate storage access
+		a.Equal(t, tc.ufCalledExp, st.inUserFindCalled, "[%s] UserFind function call status mismatch", sym)
+		a.Equal(t, tc.msCalledExp, st.inMsgSaveCalled, "[%s] MsgSave function call status mismatch", sym)
+	}
+}
+
+func Test_HTTPHandler_Message_Find_Success_Found(t *testing.T) {
+	var ts *httptest.Server
+	defer func() {
+		if ts != nil {
+			ts.Close()
+		}
+	}()
+
+	tests := map[string]struct {
+		dbUsers []User
+		dbMsg   []Message
+		tag     Tag
+		exp     MessagesCollectionOut
+	}{
+		"single": {
+			[]User{tfUserA},
+			[]Message{tfMsgAA},
+			tfTrInMsgAA.Tag,
+			MessagesCollectionOut{tfTrOutMsgAA},
+		},
+		"two": {
+			[]User{tfUserA},
+			[]Message{tfMsgAA, tfMsgAB},
+			tfTrInMsgAA.Tag,
+			MessagesCollectionOut{tfTrOutMsgAB, tfTrOutMsgAA},
+		},
+		"three, cross user, partial": {
+			[]User{tfUserA, tfUserB},
+			[]Message{tfMsgBA, tfMsgAA, tfMsgBB, tfMsgAB},
+			tfTrInMsgAA.Tag,
+			MessagesCollectionOut{tfTrOutMsgBA, tfTrOutMsgAB, tfTrOutMsgAA},
+		},
+	}
+
+	for sym, tc := range tests {
+		st := NewMemoryStorage()
+		h := NewHTTPDefaultHandler(st)
+		ts = httptest.NewServer(h)
+
+		// GIVEN: user and message are in DB
+		for _, u := range tc.dbUsers {
+			uC := u
+			ar.NoError(t, st.UserSave(context.Background(), &uC), "case: %s", sym)
+		}
+		for _, m := range tc.dbMsg {
+			mC := m
+			ar.NoError(t, st.MsgSave(context.Background(), &mC), "case: %s", sym)
+		}
+
+		res, err := http.Get(fmt.Sprintf("%s/v1/messages?tag=%s", ts.URL, tc.tag))
+
+		// THEN: validate response
+		if !a.NoError(t, err, "unexpected error from HTTP client") {
+			ts.Close()
+			continue
+		}
+		a.Equal(t, http.StatusOK, res.StatusCode, "mismatch on response code")
+		a.Equal(t, "application/json", res.Header.Get("Content-Type"), "mismatch on response content encoding")
+
+		var resBodyGot MessagesPageOut
+		err = json.NewDecoder(res.Body).Decode(&resBodyGot)
+		res.Body.Close()
+		if !a.NoError(t, err, "unexpected error on response body read") {
+			ts.Close()
+			continue
+		}
+
+		// validate message collection, newest first
+		a.Equal(t, tc.exp, resBodyGot.Messages, "[%s] mismatch on messages returned", sym)
+		a.Empty(t, resBodyGot.Next, "[%s] next cursor on the last page", sym)
+
+		ts.Close()
+	}
+}
+
+func Test_HTTPHandler_Message_Find_Success_Paging(t *testing.T) {
+	st := NewMemoryStorage()
+	h := NewHTTPDefaultHandler(st)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// GIVEN: messages are in DB
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		ar.NoError(t, st.UserSave(context.Background(), &uC))
+	}
+	for _, m := range []Message{tfMsgAA, tfMsgAB, tfMsgBA, tfMsgBB} {
+		mC := m
+		ar.NoError(t, st.MsgSave(context.Background(), &mC))
+	}
+
+	// WHEN: pages are requested one after another
+	var pagesGot []MessagesCollectionOut
+	cursor := ""
+	for i := 0; i < 5; i++ {
+		res, err := http.Get(fmt.Sprintf("%s/v1/messages?tag=%s&limit=2&cursor=%s", ts.URL, tfTagA, cursor))
+		ar.NoError(t, err, "unexpected error from HTTP client")
+		ar.Equal(t, http.StatusOK, res.StatusCode, "mismatch on response code")
+
+		var resBodyGot MessagesPageOut
+		err = json.NewDecoder(res.Body).Decode(&resBodyGot)
+		res.Body.Close()
+		ar.NoError(t, err, "unexpected error on response body read")
+
+		pagesGot = append(pagesGot, resBodyGot.Messages)
+		if resBodyGot.Next == "" {
+			break
+		}
+		cursor = resBodyGot.Next
+	}
+
+	// THEN: all messages are returned, newest first, without gaps or repetitions
+	a.Equal(t, []MessagesCollectionOut{
+		{tfTrOutMsgBA, tfTrOutMsgAB},
+		{tfTrOutMsgAA},
+	}, pagesGot)
+}
+
+func Test_HTTPHandler_Message_Find_Success_TagQuery(t *testing.T) {
+	st := NewMemoryStorage()
+	h := NewHTTPDefaultHandler(st)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// GIVEN: messages are in DB, one of them with many tags
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		ar.NoError(t, st.UserSave(context.Background(), &uC))
+	}
+	for _, m := range []Message{tfMsgAA, tfMsgAB, tfMsgBA, tfMsgBB, tfMsgAC} {
+		mC := m
+		ar.NoError(t, st.MsgSave(context.Background(), &mC))
+	}
+
+	tests := map[string]struct {
+		query string
+		exp   []string
+	}{
+		"all":               {"tag=tagB&tag=tagC", []string{tfMsgAC.ID}},
+		"any":               {"any=tagA&any=tagC", []string{tfMsgAC.ID, tfMsgBA.ID, tfMsgAB.ID, tfMsgAA.ID}},
+		"not":               {"tag=tagB&not=tagC", []string{tfMsgBB.ID}},
+		"all and any":       {"tag=tagB&any=tagA&any=tagC", []string{tfMsgAC.ID}},
+		"empty, duplicated": {"tag=&tag=tagC&tag=tagC", []string{tfMsgAC.ID}},
+	}
+
+	for sym, tc := range tests {
+		res, err := http.Get(fmt.Sprintf("%s/v1/messages?%s", ts.URL, tc.query))
+		ar.NoError(t, err, "[%s] unexpected error from HTTP client", sym)
+		ar.Equal(t, http.StatusOK, res.StatusCode, "[%s] mismatch on response code", sym)
+
+		var resBodyGot MessagesPageOut
+		err = json.NewDecoder(res.Body).Decode(&resBodyGot)
+		res.Body.Close()
+		ar.NoError(t, err, "[%s] unexpected error on response body read", sym)
+
+		// THEN: matching messages are returned, newest first
+		var idsGot []string
+		for _, m := range resBodyGot.Messages {
+			idsGot = append(idsGot, m.ID)
+		}
+		a.Equal(t, tc.exp, idsGot, "[%s] mismatch on messages returned", sym)
+	}
+}
+
+func Test_HTTPHandler_Message_Find_Failure_BadRequest(t *testing.T) {
+	st := NewTmMemoryStorageMock()
+	h := NewHTTPDefaultHandler(st)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	tests := map[string]string{
+		"limit: not a number": "limit=abc",
+		"limit: zero":         "limit=0",
+		"limit: too big":      fmt.Sprintf("limit=%d", msgsPageLimitMax+1),
+		"cursor: not base64":  "cursor=!!!",
+		"cursor: no ID":       "cursor=" + encodeMsgCursor(MsgCursor{CreatedAt: tfTimeBase}),
+		"cursor: no time":     "cursor=" + base64.RawURLEncoding.EncodeToString([]byte("abc:UserA_MessageA-ID")),
+	}
+
+	for sym, query := range tests {
+		res, err := http.Get(fmt.Sprintf("%s/v1/messages?tag=%s&%s", ts.URL, tfTagA, query))
+		if !a.NoError(t, err, "[%s] unexpected error from HTTP client", sym) {
+			continue
+		}
+		res.Body.Close()
+		a.Equal(t, http.StatusBadRequest, res.StatusCode, "[%s] mismatch on response code", sym)
+	}
+
+	// AND: storage is not queried
+	a.False(t, st.inMsgFindCalled, "MsgFind function called")
+}
+
+func Test_HTTPHandler_Message_Find_Success_NotFound(t *testing.T) {
+	st := NewMemoryStorage()
+	h := NewHTTPDefaultHandler(st)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// GIVEN: no matching messages are in DB
+	res, err := http.Get(fmt.Sprintf("%s/v1/messages?tag=%s", ts.URL, "NON_EXISTING_TAG"))
+
+	// THEN: validate response
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	a.Equal(t, http.StatusNotFound, res.StatusCode, "mismatch on response code")
+	tsAssertProblem(t, res.StatusCode, res.Header, res.Body, "tag not found")
+}
+
+func Test_HTTPHandler_Message_Find_Success_AuthorGone(t *testing.T) {
+	st := NewTmMemoryStorageMock()
+	h := NewHTTPDefaultHandler(st)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// GIVEN: message is in DB while its author is not, e.g. it was saved while the author was removed
+	user := tfUserB
+	ar.NoError(t, st.UserSave(context.Background(), &user))
+	for _, m := range []Message{tfMsgAA, tfMsgBA} {
+		mC := m
+		ar.NoError(t, st.MsgSave(context.Background(), &mC))
+	}
+
+	// WHEN: messages are listed
+	res, err := http.Get(fmt.Sprintf("%s/v1/messages?tag=%s", ts.URL, tfTagA))
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	ar.Equal(t, http.StatusOK, res.StatusCode, "mismatch on response code")
+	var pageGot MessagesPageOut
+	err = json.NewDecoder(res.Body).Decode(&pageGot)
+	res.Body.Close()
+	ar.NoError(t, err, "unexpected error on response body read")
+
+	// THEN: message is listed as anonymised one, others keep their authors
+	msgExp := tfTrOutMsgAA
+	msgExp.Author = ""
+	a.Equal(t, MessagesCollectionOut{tfTrOutMsgBA, msgExp}, pageGot.Messages, "mismatch on messages returned")
+	a.True(t, st.inUserLoadManyCalled, "UserLoadMany function not called")
+
+	// AND: it's read as anonymised one
+	res, err = http.Get(fmt.Sprintf("%s/v1/messages/%s", ts.URL, tfMsgAA.ID))
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	ar.Equal(t, http.StatusOK, res.StatusCode, "mismatch on response code")
+	var msgGot MessageOut
+	err = json.NewDecoder(res.Body).Decode(&msgGot)
+	res.Body.Close()
+	ar.NoError(t, err, "unexpected error on response body read")
+	a.Equal(t, msgExp, msgGot, "mismatch on message read")
+}
+
+func Test_HTTPHandler_Message_Find_Failure(t *testing.T) {
+	var ts *httptest.Server
+	defer func() {
+		if ts != nil {
+			ts.Close()
+		}
+	}()
+
+	tests := map[string]struct {
+		dbUsers     []User
+		dbMsg       []Message
+		tag         Tag
+		mfCalledExp bool // mf = MsgFind
+		mfErr       error
+		mlCalledExp bool // ml = MsgLoadMany
+		mlErr       error
+		ulCalledExp bool // ul = UserLoadMany
+		ulErr       error
+		resStatus   int
+	}{
+		"MsgFind error": {
+			dbUsers:     []User{tfUserA},
+			dbMsg:       []Message{tfMsgAA},
+			tag:         tfTrInMsgAA.Tag,
+			mfCalledExp: true,
+			mfErr:       errors.New("some kind of DB error"),
+			resStatus:   http.StatusInternalServerError,
+		},
+		"MsgLoadMany error": {
+			dbUsers:     []User{tfUserA},
+			dbMsg:       []Message{tfMsgAA},
+			tag:         tfTrInMsgAA.Tag,
+			mfCalledExp: true,
+			mlCalledExp: true,
+			mlErr:       errors.New("some kind of DB error"),
+			resStatus:   http.StatusInternalServerError,
+		},
+		"UserLoadMany error": {
+			dbUsers:     []User{tfUserA},
+			dbMsg:       []Message{tfMsgAA},
+			tag:         tfTrInMsgAA.Tag,
+			mfCalledExp: true,
+			mlCalledExp: true,
+			ulCalledExp: true,
+			ulErr:       errors.New("some kind of DB error"),
+			resStatus:   http.StatusInternalServerError,
+		},
+	}
+
+	for sym, tc := range tests {
+		st := NewTmMemoryStorageMock()
+		st.outMsgFindErr = tc.mfErr
+		st.outMsgLoadManyErr = tc.mlErr
+		st.outUserLoadManyErr = tc.ulErr
+
+		h := NewHTTPDefaultHandler(st)
+		ts = httptest.NewServer(h)
+
+		// GIVEN: user and message are in DB
+		for _, u := range tc.dbUsers {
+			uC := u
+			ar.NoError(t, st.UserSave(context.Background(), &uC), "case: %s", sym)
+		}
+		st.inUserSaveCalled = false
+		for _, m := range tc.dbMsg {
+			mC := m
+			ar.NoError(t, st.MsgSave(context.Background(), &mC), "case: %s", sym)
+		}
+		st.inMsgSaveCalled = false
+
+		// WHEN: message create is called
+		res, err := http.Get(fmt.Sprintf("%s/v1/messages?tag=%s", ts.URL, tc.tag))
+
+		// THEN: validate response
+		ar.NoError(t, err, "[%s] unexpected error from HTTP client", sym)
+		tsAssertProblem(t, res.StatusCode, res.Header, res.Body, sym)
+		a.Equal(t, tc.resStatus, res.StatusCode, "[%s] mismatch on response code", sym)
+
+		// AND: validate storage access
+		a.Equal(t, tc.mfCalledExp, st.inMsgFindCalled, "[%s] MsgFind function call status mismatch", sym)
+		a.Equal(t, tc.mlCalledExp, st.inMsgLoadManyCalled, "[%s] MsgLoadMany function call status mismatch", sym)
+		a.Equal(t, tc.ulCalledExp, st.inUserLoadManyCalled, "[%s] UserLoadMany function call status mismatch", sym)
+	}
+}
+
+func Test_HTTPHandler_Message_Read_Success_Found(t *testing.T) {
+	st := NewTmMemoryStorageMock()
+	h := NewHTTPDefaultHandler(st)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// GIVEN: matching message is in DB
+	user := tfUserA
+	msg := tfMsgAA
+	ar.NoError(t, st.UserSave(context.Background(), &user))
+	ar.NoError(t, st.MsgSave(context.Background(), &msg))
+
+	res, err := http.Get(fmt.Sprintf("%s/v1/messages/%s", ts.URL, msg.ID))
+
+	// THEN: validate response
+	ar.NoError(t, err, "unexpected error from HTTP client")
+
+	a.Equal(t, http.StatusOK, res.StatusCode, "mismatch on response code")
+	a.Equal(t, "application/json", res.Header.Get("Content-Type"), "mismatch on response content encoding")
+
+	var resBodyGot MessageOut
+	err = json.NewDecoder(res.Body).Decode(&resBodyGot)
+	res.Body.Close()
+	ar.NoError(t, err, "unexpected error on response body read")
+
+	a.Equal(t, tfTrOutMsgAA, resBodyGot)
+}
+
+func Test_HTTPHandler_Message_Read_Success_NotFound(t *testing.T) {
+	st := NewMemoryStorage()
+	h := NewHTTPDefaultHandler(st)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// GIVEN: message NOT in DB
+	res, err := http.Get(fmt.Sprintf("%s/v1/messages/non-existing-123", ts.URL))
+
+	// THEN: validate response
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	a.Equal(t, http.StatusNotFound, res.StatusCode, "mismatch on response code")
+	tsAssertProblem(t, res.StatusCode, res.Header, res.Body, "message not found")
+}
+
+func Test_HTTPHandler_Message_Read_Failure(t *testing.T) {
+	var ts *httptest.Server
+	defer func() {
+		if ts != nil {
+			ts.Close()
+		}
+	}()
+
+	tests := map[string]struct {
+		dbUsers     []User
+		dbMsg       []Message
+		msgID       string
 		mlCalledExp bool // ml = MsgLoad
 		mlErr       error
 		ulCalledExp bool // ul = UserLoad
 		ulErr       error
 		resStatus   int
 	}{
-		"MsgFind error": {
-			dbUsers:     []User{tfUserA},
-			dbMsg:       []Message{tfMsgAA},
-			tag:         tfTrInMsgAA.Tag,
-			mfCalledExp: true,
-			mfErr:       errors.New("some kind of DB error"),
-			resStatus:   http.StatusInternalServerError,
+		"MsgLoad error": {
+			dbUsers:     []User{tfUserA},
+			dbMsg:       []Message{tfMsgAA},
+			msgID:       tfMsgAA.ID,
+			mlCalledExp: true,
+			mlErr:       errors.New("some kind of DB error"),
+			resStatus:   http.StatusInternalServerError,
+		},
+		"UserLoad error: db error": {
+			dbUsers:     []User{tfUserA},
+			dbMsg:       []Message{tfMsgAA},
+			msgID:       tfMsgAA.ID,
+			mlCalledExp: true,
+			ulCalledExp: true,
+			ulErr:       errors.New("some kind of DB error"),
+			resStatus:   http.StatusInternalServerError,
+		},
+	}
+
+	for sym, tc := range tests {
+		st := NewTmMemoryStorageMock()
+		st.outMsgLoadErr = tc.mlErr
+		st.outUserLoadErr = tc.ulErr
+
+		h := NewHTTPDefaultHandler(st)
+		ts = httptest.NewServer(h)
+
+		// GIVEN: user and message are in DB
+		for _, u := range tc.dbUsers {
+			uC := u
+			ar.NoError(t, st.UserSave(context.Background(), &uC), "case: %s", sym)
+		}
+		st.inUserSaveCalled = false
+		for _, m := range tc.dbMsg {
+			mC := m
+			ar.NoError(t, st.MsgSave(context.Background(), &mC), "case: %s", sym)
+		}
+		st.inMsgSaveCalled = false
+
+		// WHEN: message create is called
+		res, err := http.Get(fmt.Sprintf("%s/v1/messages/%s", ts.URL, tc.msgID))
+
+		// THEN: validate response
+		ar.NoError(t, err, "[%s] unexpected error from HTTP client", sym)
+		tsAssertProblem(t, res.StatusCode, res.Header, res.Body, sym)
+		a.Equal(t, tc.resStatus, res.StatusCode, "[%s] mismatch on response code", sym)
+
+		// AND: validate storage access
+		a.Equal(t, tc.mlCalledExp, st.inMsgLoadCalled, "[%s] MsgLoad function call status mismatch", sym)
+		a.Equal(t, tc.ulCalledExp, st.inUserLoadCalled, "[%s] UserLoad function call status mismatch", sym)
+	}
+}
+
+func Test_HTTPHandler_Message_Revisions_Success(t *testing.T) {
+	var ts *httptest.Server
+	defer func() {
+		if ts != nil {
+			ts.Close()
+		}
+	}()
+
+	tests := map[string]struct {
+		dbMsg []Message
+		exp   MessagesCollectionOut
+	}{
+		"never edited": {
+			[]Message{tfMsgAA},
+			MessagesCollectionOut{tfTrOutMsgAA},
+		},
+		"edited": {
+			[]Message{tfMsgAA, tfMsgAA, tfMsgAA},
+			MessagesCollectionOut{tfTrOutMsgAA, tfTrOutMsgAA, tfTrOutMsgAA},
+		},
+	}
+
+	for sym, tc := range tests {
+		st := NewMemoryStorage()
+		h := NewHTTPDefaultHandler(st)
+		ts = httptest.NewServer(h)
+
+		// GIVEN: message with its history is in DB
+		user := tfUserA
+		ar.NoError(t, st.UserSave(context.Background(), &user), "case: %s", sym)
+		for i, m := range tc.dbMsg {
+			mC := m
+			mC.Body = fmt.Sprintf("%s-V%d", m.Body, i+1)
+			ar.NoError(t, st.MsgSave(context.Background(), &mC), "case: %s", sym)
+			tc.exp[i].Body = mC.Body
+		}
+
+		res, err := http.Get(fmt.Sprintf("%s/v1/messages/%s/revisions", ts.URL, tfMsgAA.ID))
+
+		// THEN: validate response
+		ar.NoError(t, err, "[%s] unexpected error from HTTP client", sym)
+		a.Equal(t, http.StatusOK, res.StatusCode, "[%s] mismatch on response code", sym)
+		a.Equal(t, "application/json", res.Header.Get("Content-Type"), "[%s] mismatch on response content encoding", sym)
+
+		var resBodyGot MessagesCollectionOut
+		err = json.NewDecoder(res.Body).Decode(&resBodyGot)
+		res.Body.Close()
+		ar.NoError(t, err, "[%s] unexpected error on response body read", sym)
+
+		// AND: versions are listed from the oldest, ending with the current one
+		a.Equal(t, tc.exp, resBodyGot, "[%s] mismatch on revisions returned", sym)
+
+		ts.Close()
+	}
+}
+
+func Test_HTTPHandler_Message_Revisions_Success_NotFound(t *testing.T) {
+	st := NewMemoryStorage()
+	h := NewHTTPDefaultHandler(st)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// GIVEN: message NOT in DB
+	res, err := http.Get(fmt.Sprintf("%s/v1/messages/non-existing-123/revisions", ts.URL))
+
+	// THEN: validate response
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	a.Equal(t, http.StatusNotFound, res.StatusCode, "mismatch on response code")
+	tsAssertProblem(t, res.StatusCode, res.Header, res.Body, "message not found")
+}
+
+func Test_HTTPHandler_Message_Revisions_Failure(t *testing.T) {
+	tests := map[string]struct {
+		mlErr       error // ml = MsgLoad
+		mrCalledExp bool  // mr = MsgRevisions
+		mrErr       error
+		resStatus   int
+	}{
+		"MsgLoad error": {
+			mlErr:     errors.New("some kind of DB error"),
+			resStatus: http.StatusInternalServerError,
+		},
+		"MsgRevisions error": {
+			mrCalledExp: true,
+			mrErr:       errors.New("some kind of DB error"),
+			resStatus:   http.StatusInternalServerError,
+		},
+		"MsgRevisions error: not supported": {
+			mrCalledExp: true,
+			mrErr:       ErrNotSupported,
+			resStatus:   http.StatusNotImplemented,
+		},
+	}
+
+	for sym, tc := range tests {
+		st := NewTmMemoryStorageMock()
+		st.outMsgLoadErr = tc.mlErr
+		st.outMsgRevisionsErr = tc.mrErr
+
+		// GIVEN: user and message are in DB
+		user := tfUserA
+		ar.NoError(t, st.UserSave(context.Background(), &user), "case: %s", sym)
+		msg := tfMsgAA
+		ar.NoError(t, st.MsgSave(context.Background(), &msg), "case: %s", sym)
+
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("/v1/messages/%s/revisions", msg.ID), nil)
+		ar.NoError(t, err)
+		res := httptest.NewRecorder()
+		NewHTTPDefaultHandler(st).ServeHTTP(res, req)
+
+		// THEN: validate response
+		a.Equal(t, tc.resStatus, res.Code, "[%s] mismatch on response code", sym)
+		tsAssertProblem(t, res.Code, res.Header(), res.Body, sym)
+
+		// AND: validate storage access
+		a.Equal(t, tc.mrCalledExp, st.inMsgRevisionsCalled, "[%s] MsgRevisions function call status mismatch", sym)
+	}
+}
+
+func Test_HTTPHandler_Message_Update_Success(t *testing.T) {
+	tests := map[string]struct {
+		method  string
+		reqBody string
+		exp     MessageOut
+	}{
+		"PUT": {
+			http.MethodPut,
+			`{"body":"UserA_MessageA-Body-Replaced","author":"UserA-Name","tag":"tagB"}`,
+			MessageOut{ID: tfMsgAA.ID, Body: "UserA_MessageA-Body-Replaced", Author: tfUserA.Name, Tag: tfTagB, Tags: []Tag{tfTagB}},
+		},
+		"PUT: tags": {
+			http.MethodPut,
+			`{"body":"UserA_MessageA-Body-Replaced","author":"UserA-Name","tag":"tagA","tags":["tagC","tagA"]}`,
+			MessageOut{ID: tfMsgAA.ID, Body: "UserA_MessageA-Body-Replaced", Author: tfUserA.Name, Tag: tfTagA, Tags: []Tag{tfTagA, tfTagC}},
+		},
+		"PATCH": {
+			http.MethodPatch,
+			tfTrPatchMsgAA_JSON,
+			MessageOut{ID: tfMsgAA.ID, Body: tfTrPatchMsgAA_Body, Author: tfUserA.Name, Tag: tfTagA, Tags: []Tag{tfTagA}},
+		},
+		"PATCH: tags": {
+			http.MethodPatch,
+			`{"author":"UserA-Name","tags":["tagB","tagC"]}`,
+			MessageOut{ID: tfMsgAA.ID, Body: tfMsgAA.Body, Author: tfUserA.Name, Tag: tfTagB, Tags: []Tag{tfTagB, tfTagC}},
+		},
+	}
+
+	for sym, tc := range tests {
+		st := NewMemoryStorage()
+		h := NewMessagesHandler(st)
+		timeExp := tfTimeBase.Add(time.Hour)
+		h.TimeNow = func() time.Time {
+			return timeExp
+		}
+
+		// GIVEN: message is in DB
+		user := tfUserA
+		ar.NoError(t, st.UserSave(context.Background(), &user), "case: %s", sym)
+		msg := tfMsgAA
+		ar.NoError(t, st.MsgSave(context.Background(), &msg), "case: %s", sym)
+
+		req, err := http.NewRequest(tc.method, "/v1/messages/"+tfMsgAA.ID, strings.NewReader(tc.reqBody))
+		ar.NoError(t, err)
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+
+		// THEN: validate response
+		ar.Equal(t, http.StatusOK, res.Code, "[%s] mismatch on response code", sym)
+		a.Equal(t, "application/json", res.Header().Get("Content-Type"), "[%s] mismatch on response content encoding", sym)
+
+		var resBodyGot MessageOut
+		ar.NoError(t, json.NewDecoder(res.Body).Decode(&resBodyGot), "[%s] unexpected error on response body read", sym)
+		tc.exp.CreatedAt = tfMsgAA.CreatedAt
+		tc.exp.ModifiedAt = timeExp
+		a.Equal(t, tc.exp, resBodyGot, "[%s] mismatch on message returned", sym)
+
+		// AND: change is stored along with previous version
+		msgGot, err := st.MsgLoad(context.Background(), tfMsgAA.ID)
+		ar.NoError(t, err, "[%s] unexpected error on message load", sym)
+		a.Equal(t, tc.exp.Body, msgGot.Body, "[%s] message Body mismatch", sym)
+		a.Equal(t, tc.exp.Tags, msgGot.Tags, "[%s] message Tags mismatch", sym)
+		a.Equal(t, timeExp, msgGot.ModifiedAt, "[%s] message ModifiedAt mismatch", sym)
+		revsGot, err := st.MsgRevisions(context.Background(), tfMsgAA.ID)
+		ar.NoError(t, err, "[%s] unexpected error on revisions load", sym)
+		a.Equal(t, []*Message{&tfMsgAA}, revsGot, "[%s] previous version not kept", sym)
+	}
+}
+
+func Test_HTTPHandler_Message_Update_Success_HashtagsMentions(t *testing.T) {
+	tests := map[string]struct {
+		method      string
+		reqBody     string
+		expTags     []Tag
+		expHashtags []Tag
+		expMentions []string
+	}{
+		"PUT": {
+			http.MethodPut,
+			`{"body":"#new for @UserA-Name","author":"UserA-Name","tags":["tagB"]}`,
+			[]Tag{tfTagB, "new"},
+			[]Tag{"new"},
+			[]string{tfUserA.ID},
+		},
+		"PATCH: body": {
+			http.MethodPatch,
+			`{"author":"UserA-Name","body":"#new for @UserA-Name"}`,
+			[]Tag{tfTagA, tfTagC, "new"},
+			[]Tag{"new"},
+			[]string{tfUserA.ID},
+		},
+		"PATCH: body without hashtags": {
+			// tagC is given explicitly, so it stays although it was written as hashtag too
+			http.MethodPatch,
+			`{"author":"UserA-Name","body":"plain"}`,
+			[]Tag{tfTagA, tfTagC},
+			nil,
+			nil,
+		},
+		"PATCH: tags": {
+			http.MethodPatch,
+			`{"author":"UserA-Name","tags":["tagB"]}`,
+			[]Tag{tfTagB, "old", tfTagC},
+			[]Tag{"old", tfTagC},
+			[]string{tfUserB.ID},
+		},
+	}
+
+	for sym, tc := range tests {
+		st := NewMemoryStorage()
+		h := NewMessagesHandler(st)
+
+		// GIVEN: message with hashtags and mention is in DB, one of the hashtags is given explicitly as well
+		for _, u := range []User{tfUserA, tfUserB} {
+			uC := u
+			ar.NoError(t, st.UserSave(context.Background(), &uC), "case: %s", sym)
+		}
+		msg := tfMsgAA
+		msg.Body = "#old #tagC for @UserB-Name"
+		msg.Tags = []Tag{tfTagA, tfTagC, "old"}
+		msg.Hashtags = []Tag{"old"}
+		msg.Mentions = []string{tfUserB.ID}
+		ar.NoError(t, st.MsgSave(context.Background(), &msg), "case: %s", sym)
+
+		// WHEN
+		req, err := http.NewRequest(tc.method, "/v1/messages/"+tfMsgAA.ID, strings.NewReader(tc.reqBody))
+		ar.NoError(t, err)
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+
+		// THEN: hashtags and mentions follow the body
+		ar.Equal(t, http.StatusOK, res.Code, "[%s] mismatch on response code", sym)
+		msgGot, err := st.MsgLoad(context.Background(), tfMsgAA.ID)
+		ar.NoError(t, err, "[%s] unexpected error on message load", sym)
+		a.Equal(t, tc.expTags, msgGot.Tags, "[%s] message Tags mismatch", sym)
+		a.Equal(t, tc.expHashtags, msgGot.Hashtags, "[%s] message Hashtags mismatch", sym)
+		a.Equal(t, tc.expMentions, msgGot.Mentions, "[%s] message Mentions mismatch", sym)
+	}
+}
+
+func Test_HTTPHandler_Message_Delete_Success(t *testing.T) {
+	st := NewMemoryStorage()
+	h := NewHTTPDefaultHandler(st)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// GIVEN: message is in DB
+	user := tfUserA
+	ar.NoError(t, st.UserSave(context.Background(), &user))
+	msg := tfMsgAA
+	ar.NoError(t, st.MsgSave(context.Background(), &msg))
+
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/v1/messages/%s?author=%s", ts.URL, msg.ID, user.Name), nil)
+	ar.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+
+	// THEN: validate response
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	a.Equal(t, http.StatusNoContent, res.StatusCode, "mismatch on response code")
+
+	// AND: message is gone
+	_, err = st.MsgLoad(context.Background(), msg.ID)
+	a.Equal(t, ErrElementNotFound, err, "message not removed")
+	_, err = st.MsgsIDsFindByTag(context.Background(), msg.Tags[0], MsgCursor{}, 0)
+	a.Equal(t, ErrElementNotFound, err, "message still associated with tag")
+}
+
+func Test_HTTPHandler_Message_Change_Failure(t *testing.T) {
+	tests := map[string]struct {
+		method      string
+		path        string
+		reqBody     string
+		mlErr       error // ml = MsgLoad
+		muCalledExp bool  // mu = MsgUpdate
+		muErr       error
+		mdCalledExp bool // md = MsgDelete
+		mdErr       error
+		resStatus   int
+	}{
+		"PUT: invalid JSON": {
+			method:    http.MethodPut,
+			reqBody:   "{invalid",
+			resStatus: http.StatusBadRequest,
 		},
-		"MsgLoad error": {
-			dbUsers:     []User{tfUserA},
-			dbMsg:       []Message{tfMsgAA},
-			tag:         tfTrInMsgAA.Tag,
-			mfCalledExp: true,
-			mlCalledExp: true,
-			mlErr:       errors.New("some kind of DB error"),
+		"PUT: validation failed": {
+			method:    http.MethodPut,
+			reqBody:   `{"author":"UserA-Name","tag":"tagA"}`,
+			resStatus: http.StatusBadRequest,
+		},
+		"PATCH: validation failed": {
+			method:    http.MethodPatch,
+			reqBody:   `{"author":"UserA-Name"}`,
+			resStatus: http.StatusBadRequest,
+		},
+		"DELETE: no author": {
+			method:    http.MethodDelete,
+			resStatus: http.StatusBadRequest,
+		},
+		"PATCH: message not found": {
+			method:    http.MethodPatch,
+			path:      "/v1/messages/non-existing-123",
+			reqBody:   tfTrPatchMsgAA_JSON,
+			resStatus: http.StatusNotFound,
+		},
+		"PATCH: not an author": {
+			method:    http.MethodPatch,
+			reqBody:   `{"author":"UserB-Name","body":"Hijacked"}`,
+			resStatus: http.StatusForbidden,
+		},
+		"PATCH: unknown author": {
+			method:    http.MethodPatch,
+			reqBody:   `{"author":"UserX-Name","body":"Hijacked"}`,
+			resStatus: http.StatusForbidden,
+		},
+		"DELETE: not an author": {
+			method:    http.MethodDelete,
+			path:      "/v1/messages/" + tfMsgAA.ID + "?author=UserB-Name",
+			resStatus: http.StatusForbidden,
+		},
+		"PATCH: MsgLoad error": {
+			method:    http.MethodPatch,
+			reqBody:   tfTrPatchMsgAA_JSON,
+			mlErr:     errors.New("some kind of DB error"),
+			resStatus: http.StatusInternalServerError,
+		},
+		"PATCH: MsgUpdate error": {
+			method:      http.MethodPatch,
+			reqBody:     tfTrPatchMsgAA_JSON,
+			muCalledExp: true,
+			muErr:       errors.New("some kind of DB error"),
 			resStatus:   http.StatusInternalServerError,
 		},
-		"UserLoad error": {
-			dbUsers:     []User{tfUserA},
-			dbMsg:       []Message{tfMsgAA},
-			tag:         tfTrInMsgAA.Tag,
-			mfCalledExp: true,
-			mlCalledExp: true,
-			ulCalledExp: true,
-			ulErr:       errors.New("some kind of DB error"),
+		"PATCH: MsgUpdate error, removed in the meantime": {
+			method:      http.MethodPatch,
+			reqBody:     tfTrPatchMsgAA_JSON,
+			muCalledExp: true,
+			muErr:       ErrElementNotFound,
+			resStatus:   http.StatusNotFound,
+		},
+		"DELETE: MsgDelete error": {
+			method:      http.MethodDelete,
+			path:        "/v1/messages/" + tfMsgAA.ID + "?author=UserA-Name",
+			mdCalledExp: true,
+			mdErr:       errors.New("some kind of DB error"),
 			resStatus:   http.StatusInternalServerError,
 		},
+		"DELETE: MsgDelete error, not supported": {
+			method:      http.MethodDelete,
+			path:        "/v1/messages/" + tfMsgAA.ID + "?author=UserA-Name",
+			mdCalledExp: true,
+			mdErr:       ErrNotSupported,
+			resStatus:   http.StatusNotImplemented,
+		},
 	}
 
 	for sym, tc := range tests {
 		st := NewTmMemoryStorageMock()
-		st.outMsgFindErr = tc.mfErr
 		st.outMsgLoadErr = tc.mlErr
-		st.outUserLoadErr = tc.ulErr
+		st.outMsgUpdateErr = tc.muErr
+		st.outMsgDeleteErr = tc.mdErr
 
-		h := NewHTTPDefaultHandler(st)
-		ts = httptest.NewServer(h)
-
-		// GIVEN: user and message are in DB
-		for _, u := range tc.dbUsers {
+		// GIVEN: users and message are in DB
+		for _, u := range []User{tfUserA, tfUserB} {
 			uC := u
-			ar.NoError(t, st.UserSave(&uC), "case: %s", sym)
-		}
-		st.inUserSaveCalled = false
-		for _, m := range tc.dbMsg {
-			mC := m
-			ar.NoError(t, st.MsgSave(&mC), "case: %s", sym)
+			ar.NoError(t, st.UserSave(context.Background(), &uC), "case: %s", sym)
 		}
-		st.inMsgSaveCalled = false
+		msg := tfMsgAA
+		ar.NoError(t, st.MsgSave(context.Background(), &msg), "case: %s", sym)
 
-		// WHEN: message create is called
-		res, err := http.Get(fmt.Sprintf("%s/v1/messages?tag=%s", ts.URL, tc.tag))
+		path := tc.path
+		if path == "" {
+			path = "/v1/messages/" + tfMsgAA.ID
+		}
+		req, err := http.NewRequest(tc.method, path, strings.NewReader(tc.reqBody))
+		ar.NoError(t, err)
+		res := httptest.NewRecorder()
+		NewHTTPDefaultHandler(st).ServeHTTP(res, req)
 
 		// THEN: validate response
-		ar.NoError(t, err, "[%s] unexpected error from HTTP client", sym)
-		a.EqualValues(t, 0, res.ContentLength, "[%s] non empty response body", sym)
-		a.Equal(t, tc.resStatus, res.StatusCode, "[%s] mismatch on response code", sym)
+		a.Equal(t, tc.resStatus, res.Code, "[%s] mismatch on response code", sym)
+		tsAssertProblem(t, res.Code, res.Header(), res.Body, sym)
 
 		// AND: validate storage access
-		a.Equal(t, tc.mfCalledExp, st.inMsgFindCalled, "[%s] MsgFind function call status mismatch", sym)
-		a.Equal(t, tc.mlCalledExp, st.inMsgLoadCalled, "[%s] MsgLoad function call status mismatch", sym)
-		a.Equal(t, tc.ulCalledExp, st.inUserLoadCalled, "[%s] UserLoad function call status mismatch", sym)
+		a.Equal(t, tc.muCalledExp, st.inMsgUpdateCalled, "[%s] MsgUpdate function call status mismatch", sym)
+		a.Equal(t, tc.mdCalledExp, st.inMsgDeleteCalled, "[%s] MsgDelete function call status mismatch", sym)
+
+		// AND: message is left intact
+		msgGot, err := st.memoryStorage.MsgLoad(context.Background(), tfMsgAA.ID)
+		if a.NoError(t, err, "[%s] message removed", sym) {
+			a.Equal(t, &tfMsgAA, msgGot, "[%s] message changed", sym)
+		}
 	}
 }
 
-func Test_HTTPHandler_Message_Read_Success_Found(t *testing.T) {
-	st := NewTmMemoryStorageMock()
-	h := NewHTTPDefaultHandler(st)
-	ts := httptest.NewServer(h)
-	defer ts.Close()
-
-	// GIVEN: matching message is in DB
-	user := tfUserA
-	msg := tfMsgAA
-	ar.NoError(t, st.UserSave(&user))
-	ar.NoError(t, st.MsgSave(&msg))
+func Test_HTTPHandler_Message_Authenticated(t *testing.T) {
+	tests := map[string]struct {
+		method    string
+		path      string
+		reqBody   string
+		userID    string
+		resStatus int
+		authorExp string
+	}{
+		"POST: author taken from credentials": {
+			method:    http.MethodPost,
+			path:      "/v1/messages",
+			reqBody:   `{"body":"Hello","tag":"tagA"}`,
+			userID:    tfUserB.ID,
+			resStatus: http.StatusCreated,
+			authorExp: tfUserB.ID,
+		},
+		"POST: author matching credentials": {
+			method:    http.MethodPost,
+			path:      "/v1/messages",
+			reqBody:   `{"body":"Hello","author":"UserB-Name","tag":"tagA"}`,
+			userID:    tfUserB.ID,
+			resStatus: http.StatusCreated,
+			authorExp: tfUserB.ID,
+		},
+		"POST: author not matching credentials": {
+			method:    http.MethodPost,
+			path:      "/v1/messages",
+			reqBody:   `{"body":"Hello","author":"UserA-Name","tag":"tagA"}`,
+			userID:    tfUserB.ID,
+			resStatus: http.StatusForbidden,
+		},
+		"POST: user removed": {
+			method:    http.MethodPost,
+			path:      "/v1/messages",
+			reqBody:   `{"body":"Hello","tag":"tagA"}`,
+			userID:    "UserX-ID",
+			resStatus: http.StatusUnauthorized,
+		},
+		"PATCH: by author": {
+			method:    http.MethodPatch,
+			path:      "/v1/messages/" + tfMsgAA.ID,
+			reqBody:   `{"body":"Changed"}`,
+			userID:    tfUserA.ID,
+			resStatus: http.StatusOK,
+			authorExp: tfUserA.ID,
+		},
+		"PATCH: by someone else": {
+			method:    http.MethodPatch,
+			path:      "/v1/messages/" + tfMsgAA.ID,
+			reqBody:   `{"body":"Hijacked"}`,
+			userID:    tfUserB.ID,
+			resStatus: http.StatusForbidden,
+		},
+		"PUT: by someone else, claiming to be the author": {
+			method:    http.MethodPut,
+			path:      "/v1/messages/" + tfMsgAA.ID,
+			reqBody:   `{"body":"Hijacked","author":"UserA-Name","tag":"tagA"}`,
+			userID:    tfUserB.ID,
+			resStatus: http.StatusForbidden,
+		},
+		"DELETE: by author": {
+			method:    http.MethodDelete,
+			path:      "/v1/messages/" + tfMsgAA.ID,
+			userID:    tfUserA.ID,
+			resStatus: http.StatusNoContent,
+		},
+		"DELETE: by someone else": {
+			method:    http.MethodDelete,
+			path:      "/v1/messages/" + tfMsgAA.ID + "?author=UserA-Name",
+			userID:    tfUserB.ID,
+			resStatus: http.StatusForbidden,
+		},
+	}
 
-	res, err := http.Get(fmt.Sprintf("%s/v1/messages/%s", ts.URL, msg.ID))
+	for sym, tc := range tests {
+		st := NewMemoryStorage()
 
-	// THEN: validate response
-	ar.NoError(t, err, "unexpected error from HTTP client")
+		// GIVEN: users and message are in DB
+		for _, u := range []User{tfUserA, tfUserB} {
+			uC := u
+			ar.NoError(t, st.UserSave(context.Background(), &uC), "case: %s", sym)
+		}
+		msg := tfMsgAA
+		ar.NoError(t, st.MsgSave(context.Background(), &msg), "case: %s", sym)
 
-	a.Equal(t, http.StatusOK, res.StatusCode, "mismatch on response code")
-	a.Equal(t, "application/json", res.Header.Get("Content-Type"), "mismatch on response content encoding")
+		// AND: request is authenticated
+		req, err := http.NewRequest(tc.method, tc.path, strings.NewReader(tc.reqBody))
+		ar.NoError(t, err)
+		req = req.WithContext(ContextWithUserID(req.Context(), tc.userID))
+		res := httptest.NewRecorder()
+		NewHTTPDefaultHandler(st).ServeHTTP(res, req)
 
-	var resBodyGot MessageOut
-	err = json.NewDecoder(res.Body).Decode(&resBodyGot)
-	res.Body.Close()
-	ar.NoError(t, err, "unexpected error on response body read")
+		// THEN: validate response
+		ar.Equal(t, tc.resStatus, res.Code, "[%s] mismatch on response code", sym)
+		if res.Code >= http.StatusBadRequest {
+			tsAssertProblem(t, res.Code, res.Header(), res.Body, sym)
+		}
+		if tc.resStatus == http.StatusUnauthorized {
+			a.NotEmpty(t, res.Header().Get("WWW-Authenticate"), "[%s] missing challenge", sym)
+		}
 
-	a.Equal(t, tfTrOutMsgAA, resBodyGot)
+		// AND: message is authored by authenticated user
+		if tc.authorExp != "" {
+			msgID := tfMsgAA.ID
+			if loc := res.Header().Get("Location"); loc != "" {
+				msgID = strings.TrimPrefix(loc, "/v1/messages/")
+			}
+			msgGot, err := st.MsgLoad(context.Background(), msgID)
+			if a.NoError(t, err, "[%s] message not found", sym) {
+				a.Equal(t, tc.authorExp, msgGot.AuthorID, "[%s] message AuthorID mismatch", sym)
+			}
+		}
+	}
 }
 
-func Test_HTTPHandler_Message_Read_Success_NotFound(t *testing.T) {
-	st := NewMemoryStorage()
-	h := NewHTTPDefaultHandler(st)
-	ts := httptest.NewServer(h)
-	defer ts.Close()
+func Test_HTTPHandler_Authorization(t *testing.T) {
+	userMod := User{ID: "UserMod-ID", Name: "UserMod-Name", Roles: []Role{RoleModerator}}
+	userAdmin := User{ID: "UserAdmin-ID", Name: "UserAdmin-Name", Roles: []Role{RoleAdmin}}
+	userBoot := User{ID: "UserBoot-ID", Name: "UserBoot-Name"}
 
-	// GIVEN: message NOT in DB
-	res, err := http.Get(fmt.Sprintf("%s/v1/messages/non-existing-123", ts.URL))
+	tests := map[string]struct {
+		method    string
+		path      string
+		reqBody   string
+		userID    string
+		resStatus int
+		rolesExp  []Role
+	}{
+		"PATCH message: by moderator": {
+			method:    http.MethodPatch,
+			path:      "/v1/messages/" + tfMsgAA.ID,
+			reqBody:   `{"body":"Moderated"}`,
+			userID:    userMod.ID,
+			resStatus: http.StatusOK,
+		},
+		"PATCH message: by moderator, authentication disabled": {
+			method:    http.MethodPatch,
+			path:      "/v1/messages/" + tfMsgAA.ID,
+			reqBody:   `{"author":"UserMod-Name","body":"Moderated"}`,
+			resStatus: http.StatusForbidden,
+		},
+		"PATCH message: by user": {
+			method:    http.MethodPatch,
+			path:      "/v1/messages/" + tfMsgAA.ID,
+			reqBody:   `{"body":"Hijacked"}`,
+			userID:    tfUserB.ID,
+			resStatus: http.StatusForbidden,
+		},
+		"DELETE message: by moderator": {
+			method:    http.MethodDelete,
+			path:      "/v1/messages/" + tfMsgAA.ID,
+			userID:    userMod.ID,
+			resStatus: http.StatusNoContent,
+		},
+		"PUT user: by self": {
+			method:    http.MethodPut,
+			path:      "/v1/users/" + tfUserB.ID,
+			reqBody:   `{"name":"UserB-Renamed"}`,
+			userID:    tfUserB.ID,
+			resStatus: http.StatusOK,
+		},
+		"PUT user: by other user": {
+			method:    http.MethodPut,
+			path:      "/v1/users/" + tfUserB.ID,
+			reqBody:   `{"name":"UserB-Renamed"}`,
+			userID:    tfUserA.ID,
+			resStatus: http.StatusForbidden,
+		},
+		"PUT user: by admin": {
+			method:    http.MethodPut,
+			path:      "/v1/users/" + userMod.ID,
+			reqBody:   `{"name":"UserMod-Renamed"}`,
+			userID:    userAdmin.ID,
+			resStatus: http.StatusOK,
+			rolesExp:  []Role{RoleUser, RoleModerator},
+		},
+		"DELETE user: by self": {
+			method:    http.MethodDelete,
+			path:      "/v1/users/" + tfUserB.ID,
+			userID:    tfUserB.ID,
+			resStatus: http.StatusForbidden,
+		},
+		"DELETE user: by moderator": {
+			method:    http.MethodDelete,
+			path:      "/v1/users/" + tfUserB.ID,
+			userID:    userMod.ID,
+			resStatus: http.StatusForbidden,
+		},
+		"DELETE user: by admin": {
+			method:    http.MethodDelete,
+			path:      "/v1/users/" + tfUserB.ID,
+			userID:    userAdmin.ID,
+			resStatus: http.StatusNoContent,
+		},
+		"DELETE user: authentication disabled": {
+			method:    http.MethodDelete,
+			path:      "/v1/users/" + tfUserB.ID,
+			resStatus: http.StatusNoContent,
+		},
+		"PUT role: by admin": {
+			method:    http.MethodPut,
+			path:      "/v1/users/" + tfUserB.ID + "/roles/moderator",
+			userID:    userAdmin.ID,
+			resStatus: http.StatusOK,
+			rolesExp:  []Role{RoleUser, RoleModerator},
+		},
+		"PUT role: by admin from config": {
+			method:    http.MethodPut,
+			path:      "/v1/users/" + tfUserB.ID + "/roles/admin",
+			userID:    userBoot.ID,
+			resStatus: http.StatusOK,
+			rolesExp:  []Role{RoleUser, RoleAdmin},
+		},
+		"PUT role: already held": {
+			method:    http.MethodPut,
+			path:      "/v1/users/" + userMod.ID + "/roles/moderator",
+			userID:    userAdmin.ID,
+			resStatus: http.StatusOK,
+			rolesExp:  []Role{RoleUser, RoleModerator},
+		},
+		"PUT role: by moderator": {
+			method:    http.MethodPut,
+			path:      "/v1/users/" + userMod.ID + "/roles/admin",
+			userID:    userMod.ID,
+			resStatus: http.StatusForbidden,
+		},
+		"PUT role: authentication disabled": {
+			method:    http.MethodPut,
+			path:      "/v1/users/" + tfUserB.ID + "/roles/admin",
+			resStatus: http.StatusForbidden,
+		},
+		"PUT role: unknown role": {
+			method:    http.MethodPut,
+			path:      "/v1/users/" + tfUserB.ID + "/roles/root",
+			userID:    userAdmin.ID,
+			resStatus: http.StatusBadRequest,
+		},
+		"PUT role: implicit role": {
+			method:    http.MethodPut,
+			path:      "/v1/users/" + tfUserB.ID + "/roles/user",
+			userID:    userAdmin.ID,
+			resStatus: http.StatusBadRequest,
+		},
+		"PUT role: user not found": {
+			method:    http.MethodPut,
+			path:      "/v1/users/non-existing-123/roles/moderator",
+			userID:    userAdmin.ID,
+			resStatus: http.StatusNotFound,
+		},
+		"DELETE role: by admin": {
+			method:    http.MethodDelete,
+			path:      "/v1/users/" + userMod.ID + "/roles/moderator",
+			userID:    userAdmin.ID,
+			resStatus: http.StatusOK,
+			rolesExp:  []Role{RoleUser},
+		},
+		"GET role: method not allowed": {
+			method:    http.MethodGet,
+			path:      "/v1/users/" + userMod.ID + "/roles/moderator",
+			userID:    userAdmin.ID,
+			resStatus: http.StatusMethodNotAllowed,
+		},
+	}
 
-	// THEN: validate response
-	ar.NoError(t, err, "unexpected error from HTTP client")
-	a.Equal(t, http.StatusNotFound, res.StatusCode, "mismatch on response code")
-	a.EqualValues(t, 0, res.ContentLength, "non empty response body")
-}
+	for sym, tc := range tests {
+		st := NewMemoryStorage()
 
-func Test_HTTPHandler_Message_Read_Failure(t *testing.T) {
-	var ts *httptest.Server
-	defer func() {
-		if ts != nil {
-			ts.Close()
+		// GIVEN: users with roles and message are in DB
+		for _, u := range []User{tfUserA, tfUserB, userMod, userAdmin, userBoot} {
+			uC := u
+			ar.NoError(t, st.UserSave(context.Background(), &uC), "case: %s", sym)
 		}
-	}()
+		msg := tfMsgAA
+		ar.NoError(t, st.MsgSave(context.Background(), &msg), "case: %s", sym)
+
+		// AND: request is authenticated, unless authentication is disabled
+		req, err := http.NewRequest(tc.method, tc.path, strings.NewReader(tc.reqBody))
+		ar.NoError(t, err)
+		if tc.userID != "" {
+			req = req.WithContext(ContextWithUserID(req.Context(), tc.userID))
+		}
+		res := httptest.NewRecorder()
+		NewHTTPHandler(st, HTTPHandlerConfig{Admins: []string{userBoot.ID}}).ServeHTTP(res, req)
+
+		// THEN: validate response
+		ar.Equal(t, tc.resStatus, res.Code, "[%s] mismatch on response code", sym)
+		if res.Code >= http.StatusBadRequest {
+			tsAssertProblem(t, res.Code, res.Header(), res.Body, sym)
+		}
+
+		// AND: roles are reported
+		if tc.rolesExp != nil {
+			var trOut UserOut
+			ar.NoError(t, json.NewDecoder(res.Body).Decode(&trOut), "[%s] unexpected error on body decode", sym)
+			a.Equal(t, tc.rolesExp, trOut.Roles, "[%s] roles mismatch", sym)
+		}
+
+		// AND: message changed by moderator keeps its author
+		if tc.method == http.MethodPatch && res.Code == http.StatusOK {
+			var trOut MessageOut
+			ar.NoError(t, json.NewDecoder(res.Body).Decode(&trOut), "[%s] unexpected error on body decode", sym)
+			a.Equal(t, tfUserA.Name, trOut.Author, "[%s] author mismatch in response", sym)
+			msgGot, err := st.MsgLoad(context.Background(), tfMsgAA.ID)
+			ar.NoError(t, err, "[%s] message not found", sym)
+			a.Equal(t, tfMsgAA.AuthorID, msgGot.AuthorID, "[%s] message author changed", sym)
+			a.Equal(t, "Moderated", msgGot.Body, "[%s] message not changed", sym)
+		}
+	}
+}
 
+func Test_HTTPHandler_Message_MethodNotAllowed(t *testing.T) {
 	tests := map[string]struct {
-		dbUsers     []User
-		dbMsg       []Message
-		msgID       string
-		mlCalledExp bool // ml = MsgLoad
-		mlErr       error
-		ulCalledExp bool // ul = UserLoad
-		ulErr       error
-		resStatus   int
+		method   string
+		path     string
+		allowExp string
 	}{
-		"MsgLoad error": {
-			dbUsers:     []User{tfUserA},
-			dbMsg:       []Message{tfMsgAA},
-			msgID:       tfMsgAA.ID,
-			mlCalledExp: true,
-			mlErr:       errors.New("some kind of DB error"),
-			resStatus:   http.StatusInternalServerError,
+		"collection: PUT": {
+			http.MethodPut,
+			"/v1/messages",
+			"GET, POST, OPTIONS",
 		},
-		"UserLoad error: user not found": {
-			dbUsers:     []User{tfUserA},
-			dbMsg:       []Message{tfMsgAA},
-			msgID:       tfMsgAA.ID,
-			mlCalledExp: true,
-			ulCalledExp: true,
-			ulErr:       ErrElementNotFound,
-			resStatus:   http.StatusInternalServerError,
+		"collection: DELETE": {
+			http.MethodDelete,
+			"/v1/messages/",
+			"GET, POST, OPTIONS",
 		},
-		"UserLoad error: db error": {
-			dbUsers:     []User{tfUserA},
-			dbMsg:       []Message{tfMsgAA},
-			msgID:       tfMsgAA.ID,
-			mlCalledExp: true,
-			ulCalledExp: true,
-			ulErr:       errors.New("some kind of DB error"),
-			resStatus:   http.StatusInternalServerError,
+		"item: POST": {
+			http.MethodPost,
+			"/v1/messages/" + tfMsgAA.ID,
+			"GET, PUT, PATCH, DELETE, OPTIONS",
+		},
+		"revisions: DELETE": {
+			http.MethodDelete,
+			"/v1/messages/" + tfMsgAA.ID + "/revisions",
+			"GET, OPTIONS",
+		},
+		"users collection: DELETE": {
+			http.MethodDelete,
+			"/v1/users",
+			"GET, POST, OPTIONS",
+		},
+		"user: PATCH": {
+			http.MethodPatch,
+			"/v1/users/" + tfUserA.ID,
+			"GET, PUT, DELETE, OPTIONS",
+		},
+		"user mentions: POST": {
+			http.MethodPost,
+			"/v1/users/" + tfUserA.ID + "/mentions",
+			"GET, OPTIONS",
 		},
 	}
 
 	for sym, tc := range tests {
 		st := NewTmMemoryStorageMock()
-		st.outMsgLoadErr = tc.mlErr
-		st.outUserLoadErr = tc.ulErr
 
-		h := NewHTTPDefaultHandler(st)
-		ts = httptest.NewServer(h)
+		req, err := http.NewRequest(tc.method, tc.path, nil)
+		ar.NoError(t, err)
+		res := httptest.NewRecorder()
+		NewHTTPDefaultHandler(st).ServeHTTP(res, req)
 
-		// GIVEN: user and message are in DB
-		for _, u := range tc.dbUsers {
-			uC := u
-			ar.NoError(t, st.UserSave(&uC), "case: %s", sym)
-		}
-		st.inUserSaveCalled = false
-		for _, m := range tc.dbMsg {
-			mC := m
-			ar.NoError(t, st.MsgSave(&mC), "case: %s", sym)
-		}
-		st.inMsgSaveCalled = false
+		a.Equal(t, http.StatusMethodNotAllowed, res.Code, "[%s] mismatch on response code", sym)
+		a.Equal(t, tc.allowExp, res.Header().Get("Allow"), "[%s] mismatch on allowed methods", sym)
 
-		// WHEN: message create is called
-		res, err := http.Get(fmt.Sprintf("%s/v1/messages/%s", ts.URL, tc.msgID))
+		// AND: storage is not touched
+		a.False(t, st.inMsgSaveCalled, "[%s] MsgSave function called", sym)
+		a.False(t, st.inMsgDeleteCalled, "[%s] MsgDelete function called", sym)
+	}
+}
 
-		// THEN: validate response
-		ar.NoError(t, err, "[%s] unexpected error from HTTP client", sym)
-		a.EqualValues(t, 0, res.ContentLength, "[%s] non empty response body", sym)
-		a.Equal(t, tc.resStatus, res.StatusCode, "[%s] mismatch on response code", sym)
+func Test_HTTPHandler_Problem_Validation(t *testing.T) {
+	tests := map[string]struct {
+		method    string
+		path      string
+		reqBody   string
+		errorsExp []FieldErrorOut
+	}{
+		"message: all fields invalid": {
+			method:  http.MethodPost,
+			path:    "/v1/messages",
+			reqBody: `{"tag":"s"}`,
+			errorsExp: []FieldErrorOut{
+				{Field: "body", Code: FieldErrRequired, Message: "missing Body"},
+				{Field: "author", Code: FieldErrRequired, Message: "missing Author"},
+				{Field: "tag", Code: FieldErrTooShort, Message: "too short"},
+			},
+		},
+		"message: unknown author": {
+			method:  http.MethodPost,
+			path:    "/v1/messages",
+			reqBody: `{"body":"A-Body","author":"UserX-Name","tag":"tagA"}`,
+			errorsExp: []FieldErrorOut{
+				{Field: "author", Code: FieldErrUnknown, Message: "unknown Author"},
+			},
+		},
+		"user: name too short": {
+			method:  http.MethodPost,
+			path:    "/v1/users",
+			reqBody: `{"name":"A"}`,
+			errorsExp: []FieldErrorOut{
+				{Field: "name", Code: FieldErrTooShort, Message: "Name too short"},
+			},
+		},
+		"messages: no tag": {
+			method: http.MethodGet,
+			path:   "/v1/messages?tag=&not=tagA",
+			errorsExp: []FieldErrorOut{
+				{Field: "tag", Code: FieldErrRequired, Message: "tag or any is required"},
+			},
+		},
+		"messages: too many tags": {
+			method: http.MethodGet,
+			path:   "/v1/messages?tag=tagA" + strings.Repeat("&any=tagB", 2) + tsTagsParams("not", msgsFindTagsMax),
+			errorsExp: []FieldErrorOut{
+				{Field: "tag", Code: FieldErrInvalid, Message: "too many tags, up to 20 are allowed"},
+			},
+		},
+		"message: invalid tags": {
+			method:  http.MethodPost,
+			path:    "/v1/messages",
+			reqBody: `{"body":"A-Body","author":"UserA-Name","tags":["tagA","s"]}`,
+			errorsExp: []FieldErrorOut{
+				{Field: "tags.1", Code: FieldErrTooShort, Message: "too short"},
+			},
+		},
+		"message: too many tags": {
+			method:  http.MethodPost,
+			path:    "/v1/messages",
+			reqBody: `{"body":"A-Body","author":"UserA-Name","tag":"tagA","tags":["t1","t2","t3","t4","t5","t6","t7","t8","t9","t10"]}`,
+			errorsExp: []FieldErrorOut{
+				{Field: "tags", Code: FieldErrTooLong, Message: "too many Tags, up to 10 are allowed"},
+			},
+		},
+		"messages: limit out of range": {
+			method: http.MethodGet,
+			path:   "/v1/messages?tag=tagA&limit=1000",
+			errorsExp: []FieldErrorOut{
+				{Field: "limit", Code: FieldErrInvalid, Message: "limit out of range [1, 100]"},
+			},
+		},
+	}
 
-		// AND: validate storage access
-		a.Equal(t, tc.mlCalledExp, st.inMsgLoadCalled, "[%s] MsgLoad function call status mismatch", sym)
-		a.Equal(t, tc.ulCalledExp, st.inUserLoadCalled, "[%s] UserLoad function call status mismatch", sym)
+	for sym, tc := range tests {
+		st := NewMemoryStorage()
+
+		req, err := http.NewRequest(tc.method, tc.path, strings.NewReader(tc.reqBody))
+		ar.NoError(t, err)
+		res := httptest.NewRecorder()
+		NewHTTPDefaultHandler(st).ServeHTTP(res, req)
+
+		// THEN: all invalid fields are reported
+		ar.Equal(t, http.StatusBadRequest, res.Code, "[%s] mismatch on response code", sym)
+		p := tsAssertProblem(t, res.Code, res.Header(), res.Body, sym)
+		a.Equal(t, problemValidation, p.Code, "[%s] mismatch on problem code", sym)
+		a.Equal(t, tc.errorsExp, p.Errors, "[%s] mismatch on field errors", sym)
 	}
 }
 
+// tsTagsParams builds query parameters with n distinct tags.
+func tsTagsParams(name string, n int) string {
+	var s string
+	for i := 0; i < n; i++ {
+		s += fmt.Sprintf("&%s=tag-%d", name, i)
+	}
+	return s
+}
+
+func Test_HTTPHandler_Problem_InvalidJSON(t *testing.T) {
+	st := NewMemoryStorage()
+
+	req, err := http.NewRequest(http.MethodPost, "/v1/users", strings.NewReader("NotA-JSON"))
+	ar.NoError(t, err)
+	res := httptest.NewRecorder()
+	NewHTTPDefaultHandler(st).ServeHTTP(res, req)
+
+	ar.Equal(t, http.StatusBadRequest, res.Code, "mismatch on response code")
+	p := tsAssertProblem(t, res.Code, res.Header(), res.Body, "invalid JSON")
+	a.Equal(t, problemInvalidJSON, p.Code, "mismatch on problem code")
+	a.Equal(t, "about:blank", p.Type, "mismatch on problem type")
+	a.Equal(t, "Bad Request", p.Title, "mismatch on problem title")
+	a.Empty(t, p.Errors, "field errors on malformed body")
+}
+
 func Test_HTTPHandler_Message_GET_unknownPath(t *testing.T) {
 	st := NewMemoryStorage()
 	h := NewHTTPDefaultHandler(st)
@@ -548,7 +2307,7 @@ func Test_HTTPHandler_Message_GET_unknownPath(t *testing.T) {
 	// THEN: validate response
 	ar.NoError(t, err, "unexpected error from HTTP client")
 	a.Equal(t, http.StatusNotFound, res.StatusCode, "mismatch on response code")
-	a.EqualValues(t, 0, res.ContentLength, "non empty response body")
+	tsAssertProblem(t, res.StatusCode, res.Header, res.Body, "unknown path")
 }
 
 // TODO: validate file content
@@ -577,6 +2336,10 @@ func Test_HTTPHandler_Options(t *testing.T) {
 		path string
 	}{
 		{"/v1/users"},
+		{"/v1/users/" + tfUserA.ID},
+		{"/v1/messages"},
+		{"/v1/messages/" + tfMsgAA.ID},
+		{"/v1/messages/" + tfMsgAA.ID + "/revisions"},
 	}
 
 	for _, tc := range tests {
@@ -595,3 +2358,16 @@ func Test_HTTPHandler_Options(t *testing.T) {
 		a.Equal(t, http.StatusOK, res.StatusCode, "[%s] mismatch on response code", tc.path)
 	}
 }
+
+// -- test helpers
+
+// tsAssertProblem checks if response body is a problem (RFC 7807) matching response status and returns it.
+func tsAssertProblem(t *testing.T, status int, header http.Header, body io.Reader, sym string) ProblemOut {
+	var p ProblemOut
+	a.Equal(t, problemContentType, header.Get("Content-Type"), "[%s] mismatch on response content encoding", sym)
+	if a.NoError(t, json.NewDecoder(body).Decode(&p), "[%s] unexpected error on problem decode", sym) {
+		a.Equal(t, status, p.Status, "[%s] mismatch on problem status", sym)
+		a.NotEmpty(t, p.Code, "[%s] empty problem code", sym)
+	}
+	return p
+}
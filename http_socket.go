@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// socketPingEveryDefault is an interval of pings sent to the client.
+	socketPingEveryDefault = 30 * time.Second
+
+	// socketPongWaitDefault is a time in which client must send anything, pong included, before it's disconnected.
+	socketPongWaitDefault = 60 * time.Second
+
+	// socketWriteTimeoutDefault is a time in which client must accept a frame before it's disconnected.
+	socketWriteTimeoutDefault = 10 * time.Second
+
+	// socketRepliesMax is a number of replies queued for the client before it's considered too slow.
+	// Events are queued by the bus, see msgBusBuffer.
+	socketRepliesMax = 16
+
+	// socketMessageMax is a maximum size of the command sent by the client, in bytes.
+	socketMessageMax = 64 << 10
+)
+
+// SocketConfig tunes WebSocket connections. Zero values are replaced by defaults.
+type SocketConfig struct {
+	// PingEvery is an interval of pings sent to the client.
+	PingEvery time.Duration
+
+	// PongWait is a time in which client must send anything, pong included, before it's disconnected.
+	// It should be longer than PingEvery.
+	PongWait time.Duration
+
+	// WriteTimeout is a time in which client must accept a frame before it's disconnected.
+	WriteTimeout time.Duration
+}
+
+func (c SocketConfig) withDefaults() SocketConfig {
+	if c.PingEvery <= 0 {
+		c.PingEvery = socketPingEveryDefault
+	}
+	if c.PongWait <= 0 {
+		c.PongWait = socketPongWaitDefault
+	}
+	if c.WriteTimeout <= 0 {
+		c.WriteTimeout = socketWriteTimeoutDefault
+	}
+	return c
+}
+
+// handleSocket upgrades the connection to WebSocket and serves commands of the client until it disconnects.
+func (h *messagesHandler) handleSocket(w http.ResponseWriter, r *http.Request) {
+	if h.Bus == nil {
+		writeProblem(w, newProblem(http.StatusNotImplemented, problemNotImplemented, "WebSocket is not supported"))
+		return
+	}
+
+	c, err := wsUpgrade(w, r)
+	switch err {
+	case nil:
+	case errWSHandshake:
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeProblem(w, newProblem(http.StatusBadRequest, problemInvalidHandshake, "WebSocket opening handshake expected"))
+		return
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+
+	h.serveSocket(r.Context(), c)
+}
+
+// socketSession is a state of single WebSocket connection.
+// Commands are read and handled by one goroutine, replies and events are written by the other.
+type socketSession struct {
+	h   *messagesHandler
+	c   *wsConn
+	sub *MsgSubscription
+
+	// replies are queued for the writer, connection is closed when client does not keep up
+	replies chan SocketOut
+
+	// done is closed once reading ends
+	done chan struct{}
+}
+
+// serveSocket serves commands of the client until connection ends, then closes it.
+// Connection is closed when client is silent for longer than PongWait, does not accept frames within WriteTimeout,
+// or does not keep up with replies or events. It's closed when bus is closed as well.
+func (h *messagesHandler) serveSocket(ctx context.Context, c *wsConn) {
+	cfg := h.Socket.withDefaults()
+	c.MaxMessage = socketMessageMax
+	c.ReadTimeout = cfg.PongWait
+	c.WriteTimeout = cfg.WriteTimeout
+	defer c.Close()
+
+	s := &socketSession{
+		h:       h,
+		c:       c,
+		sub:     h.Bus.Subscribe(),
+		replies: make(chan SocketOut, socketRepliesMax),
+		done:    make(chan struct{}),
+	}
+	defer s.sub.Close()
+
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		s.writeLoop(ctx, cfg.PingEvery)
+	}()
+
+	s.readLoop(ctx)
+	close(s.done)
+	<-writerDone
+}
+
+// readLoop handles commands until the connection ends.
+func (s *socketSession) readLoop(ctx context.Context) {
+	for {
+		op, data, err := s.c.ReadMessage()
+		if err != nil {
+			if pe, ok := err.(*wsProtocolError); ok {
+				s.c.WriteClose(pe.Code, pe.Reason)
+			}
+			return
+		}
+
+		var out SocketOut
+		if op != wsOpText {
+			p := newProblem(http.StatusBadRequest, problemInvalidJSON, "commands are sent as text messages")
+			out = SocketOut{Type: socketReplyError, Problem: &p}
+		} else {
+			out = s.handle(ctx, data)
+		}
+
+		select {
+		case s.replies <- out:
+		default:
+			s.c.WriteClose(wsClosePolicy, "client too slow")
+			return
+		}
+	}
+}
+
+// writeLoop sends replies, events and pings until reading ends or the connection fails.
+func (s *socketSession) writeLoop(ctx context.Context, pingEvery time.Duration) {
+	ping := time.NewTicker(pingEvery)
+	defer ping.Stop()
+
+	for {
+		var err error
+		select {
+		case <-s.done:
+			return
+		case out := <-s.replies:
+			err = s.write(out)
+		case ev, ok := <-s.sub.C:
+			if !ok {
+				switch s.sub.Err() {
+				case ErrSubscriptionLagged:
+					s.c.WriteClose(wsClosePolicy, "client too slow")
+				case ErrMsgBusClosed:
+					s.c.WriteClose(wsCloseGoingAway, "server is shutting down")
+				}
+				s.c.Close()
+				return
+			}
+			var out SocketOut
+			if out, err = s.event(ctx, ev); err != nil {
+				s.c.WriteClose(wsCloseInternal, "")
+				break
+			}
+			err = s.write(out)
+		case <-ping.C:
+			err = s.c.WriteFrame(wsOpPing, nil)
+		}
+
+		if err != nil {
+			// reading fails as well and ends the session
+			s.c.Close()
+			return
+		}
+	}
+}
+
+func (s *socketSession) write(out SocketOut) error {
+	b, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	return s.c.WriteFrame(wsOpText, b)
+}
+
+// handle executes single command and returns the reply.
+func (s *socketSession) handle(ctx context.Context, data []byte) SocketOut {
+	var in SocketIn
+	if err := json.Unmarshal(data, &in); err != nil {
+		p := newProblem(http.StatusBadRequest, problemInvalidJSON, err.Error())
+		return SocketOut{Type: socketReplyError, Problem: &p}
+	}
+
+	var out SocketOut
+	var p *ProblemOut
+	switch in.Type {
+	case socketCmdSubscribe:
+		out, p = s.handleSubscribe(in)
+	case socketCmdUnsubscribe:
+		out, p = s.handleUnsubscribe(in)
+	case socketCmdPost:
+		out, p = s.handlePost(ctx, in)
+	default:
+		vp := newValidationProblem(NewValidationError(FieldError{Field: "type", Code: FieldErrInvalid, Msg: "unknown command"}))
+		p = &vp
+	}
+
+	if p != nil {
+		return SocketOut{Type: socketReplyError, ID: in.ID, Problem: p}
+	}
+	out.Type = socketReplyAck
+	out.ID = in.ID
+	return out
+}
+
+func (s *socketSession) handleSubscribe(in SocketIn) (SocketOut, *ProblemOut) {
+	if err := socketTagsValidate(in.Tags); err != nil {
+		p := newValidationProblem(err)
+		return SocketOut{}, &p
+	}
+
+	followed := make(map[string]bool)
+	for _, t := range append(s.sub.Tags(), in.Tags...) {
+		followed[t.Key()] = true
+	}
+	if len(followed) > streamTagsMax {
+		p := newValidationProblem(NewValidationError(FieldError{Field: "tags", Code: FieldErrInvalid, Msg: fmt.Sprintf("too many tags, up to %d allowed", streamTagsMax)}))
+		return SocketOut{}, &p
+	}
+
+	s.sub.Follow(in.Tags...)
+	return SocketOut{Tags: s.sub.Tags()}, nil
+}
+
+func (s *socketSession) handleUnsubscribe(in SocketIn) (SocketOut, *ProblemOut) {
+	if err := socketTagsValidate(in.Tags); err != nil {
+		p := newValidationProblem(err)
+		return SocketOut{}, &p
+	}
+
+	s.sub.Unfollow(in.Tags...)
+	return SocketOut{Tags: s.sub.Tags()}, nil
+}
+
+// handlePost creates message the same way as POST /v1/messages does.
+func (s *socketSession) handlePost(ctx context.Context, in SocketIn) (SocketOut, *ProblemOut) {
+	if in.Message == nil {
+		p := newValidationProblem(NewValidationError(FieldError{Field: "message", Code: FieldErrRequired, Msg: "missing Message"}))
+		return SocketOut{}, &p
+	}
+
+	msg, author, p := s.h.create(ctx, *in.Message)
+	if p != nil {
+		return SocketOut{}, p
+	}
+	trOut := msgToTransport(msg, author)
+	return SocketOut{Message: &trOut}, nil
+}
+
+// event converts change of the message into event sent to the client.
+func (s *socketSession) event(ctx context.Context, ev MsgEvent) (SocketOut, error) {
+	var author *User
+	if ev.Msg.AuthorID != "" {
+		var err error
+		author, err = s.h.Storer.UserLoad(ctx, ev.Msg.AuthorID)
+		if err != nil && err != ErrElementNotFound {
+			return SocketOut{}, err
+		}
+	}
+	trOut := msgToTransport(ev.Msg, author)
+	return SocketOut{Type: string(ev.Type), Message: &trOut}, nil
+}
+
+// socketTagsValidate makes sure at least one tag is given and all of them are valid.
+func socketTagsValidate(tags []Tag) error {
+	if len(tags) == 0 {
+		return NewValidationError(FieldError{Field: "tags", Code: FieldErrRequired, Msg: "at least one tag is required"})
+	}
+	for _, t := range tags {
+		if err := t.Validate(); err != nil {
+			return NewValidationError("invalid Tag", err).InField("tags")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+// send sends the command.
+func (c *tsWSClient) send(t *testing.T, in interface{}) {
+	b, err := json.Marshal(in)
+	ar.NoError(t, err)
+	ar.NoError(t, c.writeFrame(true, wsOpText, b), "unexpected error on command write")
+}
+
+// recv receives next reply or event. Pings are skipped.
+func (c *tsWSClient) recv(t *testing.T) SocketOut {
+	for {
+		op, payload, err := c.readFrame()
+		ar.NoError(t, err, "unexpected error on read")
+		if op == wsOpPing {
+			continue
+		}
+		ar.Equal(t, wsOpText, op, "unexpected frame: %s", payload)
+		var out SocketOut
+		ar.NoError(t, json.Unmarshal(payload, &out), "unexpected error on decode")
+		return out
+	}
+}
+
+// recvAll receives n replies or events, keyed by type. Their order is not guaranteed.
+func (c *tsWSClient) recvAll(t *testing.T, n int) map[string]SocketOut {
+	out := make(map[string]SocketOut, n)
+	for i := 0; i < n; i++ {
+		o := c.recv(t)
+		out[o.Type] = o
+	}
+	return out
+}
+
+func Test_HTTPHandler_Message_Socket(t *testing.T) {
+	st := NewMemoryStorage()
+	bus := NewMsgBus()
+	ts := httptest.NewServer(NewHTTPHandler(st, HTTPHandlerConfig{Bus: bus}))
+	defer ts.Close()
+
+	// GIVEN: authors exist
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		ar.NoError(t, st.UserSave(context.Background(), &uC))
+	}
+
+	c := tsWSDial(t, ts.URL, "/v1/messages/ws", nil)
+	defer c.conn.Close()
+
+	// WHEN: client subscribes
+	c.send(t, SocketIn{Type: socketCmdSubscribe, ID: "1", Tags: []Tag{tfTagB, tfTagA}})
+	a.Equal(t, SocketOut{Type: socketReplyAck, ID: "1", Tags: []Tag{tfTagA, tfTagB}}, c.recv(t), "subscribe reply mismatch")
+
+	// AND: posts message
+	c.send(t, SocketIn{Type: socketCmdPost, ID: "2", Message: &MessageIn{Body: "Msg-1", Author: tfUserA.Name, Tag: tfTagA}})
+
+	// THEN: post is confirmed and pushed as created
+	got := c.recvAll(t, 2)
+	ack := got[socketReplyAck]
+	a.Equal(t, "2", ack.ID, "post reply ID mismatch")
+	ar.NotNil(t, ack.Message, "posted message not returned")
+	a.Equal(t, "Msg-1", ack.Message.Body, "posted message Body mismatch")
+	a.Equal(t, tfUserA.Name, ack.Message.Author, "posted message Author mismatch")
+	ar.NotNil(t, got[string(MsgEventCreated)].Message, "created event not received")
+	a.Equal(t, *ack.Message, *got[string(MsgEventCreated)].Message, "created event mismatch")
+	msgID := ack.Message.ID
+
+	msgStored, err := st.MsgLoad(context.Background(), msgID)
+	ar.NoError(t, err, "posted message not stored")
+	a.Equal(t, "Msg-1", msgStored.Body, "stored message Body mismatch")
+
+	// WHEN: message is edited and removed over HTTP
+	req, err := http.NewRequest(http.MethodPatch, ts.URL+"/v1/messages/"+msgID, strings.NewReader(`{"body":"Msg-1-edited","author":"UserA-Name"}`))
+	ar.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	ar.NoError(t, err)
+	ar.Equal(t, http.StatusOK, res.StatusCode, "message not edited")
+	req, err = http.NewRequest(http.MethodDelete, ts.URL+"/v1/messages/"+msgID+"?author=UserA-Name", nil)
+	ar.NoError(t, err)
+	res, err = http.DefaultClient.Do(req)
+	ar.NoError(t, err)
+	ar.Equal(t, http.StatusNoContent, res.StatusCode, "message not removed")
+
+	// THEN: changes are pushed
+	ev := c.recv(t)
+	a.Equal(t, string(MsgEventUpdated), ev.Type, "event type mismatch")
+	if a.NotNil(t, ev.Message, "message of the event missing") {
+		a.Equal(t, "Msg-1-edited", ev.Message.Body, "edited message Body mismatch")
+	}
+	ev = c.recv(t)
+	a.Equal(t, string(MsgEventDeleted), ev.Type, "event type mismatch")
+	if a.NotNil(t, ev.Message, "message of the event missing") {
+		a.Equal(t, msgID, ev.Message.ID, "removed message ID mismatch")
+	}
+
+	// WHEN: client unsubscribes
+	c.send(t, SocketIn{Type: socketCmdUnsubscribe, ID: "3", Tags: []Tag{tfTagA, tfTagC}})
+	a.Equal(t, SocketOut{Type: socketReplyAck, ID: "3", Tags: []Tag{tfTagB}}, c.recv(t), "unsubscribe reply mismatch")
+
+	// THEN: messages of the tag are not pushed anymore
+	c.send(t, SocketIn{Type: socketCmdPost, ID: "4", Message: &MessageIn{Body: "Msg-2", Author: tfUserB.Name, Tag: tfTagA}})
+	c.send(t, SocketIn{Type: socketCmdPost, ID: "5", Message: &MessageIn{Body: "Msg-3", Author: tfUserB.Name, Tag: tfTagB}})
+	a.Equal(t, "4", c.recv(t).ID, "reply mismatch")
+	got = c.recvAll(t, 2)
+	a.Equal(t, "5", got[socketReplyAck].ID, "reply mismatch")
+	if a.NotNil(t, got[string(MsgEventCreated)].Message, "created event not received") {
+		a.Equal(t, "Msg-3", got[string(MsgEventCreated)].Message.Body, "created message Body mismatch")
+	}
+
+	// AND: connection is closed on shutdown
+	bus.Close()
+	a.Equal(t, wsCloseGoingAway, c.readClose(t), "close code mismatch")
+}
+
+func Test_HTTPHandler_Message_Socket_Command_Failure(t *testing.T) {
+	tooMany := make([]Tag, streamTagsMax+1)
+	for i := range tooMany {
+		tooMany[i] = Tag(fmt.Sprintf("tag%d", i))
+	}
+
+	tests := map[string]struct {
+		cmd        interface{}
+		resStatus  int
+		resCode    string
+		errorField string
+	}{
+		"invalid JSON": {
+			cmd:       "not a command",
+			resStatus: http.StatusBadRequest,
+			resCode:   problemInvalidJSON,
+		},
+		"unknown command": {
+			cmd:        SocketIn{Type: "shout", ID: "X"},
+			resStatus:  http.StatusBadRequest,
+			resCode:    problemValidation,
+			errorField: "type",
+		},
+		"subscribe: no tags": {
+			cmd:        SocketIn{Type: socketCmdSubscribe, ID: "X"},
+			resStatus:  http.StatusBadRequest,
+			resCode:    problemValidation,
+			errorField: "tags",
+		},
+		"subscribe: invalid tag": {
+			cmd:        SocketIn{Type: socketCmdSubscribe, ID: "X", Tags: []Tag{tfTagA, tfTagXA_TooShort}},
+			resStatus:  http.StatusBadRequest,
+			resCode:    problemValidation,
+			errorField: "tags",
+		},
+		"subscribe: too many tags": {
+			cmd:        SocketIn{Type: socketCmdSubscribe, ID: "X", Tags: tooMany},
+			resStatus:  http.StatusBadRequest,
+			resCode:    problemValidation,
+			errorField: "tags",
+		},
+		"unsubscribe: invalid tag": {
+			cmd:        SocketIn{Type: socketCmdUnsubscribe, ID: "X", Tags: []Tag{""}},
+			resStatus:  http.StatusBadRequest,
+			resCode:    problemValidation,
+			errorField: "tags",
+		},
+		"post: no message": {
+			cmd:        SocketIn{Type: socketCmdPost, ID: "X"},
+			resStatus:  http.StatusBadRequest,
+			resCode:    problemValidation,
+			errorField: "message",
+		},
+		"post: invalid message": {
+			cmd:        SocketIn{Type: socketCmdPost, ID: "X", Message: &MessageIn{Author: tfUserA.Name, Tag: tfTagA}},
+			resStatus:  http.StatusBadRequest,
+			resCode:    problemValidation,
+			errorField: "body",
+		},
+		"post: unknown author": {
+			cmd:        SocketIn{Type: socketCmdPost, ID: "X", Message: &MessageIn{Body: "Msg", Author: "UserX-Name", Tag: tfTagA}},
+			resStatus:  http.StatusBadRequest,
+			resCode:    problemValidation,
+			errorField: "author",
+		},
+	}
+
+	st := NewMemoryStorage()
+	ar.NoError(t, st.UserSave(context.Background(), &tfUserA))
+	ts := httptest.NewServer(NewHTTPDefaultHandler(st))
+	defer ts.Close()
+	c := tsWSDial(t, ts.URL, "/v1/messages/ws", nil)
+	defer c.conn.Close()
+
+	for sym, tc := range tests {
+		c.send(t, tc.cmd)
+		out := c.recv(t)
+
+		// THEN:
+		a.Equal(t, socketReplyError, out.Type, "[%s] reply type mismatch", sym)
+		if in, ok := tc.cmd.(SocketIn); ok {
+			a.Equal(t, in.ID, out.ID, "[%s] reply ID mismatch", sym)
+		}
+		if !a.NotNil(t, out.Problem, "[%s] problem missing", sym) {
+			continue
+		}
+		a.Equal(t, tc.resStatus, out.Problem.Status, "[%s] problem status mismatch", sym)
+		a.Equal(t, tc.resCode, out.Problem.Code, "[%s] problem code mismatch", sym)
+		if tc.errorField != "" && a.NotEmpty(t, out.Problem.Errors, "[%s] field errors missing", sym) {
+			a.Equal(t, tc.errorField, out.Problem.Errors[0].Field, "[%s] invalid field mismatch", sym)
+		}
+	}
+
+	// AND: nothing was stored
+	_, err := st.MsgsIDsFindByTag(context.Background(), tfTagA, MsgCursor{}, 0)
+	a.Equal(t, ErrElementNotFound, err, "message stored")
+}
+
+func Test_HTTPHandler_Message_Socket_Authenticated(t *testing.T) {
+	st := NewMemoryStorage()
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		ar.NoError(t, st.UserSave(context.Background(), &uC))
+	}
+	keys, err := ParseAPIKeys([]string{tfUserB.ID + ":keyB"})
+	ar.NoError(t, err)
+	ts := httptest.NewServer(NewAuthMiddleware(NewHTTPDefaultHandler(st), keys, nil))
+	defer ts.Close()
+
+	tests := map[string]struct {
+		header    map[string]string
+		author    string
+		resStatus int
+		authorExp string
+	}{
+		"anonymous": {
+			author:    tfUserA.Name,
+			resStatus: http.StatusUnauthorized,
+		},
+		"API key": {
+			header:    map[string]string{"X-API-Key": "keyB"},
+			resStatus: http.StatusOK,
+			authorExp: tfUserB.Name,
+		},
+		"API key, other author": {
+			header:    map[string]string{"X-API-Key": "keyB"},
+			author:    tfUserA.Name,
+			resStatus: http.StatusForbidden,
+		},
+	}
+
+	for sym, tc := range tests {
+		c := tsWSDial(t, ts.URL, "/v1/messages/ws", tc.header)
+		c.send(t, SocketIn{Type: socketCmdPost, ID: "1", Message: &MessageIn{Body: "Msg", Author: tc.author, Tag: tfTagA}})
+		out := c.recv(t)
+		c.conn.Close()
+
+		// THEN:
+		if tc.resStatus == http.StatusOK {
+			a.Equal(t, socketReplyAck, out.Type, "[%s] reply type mismatch", sym)
+			if a.NotNil(t, out.Message, "[%s] message missing", sym) {
+				a.Equal(t, tc.authorExp, out.Message.Author, "[%s] author mismatch", sym)
+			}
+			continue
+		}
+		a.Equal(t, socketReplyError, out.Type, "[%s] reply type mismatch", sym)
+		if a.NotNil(t, out.Problem, "[%s] problem missing", sym) {
+			a.Equal(t, tc.resStatus, out.Problem.Status, "[%s] problem status mismatch", sym)
+		}
+	}
+}
+
+func Test_HTTPHandler_Message_Socket_Handshake_Failure(t *testing.T) {
+	tests := map[string]struct {
+		noBus     bool
+		resStatus int
+	}{
+		"no handshake": {
+			resStatus: http.StatusBadRequest,
+		},
+		"WebSocket not supported": {
+			noBus:     true,
+			resStatus: http.StatusNotImplemented,
+		},
+	}
+
+	for sym, tc := range tests {
+		h := NewMessagesHandler(NewMemoryStorage())
+		if !tc.noBus {
+			h.Bus = NewMsgBus()
+		}
+		req, err := http.NewRequest(http.MethodGet, "/v1/messages/ws", nil)
+		ar.NoError(t, err)
+		rr := httptest.NewRecorder()
+
+		h.ServeHTTP(rr, req)
+
+		// THEN:
+		a.Equal(t, tc.resStatus, rr.Code, "[%s] mismatch on response code", sym)
+		tsAssertProblem(t, rr.Code, rr.Header(), rr.Body, sym)
+	}
+}
+
+func Test_HTTPHandler_Message_Socket_Timeouts(t *testing.T) {
+	h := NewMessagesHandler(NewMemoryStorage())
+	h.Bus = NewMsgBus()
+	h.Socket = SocketConfig{PingEvery: 10 * time.Millisecond, PongWait: 100 * time.Millisecond}
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	c := tsWSDial(t, ts.URL, "/v1/messages/ws", nil)
+	defer c.conn.Close()
+
+	// WHEN: client stays silent
+	var pings int
+	var err error
+	for err == nil {
+		var op int
+		op, _, err = c.readFrame()
+		if op == wsOpPing {
+			pings++
+		}
+	}
+
+	// THEN: client is pinged and disconnected after a while
+	a.NotZero(t, pings, "no ping received")
+	a.NotContains(t, err.Error(), "timeout", "client not disconnected")
+}
+
+func Test_HTTPHandler_Message_Socket_Backpressure(t *testing.T) {
+	h := NewMessagesHandler(NewMemoryStorage())
+	h.Bus = NewMsgBus()
+	sc, cc := tsWSPipe()
+	done := make(chan struct{})
+	go func() {
+		h.serveSocket(context.Background(), sc)
+		close(done)
+	}()
+
+	cc.send(t, SocketIn{Type: socketCmdSubscribe, Tags: []Tag{tfTagA}})
+	ar.Equal(t, socketReplyAck, cc.recv(t).Type, "subscription failed")
+
+	// WHEN: client does not receive while messages are created
+	for i := 0; i <= msgBusBuffer+1; i++ {
+		h.Bus.Publish(MsgEvent{Type: MsgEventCreated, Msg: &tfMsgAA})
+	}
+
+	// THEN: queued events are sent and client is disconnected
+	var events int
+	for {
+		op, payload, err := cc.readFrame()
+		ar.NoError(t, err, "connection ended without close frame")
+		if op == wsOpClose {
+			a.Equal(t, []byte{0x03, 0xF0}, payload[:2], "close code mismatch")
+			break
+		}
+		events++
+	}
+	// writer may take one event from the bus before its buffer is filled
+	a.True(t, events >= msgBusBuffer && events <= msgBusBuffer+1, "number of events sent mismatch: %d", events)
+	<-done
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// streamHeartbeatDefault is an interval of heartbeat comments sent over idle stream,
+	// so proxies do not close the connection.
+	streamHeartbeatDefault = 15 * time.Second
+
+	// streamTagsMax is a maximum number of tags followed by single stream.
+	streamTagsMax = 20
+
+	// streamReplayMax is a maximum number of missed messages replayed on resume.
+	// Clients away for longer catch up with GET /v1/messages.
+	streamReplayMax = 100
+)
+
+// streamEventMessage is a type of event carrying new message.
+const streamEventMessage = "message"
+
+// handleStream streams messages associated with requested tags as Server-Sent Events, as they are created.
+// Event ID is a cursor of the message, so stream resumed with Last-Event-ID header starts with messages
+// created after it, from the oldest. Stream ends when the client disconnects, bus is closed or client lags behind.
+func (h *messagesHandler) handleStream(w http.ResponseWriter, r *http.Request) {
+	if h.Bus == nil {
+		writeProblem(w, newProblem(http.StatusNotImplemented, problemNotImplemented, "streaming is not supported"))
+		return
+	}
+
+	tags, err := streamTagsParse(r.URL.Query()["tag"])
+	if err != nil {
+		writeProblem(w, newValidationProblem(err))
+		return
+	}
+
+	var after MsgCursor
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		after, err = decodeMsgCursor(v)
+		if err != nil {
+			writeProblem(w, newValidationProblem(NewValidationError(FieldError{Field: "Last-Event-ID", Code: FieldErrInvalid, Msg: err.Error()})))
+			return
+		}
+	}
+
+	f, ok := w.(http.Flusher)
+	if !ok {
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, "streaming is not supported by the connection"))
+		return
+	}
+
+	// subscribing before replay makes sure nothing is lost in between, duplicates are skipped below
+	sub := h.Bus.Subscribe(tags...)
+	defer sub.Close()
+
+	var missed []*Message
+	if !after.IsZero() {
+		if missed, err = h.streamMissed(r.Context(), tags, after); err != nil {
+			writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// disables response buffering in nginx
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+
+	replayed := make(map[string]bool, len(missed))
+	authors, err := loadAuthors(r.Context(), h.Storer, missed)
+	if err != nil {
+		return
+	}
+	for _, msg := range missed {
+		replayed[msg.ID] = true
+		if err := writeStreamEvent(w, msg, authors[msg.AuthorID]); err != nil {
+			return
+		}
+	}
+	f.Flush()
+
+	every := h.HeartbeatEvery
+	if every <= 0 {
+		every = streamHeartbeatDefault
+	}
+	heartbeat := time.NewTicker(every)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case ev, ok := <-sub.C:
+			if !ok {
+				// client reconnects with Last-Event-ID and catches up
+				return
+			}
+			msg := ev.Msg
+			if ev.Type != MsgEventCreated {
+				continue
+			}
+			if replayed[msg.ID] {
+				delete(replayed, msg.ID)
+				continue
+			}
+			var author *User
+			if msg.AuthorID != "" {
+				author, err = h.Storer.UserLoad(r.Context(), msg.AuthorID)
+				if err != nil && err != ErrElementNotFound {
+					return
+				}
+			}
+			if err := writeStreamEvent(w, msg, author); err != nil {
+				return
+			}
+		}
+		f.Flush()
+	}
+}
+
+// streamMissed retrieves up to streamReplayMax newest messages associated with any of the tags
+// and created after the cursor. They are returned from the oldest.
+func (h *messagesHandler) streamMissed(ctx context.Context, tags []Tag, after MsgCursor) ([]*Message, error) {
+	out, err := h.streamMissedNewest(ctx, tags, after)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(msgsFromOldest(out))
+	return out, nil
+}
+
+// streamMissedNewest retrieves up to streamReplayMax newest messages associated with any of the tags
+// and created after the cursor. They are returned from the newest.
+func (h *messagesHandler) streamMissedNewest(ctx context.Context, tags []Tag, after MsgCursor) ([]*Message, error) {
+	var out []*Message
+	var page MsgCursor
+	for len(out) < streamReplayMax {
+		ids, err := h.Storer.MsgsIDsFindByTags(ctx, MsgTagQuery{Any: tags}, page, msgsPageLimitMax)
+		switch {
+		case err == ErrElementNotFound:
+			return out, nil
+		case err != nil:
+			return nil, err
+		case len(ids) == 0:
+			return out, nil
+		}
+
+		msgs, err := h.Storer.MsgLoadMany(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			if !MsgCursorOf(msg).Before(after) || len(out) == streamReplayMax {
+				return out, nil
+			}
+			out = append(out, msg)
+		}
+		page = MsgCursorOf(msgs[len(msgs)-1])
+	}
+	return out, nil
+}
+
+// writeStreamEvent sends message as a single event. Its ID is the cursor of the message.
+func writeStreamEvent(w http.ResponseWriter, msg *Message, author *User) error {
+	b, err := json.Marshal(msgToTransport(msg, author))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", encodeMsgCursor(MsgCursorOf(msg)), streamEventMessage, b)
+	return err
+}
+
+// streamTagsParse parses tags followed by the stream. Each value of the tag parameter holds comma separated list.
+// Duplicates are ignored. ValidationError is returned if none is given, there are too many or any is invalid.
+func streamTagsParse(vals []string) ([]Tag, error) {
+	var tags []Tag
+	seen := make(map[string]bool)
+	for _, v := range vals {
+		for _, s := range strings.Split(v, ",") {
+			t := Tag(strings.TrimSpace(s)).Normalize()
+			if err := t.Validate(); err != nil {
+				return nil, NewValidationError("invalid Tag", err).InField("tag")
+			}
+			if !seen[t.Key()] {
+				seen[t.Key()] = true
+				tags = append(tags, t)
+			}
+		}
+	}
+
+	switch {
+	case len(tags) == 0:
+		return nil, NewValidationError(FieldError{Field: "tag", Code: FieldErrRequired, Msg: "at least one tag is required"})
+	case len(tags) > streamTagsMax:
+		return nil, NewValidationError(FieldError{Field: "tag", Code: FieldErrInvalid, Msg: fmt.Sprintf("too many tags, up to %d allowed", streamTagsMax)})
+	}
+	return tags, nil
+}
+
+// msgsFromOldest sorts messages from the oldest to the newest, reversing MsgCursor order.
+type msgsFromOldest []*Message
+
+func (m msgsFromOldest) Len() int           { return len(m) }
+func (m msgsFromOldest) Less(i, j int) bool { return MsgCursorOf(m[j]).Before(MsgCursorOf(m[i])) }
+func (m msgsFromOldest) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+// tsStreamEvent is a single event, or comment, received over Server-Sent Events stream.
+type tsStreamEvent struct {
+	ID      string
+	Event   string
+	Data    string
+	Comment string
+}
+
+// tsStreamOpen starts the stream and makes sure it's accepted.
+// Subscription is active once it returns, as response header is sent after it's made.
+func tsStreamOpen(t *testing.T, url, lastEventID string) (*http.Response, *bufio.Reader) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	ar.NoError(t, err)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	c := &http.Client{Timeout: 5 * time.Second}
+	res, err := c.Do(req)
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	ar.Equal(t, http.StatusOK, res.StatusCode, "mismatch on response code")
+	a.Equal(t, "text/event-stream", res.Header.Get("Content-Type"), "mismatch on response content type")
+	a.Equal(t, "no-cache", res.Header.Get("Cache-Control"), "mismatch on caching")
+	return res, bufio.NewReader(res.Body)
+}
+
+// tsStreamRead reads next event from the stream.
+func tsStreamRead(t *testing.T, br *bufio.Reader) tsStreamEvent {
+	var ev tsStreamEvent
+	for {
+		line, err := br.ReadString('\n')
+		ar.NoError(t, err, "unexpected error on stream read")
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return ev
+		}
+		if strings.HasPrefix(line, ":") {
+			ev.Comment = strings.TrimSpace(line[1:])
+			continue
+		}
+		parts := strings.SplitN(line, ": ", 2)
+		ar.Len(t, parts, 2, "malformed stream line: %s", line)
+		switch parts[0] {
+		case "id":
+			ev.ID = parts[1]
+		case "event":
+			ev.Event = parts[1]
+		case "data":
+			ev.Data = parts[1]
+		}
+	}
+}
+
+// tsStreamAssertMsg asserts that event carries the message.
+func tsStreamAssertMsg(t *testing.T, ev tsStreamEvent, msg *Message, author string, sym string) {
+	a.Equal(t, streamEventMessage, ev.Event, "[%s] mismatch on event type", sym)
+	a.Equal(t, encodeMsgCursor(MsgCursorOf(msg)), ev.ID, "[%s] mismatch on event ID", sym)
+
+	var trOut MessageOut
+	ar.NoError(t, json.Unmarshal([]byte(ev.Data), &trOut), "[%s] unexpected error on event data decode", sym)
+	a.Equal(t, msg.ID, trOut.ID, "[%s] mismatch on message ID", sym)
+	a.Equal(t, msg.Body, trOut.Body, "[%s] mismatch on message Body", sym)
+	a.Equal(t, msg.Tags, trOut.Tags, "[%s] mismatch on message Tags", sym)
+	a.Equal(t, author, trOut.Author, "[%s] mismatch on message Author", sym)
+}
+
+func Test_HTTPHandler_Message_Stream(t *testing.T) {
+	st := NewMemoryStorage()
+	bus := NewMsgBus()
+	ts := httptest.NewServer(NewHTTPHandler(st, HTTPHandlerConfig{Bus: bus}))
+	defer ts.Close()
+
+	// GIVEN: authors exist
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		ar.NoError(t, st.UserSave(context.Background(), &uC))
+	}
+
+	res, br := tsStreamOpen(t, ts.URL+"/v1/messages/stream?tag=tagA,tagB", "")
+	defer res.Body.Close()
+
+	// WHEN: messages are created
+	for _, body := range []string{
+		`{"body":"Msg-1","author":"UserA-Name","tag":"tagA"}`,
+		`{"body":"Msg-2","author":"UserA-Name","tag":"tagC"}`,
+		`{"body":"Msg-3","author":"UserB-Name","tag":"tagB"}`,
+	} {
+		resC, err := http.Post(ts.URL+"/v1/messages", "application/json", strings.NewReader(body))
+		ar.NoError(t, err, "unexpected error from HTTP client")
+		ar.Equal(t, http.StatusCreated, resC.StatusCode, "message not created")
+	}
+
+	// THEN: messages of followed tags are streamed
+	for _, exp := range []struct{ tag, body, author string }{
+		{"tagA", "Msg-1", tfUserA.Name},
+		{"tagB", "Msg-3", tfUserB.Name},
+	} {
+		ev := tsStreamRead(t, br)
+		ids, err := st.MsgsIDsFindByTag(context.Background(), Tag(exp.tag), MsgCursor{}, 0)
+		ar.NoError(t, err, "unexpected error on tag seek")
+		msg, err := st.MsgLoad(context.Background(), ids[0])
+		ar.NoError(t, err, "unexpected error on message load")
+		a.Equal(t, exp.body, msg.Body, "mismatch on stored message")
+		tsStreamAssertMsg(t, ev, msg, exp.author, exp.tag)
+	}
+
+	// AND: stream ends when bus is closed
+	bus.Close()
+	_, err := br.ReadString('\n')
+	a.Equal(t, io.EOF, err, "stream not ended")
+}
+
+func Test_HTTPHandler_Message_Stream_Resume(t *testing.T) {
+	st := NewMemoryStorage()
+	bus := NewMsgBus()
+	ts := httptest.NewServer(NewHTTPHandler(st, HTTPHandlerConfig{Bus: bus}))
+	defer ts.Close()
+
+	// GIVEN: messages were created, the oldest was received before disconnect
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		ar.NoError(t, st.UserSave(context.Background(), &uC))
+	}
+	for _, m := range []Message{tfMsgAA, tfMsgAB, tfMsgBA, tfMsgBB} {
+		mC := m
+		ar.NoError(t, st.MsgSave(context.Background(), &mC))
+	}
+	lastEventID := encodeMsgCursor(MsgCursorOf(&tfMsgAA))
+
+	res, br := tsStreamOpen(t, ts.URL+"/v1/messages/stream?tag=tagA&tag=tagB", lastEventID)
+	defer res.Body.Close()
+
+	// THEN: missed messages are replayed from the oldest
+	tsStreamAssertMsg(t, tsStreamRead(t, br), &tfMsgAB, tfUserA.Name, "replay: AB")
+	tsStreamAssertMsg(t, tsStreamRead(t, br), &tfMsgBA, tfUserB.Name, "replay: BA")
+	tsStreamAssertMsg(t, tsStreamRead(t, br), &tfMsgBB, tfUserB.Name, "replay: BB")
+
+	// AND: replayed message published meanwhile is not repeated
+	bus.Publish(MsgEvent{Type: MsgEventCreated, Msg: &tfMsgBB})
+	// AND: changes other than creation are not streamed
+	bus.Publish(MsgEvent{Type: MsgEventUpdated, Msg: &tfMsgAA})
+	msgNew := Message{ID: "MsgNew-ID", Body: "MsgNew-Body", Tags: []Tag{tfTagA}, CreatedAt: tfMsgBB.CreatedAt.Add(time.Minute)}
+	bus.Publish(MsgEvent{Type: MsgEventCreated, Msg: &msgNew})
+	tsStreamAssertMsg(t, tsStreamRead(t, br), &msgNew, "", "live: anonymous")
+}
+
+func Test_HTTPHandler_Message_Stream_Heartbeat(t *testing.T) {
+	h := NewMessagesHandler(NewMemoryStorage())
+	h.Bus = NewMsgBus()
+	h.HeartbeatEvery = 10 * time.Millisecond
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	res, br := tsStreamOpen(t, ts.URL+"/v1/messages/stream?tag=tagA", "")
+	defer res.Body.Close()
+
+	// THEN: comment is sent while nothing happens
+	ev := tsStreamRead(t, br)
+	a.Equal(t, tsStreamEvent{Comment: "heartbeat"}, ev, "heartbeat mismatch")
+}
+
+func Test_HTTPHandler_Message_Stream_Failure(t *testing.T) {
+	tooMany := make([]string, streamTagsMax+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("tag%d", i)
+	}
+
+	tests := map[string]struct {
+		method      string
+		query       string
+		lastEventID string
+		noBus       bool
+		resStatus   int
+	}{
+		"no tag": {
+			resStatus: http.StatusBadRequest,
+		},
+		"empty tag": {
+			query:     "tag=tagA,",
+			resStatus: http.StatusBadRequest,
+		},
+		"invalid tag": {
+			query:     "tag=" + string(tfTagXA_TooShort),
+			resStatus: http.StatusBadRequest,
+		},
+		"too many tags": {
+			query:     "tag=" + strings.Join(tooMany, ","),
+			resStatus: http.StatusBadRequest,
+		},
+		"invalid Last-Event-ID": {
+			query:       "tag=tagA",
+			lastEventID: "not-a-cursor",
+			resStatus:   http.StatusBadRequest,
+		},
+		"method not allowed": {
+			method:    http.MethodPost,
+			query:     "tag=tagA",
+			resStatus: http.StatusMethodNotAllowed,
+		},
+		"streaming not supported": {
+			query:     "tag=tagA",
+			noBus:     true,
+			resStatus: http.StatusNotImplemented,
+		},
+	}
+
+	for sym, tc := range tests {
+		h := NewMessagesHandler(NewMemoryStorage())
+		if !tc.noBus {
+			h.Bus = NewMsgBus()
+		}
+
+		method := tc.method
+		if method == "" {
+			method = http.MethodGet
+		}
+		req, err := http.NewRequest(method, "/v1/messages/stream?"+tc.query, nil)
+		ar.NoError(t, err)
+		if tc.lastEventID != "" {
+			req.Header.Set("Last-Event-ID", tc.lastEventID)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		// THEN:
+		a.Equal(t, tc.resStatus, rr.Code, "[%s] mismatch on response code", sym)
+		tsAssertProblem(t, rr.Code, rr.Header(), rr.Body, sym)
+	}
+}
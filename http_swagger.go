@@ -19,6 +19,17 @@
 //
 //     Produces:
 //     - application/json
+//     - application/problem+json
+//
+//     SecurityDefinitions:
+//     api_key:
+//          type: apiKey
+//          name: X-API-Key
+//          in: header
+//     bearer:
+//          type: apiKey
+//          name: Authorization
+//          in: header
 //
 // swagger:meta
 package main
@@ -27,9 +38,9 @@ package main
 //
 // This is used for operations that want an User as body of the request
 //
-// swagger:parameters UserCreate
+// swagger:parameters UserCreate UserRename
 type UserBodyParams struct {
-	// User to create
+	// User to create or its new name
 	//
 	// in: body
 	// required: true
@@ -39,13 +50,119 @@ type UserBodyParams struct {
 // UserCreatedResponse represents response to creation of the user.
 //
 // swagger:response UserCreatedResponse
-type UserCreatedResponse struct{}
+type UserCreatedResponse struct {
+	// Location is relative URL to newly created user.
+	Location string
+
+	// in: body
+	Body *UserOut
+}
+
+// A UsersQueryFlags contains the query flags for listing users
+//
+// swagger:parameters UsersList
+type UsersQueryFlags struct {
+	// Name of the user to look up. Paging flags are ignored when it's set.
+	//
+	// in: query
+	Name string `json:"name"`
+
+	// Maximum number of users on the page
+	//
+	// in: query
+	// minimum: 1
+	// maximum: 100
+	// default: 20
+	Limit int `json:"limit"`
+
+	// Cursor pointing at the page, as returned in the "next" field of the previous one
+	//
+	// in: query
+	Cursor string `json:"cursor"`
+}
+
+// UserReadResponse represents transport level model for single user returned from system.
+//
+// swagger:response UserReadResponse
+type UserReadResponse struct {
+	// in: body
+	Body *UserOut
+}
+
+// UsersCollectionResponse represents transport level model for page of users returned from system.
+//
+// swagger:response UsersCollectionResponse
+type UsersCollectionResponse struct {
+	// in: body
+	Body *UsersPageOut
+}
+
+// A UserRoleParams model.
+//
+// This is used for operations on single role of the user
+//
+// swagger:parameters UserRoleGrant UserRoleRevoke
+type UserRoleParams struct {
+	// Role to grant or revoke. User role is held implicitly by everyone.
+	//
+	// in: path
+	// required: true
+	// enum: moderator,admin
+	Role string `json:"role"`
+}
+
+// A UserMentionsQueryFlags contains the query flags for listing messages mentioning the user
+//
+// swagger:parameters UserMentions
+type UserMentionsQueryFlags struct {
+	// Maximum number of messages on the page
+	//
+	// in: query
+	// minimum: 1
+	// maximum: 100
+	// default: 20
+	Limit int `json:"limit"`
+
+	// Cursor pointing at the page, as returned in the "next" field of the previous one
+	//
+	// in: query
+	Cursor string `json:"cursor"`
+}
+
+// A MessagesSearchQueryFlags contains the query flags for searching messages
+//
+// swagger:parameters MessagesSearch
+type MessagesSearchQueryFlags struct {
+	// Words, "phrases", prefixes* and filters (tag:, author:, after:, before:) of the query
+	//
+	// in: query
+	// required: true
+	Q string `json:"q"`
+
+	// Maximum number of messages on the page
+	//
+	// in: query
+	// minimum: 1
+	// maximum: 100
+	// default: 20
+	Limit int `json:"limit"`
+
+	// Cursor pointing at the page, as returned in the "next" field of the previous one
+	//
+	// in: query
+	Cursor string `json:"cursor"`
+}
+
+// UserDeletedResponse represents response to removal of the user.
+//
+// swagger:response UserDeletedResponse
+type UserDeletedResponse struct{}
 
 // A MessageBodyParams model.
 //
 // This is used for operations that want an Message as body of the request
 //
-// swagger:parameters MessageCreate
+// swagger:parameters MessageCreate MessageUpdate
 type MessageBodyParams struct {
 	// The message to submit
 	//
@@ -58,11 +175,68 @@ type MessageBodyParams struct {
 //
 // swagger:parameters MessagesFind
 type MessageQueryFlags struct {
-	// Tag attached to the message
+	// Tags all of which are attached to the message. Parameter may be repeated.
+	//
+	// in: query
+	// collection format: multi
+	Tag []string `json:"tag"`
+
+	// Tags at least one of which is attached to the message. Parameter may be repeated.
+	//
+	// in: query
+	// collection format: multi
+	Any []string `json:"any"`
+
+	// Tags none of which is attached to the message. Parameter may be repeated.
+	//
+	// in: query
+	// collection format: multi
+	Not []string `json:"not"`
+
+	// Maximum number of messages on the page
+	//
+	// in: query
+	// minimum: 1
+	// maximum: 100
+	// default: 20
+	Limit int `json:"limit"`
+
+	// Cursor pointing at the page, as returned in the "next" field of the previous one
+	//
+	// in: query
+	Cursor string `json:"cursor"`
+}
+
+// A MessagesStreamQueryFlags contains the query flags for streaming messages
+//
+// swagger:parameters MessagesStream
+type MessagesStreamQueryFlags struct {
+	// Comma separated list of up to 20 tags to follow
 	//
 	// in: query
 	// required: true
 	Tag string `json:"tag"`
+
+	// ID of the last received event. Stream starts with messages created after it.
+	//
+	// in: header
+	LastEventID string `json:"Last-Event-ID"`
+}
+
+// SocketSwitchingResponse represents switch of the connection to WebSocket protocol.
+//
+// swagger:response SocketSwitchingResponse
+type SocketSwitchingResponse struct {
+	// SecWebSocketAccept confirms the key sent by the client.
+	SecWebSocketAccept string `json:"Sec-WebSocket-Accept"`
+}
+
+// MessagesStreamResponse represents stream of Server-Sent Events, each carrying new message as MessageOut in its data.
+//
+// swagger:response MessagesStreamResponse
+type MessagesStreamResponse struct {
+	// in: body
+	Body string
 }
 
 // MessageCreatedResponse represents response to creation of the message.
@@ -73,6 +247,34 @@ type MessageCreatedResponse struct {
 	Location string
 }
 
+// A MessagePatchBodyParams model.
+//
+// This is used for operations that change part of the Message
+//
+// swagger:parameters MessagePatch
+type MessagePatchBodyParams struct {
+	// Change to apply
+	//
+	// in: body
+	// required: true
+	Patch *MessagePatchIn `json:"patch"`
+}
+
+// A MessageAuthorQueryFlags contains the query flags for operations on behalf of message author
+//
+// swagger:parameters MessageDelete
+type MessageAuthorQueryFlags struct {
+	// Name of the message author. It's optional for authenticated requests.
+	//
+	// in: query
+	Author string `json:"author"`
+}
+
+// MessageDeletedResponse represents response to removal of the message.
+//
+// swagger:response MessageDeletedResponse
+type MessageDeletedResponse struct{}
+
 // MessageResponse represents transport level model for single message returned from system to user.
 //
 // swagger:response MessageReadResponse
@@ -81,29 +283,167 @@ type MessageReadResponse struct {
 	Body *MessageOut
 }
 
-// MessagesCollectionResponse represents transport level model for collection of messages returned from system to user.
+// MessagesCollectionResponse represents transport level model for page of messages returned from system to user.
 //
 // swagger:response MessagesCollectionResponse
 type MessagesCollectionResponse struct {
+	// in: body
+	Body *MessagesPageOut
+}
+
+// SearchResponse represents transport level model for page of messages found, ordered by relevance.
+//
+// swagger:response SearchResponse
+type SearchResponse struct {
+	// in: body
+	Body *SearchPageOut
+}
+
+// MessageRevisionsResponse represents transport level model for history of single message, from the oldest version.
+//
+// swagger:response MessageRevisionsResponse
+type MessageRevisionsResponse struct {
 	// in: body
 	Body []*MessageOut
 }
 
+// A WebhookBodyParams model.
+//
+// This is used for operations that want a Webhook as body of the request
+//
+// swagger:parameters WebhookCreate WebhookUpdate
+type WebhookBodyParams struct {
+	// Webhook to register or its replacement
+	//
+	// in: body
+	// required: true
+	Webhook *WebhookIn `json:"webhook"`
+}
+
+// WebhookCreatedResponse represents response to registration of the webhook.
+//
+// swagger:response WebhookCreatedResponse
+type WebhookCreatedResponse struct {
+	// Location is relative URL to newly registered webhook.
+	Location string
+
+	// in: body
+	Body *WebhookOut
+}
+
+// WebhookReadResponse represents transport level model for single webhook returned from system.
+//
+// swagger:response WebhookReadResponse
+type WebhookReadResponse struct {
+	// in: body
+	Body *WebhookOut
+}
+
+// WebhooksCollectionResponse represents transport level model for all webhooks returned from system.
+//
+// swagger:response WebhooksCollectionResponse
+type WebhooksCollectionResponse struct {
+	// in: body
+	Body *WebhooksCollectionOut
+}
+
+// WebhookDeletedResponse represents response to removal of the webhook.
+//
+// swagger:response WebhookDeletedResponse
+type WebhookDeletedResponse struct{}
+
+// WebhookDeliveriesResponse represents transport level model for log of attempted deliveries to the webhook.
+//
+// swagger:response WebhookDeliveriesResponse
+type WebhookDeliveriesResponse struct {
+	// in: body
+	Body *WebhookDeliveriesOut
+}
+
+// WebhookDeadLettersResponse represents transport level model for deliveries to the webhook given up on.
+//
+// swagger:response WebhookDeadLettersResponse
+type WebhookDeadLettersResponse struct {
+	// in: body
+	Body *WebhookDeadLettersOut
+}
+
 // A BadRequestError is an error that is generated when user submitted request which is incorrect.
 // One of the cases is some kind of validation error.
 // Repeating the request will most probably not change the outcome.
 //
 // swagger:response BadRequestError
-type BadRequestError struct{}
+type BadRequestError struct {
+	// in: body
+	Body *ProblemOut
+}
+
+// An UnauthorizedError is an error that is generated when credentials are missing or invalid.
+// Changes require API key (X-API-Key header) or bearer token (Authorization header), registration of the user excepted.
+//
+// swagger:response UnauthorizedError
+type UnauthorizedError struct {
+	// WWWAuthenticate is an authentication challenge.
+	WWWAuthenticate string `json:"WWW-Authenticate"`
+
+	// in: body
+	Body *ProblemOut
+}
 
 // A NotFoundError is an error that is generated when requested element could not be found.
 // It's also used when collection is requested for specific parameters combination and returns empty.
 //
 // swagger:response NotFoundError
-type NotFoundError struct{}
+type NotFoundError struct {
+	// in: body
+	Body *ProblemOut
+}
+
+// A ForbiddenError is an error that is generated when user is not allowed to perform requested operation.
+// One of the cases is change of the message by someone else than its author.
+//
+// swagger:response ForbiddenError
+type ForbiddenError struct {
+	// in: body
+	Body *ProblemOut
+}
+
+// A ConflictError is an error that is generated when request conflicts with current state of the element.
+// One of the cases is removal of the user who authored messages, when server is configured to reject it.
+// Other one is creation or rename of the user to the name which is already taken.
+//
+// swagger:response ConflictError
+type ConflictError struct {
+	// in: body
+	Body *ProblemOut
+}
+
+// A MethodNotAllowedError is an error that is generated when resource does not support requested method.
+// Supported methods are listed in Allow header.
+//
+// swagger:response MethodNotAllowedError
+type MethodNotAllowedError struct {
+	// Allow lists methods supported by the resource.
+	Allow string
+
+	// in: body
+	Body *ProblemOut
+}
 
 // A InternalServerError is an error that is generated when server could not produce response.
 // Repeating the request will most probably not change the outcome.
 //
 // swagger:response InternalServerError
-type InternalServerError struct{}
+type InternalServerError struct {
+	// in: body
+	Body *ProblemOut
+}
+
+// A NotImplementedError is an error that is generated when operation is not supported by the storage backend in use.
+// Repeating the request will most probably not change the outcome.
+//
+// swagger:response NotImplementedError
+type NotImplementedError struct {
+	// in: body
+	Body *ProblemOut
+}
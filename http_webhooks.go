@@ -0,0 +1,477 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// webhookSecretSize is a number of random bytes of generated secret.
+const webhookSecretSize = 32
+
+// webhooksHandler is HTTP handler for webhooks related actions.
+// Webhooks are managed by admins only, unless authentication is disabled.
+type webhooksHandler struct {
+	// Storer keeps webhooks. Webhooks are not supported when it's nil.
+	Storer WebhookStorer
+
+	// Users is used to check authors webhooks are limited to.
+	Users UserStorer
+
+	// Dispatcher delivers changes to webhooks and keeps log of deliveries.
+	Dispatcher *WebhookDispatcher
+
+	// Authz decides whether authenticated caller may manage webhooks.
+	// Anonymous callers are let in only when authentication is disabled, as webhooks expose their URLs and payloads.
+	Authz *Authorizer
+
+	// TimeNow is testing helper for time sensitive tests. It defaults to time.Now function.
+	TimeNow func() time.Time
+}
+
+// NewWebhooksHandler returns handler of webhooks delivered by the dispatcher. Nil dispatcher disables webhooks.
+func NewWebhooksHandler(d *WebhookDispatcher, us UserStorer) *webhooksHandler {
+	h := &webhooksHandler{
+		Users:      us,
+		Dispatcher: d,
+		Authz:      NewAuthorizer(us),
+		TimeNow:    time.Now,
+	}
+	if d != nil {
+		h.Storer = d.Storer
+	}
+	return h
+}
+
+// allowed chars in ID: 0-9a-zA-Z-_ (space is NOT allowed)
+var rPathWebhook = regexp.MustCompile(`^/v1/webhooks/([\da-zA-Z\-_]+)/?$`)
+
+var rPathWebhookDeliveries = regexp.MustCompile(`^/v1/webhooks/([\da-zA-Z\-_]+)/deliveries/?$`)
+
+var rPathWebhookDeadLetters = regexp.MustCompile(`^/v1/webhooks/([\da-zA-Z\-_]+)/dead-letters/?$`)
+
+func (h *webhooksHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := StartSpan(r.Context(), "webhooksHandler", SpanKindInternal)
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	isCollection := r.URL.Path == "/v1/webhooks" || r.URL.Path == "/v1/webhooks/"
+	isItem := rPathWebhook.MatchString(r.URL.Path)
+	isDeliveries := rPathWebhookDeliveries.MatchString(r.URL.Path)
+	isDeadLetters := rPathWebhookDeadLetters.MatchString(r.URL.Path)
+
+	if !isCollection && !isItem && !isDeliveries && !isDeadLetters {
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
+		return
+	}
+	if h.Storer == nil && r.Method != http.MethodOptions {
+		writeProblem(w, newProblem(http.StatusNotImplemented, problemNotImplemented, "webhooks are not supported by the storage"))
+		return
+	}
+
+	switch true {
+	case isCollection && r.Method == http.MethodPost:
+		// swagger:route POST /v1/webhooks webhooks WebhookCreate
+		//
+		// Register webhook receiving changes of messages. Only admins are allowed to do it.
+		//
+		// Each change of matching message is sent as WebhookEventOut in POST request with X-Messenger-Event,
+		// X-Messenger-Delivery and X-Messenger-Signature headers. Signature is "sha256=" followed by hex encoded
+		// HMAC-SHA256 of the request body keyed with the secret. Secret is returned only in this response.
+		// Requests failed with network error, timeout, 408, 429 or 5xx response are retried with exponential backoff.
+		//
+		//     Security:
+		//       api_key:
+		//       bearer:
+		//
+		//     Responses:
+		//       201: WebhookCreatedResponse
+		//       400: BadRequestError
+		//       401: UnauthorizedError
+		//       403: ForbiddenError
+		//       500: InternalServerError
+		//       501: NotImplementedError
+		h.handleCreate(w, r)
+	case isCollection && r.Method == http.MethodGet:
+		// swagger:route GET /v1/webhooks webhooks WebhooksList
+		//
+		// Get all webhooks ordered by ID. Only admins are allowed to do it.
+		//
+		//     Security:
+		//       api_key:
+		//       bearer:
+		//
+		//     Responses:
+		//       200: WebhooksCollectionResponse
+		//       401: UnauthorizedError
+		//       403: ForbiddenError
+		//       500: InternalServerError
+		//       501: NotImplementedError
+		h.handleList(w, r)
+	case isItem && r.Method == http.MethodGet:
+		// swagger:route GET /v1/webhooks/{id} webhooks WebhookRead
+		//
+		// Get details of single webhook by its ID. Only admins are allowed to do it.
+		//
+		//     Security:
+		//       api_key:
+		//       bearer:
+		//
+		//     Responses:
+		//       200: WebhookReadResponse
+		//       401: UnauthorizedError
+		//       403: ForbiddenError
+		//       404: NotFoundError
+		//       500: InternalServerError
+		//       501: NotImplementedError
+		h.handleRead(w, r)
+	case isItem && r.Method == http.MethodPut:
+		// swagger:route PUT /v1/webhooks/{id} webhooks WebhookUpdate
+		//
+		// Replace URL and filters of the webhook. Secret is replaced only when given. Only admins are allowed to do it.
+		//
+		//     Security:
+		//       api_key:
+		//       bearer:
+		//
+		//     Responses:
+		//       200: WebhookReadResponse
+		//       400: BadRequestError
+		//       401: UnauthorizedError
+		//       403: ForbiddenError
+		//       404: NotFoundError
+		//       500: InternalServerError
+		//       501: NotImplementedError
+		h.handleUpdate(w, r)
+	case isItem && r.Method == http.MethodDelete:
+		// swagger:route DELETE /v1/webhooks/{id} webhooks WebhookDelete
+		//
+		// Delete the webhook along with log of its deliveries. Pending deliveries are abandoned. Only admins are allowed to do it.
+		//
+		//     Security:
+		//       api_key:
+		//       bearer:
+		//
+		//     Responses:
+		//       204: WebhookDeletedResponse
+		//       401: UnauthorizedError
+		//       403: ForbiddenError
+		//       404: NotFoundError
+		//       500: InternalServerError
+		//       501: NotImplementedError
+		h.handleDelete(w, r)
+	case isDeliveries && r.Method == http.MethodGet:
+		// swagger:route GET /v1/webhooks/{id}/deliveries webhooks WebhookDeliveries
+		//
+		// Get up to 100 latest attempts of deliveries to the webhook, from the newest. Only admins are allowed to do it.
+		//
+		//     Security:
+		//       api_key:
+		//       bearer:
+		//
+		//     Responses:
+		//       200: WebhookDeliveriesResponse
+		//       401: UnauthorizedError
+		//       403: ForbiddenError
+		//       404: NotFoundError
+		//       500: InternalServerError
+		//       501: NotImplementedError
+		h.handleDeliveries(w, r)
+	case isDeadLetters && r.Method == http.MethodGet:
+		// swagger:route GET /v1/webhooks/{id}/dead-letters webhooks WebhookDeadLetters
+		//
+		// Get up to 100 latest deliveries to the webhook given up on, from the newest. Only admins are allowed to do it.
+		//
+		//     Security:
+		//       api_key:
+		//       bearer:
+		//
+		//     Responses:
+		//       200: WebhookDeadLettersResponse
+		//       401: UnauthorizedError
+		//       403: ForbiddenError
+		//       404: NotFoundError
+		//       500: InternalServerError
+		//       501: NotImplementedError
+		h.handleDeadLetters(w, r)
+	case isCollection:
+		handleMethodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+	case isItem:
+		handleMethodNotAllowed(w, r, http.MethodGet, http.MethodPut, http.MethodDelete)
+	default:
+		handleMethodNotAllowed(w, r, http.MethodGet)
+	}
+}
+
+func webhookToTransport(wh *Webhook) WebhookOut {
+	trOut := WebhookOut{
+		ID:        wh.ID,
+		URL:       wh.URL,
+		Tags:      wh.Tags,
+		Authors:   wh.Authors,
+		CreatedAt: wh.CreatedAt,
+	}
+	if trOut.Tags == nil {
+		trOut.Tags = []Tag{}
+	}
+	if trOut.Authors == nil {
+		trOut.Authors = []string{}
+	}
+	return trOut
+}
+
+// decodeWebhook reads and validates webhook from the body of the request.
+// Authors must exist. Response is written and false returned on failure.
+func (h *webhooksHandler) decodeWebhook(w http.ResponseWriter, r *http.Request) (WebhookIn, bool) {
+	var trIn WebhookIn
+	if err := json.NewDecoder(r.Body).Decode(&trIn); err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, problemInvalidJSON, err.Error()))
+		return trIn, false
+	}
+	if err := trIn.Validate(); err != nil {
+		writeProblem(w, newValidationProblem(err))
+		return trIn, false
+	}
+
+	switch _, err := h.Users.UserLoadMany(r.Context(), trIn.Authors); err {
+	case nil:
+	case ErrElementNotFound:
+		writeProblem(w, newValidationProblem(NewValidationError(FieldError{Field: "authors", Code: FieldErrUnknown, Msg: "unknown Author"})))
+		return trIn, false
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return trIn, false
+	}
+	return trIn, true
+}
+
+func (h *webhooksHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	if !h.Authz.Authorize(w, r, PermWebhooksManage, "", !AuthEnforced(r.Context())) {
+		return
+	}
+	trIn, ok := h.decodeWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	if trIn.Secret == "" {
+		b := make([]byte, webhookSecretSize)
+		if _, err := rand.Read(b); err != nil {
+			writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+			return
+		}
+		trIn.Secret = hex.EncodeToString(b)
+	}
+
+	wh := Webhook{
+		ID:        uuid.NewV1().String(),
+		URL:       trIn.URL,
+		Secret:    trIn.Secret,
+		Tags:      trIn.Tags,
+		Authors:   trIn.Authors,
+		CreatedAt: h.TimeNow().UTC(),
+	}
+
+	if err := h.Storer.WebhookSave(r.Context(), &wh); err != nil {
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+
+	// secret is disclosed only once
+	trOut := webhookToTransport(&wh)
+	trOut.Secret = wh.Secret
+
+	w.Header().Set("Location", "/v1/webhooks/"+wh.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(trOut)
+}
+
+func (h *webhooksHandler) handleList(w http.ResponseWriter, r *http.Request) {
+	if !h.Authz.Authorize(w, r, PermWebhooksManage, "", !AuthEnforced(r.Context())) {
+		return
+	}
+
+	hooks, err := h.Storer.WebhooksList(r.Context())
+	if err != nil {
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+
+	trOut := WebhooksCollectionOut{
+		Webhooks: make([]WebhookOut, 0, len(hooks)),
+	}
+	for _, wh := range hooks {
+		trOut.Webhooks = append(trOut.Webhooks, webhookToTransport(wh))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(trOut)
+}
+
+// load retrieves webhook with ID matched by given route.
+// Response is written and false returned on failure.
+func (h *webhooksHandler) load(w http.ResponseWriter, r *http.Request, route *regexp.Regexp) (*Webhook, bool) {
+	// webhookID is on index 1, route is only taken on match
+	webhookID := route.FindStringSubmatch(r.URL.Path)[1]
+
+	wh, err := h.Storer.WebhookLoad(r.Context(), webhookID)
+	switch err {
+	case nil:
+	case ErrElementNotFound:
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
+		return nil, false
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return nil, false
+	}
+	return wh, true
+}
+
+func (h *webhooksHandler) handleRead(w http.ResponseWriter, r *http.Request) {
+	if !h.Authz.Authorize(w, r, PermWebhooksManage, "", !AuthEnforced(r.Context())) {
+		return
+	}
+	wh, ok := h.load(w, r, rPathWebhook)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(webhookToTransport(wh))
+}
+
+func (h *webhooksHandler) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	if !h.Authz.Authorize(w, r, PermWebhooksManage, "", !AuthEnforced(r.Context())) {
+		return
+	}
+	trIn, ok := h.decodeWebhook(w, r)
+	if !ok {
+		return
+	}
+	current, ok := h.load(w, r, rPathWebhook)
+	if !ok {
+		return
+	}
+
+	// stored webhook is shared, change is made on a copy
+	wh := *current
+	wh.URL = trIn.URL
+	wh.Tags = trIn.Tags
+	wh.Authors = trIn.Authors
+	if trIn.Secret != "" {
+		wh.Secret = trIn.Secret
+	}
+
+	switch err := h.Storer.WebhookUpdate(r.Context(), &wh); err {
+	case nil:
+	case ErrElementNotFound:
+		// webhook was removed in the meantime
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
+		return
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(webhookToTransport(&wh))
+}
+
+func (h *webhooksHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	if !h.Authz.Authorize(w, r, PermWebhooksManage, "", !AuthEnforced(r.Context())) {
+		return
+	}
+
+	// webhookID is on index 1, route is only taken on match
+	webhookID := rPathWebhook.FindStringSubmatch(r.URL.Path)[1]
+
+	switch err := h.Storer.WebhookDelete(r.Context(), webhookID); err {
+	case nil:
+	case ErrElementNotFound:
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, ""))
+		return
+	default:
+		writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+		return
+	}
+	h.Dispatcher.Forget(webhookID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *webhooksHandler) handleDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !h.Authz.Authorize(w, r, PermWebhooksManage, "", !AuthEnforced(r.Context())) {
+		return
+	}
+	wh, ok := h.load(w, r, rPathWebhookDeliveries)
+	if !ok {
+		return
+	}
+
+	attempts := h.Dispatcher.AttemptsOf(wh.ID)
+	trOut := WebhookDeliveriesOut{
+		Deliveries: make([]WebhookDeliveryOut, 0, len(attempts)),
+	}
+	for _, at := range attempts {
+		trOut.Deliveries = append(trOut.Deliveries, WebhookDeliveryOut{
+			DeliveryID: at.DeliveryID,
+			Event:      string(at.Event),
+			MessageID:  at.MessageID,
+			Attempt:    at.Attempt,
+			At:         at.At,
+			DurationMs: at.Duration.Seconds() * 1e3,
+			StatusCode: at.StatusCode,
+			Error:      at.Error,
+			Outcome:    string(at.Outcome),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(trOut)
+}
+
+func (h *webhooksHandler) handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if !h.Authz.Authorize(w, r, PermWebhooksManage, "", !AuthEnforced(r.Context())) {
+		return
+	}
+	wh, ok := h.load(w, r, rPathWebhookDeadLetters)
+	if !ok {
+		return
+	}
+
+	letters := h.Dispatcher.DeadLettersOf(wh.ID)
+	trOut := WebhookDeadLettersOut{
+		DeadLetters: make([]WebhookDeadLetterOut, 0, len(letters)),
+	}
+	for _, dl := range letters {
+		// payload was encoded by the dispatcher, it's decoded to be embedded as is
+		var payload WebhookEventOut
+		if err := json.Unmarshal(dl.Payload, &payload); err != nil {
+			writeProblem(w, newProblem(http.StatusInternalServerError, problemInternal, ""))
+			return
+		}
+		trOut.DeadLetters = append(trOut.DeadLetters, WebhookDeadLetterOut{
+			DeliveryID: dl.DeliveryID,
+			Event:      string(dl.Event),
+			MessageID:  dl.MessageID,
+			Attempts:   dl.Attempts,
+			Error:      dl.Error,
+			FailedAt:   dl.FailedAt,
+			Payload:    &payload,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(trOut)
+}
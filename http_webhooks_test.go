@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+// tsWebhooksHandlerSetup creates handler with webhooks delivered by dispatcher with quick retries.
+// Users A and B are saved in the storage.
+func tsWebhooksHandlerSetup(t *testing.T) (*memoryStorage, *WebhookDispatcher, http.Handler) {
+	st := NewMemoryStorage()
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		ar.NoError(t, st.UserSave(context.Background(), &uC))
+	}
+
+	bus := NewMsgBus()
+	d := NewWebhookDispatcher(st, st, bus)
+	d.Backoff = time.Millisecond
+	d.BackoffMax = 4 * time.Millisecond
+	d.Start()
+
+	return st, d, NewHTTPHandler(st, HTTPHandlerConfig{Bus: bus, Webhooks: d})
+}
+
+// tsWebhooksRequest serves request and validates response code, problem is checked on failures.
+// Decoded body of successful response is returned in trOut.
+func tsWebhooksRequest(t *testing.T, h http.Handler, method, path, body string, status int, trOut interface{}) http.Header {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	ar.NoError(t, err)
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	ar.Equal(t, status, res.Code, "[%s %s] mismatch on response code", method, path)
+	if res.Code >= http.StatusBadRequest {
+		tsAssertProblem(t, res.Code, res.Header(), res.Body, method+" "+path)
+		return res.Header()
+	}
+	if trOut != nil {
+		a.Equal(t, "application/json", res.Header().Get("Content-Type"), "[%s %s] mismatch on content type", method, path)
+		ar.NoError(t, json.NewDecoder(res.Body).Decode(trOut), "[%s %s] unexpected error on body decode", method, path)
+	}
+	return res.Header()
+}
+
+func Test_HTTPHandler_Webhook_Manage_Success(t *testing.T) {
+	st, d, h := tsWebhooksHandlerSetup(t)
+	defer d.Close()
+
+	// WHEN: webhook is created without secret
+	var created WebhookOut
+	header := tsWebhooksRequest(t, h, http.MethodPost, "/v1/webhooks",
+		`{"url":"https://hooks.example.com/x","tags":["tagA"],"authors":["UserB-ID"]}`, http.StatusCreated, &created)
+
+	// THEN: secret is generated and returned
+	a.Equal(t, "/v1/webhooks/"+created.ID, header.Get("Location"), "mismatch on location")
+	a.Len(t, created.Secret, 2*webhookSecretSize, "mismatch on length of generated secret")
+	a.Equal(t, "https://hooks.example.com/x", created.URL, "mismatch on URL")
+	a.Equal(t, []Tag{tfTagA}, created.Tags, "mismatch on tags")
+	a.Equal(t, []string{tfUserB.ID}, created.Authors, "mismatch on authors")
+
+	// AND: webhook is stored
+	wh, err := st.WebhookLoad(context.Background(), created.ID)
+	ar.NoError(t, err, "webhook not stored")
+	a.Equal(t, created.Secret, wh.Secret, "mismatch on stored secret")
+
+	// AND: secret is not disclosed by later reads
+	var read WebhookOut
+	tsWebhooksRequest(t, h, http.MethodGet, "/v1/webhooks/"+created.ID, "", http.StatusOK, &read)
+	a.Empty(t, read.Secret, "secret disclosed on read")
+	a.Equal(t, created.URL, read.URL, "mismatch on URL")
+
+	var list WebhooksCollectionOut
+	tsWebhooksRequest(t, h, http.MethodGet, "/v1/webhooks", "", http.StatusOK, &list)
+	if a.Len(t, list.Webhooks, 1, "mismatch on listed webhooks") {
+		a.Equal(t, read, list.Webhooks[0], "mismatch on listed webhook")
+	}
+
+	// WHEN: webhook is replaced without secret
+	var updated WebhookOut
+	tsWebhooksRequest(t, h, http.MethodPut, "/v1/webhooks/"+created.ID,
+		`{"url":"https://hooks.example.com/y"}`, http.StatusOK, &updated)
+
+	// THEN: filters are cleared and secret is kept
+	a.Equal(t, "https://hooks.example.com/y", updated.URL, "mismatch on URL")
+	a.Equal(t, []Tag{}, updated.Tags, "mismatch on tags")
+	a.Equal(t, []string{}, updated.Authors, "mismatch on authors")
+	a.Empty(t, updated.Secret, "secret disclosed on update")
+	wh, err = st.WebhookLoad(context.Background(), created.ID)
+	ar.NoError(t, err, "webhook not stored")
+	a.Equal(t, created.Secret, wh.Secret, "secret changed")
+
+	// WHEN: webhook is replaced with secret
+	tsWebhooksRequest(t, h, http.MethodPut, "/v1/webhooks/"+created.ID,
+		`{"url":"https://hooks.example.com/y","secret":"Replaced-Secret-0123456789"}`, http.StatusOK, nil)
+
+	// THEN:
+	wh, err = st.WebhookLoad(context.Background(), created.ID)
+	ar.NoError(t, err, "webhook not stored")
+	a.Equal(t, "Replaced-Secret-0123456789", wh.Secret, "secret not replaced")
+
+	// WHEN: webhook is deleted
+	tsWebhooksRequest(t, h, http.MethodDelete, "/v1/webhooks/"+created.ID, "", http.StatusNoContent, nil)
+
+	// THEN:
+	tsWebhooksRequest(t, h, http.MethodGet, "/v1/webhooks/"+created.ID, "", http.StatusNotFound, nil)
+	tsWebhooksRequest(t, h, http.MethodGet, "/v1/webhooks", "", http.StatusOK, &list)
+	a.Empty(t, list.Webhooks, "webhook listed after delete")
+}
+
+func Test_HTTPHandler_Webhook_Failure(t *testing.T) {
+	userAdmin := User{ID: "UserAdmin-ID", Name: "UserAdmin-Name", Roles: []Role{RoleAdmin}}
+
+	tests := map[string]struct {
+		method     string
+		path       string
+		reqBody    string
+		userID     string
+		anonymous  bool // anonymous request with authentication enforced
+		noWebhooks bool
+		resStatus  int
+		fieldsExp  []string
+	}{
+		"POST: invalid JSON": {
+			method:    http.MethodPost,
+			path:      "/v1/webhooks",
+			reqBody:   `{"url":`,
+			resStatus: http.StatusBadRequest,
+		},
+		"POST: invalid": {
+			method:    http.MethodPost,
+			path:      "/v1/webhooks",
+			reqBody:   `{"url":"ftp://hooks.example.com","secret":"short","tags":["a"]}`,
+			resStatus: http.StatusBadRequest,
+			fieldsExp: []string{"url", "secret", "tags.0"},
+		},
+		"POST: unknown author": {
+			method:    http.MethodPost,
+			path:      "/v1/webhooks",
+			reqBody:   `{"url":"https://hooks.example.com","authors":["UserA-ID","UserX-ID"]}`,
+			resStatus: http.StatusBadRequest,
+			fieldsExp: []string{"authors"},
+		},
+		"POST: by user": {
+			method:    http.MethodPost,
+			path:      "/v1/webhooks",
+			reqBody:   `{"url":"https://hooks.example.com"}`,
+			userID:    tfUserA.ID,
+			resStatus: http.StatusForbidden,
+		},
+		"POST: anonymous": {
+			method:    http.MethodPost,
+			path:      "/v1/webhooks",
+			reqBody:   `{"url":"https://hooks.example.com"}`,
+			anonymous: true,
+			resStatus: http.StatusForbidden,
+		},
+		"POST: not supported": {
+			method:     http.MethodPost,
+			path:       "/v1/webhooks",
+			reqBody:    `{"url":"https://hooks.example.com"}`,
+			noWebhooks: true,
+			resStatus:  http.StatusNotImplemented,
+		},
+		"GET list: by user": {
+			method:    http.MethodGet,
+			path:      "/v1/webhooks",
+			userID:    tfUserA.ID,
+			resStatus: http.StatusForbidden,
+		},
+		"GET list: by admin": {
+			method:    http.MethodGet,
+			path:      "/v1/webhooks",
+			userID:    userAdmin.ID,
+			resStatus: http.StatusOK,
+		},
+		"GET list: anonymous": {
+			method:    http.MethodGet,
+			path:      "/v1/webhooks",
+			anonymous: true,
+			resStatus: http.StatusForbidden,
+		},
+		"GET list: not supported": {
+			method:     http.MethodGet,
+			path:       "/v1/webhooks",
+			noWebhooks: true,
+			resStatus:  http.StatusNotImplemented,
+		},
+		"GET: not found": {
+			method:    http.MethodGet,
+			path:      "/v1/webhooks/WebhookX-ID",
+			resStatus: http.StatusNotFound,
+		},
+		"GET: by user": {
+			method:    http.MethodGet,
+			path:      "/v1/webhooks/" + tfWebhookA.ID,
+			userID:    tfUserA.ID,
+			resStatus: http.StatusForbidden,
+		},
+		"GET: anonymous": {
+			method:    http.MethodGet,
+			path:      "/v1/webhooks/" + tfWebhookA.ID,
+			anonymous: true,
+			resStatus: http.StatusForbidden,
+		},
+		"PUT: not found": {
+			method:    http.MethodPut,
+			path:      "/v1/webhooks/WebhookX-ID",
+			reqBody:   `{"url":"https://hooks.example.com"}`,
+			resStatus: http.StatusNotFound,
+		},
+		"PUT: invalid": {
+			method:    http.MethodPut,
+			path:      "/v1/webhooks/" + tfWebhookA.ID,
+			reqBody:   `{"authors":[""]}`,
+			resStatus: http.StatusBadRequest,
+			fieldsExp: []string{"url", "authors.0"},
+		},
+		"PUT: by user": {
+			method:    http.MethodPut,
+			path:      "/v1/webhooks/" + tfWebhookA.ID,
+			reqBody:   `{"url":"https://hooks.example.com"}`,
+			userID:    tfUserA.ID,
+			resStatus: http.StatusForbidden,
+		},
+		"PUT: anonymous": {
+			method:    http.MethodPut,
+			path:      "/v1/webhooks/" + tfWebhookA.ID,
+			reqBody:   `{"url":"https://hooks.example.com"}`,
+			anonymous: true,
+			resStatus: http.StatusForbidden,
+		},
+		"DELETE: not found": {
+			method:    http.MethodDelete,
+			path:      "/v1/webhooks/WebhookX-ID",
+			resStatus: http.StatusNotFound,
+		},
+		"DELETE: by user": {
+			method:    http.MethodDelete,
+			path:      "/v1/webhooks/" + tfWebhookA.ID,
+			userID:    tfUserA.ID,
+			resStatus: http.StatusForbidden,
+		},
+		"DELETE: anonymous": {
+			method:    http.MethodDelete,
+			path:      "/v1/webhooks/" + tfWebhookA.ID,
+			anonymous: true,
+			resStatus: http.StatusForbidden,
+		},
+		"GET deliveries: not found": {
+			method:    http.MethodGet,
+			path:      "/v1/webhooks/WebhookX-ID/deliveries",
+			resStatus: http.StatusNotFound,
+		},
+		"GET deliveries: anonymous": {
+			method:    http.MethodGet,
+			path:      "/v1/webhooks/" + tfWebhookA.ID + "/deliveries",
+			anonymous: true,
+			resStatus: http.StatusForbidden,
+		},
+		"GET dead letters: not found": {
+			method:    http.MethodGet,
+			path:      "/v1/webhooks/WebhookX-ID/dead-letters",
+			resStatus: http.StatusNotFound,
+		},
+		"GET dead letters: by user": {
+			method:    http.MethodGet,
+			path:      "/v1/webhooks/" + tfWebhookA.ID + "/dead-letters",
+			userID:    tfUserA.ID,
+			resStatus: http.StatusForbidden,
+		},
+		"GET dead letters: anonymous": {
+			method:    http.MethodGet,
+			path:      "/v1/webhooks/" + tfWebhookA.ID + "/dead-letters",
+			anonymous: true,
+			resStatus: http.StatusForbidden,
+		},
+		"PATCH: method not allowed": {
+			method:    http.MethodPatch,
+			path:      "/v1/webhooks/" + tfWebhookA.ID,
+			resStatus: http.StatusMethodNotAllowed,
+		},
+		"DELETE list: method not allowed": {
+			method:    http.MethodDelete,
+			path:      "/v1/webhooks",
+			resStatus: http.StatusMethodNotAllowed,
+		},
+		"POST deliveries: method not allowed": {
+			method:    http.MethodPost,
+			path:      "/v1/webhooks/" + tfWebhookA.ID + "/deliveries",
+			resStatus: http.StatusMethodNotAllowed,
+		},
+		"GET: unknown path": {
+			method:    http.MethodGet,
+			path:      "/v1/webhooks/" + tfWebhookA.ID + "/unknown",
+			resStatus: http.StatusNotFound,
+		},
+	}
+
+	for sym, tc := range tests {
+		st := NewMemoryStorage()
+
+		// GIVEN: users and webhook are in DB
+		for _, u := range []User{tfUserA, tfUserB, userAdmin} {
+			uC := u
+			ar.NoError(t, st.UserSave(context.Background(), &uC), "case: %s", sym)
+		}
+		wh := tfWebhookA
+		ar.NoError(t, st.WebhookSave(context.Background(), &wh), "case: %s", sym)
+
+		// AND: webhooks are supported, unless disabled
+		cfg := HTTPHandlerConfig{Bus: NewMsgBus()}
+		if !tc.noWebhooks {
+			cfg.Webhooks = NewWebhookDispatcher(st, st, cfg.Bus)
+		}
+
+		// AND: request is authenticated, unless authentication is disabled or request is anonymous
+		req, err := http.NewRequest(tc.method, tc.path, strings.NewReader(tc.reqBody))
+		ar.NoError(t, err)
+		if tc.userID != "" {
+			req = req.WithContext(ContextWithUserID(req.Context(), tc.userID))
+		}
+		if tc.anonymous {
+			req = req.WithContext(ContextWithAuthEnforced(req.Context()))
+		}
+		res := httptest.NewRecorder()
+		NewHTTPHandler(st, cfg).ServeHTTP(res, req)
+
+		// THEN: validate response
+		ar.Equal(t, tc.resStatus, res.Code, "[%s] mismatch on response code", sym)
+		if res.Code < http.StatusBadRequest {
+			continue
+		}
+		problem := tsAssertProblem(t, res.Code, res.Header(), res.Body, sym)
+
+		// AND: invalid fields are reported
+		if tc.fieldsExp != nil {
+			var fields []string
+			for _, fe := range problem.Errors {
+				fields = append(fields, fe.Field)
+			}
+			a.Equal(t, tc.fieldsExp, fields, "[%s] mismatch on invalid fields", sym)
+		}
+
+		// AND: webhook is intact
+		whGot, err := st.WebhookLoad(context.Background(), tfWebhookA.ID)
+		if a.NoError(t, err, "[%s] webhook removed", sym) {
+			a.Equal(t, tfWebhookA.URL, whGot.URL, "[%s] webhook changed", sym)
+		}
+	}
+}
+
+func Test_HTTPHandler_Webhook_Deliveries(t *testing.T) {
+	ts, reqs := tsWebhookReceiver(http.StatusServiceUnavailable, http.StatusBadRequest)
+	defer ts.Close()
+	_, d, h := tsWebhooksHandlerSetup(t)
+	defer d.Close()
+
+	// GIVEN: webhook is registered
+	var created WebhookOut
+	tsWebhooksRequest(t, h, http.MethodPost, "/v1/webhooks",
+		`{"url":"`+ts.URL+`","secret":"Webhook-Secret-0123456789"}`, http.StatusCreated, &created)
+
+	// WHEN: message is created
+	tsWebhooksRequest(t, h, http.MethodPost, "/v1/messages",
+		`{"body":"Hello","author":"UserA-Name","tag":"tagA"}`, http.StatusCreated, nil)
+
+	// THEN: it's retried and rejected by the receiver
+	for i := 0; i < 2; i++ {
+		req := tsWebhookRecv(t, reqs)
+		a.Equal(t, webhookSignature("Webhook-Secret-0123456789", req.Body), req.Header.Get(webhookHeaderSignature), "[%d] mismatch on signature", i)
+	}
+	tsWaitFor(t, "dead letter", func() bool { return len(d.DeadLettersOf(created.ID)) == 1 })
+
+	// AND: attempts are reported from the newest
+	var deliveries WebhookDeliveriesOut
+	tsWebhooksRequest(t, h, http.MethodGet, "/v1/webhooks/"+created.ID+"/deliveries", "", http.StatusOK, &deliveries)
+	ar.Len(t, deliveries.Deliveries, 2, "mismatch on number of deliveries")
+	a.Equal(t, string(WebhookFailed), deliveries.Deliveries[0].Outcome, "mismatch on outcome")
+	a.Equal(t, http.StatusBadRequest, deliveries.Deliveries[0].StatusCode, "mismatch on status code")
+	a.Equal(t, 2, deliveries.Deliveries[0].Attempt, "mismatch on attempt")
+	a.Equal(t, string(WebhookRetrying), deliveries.Deliveries[1].Outcome, "mismatch on outcome")
+	a.Equal(t, string(MsgEventCreated), deliveries.Deliveries[1].Event, "mismatch on event")
+
+	// AND: dead letter is reported with its payload
+	var letters WebhookDeadLettersOut
+	tsWebhooksRequest(t, h, http.MethodGet, "/v1/webhooks/"+created.ID+"/dead-letters", "", http.StatusOK, &letters)
+	ar.Len(t, letters.DeadLetters, 1, "mismatch on number of dead letters")
+	dl := letters.DeadLetters[0]
+	a.Equal(t, deliveries.Deliveries[0].DeliveryID, dl.DeliveryID, "mismatch on delivery ID")
+	a.Equal(t, 2, dl.Attempts, "mismatch on attempts")
+	a.Equal(t, "unexpected response: 400 Bad Request", dl.Error, "mismatch on error")
+	if a.NotNil(t, dl.Payload, "missing payload") {
+		a.Equal(t, dl.DeliveryID, dl.Payload.ID, "mismatch on payload ID")
+		a.Equal(t, "Hello", dl.Payload.Message.Body, "mismatch on payload message")
+		a.Equal(t, tfUserA.Name, dl.Payload.Message.Author, "mismatch on payload author")
+	}
+
+	// WHEN: webhook is deleted
+	tsWebhooksRequest(t, h, http.MethodDelete, "/v1/webhooks/"+created.ID, "", http.StatusNoContent, nil)
+
+	// THEN: its log is dropped
+	a.Empty(t, d.AttemptsOf(created.ID), "attempts kept")
+	a.Empty(t, d.DeadLettersOf(created.ID), "dead letters kept")
+}
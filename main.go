@@ -2,7 +2,17 @@ package main
 
 //noinspection SpellCheckingInspection
 import (
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/uber-go/zap"
 	"github.com/vrischmann/envconfig"
@@ -11,6 +21,9 @@ import (
 const (
 	// ConfigAppPrefix prefixes all ENV values used to config the program.
 	ConfigAppPrefix = "APP"
+
+	// configSecretMask replaces secrets in config written to logs.
+	configSecretMask = "***"
 )
 
 type config struct {
@@ -20,9 +33,170 @@ type config struct {
 	// HTTPPort is a port number on which HTTP server endpoint is listening.
 	HTTPPort int `envconfig:"default=8080"`
 
+	// AdminHost is address on which admin HTTP endpoint, exposing /metrics, /healthz and /readyz, is listening.
+	AdminHost string `envconfig:"default=0.0.0.0"`
+
+	// AdminPort is a port number on which admin HTTP endpoint is listening.
+	AdminPort int `envconfig:"default=9090"`
+
+	// ShutdownTimeout is a time given to in-flight requests to complete once SIGINT or SIGTERM is received.
+	// Connections which are still open after it are closed forcibly.
+	ShutdownTimeout time.Duration `envconfig:"default=10s"`
+
 	// LogLevel is a minimal log severity required for the message to be logged.
 	// Valid levels: [debug, info, warn, error, fatal, panic, none].
 	LogLevel string `envconfig:"default=info"`
+
+	// Storage is a backend used to persist users and messages.
+	// Valid backends: [memory, file, sql].
+	Storage string `envconfig:"default=memory"`
+
+	// StorageDir is a directory in which file storage keeps its snapshot and write-ahead log.
+	StorageDir string `envconfig:"default=./data"`
+
+	// StorageSnapshotEvery is a number of write-ahead log records after which file storage is compacted into snapshot.
+	StorageSnapshotEvery int `envconfig:"default=1000"`
+
+	// StorageSQLDriver is a database driver used by sql storage.
+	// Valid drivers: [sqlite3, postgres].
+	StorageSQLDriver string `envconfig:"default=sqlite3"`
+
+	// StorageSQLDSN is a data source name used by sql storage to connect to the database.
+	StorageSQLDSN string `envconfig:"default=./messenger.db"`
+
+	// TraceExporter is a destination of recorded traces.
+	// Valid exporters: [none, stdout, file, otlp].
+	TraceExporter string `envconfig:"default=none"`
+
+	// TraceFile is a file to which traces are appended by file exporter, one span per line.
+	TraceFile string `envconfig:"default=./traces.jsonl"`
+
+	// TraceOTLPEndpoint is a base URL of OTLP/HTTP collector used by otlp exporter.
+	TraceOTLPEndpoint string `envconfig:"default=http://localhost:4318"`
+
+	// AuthAPIKeys lists API keys accepted in X-API-Key header, in format: userID:key.
+	// Any API key or JWT key is required, unless authentication is disabled.
+	AuthAPIKeys []string `envconfig:"optional"`
+
+	// AuthJWTHMACSecret is a secret used to verify bearer tokens signed with HS256, HS384 or HS512.
+	AuthJWTHMACSecret string `envconfig:"optional"`
+
+	// AuthJWTRSAPublicKeyFile is a PEM file with public key used to verify bearer tokens signed with RS256, RS384 or RS512.
+	AuthJWTRSAPublicKeyFile string `envconfig:"optional"`
+
+	// AuthJWTIssuer, if set, must match iss claim of bearer tokens.
+	AuthJWTIssuer string `envconfig:"optional"`
+
+	// AuthJWTAudience, if set, must be listed in aud claim of bearer tokens.
+	AuthJWTAudience string `envconfig:"optional"`
+
+	// AuthDisabled runs the server without authentication, trusting author field of requests.
+	// It's an explicit opt-in, startup fails when no credentials are configured without it.
+	AuthDisabled bool `envconfig:"default=false"`
+
+	// AuthAdmins lists IDs of users holding admin role regardless of roles granted in the storage.
+	// It's used to bootstrap the first admin, who grants roles to others.
+	AuthAdmins []string `envconfig:"optional"`
+
+	// UserDeletePolicy decides what happens to messages of removed user.
+	// Valid policies: [reject, cascade, anonymise].
+	UserDeletePolicy string `envconfig:"default=reject"`
+}
+
+// String returns config in the format of %+v, with secrets masked so that it may be logged.
+// Users of API keys are kept to tell the keys apart.
+func (c config) String() string {
+	if c.AuthJWTHMACSecret != "" {
+		c.AuthJWTHMACSecret = configSecretMask
+	}
+	keys := make([]string, 0, len(c.AuthAPIKeys))
+	for _, k := range c.AuthAPIKeys {
+		i := strings.IndexByte(k, ':')
+		keys = append(keys, k[:i+1]+configSecretMask)
+	}
+	c.AuthAPIKeys = keys
+
+	// plain has no String method, so it's printed field by field
+	type plain config
+	return fmt.Sprintf("%+v", plain(c))
+}
+
+// newStorage creates storage backend selected in config.
+func newStorage(cfg *config) (Storer, error) {
+	switch cfg.Storage {
+	case "memory":
+		return NewMemoryStorage(), nil
+	case "file":
+		return NewFileStorage(cfg.StorageDir, cfg.StorageSnapshotEvery)
+	case "sql":
+		return NewSQLStorage(cfg.StorageSQLDriver, cfg.StorageSQLDSN)
+	}
+	return nil, fmt.Errorf("unknown storage: %s", cfg.Storage)
+}
+
+// newTracer creates tracer exporting spans to destination selected in config.
+// Nil tracer is returned when tracing is disabled.
+func newTracer(cfg *config) (*Tracer, error) {
+	var exp SpanExporter
+	switch cfg.TraceExporter {
+	case "none":
+		return nil, nil
+	case "stdout":
+		exp = NewStdoutExporter()
+	case "file":
+		var err error
+		if exp, err = NewFileExporter(cfg.TraceFile); err != nil {
+			return nil, err
+		}
+	case "otlp":
+		exp = NewOTLPExporter(cfg.TraceOTLPEndpoint)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter: %s", cfg.TraceExporter)
+	}
+	return NewTracer(exp), nil
+}
+
+// newAuth creates API key set and bearer token verifier configured in config.
+// Nil is returned for each of them which is not configured. Error is returned if none of them is configured,
+// unless authentication is disabled explicitly, and if any of them is configured along with disabled authentication.
+func newAuth(cfg *config) (*APIKeys, *JWTVerifier, error) {
+	configured := len(cfg.AuthAPIKeys) > 0 || cfg.AuthJWTHMACSecret != "" || cfg.AuthJWTRSAPublicKeyFile != ""
+	switch {
+	case cfg.AuthDisabled && configured:
+		return nil, nil, errors.New("auth: credentials configured while authentication is disabled")
+	case cfg.AuthDisabled:
+		return nil, nil, nil
+	case !configured:
+		return nil, nil, errors.New("auth: no API keys or JWT keys configured, set APP_AUTH_DISABLED=true to run without authentication")
+	}
+
+	var keys *APIKeys
+	if len(cfg.AuthAPIKeys) > 0 {
+		var err error
+		if keys, err = ParseAPIKeys(cfg.AuthAPIKeys); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if cfg.AuthJWTHMACSecret == "" && cfg.AuthJWTRSAPublicKeyFile == "" {
+		return keys, nil, nil
+	}
+	tokens := NewJWTVerifier()
+	tokens.Issuer = cfg.AuthJWTIssuer
+	tokens.Audience = cfg.AuthJWTAudience
+	if cfg.AuthJWTHMACSecret != "" {
+		tokens.SetHMACKey([]byte(cfg.AuthJWTHMACSecret))
+	}
+	if cfg.AuthJWTRSAPublicKeyFile != "" {
+		b, err := ioutil.ReadFile(cfg.AuthJWTRSAPublicKeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := tokens.SetRSAKey(b); err != nil {
+			return nil, nil, err
+		}
+	}
+	return keys, tokens, nil
 }
 
 func main() {
@@ -41,17 +215,103 @@ func main() {
 	}
 
 	lgr.SetLevel(logLevel)
-	lgr.Debug(fmt.Sprintf("Parsed config from env => %+v", *cfg))
+	lgr.Debug(fmt.Sprintf("Parsed config from env => %s", cfg))
 
 	lgr.Info("starting")
 
-	st := NewMemoryStorage()
-	h := NewHTTPDefaultHandler(st)
-	mc := NewCORSMiddleware(h)
-	ml := NewLoggingMiddleware(mc, lgr)
-	s := NewHTTPServer(cfg.HTTPHost, cfg.HTTPPort, ml)
+	userDeletePolicy := UserDeletePolicy(cfg.UserDeletePolicy)
+	if err := userDeletePolicy.Validate(); err != nil {
+		lgr.Fatal(err.Error())
+	}
+
+	keys, tokens, err := newAuth(cfg)
+	if err != nil {
+		lgr.Fatal(err.Error())
+	}
+	if cfg.AuthDisabled {
+		lgr.Warn("auth:disabled")
+	}
+
+	tracer, err := newTracer(cfg)
+	if err != nil {
+		lgr.Fatal(err.Error())
+	}
+	if tracer != nil {
+		tracer.OnError = func(err error) {
+			lgr.Warn("trace:export:failed", zap.String("error", err.Error()))
+		}
+	}
+
+	st, err := newStorage(cfg)
+	if err != nil {
+		lgr.Fatal(err.Error())
+	}
+	if fs, ok := st.(*fileStorage); ok {
+		fs.OnError = func(err error) {
+			lgr.Warn("storage:failed", zap.String("error", err.Error()))
+		}
+	}
+	// -- metrics
+	reg := NewMetricsRegistry()
+	if ss, ok := st.(StatsStorer); ok {
+		reg.Register(NewStorageCollector(ss))
+	}
+	adminMux := http.NewServeMux()
+	adminMux.Handle("/metrics", reg)
+	health := NewHealthHandler(st)
+	adminMux.Handle("/healthz", health)
+	adminMux.Handle("/readyz", health)
+	as := NewHTTPServer(cfg.AdminHost, cfg.AdminPort, adminMux)
+	go func() {
+		if err := as.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			lgr.Fatal(err.Error())
+		}
+	}()
+
+	hst := st
+	if tracer != nil {
+		hst = NewTracingStorer(st)
+	}
+	bus := NewMsgBus()
+	// storage is closed after the server, so deliveries are stopped first
+	var closer io.Closer = st
+	var webhooks *WebhookDispatcher
+	if ws, ok := st.(WebhookStorer); ok {
+		webhooks = NewWebhookDispatcher(ws, st, bus)
+		webhooks.OnError = func(err error) {
+			lgr.Warn("webhooks:failed", zap.String("error", err.Error()))
+		}
+		webhooks.Start()
+		closer = closers{webhooks, st}
+	}
+	h := NewHTTPHandler(hst, HTTPHandlerConfig{UserDeletePolicy: userDeletePolicy, Admins: cfg.AuthAdmins, Bus: bus, Webhooks: webhooks})
+	var ah http.Handler = h
+	if !cfg.AuthDisabled {
+		ah = NewAuthMiddleware(h, keys, tokens)
+	}
+	mc := NewCORSMiddleware(ah)
+	mm := NewMetricsMiddleware(mc, reg)
+	ml := NewLoggingMiddleware(mm, lgr)
+	mt := NewTracingMiddleware(ml, tracer)
+	s := NewHTTPServer(cfg.HTTPHost, cfg.HTTPPort, mt)
+	// probes served on admin port fail while requests are drained
+	s.RegisterOnShutdown(health.SetDraining)
+	// streams never complete on their own, so they are ended instead of holding up the drain
+	s.RegisterOnShutdown(bus.Close)
+
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		lgr.Fatal(err.Error())
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
-	if err := s.ListenAndServe(); err != nil {
+	err = serveGraceful(s, ln, closer, lgr, sigCh, cfg.ShutdownTimeout)
+	as.Close()
+	// spans of drained requests are exported before exit
+	tracer.Close()
+	if err != nil {
 		lgr.Fatal(err.Error())
 	}
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+func Test_Config_String_SecretsMasked(t *testing.T) {
+	cfg := &config{
+		HTTPPort:          8080,
+		AuthAPIKeys:       []string{"UserA-ID:keyA-secret", "keyB-secret"},
+		AuthJWTHMACSecret: "hmac-secret",
+		AuthJWTIssuer:     "issuer",
+	}
+
+	got := fmt.Sprintf("%s", cfg)
+
+	a.NotContains(t, got, "secret", "secret logged")
+	a.Contains(t, got, "AuthAPIKeys:[UserA-ID:*** ***]", "mismatch on API keys")
+	a.Contains(t, got, "AuthJWTHMACSecret:***", "mismatch on HMAC secret")
+	a.Contains(t, got, "HTTPPort:8080", "other fields not logged")
+	a.Contains(t, got, "AuthJWTIssuer:issuer", "other fields not logged")
+
+	// AND: config is left intact
+	a.Equal(t, "hmac-secret", cfg.AuthJWTHMACSecret)
+	a.Equal(t, "UserA-ID:keyA-secret", cfg.AuthAPIKeys[0])
+}
+
+func Test_Config_NewAuth_Success(t *testing.T) {
+	tests := map[string]struct {
+		cfg       config
+		keysExp   bool
+		tokensExp bool
+	}{
+		"API keys":      {cfg: config{AuthAPIKeys: []string{"UserA-ID:keyA"}}, keysExp: true},
+		"JWT secret":    {cfg: config{AuthJWTHMACSecret: "secret"}, tokensExp: true},
+		"both":          {cfg: config{AuthAPIKeys: []string{"UserA-ID:keyA"}, AuthJWTHMACSecret: "secret"}, keysExp: true, tokensExp: true},
+		"auth disabled": {cfg: config{AuthDisabled: true}},
+	}
+
+	for sym, tc := range tests {
+		keys, tokens, err := newAuth(&tc.cfg)
+		ar.NoError(t, err, "[%s] unexpected error", sym)
+		a.Equal(t, tc.keysExp, keys != nil, "[%s] mismatch on API keys", sym)
+		a.Equal(t, tc.tokensExp, tokens != nil, "[%s] mismatch on token verifier", sym)
+	}
+}
+
+func Test_Config_NewAuth_Failure(t *testing.T) {
+	tests := map[string]config{
+		"nothing configured":            {},
+		"API keys while disabled":       {AuthDisabled: true, AuthAPIKeys: []string{"UserA-ID:keyA"}},
+		"JWT secret while disabled":     {AuthDisabled: true, AuthJWTHMACSecret: "secret"},
+		"JWT public key while disabled": {AuthDisabled: true, AuthJWTRSAPublicKeyFile: "jwt.pem"},
+	}
+
+	for sym, cfg := range tests {
+		cfg := cfg
+		_, _, err := newAuth(&cfg)
+		a.Error(t, err, "[%s] config accepted", sym)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metricsContentType is a media type of Prometheus text exposition format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metricsCollector is a source of metrics exposed by MetricsRegistry.
+type metricsCollector interface {
+	// writeMetrics writes all metric families of the collector in Prometheus text format.
+	writeMetrics(w io.Writer)
+}
+
+// MetricsRegistry keeps metrics and exposes them in Prometheus text format.
+// It's safe for concurrent use.
+type MetricsRegistry struct {
+	mu         sync.Mutex
+	collectors []metricsCollector
+}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{}
+}
+
+// Register adds collector to the registry. Metrics are exposed in order of registration.
+func (r *MetricsRegistry) Register(c metricsCollector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// ServeHTTP writes all registered metrics.
+func (r *MetricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	cs := append([]metricsCollector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", metricsContentType)
+	bw := bufio.NewWriter(w)
+	for _, c := range cs {
+		c.writeMetrics(bw)
+	}
+	bw.Flush()
+}
+
+// -- section: counter
+
+// counterVec is a monotonic counter partitioned by label values.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]*counterValue),
+	}
+}
+
+// Add increases counter identified by label values, given in order of labels, by v.
+func (c *counterVec) Add(v float64, lvs ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := metricsKey(lvs)
+	cv, found := c.values[k]
+	if !found {
+		cv = &counterValue{labels: lvs}
+		c.values[k] = cv
+	}
+	cv.value += v
+}
+
+func (c *counterVec) writeMetrics(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	metricsWriteHeader(w, c.name, c.help, "counter")
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cv := c.values[k]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, metricsLabels(c.labels, cv.labels), metricsFloat(cv.value))
+	}
+}
+
+// -- section: histogram
+
+// histogramVec counts observations in configurable buckets, partitioned by label values.
+type histogramVec struct {
+	name   string
+	help   string
+	labels []string
+	// buckets are upper bounds of the buckets, in increasing order. +Inf bucket is implicit.
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	// counts keeps number of observations per bucket, not cumulative.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		values:  make(map[string]*histogramValue),
+	}
+}
+
+// Observe records single value in histogram identified by label values, given in order of labels.
+func (h *histogramVec) Observe(v float64, lvs ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	k := metricsKey(lvs)
+	hv, found := h.values[k]
+	if !found {
+		hv = &histogramValue{labels: lvs, counts: make([]uint64, len(h.buckets))}
+		h.values[k] = hv
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		hv.counts[i]++
+	}
+	hv.sum += v
+	hv.count++
+}
+
+func (h *histogramVec) writeMetrics(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	metricsWriteHeader(w, h.name, h.help, "histogram")
+	bucketLabels := append(append([]string(nil), h.labels...), "le")
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		hv := h.values[k]
+		var cumulative uint64
+		for i, ub := range h.buckets {
+			cumulative += hv.counts[i]
+			lvs := append(append([]string(nil), hv.labels...), metricsFloat(ub))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, metricsLabels(bucketLabels, lvs), cumulative)
+		}
+		lvs := append(append([]string(nil), hv.labels...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, metricsLabels(bucketLabels, lvs), hv.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, metricsLabels(h.labels, hv.labels), metricsFloat(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, metricsLabels(h.labels, hv.labels), hv.count)
+	}
+}
+
+// -- section: storage
+
+// StatsStorer is implemented by storage backends able to report number of kept elements.
+type StatsStorer interface {
+	Stats() StorageStats
+}
+
+// storageCollector exposes storage statistics as gauges. Statistics are read on each scrape.
+type storageCollector struct {
+	st StatsStorer
+}
+
+// NewStorageCollector creates collector exposing number of users, messages and tags kept in st.
+func NewStorageCollector(st StatsStorer) *storageCollector {
+	return &storageCollector{st: st}
+}
+
+func (c *storageCollector) writeMetrics(w io.Writer) {
+	s := c.st.Stats()
+	for _, g := range []struct {
+		name  string
+		help  string
+		value int
+	}{
+		{"messenger_storage_users", "Number of users kept in the storage.", s.Users},
+		{"messenger_storage_messages", "Number of messages kept in the storage.", s.Messages},
+		{"messenger_storage_tags", "Number of distinct tags attached to messages kept in the storage.", s.Tags},
+	} {
+		metricsWriteHeader(w, g.name, g.help, "gauge")
+		fmt.Fprintf(w, "%s %d\n", g.name, g.value)
+	}
+}
+
+// -- section: text format helpers
+
+// metricsKey joins label values into map key. Separator can not be a part of valid UTF-8 string.
+// Keys are sorted on output, so order of the series is stable between scrapes.
+func metricsKey(lvs []string) string {
+	return strings.Join(lvs, "\xff")
+}
+
+func metricsWriteHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, metricsHelpEscaper.Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+var (
+	metricsHelpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	metricsLabelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// metricsLabels formats label pairs, e.g. {code="200",method="GET"}. Empty string is returned for no labels.
+func metricsLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, n := range names {
+		pairs[i] = n + `="` + metricsLabelEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func metricsFloat(v float64) string {
+	switch {
+	case math.IsInf(v, +1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
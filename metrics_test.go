@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	a "github.com/stretchr/testify/assert"
+)
+
+// tsMetricsScrape returns metrics exposed by the registry.
+func tsMetricsScrape(t *testing.T, reg *MetricsRegistry) string {
+	rr := httptest.NewRecorder()
+	reg.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	a.Equal(t, http.StatusOK, rr.Code, "incorrect status")
+	a.Equal(t, metricsContentType, rr.Header().Get("Content-Type"), "incorrect content type")
+	return rr.Body.String()
+}
+
+func Test_Metrics_Counter(t *testing.T) {
+	reg := NewMetricsRegistry()
+	c := newCounterVec("test_total", "Test counter.", "route", "code")
+	reg.Register(c)
+
+	c.Add(1, "/b", "200")
+	c.Add(1, "/a", "404")
+	c.Add(2, "/b", "200")
+
+	exp := `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{route="/a",code="404"} 1
+test_total{route="/b",code="200"} 3
+`
+	a.Equal(t, exp, tsMetricsScrape(t, reg))
+}
+
+func Test_Metrics_Histogram(t *testing.T) {
+	reg := NewMetricsRegistry()
+	h := newHistogramVec("test_seconds", "Test histogram.", []float64{0.1, 1}, "route")
+	reg.Register(h)
+
+	h.Observe(0.05, "/a")
+	h.Observe(0.1, "/a")
+	h.Observe(0.5, "/a")
+	h.Observe(5, "/a")
+
+	exp := `# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{route="/a",le="0.1"} 2
+test_seconds_bucket{route="/a",le="1"} 3
+test_seconds_bucket{route="/a",le="+Inf"} 4
+test_seconds_sum{route="/a"} 5.65
+test_seconds_count{route="/a"} 4
+`
+	a.Equal(t, exp, tsMetricsScrape(t, reg))
+}
+
+func Test_Metrics_Escaping(t *testing.T) {
+	reg := NewMetricsRegistry()
+	c := newCounterVec("test_total", "Help with \\ and\nnew line.", "path")
+	reg.Register(c)
+
+	c.Add(1, "a\"b\\c\nd")
+
+	out := tsMetricsScrape(t, reg)
+	a.Contains(t, out, `# HELP test_total Help with \\ and\nnew line.`+"\n")
+	a.Contains(t, out, `test_total{path="a\"b\\c\nd"} 1`+"\n")
+}
+
+func Test_Metrics_Float(t *testing.T) {
+	tests := map[float64]string{
+		0:            "0",
+		1:            "1",
+		0.005:        "0.005",
+		1e7:          "1e+07",
+		math.Inf(+1): "+Inf",
+		math.Inf(-1): "-Inf",
+	}
+	for v, exp := range tests {
+		a.Equal(t, exp, metricsFloat(v), "case: %v", v)
+	}
+}
+
+type tsStatsStorerStub StorageStats
+
+func (s tsStatsStorerStub) Stats() StorageStats {
+	return StorageStats(s)
+}
+
+func Test_Metrics_StorageCollector(t *testing.T) {
+	reg := NewMetricsRegistry()
+	reg.Register(NewStorageCollector(tsStatsStorerStub{Users: 2, Messages: 4, Tags: 3}))
+
+	exp := `# HELP messenger_storage_users Number of users kept in the storage.
+# TYPE messenger_storage_users gauge
+messenger_storage_users 2
+# HELP messenger_storage_messages Number of messages kept in the storage.
+# TYPE messenger_storage_messages gauge
+messenger_storage_messages 4
+# HELP messenger_storage_tags Number of distinct tags attached to messages kept in the storage.
+# TYPE messenger_storage_tags gauge
+messenger_storage_tags 3
+`
+	a.Equal(t, exp, tsMetricsScrape(t, reg))
+}
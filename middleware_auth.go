@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// apiKeyHeader is a name of the header carrying API key.
+const apiKeyHeader = "X-Api-Key"
+
+// authChallenge is sent in WWW-Authenticate header of 401 responses.
+const authChallenge = `Bearer realm="messenger"`
+
+// AuthMiddleware provides HTTP middleware which authenticates requests with API key (X-API-Key header)
+// or signed bearer token (Authorization: Bearer header). ID of the authenticated user is bound to the request
+// context, see UserIDFromContext.
+//
+// Requests changing the state require credentials, except of user registration (POST /v1/users).
+// Reading does not, but invalid credentials are rejected on any request.
+type AuthMiddleware struct {
+	// Handler is the handler to be wrapped
+	Handler http.Handler
+
+	// Keys authenticates API keys. Nil disables API keys.
+	Keys *APIKeys
+
+	// Tokens verifies bearer tokens. Nil disables bearer tokens.
+	Tokens *JWTVerifier
+}
+
+func NewAuthMiddleware(h http.Handler, keys *APIKeys, tokens *JWTVerifier) *AuthMiddleware {
+	return &AuthMiddleware{
+		Handler: h,
+		Keys:    keys,
+		Tokens:  tokens,
+	}
+}
+
+func (m *AuthMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := StartSpan(r.Context(), "AuthMiddleware", SpanKindInternal)
+	defer span.End()
+	r = r.WithContext(ContextWithAuthEnforced(ctx))
+
+	userID, given, err := m.authenticate(r)
+	switch {
+	case err != nil:
+		writeUnauthorized(w, "invalid credentials")
+		return
+	case !given && authRequired(r):
+		writeUnauthorized(w, "credentials required")
+		return
+	case given:
+		span.SetAttr("enduser.id", userID)
+		r = r.WithContext(ContextWithUserID(r.Context(), userID))
+	}
+
+	m.Handler.ServeHTTP(w, r)
+}
+
+// authenticate checks credentials sent with the request. Given is false when there are none.
+// Credentials of disabled kind are treated as invalid, so they are never silently ignored.
+func (m *AuthMiddleware) authenticate(r *http.Request) (userID string, given bool, err error) {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		if m.Keys == nil {
+			return "", true, ErrCredentialsInvalid
+		}
+		userID, err = m.Keys.Authenticate(key)
+		return userID, true, err
+	}
+
+	authz := r.Header.Get("Authorization")
+	if authz == "" {
+		return "", false, nil
+	}
+	const prefix = "bearer "
+	if len(authz) <= len(prefix) || !strings.EqualFold(authz[:len(prefix)], prefix) || m.Tokens == nil {
+		return "", true, ErrCredentialsInvalid
+	}
+	claims, err := m.Tokens.Verify(strings.TrimSpace(authz[len(prefix):]))
+	if err != nil {
+		return "", true, err
+	}
+	return claims.Subject, true, nil
+}
+
+// authRequired reports whether request changes the state and must be authenticated.
+func authRequired(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	case http.MethodPost:
+		// registration is open, new users get their credentials afterwards
+		return routeTemplate(r.URL.Path) != "/v1/users"
+	}
+	return true
+}
+
+func writeUnauthorized(w http.ResponseWriter, detail string) {
+	writeProblem(w, newProblem(http.StatusUnauthorized, problemUnauthorized, detail))
+}
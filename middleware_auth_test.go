@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+func Test_HTTPMiddleware_Auth(t *testing.T) {
+	validToken := tsJWTSign(t, "HS256", tfJWTSecret, tsJWTClaims())
+	expiredClaims := tsJWTClaims()
+	expiredClaims["exp"] = tfJWTNow.Add(-time.Hour).Unix()
+	expiredToken := tsJWTSign(t, "HS256", tfJWTSecret, expiredClaims)
+
+	tests := map[string]struct {
+		method    string
+		path      string
+		header    map[string]string
+		noKeys    bool
+		resStatus int
+		userIDExp string
+	}{
+		"GET: anonymous": {
+			method:    http.MethodGet,
+			path:      "/v1/messages?tag=tagA",
+			resStatus: http.StatusOK,
+		},
+		"OPTIONS: anonymous": {
+			method:    http.MethodOptions,
+			path:      "/v1/messages",
+			resStatus: http.StatusOK,
+		},
+		"POST users: anonymous registration": {
+			method:    http.MethodPost,
+			path:      "/v1/users",
+			resStatus: http.StatusOK,
+		},
+		"POST messages: anonymous": {
+			method:    http.MethodPost,
+			path:      "/v1/messages",
+			resStatus: http.StatusUnauthorized,
+		},
+		"PATCH message: anonymous": {
+			method:    http.MethodPatch,
+			path:      "/v1/messages/" + tfMsgAA.ID,
+			resStatus: http.StatusUnauthorized,
+		},
+		"DELETE user: anonymous": {
+			method:    http.MethodDelete,
+			path:      "/v1/users/" + tfUserA.ID,
+			resStatus: http.StatusUnauthorized,
+		},
+		"POST messages: API key": {
+			method:    http.MethodPost,
+			path:      "/v1/messages",
+			header:    map[string]string{"X-API-Key": "keyB"},
+			resStatus: http.StatusOK,
+			userIDExp: tfUserB.ID,
+		},
+		"POST messages: unknown API key": {
+			method:    http.MethodPost,
+			path:      "/v1/messages",
+			header:    map[string]string{"X-API-Key": "keyX"},
+			resStatus: http.StatusUnauthorized,
+		},
+		"POST messages: API keys disabled": {
+			method:    http.MethodPost,
+			path:      "/v1/messages",
+			header:    map[string]string{"X-API-Key": "keyB"},
+			noKeys:    true,
+			resStatus: http.StatusUnauthorized,
+		},
+		"POST messages: bearer token": {
+			method:    http.MethodPost,
+			path:      "/v1/messages",
+			header:    map[string]string{"Authorization": "Bearer " + validToken},
+			resStatus: http.StatusOK,
+			userIDExp: tfUserA.ID,
+		},
+		"POST messages: bearer token, lower case scheme": {
+			method:    http.MethodPost,
+			path:      "/v1/messages",
+			header:    map[string]string{"Authorization": "bearer " + validToken},
+			resStatus: http.StatusOK,
+			userIDExp: tfUserA.ID,
+		},
+		"POST messages: expired bearer token": {
+			method:    http.MethodPost,
+			path:      "/v1/messages",
+			header:    map[string]string{"Authorization": "Bearer " + expiredToken},
+			resStatus: http.StatusUnauthorized,
+		},
+		"POST messages: unsupported scheme": {
+			method:    http.MethodPost,
+			path:      "/v1/messages",
+			header:    map[string]string{"Authorization": "Basic dXNlcjpwYXNz"},
+			resStatus: http.StatusUnauthorized,
+		},
+		"GET: invalid credentials": {
+			method:    http.MethodGet,
+			path:      "/v1/messages?tag=tagA",
+			header:    map[string]string{"Authorization": "Bearer " + expiredToken},
+			resStatus: http.StatusUnauthorized,
+		},
+		"GET: bearer token": {
+			method:    http.MethodGet,
+			path:      "/v1/messages?tag=tagA",
+			header:    map[string]string{"Authorization": "Bearer " + validToken},
+			resStatus: http.StatusOK,
+			userIDExp: tfUserA.ID,
+		},
+	}
+
+	for sym, tc := range tests {
+		// GIVEN: both kinds of credentials are enabled
+		keys, err := ParseAPIKeys([]string{tfUserB.ID + ":keyB"})
+		ar.NoError(t, err)
+		if tc.noKeys {
+			keys = nil
+		}
+		tokens := NewJWTVerifier()
+		tokens.TimeNow = func() time.Time { return tfJWTNow }
+		tokens.SetHMACKey(tfJWTSecret)
+
+		var called bool
+		var userIDGot string
+		hFn := func(w http.ResponseWriter, r *http.Request) {
+			called = true
+			userIDGot, _ = UserIDFromContext(r.Context())
+		}
+		m := NewAuthMiddleware(&tmHTTPHandler{hFn}, keys, tokens)
+
+		req, err := http.NewRequest(tc.method, tc.path, nil)
+		ar.NoError(t, err)
+		for k, v := range tc.header {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		m.ServeHTTP(rr, req)
+
+		// THEN:
+		ar.Equal(t, tc.resStatus, rr.Code, "[%s] mismatch on response code", sym)
+		if tc.resStatus == http.StatusUnauthorized {
+			a.False(t, called, "[%s] wrapped handler called", sym)
+			a.Equal(t, `Bearer realm="messenger"`, rr.Header().Get("WWW-Authenticate"), "[%s] challenge mismatch", sym)
+			p := tsAssertProblem(t, rr.Code, rr.Header(), rr.Body, sym)
+			a.Equal(t, problemUnauthorized, p.Code, "[%s] problem code mismatch", sym)
+			continue
+		}
+		a.True(t, called, "[%s] wrapped handler not called", sym)
+		a.Equal(t, tc.userIDExp, userIDGot, "[%s] authenticated user mismatch", sym)
+	}
+}
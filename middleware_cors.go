@@ -1,9 +1,6 @@
 package main
 
-import (
-	"net/http"
-	"net/http/httptest"
-)
+import "net/http"
 
 type CORSMiddleware struct {
 	// Handler is the handler to be wrapped
@@ -17,19 +14,13 @@ func NewCORSMiddleware(h http.Handler) *CORSMiddleware {
 }
 
 func (m *CORSMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	rec := httptest.NewRecorder()
-
-	m.Handler.ServeHTTP(rec, r)
-
-	// recreate the response
-	for k, v := range rec.Header() {
-		w.Header()[k] = v
-	}
-	// add CORS headers
+	// CORS headers are set upfront, so response is not held back by the middleware
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, PUT, PATCH, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization, X-API-Key")
+
+	ctx, span := StartSpan(r.Context(), "CORSMiddleware", SpanKindInternal)
+	defer span.End()
 
-	w.WriteHeader(rec.Code)
-	w.Write(rec.Body.Bytes())
+	m.Handler.ServeHTTP(w, r.WithContext(ctx))
 }
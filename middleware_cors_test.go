@@ -45,7 +45,7 @@ func Test_HTTPMiddleware_CORS(t *testing.T) {
 		"X-Test-B":                     "456",
 		"Access-Control-Allow-Origin":  "*",
 		"Access-Control-Allow-Methods": "GET, POST, DELETE, PUT, PATCH, OPTIONS",
-		"Access-Control-Allow-Headers": "Origin, Content-Type",
+		"Access-Control-Allow-Headers": "Origin, Content-Type, Authorization, X-API-Key",
 	}
 	for hName, hVal := range hExp {
 		a.Equal(t, hVal, res.Header().Get(hName), "mismatch on response header: %s", hName)
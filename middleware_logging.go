@@ -2,7 +2,6 @@ package main
 
 import (
 	"net/http"
-	"net/http/httptest"
 	"time"
 
 	"github.com/uber-go/zap"
@@ -31,20 +30,16 @@ func NewLoggingMiddleware(h http.Handler, l zap.Logger) *LoggingMiddleware {
 func (m *LoggingMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	reqStartedTime := m.TimeNow()
 
-	rec := httptest.NewRecorder()
+	ctx, span := StartSpan(r.Context(), "LoggingMiddleware", SpanKindInternal)
+	defer span.End()
 
-	m.Handler.ServeHTTP(rec, r)
+	rw := newResponseWriter(w)
 
-	// -- recreate the response
-	for k, v := range rec.Header() {
-		w.Header()[k] = v
-	}
-	w.WriteHeader(rec.Code)
-	w.Write(rec.Body.Bytes())
+	m.Handler.ServeHTTP(rw, r.WithContext(ctx))
 
 	// -- log
 	var ll zap.Level
-	switch rec.Code {
+	switch rw.Status() {
 	case http.StatusInternalServerError:
 		ll = zap.ErrorLevel
 	case http.StatusServiceUnavailable:
@@ -52,16 +47,19 @@ func (m *LoggingMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	default:
 		ll = zap.InfoLevel
 	}
-	m.Logger.Log(
-		ll,
-		"request:done",
+	fields := []zap.Field{
 		zap.String("req:method", r.Method),
 		zap.String("req:proto", r.Proto),
 		zap.String("req:host", r.Host),
 		zap.String("req:URI", r.URL.Path),
 		zap.Int64("req:contentLength", r.ContentLength),
-		zap.Int("res:status", rec.Code),
-		zap.Int("res:contentLength", rec.Body.Len()),
+		zap.Int("res:status", rw.Status()),
+		zap.Int("res:contentLength", int(rw.Written())),
 		zap.Float64("req:duration:ms", m.TimeNow().Sub(reqStartedTime).Seconds()*1e-3),
-	)
+	}
+	// trace IDs are added only when request is traced, so logs can be joined with spans
+	if sc := span.SpanContext(); sc.IsValid() {
+		fields = append(fields, zap.String("trace:id", sc.TraceID.String()), zap.String("span:id", sc.SpanID.String()))
+	}
+	m.Logger.Log(ll, "request:done", fields...)
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	// metricsDurationBuckets are upper bounds of request duration buckets, in seconds.
+	metricsDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+	// metricsSizeBuckets are upper bounds of response size buckets, in bytes.
+	metricsSizeBuckets = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+)
+
+// MetricsMiddleware provides HTTP middleware which records number, duration and size of responses.
+// Requests are labelled with route template instead of the path, so number of series is bounded.
+type MetricsMiddleware struct {
+	// Handler is the handler to be wrapped
+	Handler http.Handler
+
+	// TimeNow is testing helper for time sensitive tests. It defaults to time.Now function.
+	TimeNow func() time.Time
+
+	requests *counterVec
+	duration *histogramVec
+	size     *histogramVec
+}
+
+// NewMetricsMiddleware creates middleware and registers its metrics in reg.
+func NewMetricsMiddleware(h http.Handler, reg *MetricsRegistry) *MetricsMiddleware {
+	m := &MetricsMiddleware{
+		Handler: h,
+		TimeNow: time.Now,
+		requests: newCounterVec(
+			"messenger_http_requests_total", "Number of HTTP requests served.",
+			"route", "method", "code",
+		),
+		duration: newHistogramVec(
+			"messenger_http_request_duration_seconds", "Time spent on serving HTTP request.",
+			metricsDurationBuckets, "route", "method",
+		),
+		size: newHistogramVec(
+			"messenger_http_response_size_bytes", "Size of HTTP response body.",
+			metricsSizeBuckets, "route", "method",
+		),
+	}
+	reg.Register(m.requests)
+	reg.Register(m.duration)
+	reg.Register(m.size)
+	return m
+}
+
+func (m *MetricsMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reqStartedTime := m.TimeNow()
+
+	rw := newResponseWriter(w)
+
+	m.Handler.ServeHTTP(rw, r)
+
+	route := routeTemplate(r.URL.Path)
+	method := metricsMethod(r.Method)
+	m.requests.Add(1, route, method, strconv.Itoa(rw.Status()))
+	m.duration.Observe(m.TimeNow().Sub(reqStartedTime).Seconds(), route, method)
+	m.size.Observe(float64(rw.Written()), route, method)
+}
+
+// routeTemplate maps request path into template of the route serving it, e.g. /v1/messages/{id}.
+// Paths not served by any route are reported as "other".
+func routeTemplate(path string) string {
+	switch {
+	case path == "/v1/users" || path == "/v1/users/":
+		return "/v1/users"
+	case rPathUser.MatchString(path):
+		return "/v1/users/{id}"
+	case rPathUserRole.MatchString(path):
+		return "/v1/users/{id}/roles/{role}"
+	case rPathUserMentions.MatchString(path):
+		return "/v1/users/{id}/mentions"
+	case path == "/v1/messages" || path == "/v1/messages/":
+		return "/v1/messages"
+	case rPathMsgStream.MatchString(path):
+		return "/v1/messages/stream"
+	case rPathMsgSocket.MatchString(path):
+		return "/v1/messages/ws"
+	case rPathMsgRevisions.MatchString(path):
+		return "/v1/messages/{id}/revisions"
+	case rPathMsgRead.MatchString(path):
+		return "/v1/messages/{id}"
+	case path == "/v1/search" || path == "/v1/search/":
+		return "/v1/search"
+	case path == "/v1/webhooks" || path == "/v1/webhooks/":
+		return "/v1/webhooks"
+	case rPathWebhook.MatchString(path):
+		return "/v1/webhooks/{id}"
+	case rPathWebhookDeliveries.MatchString(path):
+		return "/v1/webhooks/{id}/deliveries"
+	case rPathWebhookDeadLetters.MatchString(path):
+		return "/v1/webhooks/{id}/dead-letters"
+	case path == "/v1/swagger.json":
+		return path
+	}
+	return "other"
+}
+
+// metricsMethod passes through standard HTTP methods and reports all others as "other".
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "other"
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+func Test_HTTPMiddleware_Metrics_Factory(t *testing.T) {
+	h := &tmHTTPHandler{hFn: func(w http.ResponseWriter, r *http.Request) {}}
+	reg := NewMetricsRegistry()
+
+	m := NewMetricsMiddleware(h, reg)
+
+	ar.NotNil(t, m, "empty element returned")
+	a.NotNil(t, m.TimeNow, "TimeNow not initialised")
+	a.Equal(t, h, m.Handler, "Handler is not attached")
+	a.Len(t, reg.collectors, 3, "metrics not registered")
+}
+
+func Test_HTTPMiddleware_Metrics_RouteTemplate(t *testing.T) {
+	tests := map[string]string{
+		"/v1/users":                         "/v1/users",
+		"/v1/users/":                        "/v1/users",
+		"/v1/users/UserA-ID":                "/v1/users/{id}",
+		"/v1/users/UserA-ID/":               "/v1/users/{id}",
+		"/v1/users/UserA-ID/roles/admin":    "/v1/users/{id}/roles/{role}",
+		"/v1/users/UserA-ID/mentions":       "/v1/users/{id}/mentions",
+		"/v1/messages":                      "/v1/messages",
+		"/v1/messages/":                     "/v1/messages",
+		"/v1/messages/MsgAA-ID":             "/v1/messages/{id}",
+		"/v1/messages/stream":               "/v1/messages/stream",
+		"/v1/messages/ws":                   "/v1/messages/ws",
+		"/v1/messages/MsgAA-ID/revisions":   "/v1/messages/{id}/revisions",
+		"/v1/messages/MsgAA-ID/revisions/":  "/v1/messages/{id}/revisions",
+		"/v1/search":                        "/v1/search",
+		"/v1/webhooks":                      "/v1/webhooks",
+		"/v1/webhooks/Hook-ID":              "/v1/webhooks/{id}",
+		"/v1/webhooks/Hook-ID/deliveries":   "/v1/webhooks/{id}/deliveries",
+		"/v1/webhooks/Hook-ID/dead-letters": "/v1/webhooks/{id}/dead-letters",
+		"/v1/swagger.json":                  "/v1/swagger.json",
+		"/":                                 "other",
+		"/v1/messages/MsgAA-ID/unknown":     "other",
+		"/v1/users/UserA-ID/messages/extra": "other",
+	}
+	for path, exp := range tests {
+		a.Equal(t, exp, routeTemplate(path), "case: %s", path)
+	}
+}
+
+func Test_HTTPMiddleware_Metrics(t *testing.T) {
+	timePreRequest := time.Date(2016, time.May, 29, 10, 11, 12, 13, time.UTC)
+	timeFakeCh := make(chan time.Time, 2)
+
+	h := &tmHTTPHandler{hFn: func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "missing") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("12345"))
+	}}
+	reg := NewMetricsRegistry()
+	m := NewMetricsMiddleware(h, reg)
+	m.TimeNow = func() time.Time { return <-timeFakeCh }
+
+	// WHEN: requests for different IDs are served
+	for _, path := range []string{"/v1/messages/MsgAA-ID", "/v1/messages/MsgAB-ID", "/v1/messages/missing"} {
+		timeFakeCh <- timePreRequest
+		timeFakeCh <- timePreRequest.Add(20 * time.Millisecond)
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	out := tsMetricsScrape(t, reg)
+
+	// THEN: they are counted under the route template
+	a.Contains(t, out, `messenger_http_requests_total{route="/v1/messages/{id}",method="GET",code="200"} 2`+"\n")
+	a.Contains(t, out, `messenger_http_requests_total{route="/v1/messages/{id}",method="GET",code="404"} 1`+"\n")
+	a.NotContains(t, out, "MsgAA-ID", "raw path should not be used as label")
+
+	// AND: duration is observed
+	a.Contains(t, out, `messenger_http_request_duration_seconds_bucket{route="/v1/messages/{id}",method="GET",le="0.01"} 0`+"\n")
+	a.Contains(t, out, `messenger_http_request_duration_seconds_bucket{route="/v1/messages/{id}",method="GET",le="0.025"} 3`+"\n")
+	a.Contains(t, out, `messenger_http_request_duration_seconds_count{route="/v1/messages/{id}",method="GET"} 3`+"\n")
+
+	// AND: response size is observed
+	a.Contains(t, out, `messenger_http_response_size_bytes_sum{route="/v1/messages/{id}",method="GET"} 10`+"\n")
+	a.Contains(t, out, `messenger_http_response_size_bytes_bucket{route="/v1/messages/{id}",method="GET",le="100"} 3`+"\n")
+}
+
+func Test_HTTPMiddleware_Metrics_UnknownMethod(t *testing.T) {
+	h := &tmHTTPHandler{hFn: func(w http.ResponseWriter, r *http.Request) {}}
+	reg := NewMetricsRegistry()
+	m := NewMetricsMiddleware(h, reg)
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PROPFIND", "/v1/users", nil))
+
+	a.Contains(t, tsMetricsScrape(t, reg), `messenger_http_requests_total{route="/v1/users",method="other",code="200"} 1`+"\n")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// TracingMiddleware provides HTTP middleware which starts server span for each request.
+// Span context received in W3C traceparent header is used as the parent, so trace continues across services.
+// Handlers down the chain start child spans with StartSpan.
+type TracingMiddleware struct {
+	// Handler is the handler to be wrapped
+	Handler http.Handler
+
+	// Tracer creates spans. Nil tracer disables tracing.
+	Tracer *Tracer
+}
+
+func NewTracingMiddleware(h http.Handler, t *Tracer) *TracingMiddleware {
+	return &TracingMiddleware{
+		Handler: h,
+		Tracer:  t,
+	}
+}
+
+func (m *TracingMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m.Tracer == nil {
+		m.Handler.ServeHTTP(w, r)
+		return
+	}
+
+	ctx := r.Context()
+	if sc, ok := parseTraceparent(r.Header.Get(traceparentHeader)); ok {
+		ctx = ContextWithRemoteSpanContext(ctx, sc)
+	}
+
+	route := routeTemplate(r.URL.Path)
+	ctx, span := m.Tracer.Start(ctx, r.Method+" "+route, SpanKindServer)
+	defer span.End()
+	span.SetAttr("http.method", r.Method)
+	span.SetAttr("http.route", route)
+	span.SetAttr("http.target", r.URL.RequestURI())
+
+	rw := newResponseWriter(w)
+
+	m.Handler.ServeHTTP(rw, r.WithContext(ctx))
+
+	span.SetAttr("http.status_code", rw.Status())
+	if rw.Status() >= http.StatusInternalServerError {
+		span.SetError(fmt.Errorf("%d %s", rw.Status(), http.StatusText(rw.Status())))
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/uber-go/zap"
+	"github.com/uber-go/zap/spy"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+func Test_HTTPMiddleware_Tracing_Chain(t *testing.T) {
+	tr, rec := tsTracerSetup()
+	lgr, sink := spy.New()
+
+	st := NewMemoryStorage()
+	userExp := tfUserA
+	ar.NoError(t, st.UserSave(context.Background(), &userExp))
+
+	h := NewHTTPDefaultHandler(NewTracingStorer(st))
+	m := NewTracingMiddleware(NewLoggingMiddleware(NewCORSMiddleware(h), lgr), tr)
+
+	// WHEN: request continuing remote trace is served
+	req := httptest.NewRequest(http.MethodGet, "/v1/users/"+userExp.ID, nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	m.ServeHTTP(rr, req)
+	a.Equal(t, http.StatusOK, rr.Code)
+
+	ar.NoError(t, tr.Close())
+	spans := rec.Spans()
+
+	// THEN: each layer of the chain has span, nested in order
+	chain := []string{"GET /v1/users/{id}", "LoggingMiddleware", "CORSMiddleware", "usersHandler", "Storer.UserLoad"}
+	ar.Len(t, spans, len(chain), "incorrect spans exported: %v", spans)
+	parent := SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7}
+	for _, name := range chain {
+		s, found := spans[name]
+		if !a.True(t, found, "missing span: %s", name) {
+			continue
+		}
+		a.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", s.SpanContext.TraceID.String(), "[%s] remote trace not continued", name)
+		a.Equal(t, parent, s.ParentSpanID, "[%s] incorrect parent", name)
+		parent = s.SpanContext.SpanID
+	}
+
+	// AND: server span describes the request
+	a.Contains(t, spans[chain[0]].Attrs, SpanAttr{Key: "http.status_code", Value: http.StatusOK})
+	a.Contains(t, spans[chain[0]].Attrs, SpanAttr{Key: "http.route", Value: "/v1/users/{id}"})
+
+	// AND: log entry carries IDs of the trace
+	logs := sink.Logs()
+	ar.Len(t, logs, 1)
+	a.Contains(t, logs[0].Fields, zap.String("trace:id", "4bf92f3577b34da6a3ce929d0e0e4736"))
+	a.Contains(t, logs[0].Fields, zap.String("span:id", spans["LoggingMiddleware"].SpanContext.SpanID.String()))
+}
+
+func Test_HTTPMiddleware_Tracing_Failure(t *testing.T) {
+	tr, rec := tsTracerSetup()
+
+	st := NewTmMemoryStorageMock()
+	st.outUserLoadErr = ErrElementIDNotSet
+	m := NewTracingMiddleware(NewHTTPDefaultHandler(NewTracingStorer(st)), tr)
+
+	rr := httptest.NewRecorder()
+	m.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/users/UserA-ID", nil))
+	a.Equal(t, http.StatusInternalServerError, rr.Code)
+
+	ar.NoError(t, tr.Close())
+	spans := rec.Spans()
+
+	// THEN: new trace is started and failures are recorded
+	a.False(t, spans["GET /v1/users/{id}"].ParentSpanID.IsValid(), "server span should be the root")
+	a.Equal(t, "500 Internal Server Error", spans["GET /v1/users/{id}"].Err)
+	a.Equal(t, ErrElementIDNotSet.Error(), spans["Storer.UserLoad"].Err)
+}
+
+func Test_HTTPMiddleware_Tracing_Disabled(t *testing.T) {
+	lgr, sink := spy.New()
+	m := NewTracingMiddleware(NewLoggingMiddleware(NewHTTPDefaultHandler(NewMemoryStorage()), lgr), nil)
+
+	rr := httptest.NewRecorder()
+	m.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/users/UserA-ID", nil))
+	a.Equal(t, http.StatusNotFound, rr.Code)
+
+	logs := sink.Logs()
+	ar.Len(t, logs, 1)
+	a.Len(t, logs[0].Fields, 8, "trace IDs should not be logged")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// errHijackNotSupported is returned when connection of the wrapped ResponseWriter can not be taken over.
+var errHijackNotSupported = errors.New("http: underlying ResponseWriter does not support hijacking")
+
+// responseWriter wraps http.ResponseWriter and captures status code and number of bytes written, as they are sent.
+// Nothing is buffered, so streamed responses are passed to the client immediately.
+// Optional http.Flusher and http.Hijacker interfaces are passed through to the wrapped writer.
+type responseWriter struct {
+	http.ResponseWriter
+
+	status      int
+	written     int64
+	wroteHeader bool
+}
+
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	return &responseWriter{
+		ResponseWriter: w,
+	}
+}
+
+// WriteHeader sends response header with given status code.
+// Only the first call is recorded, as following ones are ignored by net/http as well.
+func (w *responseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write sends part of response body. Response header with 200 status code is sent first if it was not done before.
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+// Flush sends buffered data to the client. It's no-op if wrapped writer does not support it.
+func (w *responseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection.
+// Status is recorded as 101 (Switching Protocols) as the response is no longer controlled by net/http.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errHijackNotSupported
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && !w.wroteHeader {
+		w.status = http.StatusSwitchingProtocols
+		w.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns wrapped ResponseWriter.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Status returns status code of the response. It's 200 if handler did not send anything.
+func (w *responseWriter) Status() int {
+	if !w.wroteHeader {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Written returns number of bytes of the response body sent so far.
+func (w *responseWriter) Written() int64 {
+	return w.written
+}
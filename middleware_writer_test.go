@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/uber-go/zap"
+	"github.com/uber-go/zap/spy"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+func Test_HTTPResponseWriter_Capture(t *testing.T) {
+	tests := map[string]struct {
+		handlerFn  func(w http.ResponseWriter)
+		statusExp  int
+		writtenExp int64
+	}{
+		"nothing written": {
+			handlerFn:  func(w http.ResponseWriter) {},
+			statusExp:  http.StatusOK,
+			writtenExp: 0,
+		},
+		"implicit status": {
+			handlerFn: func(w http.ResponseWriter) {
+				w.Write([]byte("01234"))
+				w.Write([]byte("56789"))
+			},
+			statusExp:  http.StatusOK,
+			writtenExp: 10,
+		},
+		"explicit status, repeated": {
+			handlerFn: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusNotFound)
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("012"))
+			},
+			statusExp:  http.StatusNotFound,
+			writtenExp: 3,
+		},
+		"flushed": {
+			handlerFn: func(w http.ResponseWriter) {
+				w.(http.Flusher).Flush()
+			},
+			statusExp:  http.StatusOK,
+			writtenExp: 0,
+		},
+	}
+
+	for sym, tc := range tests {
+		rec := httptest.NewRecorder()
+		rw := newResponseWriter(rec)
+
+		tc.handlerFn(rw)
+
+		a.Equal(t, tc.statusExp, rw.Status(), "[%s] mismatch on status", sym)
+		a.Equal(t, tc.writtenExp, rw.Written(), "[%s] mismatch on number of bytes written", sym)
+
+		// AND: everything is passed through
+		a.Equal(t, tc.statusExp, rec.Code, "[%s] status not passed", sym)
+		a.EqualValues(t, tc.writtenExp, rec.Body.Len(), "[%s] body not passed", sym)
+		a.Equal(t, rec, rw.Unwrap(), "[%s] wrapped writer mismatch", sym)
+	}
+}
+
+func Test_HTTPResponseWriter_Flush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := newResponseWriter(rec)
+
+	rw.Write([]byte("0123456789"))
+	rw.Flush()
+
+	a.True(t, rec.Flushed, "flush not passed")
+}
+
+func Test_HTTPResponseWriter_Hijack_NotSupported(t *testing.T) {
+	rw := newResponseWriter(httptest.NewRecorder())
+
+	_, _, err := rw.Hijack()
+	a.Equal(t, errHijackNotSupported, err)
+}
+
+// Test_HTTPMiddleware_Chain_Streaming checks if middlewares chained as in main pass response to the client as it's written.
+func Test_HTTPMiddleware_Chain_Streaming(t *testing.T) {
+	lgr, sink := spy.New()
+	lgr.SetLevel(zap.DebugLevel)
+
+	releaseCh := make(chan struct{})
+	h := &tmHTTPHandler{
+		hFn: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprintln(w, "first")
+			w.(http.Flusher).Flush()
+
+			// rest of the response is held until client gets the first part
+			<-releaseCh
+			fmt.Fprintln(w, "second")
+		},
+	}
+	ts := httptest.NewServer(NewLoggingMiddleware(NewCORSMiddleware(h), lgr))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	defer res.Body.Close()
+
+	// THEN: first part arrives before handler is done
+	a.Equal(t, "*", res.Header.Get("Access-Control-Allow-Origin"), "CORS header missing")
+	br := bufio.NewReader(res.Body)
+	lineCh := make(chan string, 1)
+	go func() {
+		line, _ := br.ReadString('\n')
+		lineCh <- line
+	}()
+	select {
+	case line := <-lineCh:
+		a.Equal(t, "first\n", line, "mismatch on first part")
+	case <-time.After(5 * time.Second):
+		close(releaseCh)
+		t.Fatal("response buffered by middleware")
+	}
+
+	// AND: rest follows once handler is released
+	close(releaseCh)
+	line, err := br.ReadString('\n')
+	ar.NoError(t, err, "unexpected error on second part read")
+	a.Equal(t, "second\n", line, "mismatch on second part")
+	res.Body.Close()
+
+	// AND: whole response is logged
+	for i := 0; i < 100 && len(sink.Logs()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if logs := sink.Logs(); a.Len(t, logs, 1, "incorrect number of logs generated") {
+		a.Contains(t, logs[0].Fields, zap.Int("res:contentLength", len("first\nsecond\n")), "mismatch on logged length")
+	}
+}
+
+// Test_HTTPMiddleware_Chain_Hijack checks if connection can be taken over through middlewares chained as in main.
+func Test_HTTPMiddleware_Chain_Hijack(t *testing.T) {
+	lgr, _ := spy.New()
+
+	h := &tmHTTPHandler{
+		hFn: func(w http.ResponseWriter, r *http.Request) {
+			hj, ok := w.(http.Hijacker)
+			if !ok {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			conn, bufrw, err := hj.Hijack()
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			defer conn.Close()
+			bufrw.WriteString("HTTP/1.1 418 I'm a teapot\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+			bufrw.Flush()
+		},
+	}
+	ts := httptest.NewServer(NewLoggingMiddleware(NewCORSMiddleware(h), lgr))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	ar.NoError(t, err, "unexpected error from HTTP client")
+	res.Body.Close()
+
+	a.Equal(t, http.StatusTeapot, res.StatusCode, "response not written over hijacked connection")
+}
@@ -2,17 +2,46 @@ package main
 
 import "strings"
 
+// Codes of field validation failures.
+// They are machine readable and shall never be changed once released.
+const (
+	FieldErrRequired = "required"
+	FieldErrTooShort = "too_short"
+	FieldErrTooLong  = "too_long"
+	FieldErrInvalid  = "invalid"
+	FieldErrUnknown  = "unknown"
+)
+
+// FieldError describes validation failure of single field.
+type FieldError struct {
+	// Field is a path to the invalid field, nested fields are separated with dot.
+	// It's empty when the value being validated is not a structure (e.g. Tag).
+	Field string
+
+	// Code is a machine readable reason of the failure (one of FieldErr* constants).
+	Code string
+
+	// Msg is a human readable description of the failure.
+	Msg string
+}
+
 // NewValidationError constructs new validation error from different elements (also from other ValidationErrors)
+// Strings and FieldErrors are used as messages, FieldErrors are also kept for detailed reporting.
 func NewValidationError(errs ...interface{}) *ValidationError {
 	e := ValidationError{}
 	for _, eIn := range errs {
 		switch eInCst := eIn.(type) {
 		case string:
 			e.msgs = append(e.msgs, eInCst)
+		case FieldError:
+			e.msgs = append(e.msgs, eInCst.Msg)
+			e.fields = append(e.fields, eInCst)
 		case *ValidationError:
 			e.msgs = append(e.msgs, eInCst.msgs...)
+			e.fields = append(e.fields, eInCst.fields...)
 		case ValidationError:
 			e.msgs = append(e.msgs, eInCst.msgs...)
+			e.fields = append(e.fields, eInCst.fields...)
 		}
 	}
 	return &e
@@ -20,10 +49,34 @@ func NewValidationError(errs ...interface{}) *ValidationError {
 
 // ValidationError is an Error triggered by validation failure
 type ValidationError struct {
-	msgs []string
+	msgs   []string
+	fields []FieldError
 }
 
 // Error returns human readable representation of error
 func (e ValidationError) Error() string {
 	return strings.Join(append([]string{"validation failed"}, e.msgs...), ": ")
 }
+
+// Fields returns failures of individual fields.
+func (e ValidationError) Fields() []FieldError {
+	return e.fields
+}
+
+// InField returns copy of the error with failures moved under the field with given name.
+// It's used when validated value is nested in a structure.
+func (e ValidationError) InField(name string) *ValidationError {
+	out := ValidationError{
+		msgs:   e.msgs,
+		fields: make([]FieldError, 0, len(e.fields)),
+	}
+	for _, f := range e.fields {
+		if f.Field == "" {
+			f.Field = name
+		} else {
+			f.Field = name + "." + f.Field
+		}
+		out.fields = append(out.fields, f)
+	}
+	return &out
+}
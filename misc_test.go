@@ -19,9 +19,33 @@ func Test_ValidationError_Chained_Ref_Multiple(t *testing.T) {
 }
 
 func Test_ValidationError_Chained_Val_Single(t *testing.T) {
-	a.EqualError(t, NewValidationError(ValidationError{[]string{"simpleA"}}), "validation failed: simpleA")
+	a.EqualError(t, NewValidationError(ValidationError{msgs: []string{"simpleA"}}), "validation failed: simpleA")
 }
 
 func Test_ValidationError_Chained_Val_Multiple(t *testing.T) {
-	a.EqualError(t, NewValidationError(ValidationError{[]string{"simpleA"}}, "simpleB"), "validation failed: simpleA: simpleB")
+	a.EqualError(t, NewValidationError(ValidationError{msgs: []string{"simpleA"}}, "simpleB"), "validation failed: simpleA: simpleB")
+}
+
+func Test_ValidationError_Field(t *testing.T) {
+	e := NewValidationError(FieldError{Field: "fieldA", Code: FieldErrRequired, Msg: "simpleA"}, "simpleB")
+
+	a.EqualError(t, e, "validation failed: simpleA: simpleB")
+	a.Equal(t, []FieldError{{Field: "fieldA", Code: FieldErrRequired, Msg: "simpleA"}}, e.Fields())
+}
+
+func Test_ValidationError_InField(t *testing.T) {
+	inner := NewValidationError(
+		FieldError{Code: FieldErrTooShort, Msg: "simpleA"},
+		FieldError{Field: "fieldB", Code: FieldErrRequired, Msg: "simpleB"},
+	)
+	e := NewValidationError("outer", inner.InField("parent"))
+
+	a.EqualError(t, e, "validation failed: outer: simpleA: simpleB")
+	a.Equal(t, []FieldError{
+		{Field: "parent", Code: FieldErrTooShort, Msg: "simpleA"},
+		{Field: "parent.fieldB", Code: FieldErrRequired, Msg: "simpleB"},
+	}, e.Fields())
+
+	// AND: original error is left intact
+	a.Equal(t, "", inner.Fields()[0].Field, "inner error changed")
 }
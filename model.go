@@ -1,8 +1,21 @@
 package main
 
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
 var (
+	// tag length is measured in characters as perceived by the user, see textLen
 	tagLengthMin = 2
 	tagLengthMax = 128
+
+	// msgTagsMax is a maximum number of tags attached to single message.
+	msgTagsMax = 10
+
+	// msgMentionsMax is a maximum number of distinct names mentioned in single message which are looked up.
+	msgMentionsMax = 20
 )
 
 // User represents model for single user using the system.
@@ -13,7 +26,98 @@ type User struct {
 
 	// Name represents the user to the outside world.
 	// It may be changed and shall never be used for anything else then human interaction.
+	// Names are unique up to their keys, see userNameKey.
 	Name string
+
+	// Roles lists roles granted to the user, in order of granting. RoleUser is held implicitly and never listed.
+	Roles []Role
+}
+
+// userNameKey returns key under which user is found by the name.
+// Names differing only by normalisation, case or characters which look alike (see textSkeleton) share the key,
+// as people are not able to tell them apart.
+func userNameKey(name string) string {
+	return textSkeleton(name)
+}
+
+// HasRole reports whether user holds the role. Every user holds RoleUser.
+func (u *User) HasRole(r Role) bool {
+	if r == RoleUser {
+		return true
+	}
+	for _, ur := range u.Roles {
+		if ur == r {
+			return true
+		}
+	}
+	return false
+}
+
+// WithRole returns copy of the user holding the role. Roles of the user are not changed.
+func (u User) WithRole(r Role) User {
+	if u.HasRole(r) {
+		return u
+	}
+	u.Roles = append(append([]Role(nil), u.Roles...), r)
+	return u
+}
+
+// WithoutRole returns copy of the user not holding the role. Roles of the user are not changed.
+func (u User) WithoutRole(r Role) User {
+	var roles []Role
+	for _, ur := range u.Roles {
+		if ur != r {
+			roles = append(roles, ur)
+		}
+	}
+	u.Roles = roles
+	return u
+}
+
+// Role grants user permissions to change content of other users, see rolePermissions.
+type Role string
+
+const (
+	// RoleUser is held by every user. It allows changes of own messages and name only.
+	RoleUser Role = "user"
+
+	// RoleModerator allows changes and removal of messages of other users.
+	RoleModerator Role = "moderator"
+
+	// RoleAdmin allows everything moderator does, changes and removal of users and management of roles.
+	RoleAdmin Role = "admin"
+)
+
+// Validate validates the role and returns error on failure.
+func (r Role) Validate() error {
+	switch r {
+	case RoleUser, RoleModerator, RoleAdmin:
+		return nil
+	}
+	return NewValidationError("unknown role")
+}
+
+// UserDeletePolicy decides what happens to messages of the user being removed.
+type UserDeletePolicy string
+
+const (
+	// UserDeleteReject refuses to remove user who authored any message.
+	UserDeleteReject UserDeletePolicy = "reject"
+
+	// UserDeleteCascade removes user along with all authored messages.
+	UserDeleteCascade UserDeletePolicy = "cascade"
+
+	// UserDeleteAnonymise keeps messages of the removed user with author cleared.
+	UserDeleteAnonymise UserDeletePolicy = "anonymise"
+)
+
+// Validate validates the policy and returns error on failure.
+func (p UserDeletePolicy) Validate() error {
+	switch p {
+	case UserDeleteReject, UserDeleteCascade, UserDeleteAnonymise:
+		return nil
+	}
+	return NewValidationError("unknown user delete policy")
 }
 
 // Message represents model for single message sent by user to the system.
@@ -26,25 +130,205 @@ type Message struct {
 	Body string
 
 	// AuthorID is an ID of the user who authored message.
+	// It's empty once the author is removed with UserDeleteAnonymise policy.
 	AuthorID string
 
-	// Tag is a tag attached to a message
-	Tag Tag
+	// Tags are attached to a message, in order given by the author and without duplicates.
+	// Hashtags found in the body follow the tags given explicitly.
+	Tags []Tag
+
+	// Hashtags lists tags which were attached because they're written as hashtags in the body, see Tags.
+	// They're detached once the body is changed. Tags of messages stored before it was tracked are all given explicitly.
+	Hashtags []Tag
+
+	// Mentions lists IDs of users mentioned in the body, in order of the first mention and without duplicates.
+	Mentions []string
+
+	// CreatedAt is a point in time when message was submitted.
+	// Messages are listed from the newest to the oldest by it.
+	CreatedAt time.Time
+
+	// ModifiedAt is a point in time when message was last changed.
+	// It's equal to CreatedAt for messages which were never edited.
+	ModifiedAt time.Time
+}
+
+// HasTag reports whether tag is attached to the message. Tags are compared by their keys.
+func (m *Message) HasTag(t Tag) bool {
+	for _, mt := range m.Tags {
+		if mt.Is(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalJSON decodes message, including ones encoded before it could have many tags.
+// Single tag of such message is kept in Tag field.
+func (m *Message) UnmarshalJSON(b []byte) error {
+	// message has no methods, so decoding does not recurse
+	type message Message
+	var v struct {
+		message
+		Tag Tag
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*m = Message(v.message)
+	if len(m.Tags) == 0 && v.Tag != "" {
+		m.Tags = []Tag{v.Tag}
+	}
+	return nil
+}
+
+// MsgTagQuery selects messages by their tags.
+// Message matches if it's associated with all of All tags, with at least one of Any tags and with none of Not tags.
+// Empty list does not limit the selection, but All or Any must be given.
+type MsgTagQuery struct {
+	All []Tag
+	Any []Tag
+	Not []Tag
+}
+
+// IsEmpty reports whether query has no All and no Any tags, so it would select everything.
+func (q MsgTagQuery) IsEmpty() bool {
+	return len(q.All) == 0 && len(q.Any) == 0
+}
+
+// Matches reports whether message is selected by the query.
+func (q MsgTagQuery) Matches(m *Message) bool {
+	for _, t := range q.All {
+		if !m.HasTag(t) {
+			return false
+		}
+	}
+	if len(q.Any) > 0 {
+		found := false
+		for _, t := range q.Any {
+			if m.HasTag(t) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, t := range q.Not {
+		if m.HasTag(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// MsgCursor is a position in the list of messages ordered from the newest to the oldest.
+// Messages created at the same time are ordered by ID, descending.
+// Zero value points before the newest message.
+type MsgCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// MsgCursorOf returns cursor pointing at given message.
+func MsgCursorOf(m *Message) MsgCursor {
+	return MsgCursor{CreatedAt: m.CreatedAt, ID: m.ID}
+}
+
+// IsZero reports whether cursor points before the newest message.
+func (c MsgCursor) IsZero() bool {
+	return c.CreatedAt.IsZero() && c.ID == ""
+}
+
+// Before reports whether message at cursor c is listed before the one at cursor o.
+func (c MsgCursor) Before(o MsgCursor) bool {
+	if !c.CreatedAt.Equal(o.CreatedAt) {
+		return c.CreatedAt.After(o.CreatedAt)
+	}
+	return c.ID > o.ID
 }
 
 // Tag represents model for a single Tag attached to a message.
+// Tag keeps the form given by the author, but tags differing only by Unicode normalisation or case are the same tag.
 type Tag string
 
 // Validate validates the tag and returns error on failure.
 func (t Tag) Validate() error {
 	if t == "" {
-		return NewValidationError("empty value")
+		return NewValidationError(FieldError{Code: FieldErrRequired, Msg: "empty value"})
+	}
+	if r, found := textInvalidRune(string(t)); found {
+		return NewValidationError(FieldError{Code: FieldErrInvalid, Msg: fmt.Sprintf("invalid character %U", r)})
 	}
-	if len(t) < tagLengthMin {
-		return NewValidationError("too short")
+	n := textLen(string(t))
+	if n < tagLengthMin {
+		return NewValidationError(FieldError{Code: FieldErrTooShort, Msg: "too short"})
 	}
-	if len(t) > tagLengthMax {
-		return NewValidationError("too long")
+	if n > tagLengthMax {
+		return NewValidationError(FieldError{Code: FieldErrTooLong, Msg: "too long"})
 	}
 	return nil
 }
+
+// Normalize returns the tag in Unicode normalisation form C.
+func (t Tag) Normalize() Tag {
+	return Tag(textNormalize(string(t)))
+}
+
+// Key returns case-folded form of the tag under which messages are indexed and looked up.
+func (t Tag) Key() string {
+	return textKey(string(t))
+}
+
+// Is reports whether both tags are the same tag, regardless of normalisation and case.
+func (t Tag) Is(o Tag) bool {
+	return t == o || t.Key() == o.Key()
+}
+
+// Webhook is a subscription of external service to changes of messages, see WebhookDispatcher.
+type Webhook struct {
+	// ID is a unique, immutable identifier for the webhook.
+	ID string
+
+	// URL receives signed POST request for each change of matching message.
+	URL string
+
+	// Secret is a key of HMAC-SHA256 signature of the requests. It's never disclosed after creation.
+	Secret string
+
+	// Tags limits changes to messages associated with any of them. Empty list matches all tags.
+	Tags []Tag
+
+	// Authors limits changes to messages authored by any of the users, by ID. Empty list matches all authors.
+	Authors []string
+
+	// CreatedAt is a point in time when webhook was registered.
+	CreatedAt time.Time
+}
+
+// Matches reports whether change of the message is delivered to the webhook.
+// Message detached from one of the tags matches as well, so the receiver learns it's gone.
+func (wh *Webhook) Matches(ev MsgEvent) bool {
+	if len(wh.Tags) > 0 {
+		found := false
+		for _, t := range wh.Tags {
+			if ev.Msg.HasTag(t) || ev.HadTag(t) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(wh.Authors) > 0 {
+		for _, id := range wh.Authors {
+			if id == ev.Msg.AuthorID {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
@@ -1,5 +1,7 @@
 package main
 
+import "time"
+
 // -- section: User
 var tfUserA = User{
 	ID:   "UserA-ID",
@@ -16,38 +18,60 @@ var tfUserXA_NoID = User{
 }
 
 // -- section: Message
+
+// tfTimeBase is a creation time of the oldest message fixture.
+var tfTimeBase = time.Date(2016, time.October, 1, 12, 0, 0, 0, time.UTC)
+
 var tfMsgAA = Message{
-	ID:       "UserA_MessageA-ID",
-	Body:     "UserA_MessageA-Body",
-	AuthorID: "UserA-ID",
-	Tag:      Tag("tagA"),
+	ID:         "UserA_MessageA-ID",
+	Body:       "UserA_MessageA-Body",
+	AuthorID:   "UserA-ID",
+	Tags:       []Tag{"tagA"},
+	CreatedAt:  tfTimeBase,
+	ModifiedAt: tfTimeBase,
 }
 
 var tfMsgAB = Message{
-	ID:       "UserA_MessageB-ID",
-	Body:     "UserA_MessageB-Body",
-	AuthorID: "UserA-ID",
-	Tag:      Tag("tagA"),
+	ID:         "UserA_MessageB-ID",
+	Body:       "UserA_MessageB-Body",
+	AuthorID:   "UserA-ID",
+	Tags:       []Tag{"tagA"},
+	CreatedAt:  tfTimeBase.Add(1 * time.Minute),
+	ModifiedAt: tfTimeBase.Add(1 * time.Minute),
 }
 
 var tfMsgBA = Message{
-	ID:       "UserB_MessageA-ID",
-	Body:     "UserB_MessageA-Body",
-	AuthorID: "UserB-ID",
-	Tag:      Tag("tagA"),
+	ID:         "UserB_MessageA-ID",
+	Body:       "UserB_MessageA-Body",
+	AuthorID:   "UserB-ID",
+	Tags:       []Tag{"tagA"},
+	CreatedAt:  tfTimeBase.Add(2 * time.Minute),
+	ModifiedAt: tfTimeBase.Add(2 * time.Minute),
 }
 
 var tfMsgBB = Message{
-	ID:       "UserB_MessageB-ID",
-	Body:     "UserB_MessageB-Body",
-	AuthorID: "UserB-ID",
-	Tag:      Tag("tagB"),
+	ID:         "UserB_MessageB-ID",
+	Body:       "UserB_MessageB-Body",
+	AuthorID:   "UserB-ID",
+	Tags:       []Tag{"tagB"},
+	CreatedAt:  tfTimeBase.Add(3 * time.Minute),
+	ModifiedAt: tfTimeBase.Add(3 * time.Minute),
+}
+
+// tfMsgAC is associated with many tags, the first one is shared with tfMsgBB.
+var tfMsgAC = Message{
+	ID:         "UserA_MessageC-ID",
+	Body:       "UserA_MessageC-Body",
+	AuthorID:   "UserA-ID",
+	Tags:       []Tag{"tagB", "tagC"},
+	CreatedAt:  tfTimeBase.Add(4 * time.Minute),
+	ModifiedAt: tfTimeBase.Add(4 * time.Minute),
 }
 
 var tfMsgAXA_NoID = Message{
 	Body:     "UserA_MessageXA-Body",
 	AuthorID: "UserA-ID",
-	Tag:      Tag("tagB"),
+	Tags:     []Tag{"tagB"},
 }
 
 // -- section: Tag
@@ -56,3 +80,21 @@ var tfTagB = Tag("tagB")
 var tfTagC = Tag("tagC")
 
 var tfTagXA_TooShort = Tag("s")
+
+// -- section: Webhook
+var tfWebhookA = Webhook{
+	ID:        "WebhookA-ID",
+	URL:       "http://hooks.example.com/a",
+	Secret:    "WebhookA-Secret-0123456789",
+	Tags:      []Tag{"tagA"},
+	CreatedAt: tfTimeBase,
+}
+
+var tfWebhookB = Webhook{
+	ID:        "WebhookB-ID",
+	URL:       "https://hooks.example.com/b",
+	Secret:    "WebhookB-Secret-0123456789",
+	Tags:      []Tag{"tagA", "tag,with,commas"},
+	Authors:   []string{"UserB-ID"},
+	CreatedAt: tfTimeBase.Add(time.Minute),
+}
@@ -1,15 +1,30 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 
 	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
 )
 
 // -- section: Tag
 func Test_Model_Tag_Success(t *testing.T) {
 	a.NoError(t, tfTagA.Validate())
+
+	// length is measured in characters, not bytes
+	for sym, tag := range map[string]Tag{
+		"non ASCII":        Tag(strings.Repeat("ż", tagLengthMax)),
+		"emoji":            Tag(strings.Repeat("🚀", tagLengthMax)),
+		"emoji sequence":   "👩\u200d💻👩🏽\u200d💻",
+		"flags":            "🇵🇱🇪🇺",
+		"combining mark":   "e\u0301e",
+		"variation select": "❤\ufe0f🔥",
+	} {
+		a.NoError(t, tag.Validate(), "case: %s", sym)
+	}
 }
 
 func Test_Model_Tag_Failure(t *testing.T) {
@@ -17,8 +32,13 @@ func Test_Model_Tag_Failure(t *testing.T) {
 		tag  Tag
 		eStr string
 	}{
-		"zero":      {Tag(""), "empty value"},
-		"too short": {Tag("a"), "too short"},
+		"zero":                {Tag(""), "empty value"},
+		"too short":           {Tag("a"), "too short"},
+		"single emoji":        {Tag("👩\u200d💻"), "too short"},
+		"too long, non ASCII": {Tag(strings.Repeat("ż", tagLengthMax+1)), "too long"},
+		"control character":   {Tag("tag\nA"), "invalid character U+000A"},
+		"zero width joiner":   {Tag("ta\u200dg"), "invalid character U+200D"},
+		"soft hyphen":         {Tag("ta\u00adg"), "invalid character U+00AD"},
 		"too long": {Tag(func() string {
 			s := ""
 			for i := 0; i < 256; i++ {
@@ -32,3 +52,179 @@ func Test_Model_Tag_Failure(t *testing.T) {
 		a.EqualError(t, tc.tag.Validate(), fmt.Sprintf("validation failed: %s", tc.eStr), "case: %s", s)
 	}
 }
+
+func Test_Model_Tag_Key(t *testing.T) {
+	a.Equal(t, "café-go", Tag("CAFE\u0301-Go").Key(), "case and normalisation")
+	a.True(t, Tag("Straße").Is("STRASSE"), "full case folding")
+	a.True(t, Tag("Cafe\u0301").Is("café"), "normalisation")
+	a.False(t, Tag("café").Is("cafe"), "different tags")
+	a.Equal(t, Tag("Café"), Tag("Cafe\u0301").Normalize(), "normalisation keeps case")
+}
+
+// -- section: User
+func Test_Model_UserNameKey(t *testing.T) {
+	tests := map[string]struct {
+		a, b string
+		same bool
+	}{
+		"case":                 {"PayPal", "paypal", true},
+		"capital I as l":       {"PayPal", "PAYPAI", true},
+		"capital I lowercased": {"Ian", "ian", true},
+		"Greek capital":        {"ΒΗΤΑ", "βητα", true},
+		"Cyrillic look-alike":  {"PayPal", "РауРаl", true},
+		"Greek look-alike":     {"Kappa", "Καppa", true},
+		"digits":               {"Bob01", "BobOl", true},
+		"fullwidth":            {"bob", "ｂｏｂ", true},
+		"decomposed":           {"Zoë", "Zoe\u0308", true},
+		"diacritic":            {"Zoë", "Zoe", false},
+		"different":            {"UserA-Name", "UserB-Name", false},
+	}
+
+	for sym, tc := range tests {
+		a.Equal(t, tc.same, userNameKey(tc.a) == userNameKey(tc.b), "[%s] mismatch", sym)
+	}
+}
+
+// -- section: Message
+func Test_Model_Message_UnmarshalJSON(t *testing.T) {
+	tests := map[string]struct {
+		in  string
+		exp []Tag
+	}{
+		"tags":             {`{"ID":"mID-1","Tags":["tagB","tagC"]}`, []Tag{tfTagB, tfTagC}},
+		"legacy tag":       {`{"ID":"mID-1","Tag":"tagA"}`, []Tag{tfTagA}},
+		"tags over legacy": {`{"ID":"mID-1","Tag":"tagA","Tags":["tagB"]}`, []Tag{tfTagB}},
+		"none":             {`{"ID":"mID-1"}`, nil},
+	}
+
+	for sym, tc := range tests {
+		var m Message
+		ar.NoError(t, json.Unmarshal([]byte(tc.in), &m), "[%s] unexpected error on decode", sym)
+		a.Equal(t, "mID-1", m.ID, "[%s] mismatch on ID", sym)
+		a.Equal(t, tc.exp, m.Tags, "[%s] mismatch on tags", sym)
+	}
+}
+
+// -- section: MsgTagQuery
+func Test_Model_MsgTagQuery_Matches(t *testing.T) {
+	tests := map[string]struct {
+		q   MsgTagQuery
+		exp bool
+	}{
+		"all matched":          {MsgTagQuery{All: []Tag{tfTagC, tfTagB}}, true},
+		"all, one missing":     {MsgTagQuery{All: []Tag{tfTagA, tfTagB}}, false},
+		"any matched":          {MsgTagQuery{Any: []Tag{tfTagA, tfTagC}}, true},
+		"any not matched":      {MsgTagQuery{Any: []Tag{tfTagA}}, false},
+		"not excluded":         {MsgTagQuery{All: []Tag{tfTagB}, Not: []Tag{tfTagC}}, false},
+		"not, other tag":       {MsgTagQuery{All: []Tag{tfTagB}, Not: []Tag{tfTagA}}, true},
+		"all and any matched":  {MsgTagQuery{All: []Tag{tfTagB}, Any: []Tag{tfTagA, tfTagC}}, true},
+		"all matched, any not": {MsgTagQuery{All: []Tag{tfTagB}, Any: []Tag{tfTagA}}, false},
+	}
+
+	for sym, tc := range tests {
+		a.Equal(t, tc.exp, tc.q.Matches(&tfMsgAC), "[%s] mismatch", sym)
+	}
+}
+
+func Test_Model_MsgTagQuery_IsEmpty(t *testing.T) {
+	a.True(t, MsgTagQuery{}.IsEmpty(), "zero query")
+	a.True(t, MsgTagQuery{Not: []Tag{tfTagA}}.IsEmpty(), "exclusions only")
+	a.False(t, MsgTagQuery{Any: []Tag{tfTagA}}.IsEmpty(), "any")
+}
+
+// -- section: UserDeletePolicy
+func Test_Model_UserDeletePolicy_Validate(t *testing.T) {
+	for _, p := range []UserDeletePolicy{UserDeleteReject, UserDeleteCascade, UserDeleteAnonymise} {
+		a.NoError(t, p.Validate(), "case: %s", p)
+	}
+	a.EqualError(t, UserDeletePolicy("").Validate(), "validation failed: unknown user delete policy")
+	a.EqualError(t, UserDeletePolicy("purge").Validate(), "validation failed: unknown user delete policy")
+}
+
+// -- section: Role
+func Test_Model_Role_Validate(t *testing.T) {
+	for _, r := range []Role{RoleUser, RoleModerator, RoleAdmin} {
+		a.NoError(t, r.Validate(), "case: %s", r)
+	}
+	a.Error(t, Role("root").Validate(), "unknown role accepted")
+}
+
+func Test_Model_User_Roles(t *testing.T) {
+	user := tfUserA
+	a.True(t, user.HasRole(RoleUser), "user role is not implicit")
+	a.False(t, user.HasRole(RoleModerator), "role held before granting")
+
+	// WHEN: roles are granted, the second time with no effect
+	granted := user.WithRole(RoleModerator).WithRole(RoleAdmin).WithRole(RoleModerator)
+
+	// THEN:
+	a.Equal(t, []Role{RoleModerator, RoleAdmin}, granted.Roles, "granted roles mismatch")
+	a.True(t, granted.HasRole(RoleAdmin), "granted role not held")
+	a.Empty(t, user.Roles, "roles of the original changed")
+
+	// WHEN: role is revoked
+	revoked := granted.WithoutRole(RoleModerator)
+
+	// THEN:
+	a.Equal(t, []Role{RoleAdmin}, revoked.Roles, "roles mismatch after revoke")
+	a.Equal(t, []Role{RoleModerator, RoleAdmin}, granted.Roles, "roles of the original changed")
+}
+
+// -- section: Webhook
+func Test_Model_Webhook_Matches(t *testing.T) {
+	tests := map[string]struct {
+		wh  Webhook
+		ev  MsgEvent
+		exp bool
+	}{
+		"no filters": {
+			wh:  Webhook{},
+			ev:  MsgEvent{Type: MsgEventCreated, Msg: &tfMsgBB},
+			exp: true,
+		},
+		"tag matched": {
+			wh:  Webhook{Tags: []Tag{tfTagC, tfTagA}},
+			ev:  MsgEvent{Type: MsgEventCreated, Msg: &tfMsgAA},
+			exp: true,
+		},
+		"tag not matched": {
+			wh:  Webhook{Tags: []Tag{tfTagA}},
+			ev:  MsgEvent{Type: MsgEventCreated, Msg: &tfMsgBB},
+			exp: false,
+		},
+		"previous tag matched": {
+			wh:  Webhook{Tags: []Tag{tfTagA}},
+			ev:  MsgEvent{Type: MsgEventUpdated, Msg: &tfMsgBB, PrevTags: []Tag{tfTagA}},
+			exp: true,
+		},
+		"author matched": {
+			wh:  Webhook{Authors: []string{tfUserB.ID}},
+			ev:  MsgEvent{Type: MsgEventCreated, Msg: &tfMsgBB},
+			exp: true,
+		},
+		"author not matched": {
+			wh:  Webhook{Authors: []string{tfUserB.ID}},
+			ev:  MsgEvent{Type: MsgEventCreated, Msg: &tfMsgAA},
+			exp: false,
+		},
+		"anonymised message not matched by author": {
+			wh:  Webhook{Authors: []string{tfUserA.ID}},
+			ev:  MsgEvent{Type: MsgEventCreated, Msg: &Message{ID: "Anonymous-ID", Tags: []Tag{tfTagA}}},
+			exp: false,
+		},
+		"tag and author matched": {
+			wh:  Webhook{Tags: []Tag{tfTagA}, Authors: []string{tfUserB.ID}},
+			ev:  MsgEvent{Type: MsgEventCreated, Msg: &tfMsgBA},
+			exp: true,
+		},
+		"tag matched, author not matched": {
+			wh:  Webhook{Tags: []Tag{tfTagA}, Authors: []string{tfUserB.ID}},
+			ev:  MsgEvent{Type: MsgEventCreated, Msg: &tfMsgAA},
+			exp: false,
+		},
+	}
+
+	for sym, tc := range tests {
+		a.Equal(t, tc.exp, tc.wh.Matches(tc.ev), "[%s] mismatch", sym)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// msgBusBuffer is a number of messages queued for a subscriber before it's considered too slow.
+const msgBusBuffer = 64
+
+var (
+	// ErrSubscriptionLagged is reported by subscription dropped because its subscriber did not keep up with the messages.
+	ErrSubscriptionLagged = errors.New("MsgBus: subscriber lagged behind")
+
+	// ErrMsgBusClosed is reported by subscriptions ended by closing the bus.
+	ErrMsgBusClosed = errors.New("MsgBus: closed")
+)
+
+// MsgEventType tells what happened to the message.
+type MsgEventType string
+
+const (
+	MsgEventCreated MsgEventType = "created"
+	MsgEventUpdated MsgEventType = "updated"
+	MsgEventDeleted MsgEventType = "deleted"
+)
+
+// MsgEvent is a change of the message published on MsgBus.
+type MsgEvent struct {
+	Type MsgEventType
+
+	// Msg is the message after the change. Removed message is given as it was before removal.
+	Msg *Message
+
+	// PrevTags are tags detached from updated message by the change, so subscribers of them learn the message is gone.
+	// It's empty when no tag was detached or previous version is not known.
+	PrevTags []Tag
+}
+
+// HadTag reports whether tag was detached from the message by the change.
+func (ev MsgEvent) HadTag(t Tag) bool {
+	for _, pt := range ev.PrevTags {
+		if pt.Is(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// MsgBus is an in-process publish/subscribe bus delivering changes of messages to subscribers of their tags.
+// Publishing never blocks: subscriber which does not keep up is dropped, so it has to catch up on its own.
+type MsgBus struct {
+	mu     sync.Mutex
+	subs   map[*MsgSubscription]struct{}
+	closed bool
+}
+
+func NewMsgBus() *MsgBus {
+	return &MsgBus{
+		subs: make(map[*MsgSubscription]struct{}),
+	}
+}
+
+// MsgSubscription receives events of messages associated with any of the followed tags, in order of publishing.
+// Channel C is closed when subscription ends, Err tells why.
+type MsgSubscription struct {
+	C <-chan MsgEvent
+
+	c   chan MsgEvent
+	bus *MsgBus
+
+	// all makes subscription receive events of all messages, regardless of followed tags
+	all bool
+
+	// tags and err are guarded by bus.mu, followed tags are kept under their keys
+	tags map[string]Tag
+	err  error
+}
+
+// Subscribe starts subscription of events of messages associated with any of the tags.
+// Subscription of the closed bus ends immediately.
+func (b *MsgBus) Subscribe(tags ...Tag) *MsgSubscription {
+	return b.subscribe(false, tags)
+}
+
+// SubscribeAll starts subscription of events of all messages.
+func (b *MsgBus) SubscribeAll() *MsgSubscription {
+	return b.subscribe(true, nil)
+}
+
+func (b *MsgBus) subscribe(all bool, tags []Tag) *MsgSubscription {
+	c := make(chan MsgEvent, msgBusBuffer)
+	s := &MsgSubscription{
+		C:    c,
+		c:    c,
+		bus:  b,
+		all:  all,
+		tags: make(map[string]Tag, len(tags)),
+	}
+	for _, t := range tags {
+		s.tags[t.Key()] = t
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		s.err = ErrMsgBusClosed
+		close(c)
+		return s
+	}
+	b.subs[s] = struct{}{}
+	return s
+}
+
+// Publish delivers event to subscribers of any tag of its message, or detached one, and to subscribers of all messages.
+// Subscribers with full buffer are dropped.
+// Message is shared between subscribers and shall not be changed once published.
+func (b *MsgBus) Publish(ev MsgEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		if !s.all && !s.follows(ev) {
+			continue
+		}
+		select {
+		case s.c <- ev:
+		default:
+			b.drop(s, ErrSubscriptionLagged)
+		}
+	}
+}
+
+// Close ends all subscriptions. Following subscriptions end immediately and nothing is published anymore.
+func (b *MsgBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for s := range b.subs {
+		b.drop(s, ErrMsgBusClosed)
+	}
+}
+
+// drop ends the subscription with given reason. Caller must hold the lock.
+func (b *MsgBus) drop(s *MsgSubscription, err error) {
+	delete(b.subs, s)
+	s.err = err
+	close(s.c)
+}
+
+// Follow adds tags to the followed ones.
+func (s *MsgSubscription) Follow(tags ...Tag) {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	for _, t := range tags {
+		s.tags[t.Key()] = t
+	}
+}
+
+// Unfollow removes tags from the followed ones. Events already queued are still delivered.
+func (s *MsgSubscription) Unfollow(tags ...Tag) {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	for _, t := range tags {
+		delete(s.tags, t.Key())
+	}
+}
+
+// follows reports whether subscriber follows any tag attached to the message, or detached from it.
+// Must be called with bus lock held.
+func (s *MsgSubscription) follows(ev MsgEvent) bool {
+	for _, t := range ev.Msg.Tags {
+		if _, found := s.tags[t.Key()]; found {
+			return true
+		}
+	}
+	for _, t := range ev.PrevTags {
+		if _, found := s.tags[t.Key()]; found {
+			return true
+		}
+	}
+	return false
+}
+
+// Tags returns followed tags, in alphabetical order.
+func (s *MsgSubscription) Tags() []Tag {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	out := make([]Tag, 0, len(s.tags))
+	for _, t := range s.tags {
+		out = append(out, t)
+	}
+	sort.Sort(tagsByName(out))
+	return out
+}
+
+// tagsByName sorts tags alphabetically.
+type tagsByName []Tag
+
+func (t tagsByName) Len() int           { return len(t) }
+func (t tagsByName) Less(i, j int) bool { return t[i] < t[j] }
+func (t tagsByName) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
+// Close ends the subscription. It's safe to call it more than once.
+func (s *MsgSubscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if _, found := s.bus.subs[s]; found {
+		s.bus.drop(s, nil)
+	}
+}
+
+// Err returns reason for which the subscription ended. It's nil while subscription is active or after Close.
+func (s *MsgSubscription) Err() error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	return s.err
+}
+
+// publishingStorer wraps storage and publishes changes of messages to the bus.
+type publishingStorer struct {
+	Storer
+	bus *MsgBus
+}
+
+// NewPublishingStorer wraps storage so messages saved, updated and deleted successfully are published to the bus.
+// Messages removed or anonymised along with their author are published as well.
+func NewPublishingStorer(st Storer, bus *MsgBus) Storer {
+	return &publishingStorer{Storer: st, bus: bus}
+}
+
+func (s *publishingStorer) MsgSave(ctx context.Context, m *Message) error {
+	if err := s.Storer.MsgSave(ctx, m); err != nil {
+		return err
+	}
+	// caller keeps ownership of m, subscribers get a copy
+	mC := *m
+	s.bus.Publish(MsgEvent{Type: MsgEventCreated, Msg: &mC})
+	return nil
+}
+
+// MsgUpdate loads the message before the update, so subscribers of tags detached from it are notified as well.
+func (s *publishingStorer) MsgUpdate(ctx context.Context, m *Message) error {
+	var prevTags []Tag
+	if prev, err := s.Storer.MsgLoad(ctx, m.ID); err == nil {
+		for _, t := range prev.Tags {
+			if !m.HasTag(t) {
+				prevTags = append(prevTags, t)
+			}
+		}
+	}
+	if err := s.Storer.MsgUpdate(ctx, m); err != nil {
+		return err
+	}
+	mC := *m
+	s.bus.Publish(MsgEvent{Type: MsgEventUpdated, Msg: &mC, PrevTags: prevTags})
+	return nil
+}
+
+// MsgDelete loads the message before removal, as subscribers are picked by its tag.
+// Nothing is published if it could not be loaded.
+func (s *publishingStorer) MsgDelete(ctx context.Context, id string) error {
+	prev, loadErr := s.Storer.MsgLoad(ctx, id)
+	if err := s.Storer.MsgDelete(ctx, id); err != nil {
+		return err
+	}
+	if loadErr == nil {
+		mC := *prev
+		s.bus.Publish(MsgEvent{Type: MsgEventDeleted, Msg: &mC})
+	}
+	return nil
+}
+
+// UserDelete loads messages of the user before removal, so their removal or anonymisation is published.
+// Nothing is published for them if they could not be loaded, e.g. storage is not able to find messages by author.
+func (s *publishingStorer) UserDelete(ctx context.Context, id string, policy UserDeletePolicy) error {
+	var owned []*Message
+	if policy == UserDeleteCascade || policy == UserDeleteAnonymise {
+		if ids, err := s.Storer.MsgsIDsFindByAuthor(ctx, id, MsgCursor{}, 0); err == nil && len(ids) > 0 {
+			owned, _ = s.Storer.MsgLoadMany(ctx, ids)
+		}
+	}
+	if err := s.Storer.UserDelete(ctx, id, policy); err != nil {
+		return err
+	}
+	for _, m := range owned {
+		mC := *m
+		if policy == UserDeleteCascade {
+			s.bus.Publish(MsgEvent{Type: MsgEventDeleted, Msg: &mC})
+			continue
+		}
+		mC.AuthorID = ""
+		s.bus.Publish(MsgEvent{Type: MsgEventUpdated, Msg: &mC})
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+func Test_MsgBus_Publish(t *testing.T) {
+	b := NewMsgBus()
+	subA := b.Subscribe(tfTagA)
+	subAB := b.Subscribe(tfTagA, tfTagB)
+	subC := b.Subscribe(tfTagC)
+
+	evAA := MsgEvent{Type: MsgEventCreated, Msg: &tfMsgAA}
+	evBB := MsgEvent{Type: MsgEventCreated, Msg: &tfMsgBB}
+	// tag changed from tagC to tagB
+	evBBMoved := MsgEvent{Type: MsgEventUpdated, Msg: &tfMsgBB, PrevTags: []Tag{tfTagC}}
+	b.Publish(evAA)
+	b.Publish(evBB)
+	b.Publish(evBBMoved)
+
+	// THEN:
+	a.Equal(t, []MsgEvent{evAA}, tsMsgBusDrain(subA), "subscriber of tagA mismatch")
+	a.Equal(t, []MsgEvent{evAA, evBB, evBBMoved}, tsMsgBusDrain(subAB), "subscriber of tagA and tagB mismatch")
+	a.Equal(t, []MsgEvent{evBBMoved}, tsMsgBusDrain(subC), "subscriber of tagC mismatch")
+}
+
+func Test_MsgBus_Lagged(t *testing.T) {
+	b := NewMsgBus()
+	sub := b.Subscribe(tfTagA)
+	other := b.Subscribe(tfTagA)
+
+	// WHEN: subscriber does not receive anything
+	for i := 0; i <= msgBusBuffer; i++ {
+		b.Publish(MsgEvent{Type: MsgEventCreated, Msg: &tfMsgAA})
+		if i < msgBusBuffer {
+			<-other.C
+		}
+	}
+
+	// THEN: lagging subscriber is dropped after its buffer is filled
+	a.Len(t, tsMsgBusDrain(sub), msgBusBuffer, "queued messages mismatch")
+	a.Equal(t, ErrSubscriptionLagged, sub.Err(), "reason mismatch")
+
+	// AND: others keep receiving
+	a.Len(t, other.C, 1, "other subscriber not served")
+	a.NoError(t, other.Err(), "other subscriber ended")
+}
+
+func Test_MsgBus_Close(t *testing.T) {
+	b := NewMsgBus()
+	sub := b.Subscribe(tfTagA)
+	closed := b.Subscribe(tfTagA)
+	closed.Close()
+	closed.Close()
+	a.NoError(t, closed.Err(), "subscription closed by subscriber has reason")
+
+	b.Close()
+	b.Publish(MsgEvent{Type: MsgEventCreated, Msg: &tfMsgAA})
+
+	// THEN:
+	_, ok := <-sub.C
+	a.False(t, ok, "subscription not ended")
+	a.Equal(t, ErrMsgBusClosed, sub.Err(), "reason mismatch")
+
+	late := b.Subscribe(tfTagA)
+	_, ok = <-late.C
+	a.False(t, ok, "subscription of closed bus not ended")
+	a.Equal(t, ErrMsgBusClosed, late.Err(), "reason mismatch")
+}
+
+func Test_MsgBus_Follow(t *testing.T) {
+	b := NewMsgBus()
+	sub := b.Subscribe()
+	sub.Follow(tfTagB, tfTagA, tfTagC)
+	sub.Unfollow(tfTagC)
+	a.Equal(t, []Tag{tfTagA, tfTagB}, sub.Tags(), "followed tags mismatch")
+
+	evAA := MsgEvent{Type: MsgEventCreated, Msg: &tfMsgAA}
+	b.Publish(evAA)
+	sub.Unfollow(tfTagA)
+	b.Publish(evAA)
+
+	// THEN:
+	a.Equal(t, []MsgEvent{evAA}, tsMsgBusDrain(sub), "events mismatch")
+}
+
+func Test_MsgBus_Follow_ByKey(t *testing.T) {
+	b := NewMsgBus()
+	sub := b.Subscribe("TAGA")
+	sub.Follow("Taga")
+	a.Len(t, sub.Tags(), 1, "same tag followed twice")
+
+	// WHEN: message is tagged with different case of the followed tag
+	evAA := MsgEvent{Type: MsgEventCreated, Msg: &tfMsgAA}
+	b.Publish(evAA)
+	sub.Unfollow("taga")
+	b.Publish(evAA)
+
+	// THEN
+	a.Equal(t, []MsgEvent{evAA}, tsMsgBusDrain(sub), "events mismatch")
+}
+
+func Test_MsgBus_Publish_ManyTags(t *testing.T) {
+	b := NewMsgBus()
+	subBC := b.Subscribe(tfTagB, tfTagC)
+	subC := b.Subscribe(tfTagC)
+	subA := b.Subscribe(tfTagA)
+
+	evAC := MsgEvent{Type: MsgEventCreated, Msg: &tfMsgAC}
+	b.Publish(evAC)
+
+	// THEN: message is delivered once to followers of any of its tags
+	a.Equal(t, []MsgEvent{evAC}, tsMsgBusDrain(subBC), "events mismatch, many tags followed")
+	a.Equal(t, []MsgEvent{evAC}, tsMsgBusDrain(subC), "events mismatch, second tag followed")
+	a.Empty(t, tsMsgBusDrain(subA), "event delivered to follower of other tag")
+}
+
+func Test_MsgBus_SubscribeAll(t *testing.T) {
+	b := NewMsgBus()
+	sub := b.SubscribeAll()
+
+	evAA := MsgEvent{Type: MsgEventCreated, Msg: &tfMsgAA}
+	evBB := MsgEvent{Type: MsgEventDeleted, Msg: &tfMsgBB}
+	b.Publish(evAA)
+	b.Publish(evBB)
+
+	// THEN:
+	a.Equal(t, []MsgEvent{evAA, evBB}, tsMsgBusDrain(sub), "events mismatch")
+}
+
+func Test_PublishingStorer_MsgSave(t *testing.T) {
+	b := NewMsgBus()
+	sub := b.Subscribe(tfTagA)
+	st := NewTmMemoryStorageMock()
+	pst := NewPublishingStorer(st, b)
+
+	msg := tfMsgAA
+	ar.NoError(t, pst.MsgSave(context.Background(), &msg))
+	msg.Body = "changed after save"
+
+	st.outMsgSaveErr = errors.New("some kind of DB error")
+	failed := tfMsgAB
+	a.Error(t, pst.MsgSave(context.Background(), &failed), "error not passed through")
+
+	// THEN: only saved message is published, as it was saved
+	a.Equal(t, []MsgEvent{{Type: MsgEventCreated, Msg: &tfMsgAA}}, tsMsgBusDrain(sub), "published events mismatch")
+}
+
+func Test_PublishingStorer_MsgUpdate_MsgDelete(t *testing.T) {
+	b := NewMsgBus()
+	sub := b.Subscribe(tfTagA)
+	st := NewMemoryStorage()
+	pst := NewPublishingStorer(st, b)
+	msg := tfMsgAA
+	ar.NoError(t, st.MsgSave(context.Background(), &msg))
+
+	// WHEN: message is moved to other tag and removed
+	moved := tfMsgAA
+	moved.Tags = []Tag{tfTagB}
+	ar.NoError(t, pst.MsgUpdate(context.Background(), &moved))
+	ar.NoError(t, pst.MsgDelete(context.Background(), moved.ID))
+	a.Equal(t, ErrElementNotFound, pst.MsgDelete(context.Background(), moved.ID), "error not passed through")
+
+	// THEN: subscriber of the previous tag learns about the move only
+	a.Equal(t, []MsgEvent{{Type: MsgEventUpdated, Msg: &moved, PrevTags: []Tag{tfTagA}}}, tsMsgBusDrain(sub), "published events mismatch")
+}
+
+func Test_PublishingStorer_MsgUpdate_TagsKept(t *testing.T) {
+	b := NewMsgBus()
+	sub := b.Subscribe(tfTagB)
+	st := NewMemoryStorage()
+	pst := NewPublishingStorer(st, b)
+	msg := tfMsgAC
+	ar.NoError(t, st.MsgSave(context.Background(), &msg))
+
+	// WHEN: one of the tags is replaced
+	changed := tfMsgAC
+	changed.Tags = []Tag{tfTagB, tfTagA}
+	ar.NoError(t, pst.MsgUpdate(context.Background(), &changed))
+
+	// THEN: only detached tag is reported as previous one
+	a.Equal(t, []MsgEvent{{Type: MsgEventUpdated, Msg: &changed, PrevTags: []Tag{tfTagC}}}, tsMsgBusDrain(sub), "published events mismatch")
+}
+
+// tsMsgBusDrain receives all events queued for subscriber and ends the subscription.
+func tsMsgBusDrain(s *MsgSubscription) []MsgEvent {
+	s.Close()
+	var out []MsgEvent
+	for m := range s.C {
+		out = append(out, m)
+	}
+	return out
+}
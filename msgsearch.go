@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// msgSearchTermsMax is a maximum number of words, prefixes, phrases and tags in single query.
+	msgSearchTermsMax = 10
+
+	// msgSearchPrefixMin is a minimum number of characters of the prefix, shorter ones would match most of the words.
+	msgSearchPrefixMin = 2
+
+	// msgSearchSnippetWords is a number of words of the snippet, longer bodies are cut around the matching words.
+	msgSearchSnippetWords = 30
+
+	// msgSearchEllipsis marks the body cut by the snippet.
+	msgSearchEllipsis = "…"
+)
+
+// msgSearchToken is a single word of the text.
+type msgSearchToken struct {
+	// Key is case-folded, normalised form of the word (see textKey) under which it's indexed and matched.
+	Key string
+
+	// Start and End are byte offsets of the word in the text.
+	Start, End int
+}
+
+// msgSearchTokens splits text into words, i.e. runs of letters, combining marks and digits.
+// Everything else, including punctuation, # of hashtags and @ of mentions, separates words.
+func msgSearchTokens(s string) []msgSearchToken {
+	var out []msgSearchToken
+	start := -1
+	for i, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.M, r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			out = append(out, msgSearchToken{Key: textKey(s[start:i]), Start: start, End: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		out = append(out, msgSearchToken{Key: textKey(s[start:]), Start: start, End: len(s)})
+	}
+	return out
+}
+
+// MsgSearchTerm is a condition on words of the message body.
+// Single word matches the word and more of them make a phrase matching words following each other.
+type MsgSearchTerm struct {
+	// Words are keys of the words, see msgSearchTokens.
+	Words []string
+
+	// Prefix makes the last word match any word starting with it.
+	Prefix bool
+}
+
+// MatchesAt reports whether term matches the words starting at i-th one.
+func (t MsgSearchTerm) MatchesAt(toks []msgSearchToken, i int) bool {
+	if i+len(t.Words) > len(toks) {
+		return false
+	}
+	for j, w := range t.Words {
+		key := toks[i+j].Key
+		if key != w && !(t.Prefix && j == len(t.Words)-1 && strings.HasPrefix(key, w)) {
+			return false
+		}
+	}
+	return true
+}
+
+// MsgSearchQuery selects messages by words of their bodies, narrowed by tags, author and time of creation.
+// Message matches if it contains all of Terms and passes all of the filters. Zero values do not limit the selection.
+type MsgSearchQuery struct {
+	Terms []MsgSearchTerm
+
+	// Tags must be all associated with the message.
+	Tags []Tag
+
+	// AuthorID is an ID of the author of the message.
+	AuthorID string
+
+	// After and Before limit the time of creation of the message. After is inclusive, Before is not.
+	After  time.Time
+	Before time.Time
+}
+
+// IsEmpty reports whether query has neither terms nor filters, so it would select everything.
+func (q MsgSearchQuery) IsEmpty() bool {
+	return len(q.Terms) == 0 && len(q.Tags) == 0 && q.AuthorID == "" && q.After.IsZero() && q.Before.IsZero()
+}
+
+// MatchesFilters reports whether message passes filters of the query. Terms are left for the full-text index.
+func (q MsgSearchQuery) MatchesFilters(m *Message) bool {
+	for _, t := range q.Tags {
+		if !m.HasTag(t) {
+			return false
+		}
+	}
+	if q.AuthorID != "" && m.AuthorID != q.AuthorID {
+		return false
+	}
+	if !q.After.IsZero() && m.CreatedAt.Before(q.After) {
+		return false
+	}
+	if !q.Before.IsZero() && !m.CreatedAt.Before(q.Before) {
+		return false
+	}
+	return true
+}
+
+// MsgSearchHit is a message found with MsgSearchQuery.
+type MsgSearchHit struct {
+	ID string
+
+	// Score tells how relevant message is to the terms of the query, the higher the better.
+	// It's zero for query without terms.
+	Score float64
+}
+
+// msgSearchParse builds query from the text typed by the user.
+// Words are separated with spaces, "double quotes" make a phrase and * at the end of a word or phrase makes a prefix.
+// Filters tag:, author:, after: and before: take values up to the next space, dates are given as 2006-01-02 or
+// in RFC 3339 format. Name given in author: is returned for the caller to resolve it into AuthorID.
+// ValidationError is returned if query selects everything, has too many terms or invalid filter.
+func msgSearchParse(s string) (MsgSearchQuery, string, error) {
+	var q MsgSearchQuery
+	var author string
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		var part string
+		quoted := s[0] == '"'
+		if quoted {
+			// phrase not closed takes the rest of the query
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				part, s = s[1:], ""
+			} else {
+				part, s = s[1:end+1], s[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(s, unicode.IsSpace)
+			if end < 0 {
+				end = len(s)
+			}
+			part, s = s[:end], s[end:]
+		}
+
+		if !quoted {
+			name, value, isFilter := msgSearchFilterSplit(part)
+			if isFilter {
+				var err error
+				switch name {
+				case "tag":
+					t := Tag(value).Normalize()
+					if err := t.Validate(); err != nil {
+						return q, "", NewValidationError(FieldError{Field: "q", Code: FieldErrInvalid, Msg: fmt.Sprintf("tag %q: %s", value, err.(*ValidationError).Fields()[0].Msg)})
+					}
+					q.Tags = append(q.Tags, t)
+				case "author":
+					if author != "" && textKey(author) != textKey(value) {
+						return q, "", NewValidationError(FieldError{Field: "q", Code: FieldErrInvalid, Msg: "only one author is allowed"})
+					}
+					author = value
+				case "after":
+					q.After, err = msgSearchDateParse(value, false)
+				case "before":
+					q.Before, err = msgSearchDateParse(value, true)
+				}
+				if err != nil {
+					return q, "", NewValidationError(FieldError{Field: "q", Code: FieldErrInvalid, Msg: fmt.Sprintf("%s: %q is not a date", name, value)})
+				}
+				continue
+			}
+		}
+
+		prefix := strings.HasSuffix(part, "*")
+		toks := msgSearchTokens(strings.TrimSuffix(part, "*"))
+		if len(toks) == 0 {
+			continue
+		}
+		t := MsgSearchTerm{Prefix: prefix}
+		for _, tok := range toks {
+			t.Words = append(t.Words, tok.Key)
+		}
+		if prefix && textLen(t.Words[len(t.Words)-1]) < msgSearchPrefixMin {
+			return q, "", NewValidationError(FieldError{Field: "q", Code: FieldErrInvalid, Msg: fmt.Sprintf("prefix %q is too short, at least %d characters are required", part, msgSearchPrefixMin)})
+		}
+		q.Terms = append(q.Terms, t)
+	}
+
+	if q.IsEmpty() && author == "" {
+		return q, "", NewValidationError(FieldError{Field: "q", Code: FieldErrRequired, Msg: "query has neither words nor filters"})
+	}
+	if len(q.Terms)+len(q.Tags) > msgSearchTermsMax {
+		return q, "", NewValidationError(FieldError{Field: "q", Code: FieldErrInvalid, Msg: fmt.Sprintf("too many terms, up to %d words, phrases and tags are allowed", msgSearchTermsMax)})
+	}
+	return q, author, nil
+}
+
+// msgSearchFilterSplit splits filter into its name and value. Words which are not filters are reported as such.
+func msgSearchFilterSplit(part string) (string, string, bool) {
+	i := strings.IndexByte(part, ':')
+	if i < 0 || i == len(part)-1 {
+		return "", "", false
+	}
+	name := strings.ToLower(part[:i])
+	switch name {
+	case "tag", "author", "after", "before":
+		return name, part[i+1:], true
+	}
+	return "", "", false
+}
+
+// msgSearchDateParse parses date given as 2006-01-02 (UTC) or in RFC 3339 format.
+// Day given as upper bound is included, so the end of the day is returned.
+func msgSearchDateParse(v string, upper bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// msgSearchRange is a range of characters (Unicode code points) of the text.
+type msgSearchRange struct {
+	Start, End int
+}
+
+// msgSearchSnippet returns fragment of the body with the most words matching the terms, along with ranges of the words.
+// Body which is not longer than msgSearchSnippetWords words is returned as a whole.
+func msgSearchSnippet(body string, terms []MsgSearchTerm) (string, []msgSearchRange) {
+	toks := msgSearchTokens(body)
+	matched := make([]bool, len(toks))
+	for i := range toks {
+		for _, t := range terms {
+			if t.MatchesAt(toks, i) {
+				for j := range t.Words {
+					matched[i+j] = true
+				}
+			}
+		}
+	}
+
+	// window of words with the most of matches, the earliest one wins
+	from, to := 0, len(toks)
+	if len(toks) > msgSearchSnippetWords {
+		best, n := 0, 0
+		for i := 0; i < len(toks); i++ {
+			if matched[i] {
+				n++
+			}
+			if i >= msgSearchSnippetWords && matched[i-msgSearchSnippetWords] {
+				n--
+			}
+			if i >= msgSearchSnippetWords-1 && n > best {
+				best, from = n, i-msgSearchSnippetWords+1
+			}
+		}
+		to = from + msgSearchSnippetWords
+	}
+
+	start, end := 0, len(body)
+	prefix, suffix := "", ""
+	if from > 0 {
+		start, prefix = toks[from].Start, msgSearchEllipsis
+	}
+	if to < len(toks) {
+		end, suffix = toks[to-1].End, msgSearchEllipsis
+	}
+
+	var ranges []msgSearchRange
+	base := utf8.RuneCountInString(prefix)
+	for i := from; i < to; i++ {
+		if !matched[i] {
+			continue
+		}
+		r := msgSearchRange{
+			Start: base + utf8.RuneCountInString(body[start:toks[i].Start]),
+			End:   base + utf8.RuneCountInString(body[start:toks[i].End]),
+		}
+		ranges = append(ranges, r)
+	}
+	return prefix + body[start:end] + suffix, ranges
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+// -- section: tokens
+func Test_MsgSearch_Tokens(t *testing.T) {
+	tests := map[string]struct {
+		text string
+		exp  []msgSearchToken
+	}{
+		"empty":       {"", nil},
+		"punctuation": {" ,.!? ", nil},
+		"words": {"Deploy, done!", []msgSearchToken{
+			{Key: "deploy", Start: 0, End: 6},
+			{Key: "done", Start: 8, End: 12},
+		}},
+		"hashtag and mention": {"#ops @UserB-Name", []msgSearchToken{
+			{Key: "ops", Start: 1, End: 4},
+			{Key: "userb", Start: 6, End: 11},
+			{Key: "name", Start: 12, End: 16},
+		}},
+		"digits": {"v2 at 10:30", []msgSearchToken{
+			{Key: "v2", Start: 0, End: 2},
+			{Key: "at", Start: 3, End: 5},
+			{Key: "10", Start: 6, End: 8},
+			{Key: "30", Start: 9, End: 11},
+		}},
+		"decomposed": {"Cafe\u0301 straße", []msgSearchToken{
+			{Key: "café", Start: 0, End: 6},
+			{Key: "strasse", Start: 7, End: 14},
+		}},
+	}
+
+	for sym, tc := range tests {
+		a.Equal(t, tc.exp, msgSearchTokens(tc.text), "[%s] mismatch", sym)
+	}
+}
+
+// -- section: query
+func Test_MsgSearch_Parse_Success(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2016, time.May, d, 0, 0, 0, 0, time.UTC) }
+	tests := map[string]struct {
+		q         string
+		exp       MsgSearchQuery
+		expAuthor string
+	}{
+		"words": {
+			q:   "Deploy  done",
+			exp: MsgSearchQuery{Terms: []MsgSearchTerm{{Words: []string{"deploy"}}, {Words: []string{"done"}}}},
+		},
+		"phrase": {
+			q:   `"deploy is Done" prod`,
+			exp: MsgSearchQuery{Terms: []MsgSearchTerm{{Words: []string{"deploy", "is", "done"}}, {Words: []string{"prod"}}}},
+		},
+		"phrase not closed": {
+			q:   `prod "deploy is`,
+			exp: MsgSearchQuery{Terms: []MsgSearchTerm{{Words: []string{"prod"}}, {Words: []string{"deploy", "is"}}}},
+		},
+		"word with punctuation is a phrase": {
+			q:   "e-mail",
+			exp: MsgSearchQuery{Terms: []MsgSearchTerm{{Words: []string{"e", "mail"}}}},
+		},
+		"prefix": {
+			q:   `depl* "deploy is do*"`,
+			exp: MsgSearchQuery{Terms: []MsgSearchTerm{{Words: []string{"depl"}, Prefix: true}, {Words: []string{"deploy", "is", "do"}, Prefix: true}}},
+		},
+		"punctuation only skipped": {
+			q:   "deploy - !",
+			exp: MsgSearchQuery{Terms: []MsgSearchTerm{{Words: []string{"deploy"}}}},
+		},
+		"filters": {
+			q: "deploy tag:ops TAG:Café author:UserA-Name after:2016-05-01 before:2016-05-31",
+			exp: MsgSearchQuery{
+				Terms:  []MsgSearchTerm{{Words: []string{"deploy"}}},
+				Tags:   []Tag{"ops", "Café"},
+				After:  day(1),
+				Before: day(32),
+			},
+			expAuthor: "UserA-Name",
+		},
+		"filters only": {
+			q:         "author:UserA-Name",
+			expAuthor: "UserA-Name",
+		},
+		"author repeated": {
+			q:         "author:UserA-Name author:usera-name",
+			expAuthor: "usera-name",
+		},
+		"date with time": {
+			q:   "after:2016-05-01T10:00:00+02:00",
+			exp: MsgSearchQuery{After: time.Date(2016, time.May, 1, 8, 0, 0, 0, time.UTC)},
+		},
+		"filter without value is a word": {
+			q:   "tag:",
+			exp: MsgSearchQuery{Terms: []MsgSearchTerm{{Words: []string{"tag"}}}},
+		},
+		"unknown filter is a phrase": {
+			q:   "see:docs",
+			exp: MsgSearchQuery{Terms: []MsgSearchTerm{{Words: []string{"see", "docs"}}}},
+		},
+		"quoted filter is a phrase": {
+			q:   `"tag:ops"`,
+			exp: MsgSearchQuery{Terms: []MsgSearchTerm{{Words: []string{"tag", "ops"}}}},
+		},
+	}
+
+	for sym, tc := range tests {
+		q, author, err := msgSearchParse(tc.q)
+		if !a.NoError(t, err, "[%s] unexpected error", sym) {
+			continue
+		}
+		a.Equal(t, tc.exp.Terms, q.Terms, "[%s] mismatch on terms", sym)
+		a.Equal(t, tc.exp.Tags, q.Tags, "[%s] mismatch on tags", sym)
+		a.True(t, tc.exp.After.Equal(q.After), "[%s] mismatch on after: %s", sym, q.After)
+		a.True(t, tc.exp.Before.Equal(q.Before), "[%s] mismatch on before: %s", sym, q.Before)
+		a.Equal(t, tc.expAuthor, author, "[%s] mismatch on author", sym)
+	}
+}
+
+func Test_MsgSearch_Parse_Failure(t *testing.T) {
+	tests := map[string]struct {
+		q       string
+		expCode string
+	}{
+		"empty":            {"", FieldErrRequired},
+		"blank":            {"  ", FieldErrRequired},
+		"no words":         {`- "" !`, FieldErrRequired},
+		"prefix too short": {"d*", FieldErrInvalid},
+		"tag invalid":      {"tag:a", FieldErrInvalid},
+		"author twice":     {"author:UserA-Name author:UserB-Name", FieldErrInvalid},
+		"date invalid":     {"after:yesterday", FieldErrInvalid},
+		"too many terms":   {"a b c d e f g h i j k", FieldErrInvalid},
+		"too many tags":    {"a b c d e f g h i tag:ops tag:dev", FieldErrInvalid},
+	}
+
+	for sym, tc := range tests {
+		_, _, err := msgSearchParse(tc.q)
+		if !a.IsType(t, &ValidationError{}, err, "[%s] mismatch on error", sym) {
+			continue
+		}
+		fields := err.(*ValidationError).Fields()
+		if a.Len(t, fields, 1, "[%s] mismatch on fields", sym) {
+			a.Equal(t, "q", fields[0].Field, "[%s] mismatch on field", sym)
+			a.Equal(t, tc.expCode, fields[0].Code, "[%s] mismatch on code", sym)
+		}
+	}
+}
+
+func Test_MsgSearch_Query_MatchesFilters(t *testing.T) {
+	m := tfMsgAC
+	tests := map[string]struct {
+		q   MsgSearchQuery
+		exp bool
+	}{
+		"no filters":       {MsgSearchQuery{Terms: []MsgSearchTerm{{Words: []string{"other"}}}}, true},
+		"tags":             {MsgSearchQuery{Tags: []Tag{"TAGB", tfTagC}}, true},
+		"tag missing":      {MsgSearchQuery{Tags: []Tag{tfTagA, "tagX"}}, false},
+		"author":           {MsgSearchQuery{AuthorID: tfUserA.ID}, true},
+		"author other":     {MsgSearchQuery{AuthorID: tfUserB.ID}, false},
+		"after inclusive":  {MsgSearchQuery{After: m.CreatedAt}, true},
+		"after":            {MsgSearchQuery{After: m.CreatedAt.Add(time.Nanosecond)}, false},
+		"before exclusive": {MsgSearchQuery{Before: m.CreatedAt}, false},
+		"before":           {MsgSearchQuery{Before: m.CreatedAt.Add(time.Nanosecond)}, true},
+	}
+
+	for sym, tc := range tests {
+		a.Equal(t, tc.exp, tc.q.MatchesFilters(&m), "[%s] mismatch", sym)
+	}
+}
+
+// -- section: snippet
+func Test_MsgSearch_Snippet(t *testing.T) {
+	long := "one two three four five six seven eight nine ten eleven twelve thirteen fourteen fifteen " +
+		"sixteen seventeen eighteen nineteen twenty deploy done twenty-three twenty-four twenty-five " +
+		"twenty-six twenty-seven twenty-eight twenty-nine thirty thirty-one thirty-two deploy"
+	tests := map[string]struct {
+		body          string
+		q             string
+		expText       string
+		expHighlights []msgSearchRange
+	}{
+		"whole body": {
+			body:          "Deploy is done, deploying next",
+			q:             "deploy",
+			expText:       "Deploy is done, deploying next",
+			expHighlights: []msgSearchRange{{0, 6}},
+		},
+		"prefix and phrase": {
+			body:          "Deploy is done, deploying next",
+			q:             `"is done" depl*`,
+			expText:       "Deploy is done, deploying next",
+			expHighlights: []msgSearchRange{{0, 6}, {7, 9}, {10, 14}, {16, 25}},
+		},
+		"phrase only as a whole": {
+			body:          "done is done",
+			q:             `"is done"`,
+			expText:       "done is done",
+			expHighlights: []msgSearchRange{{5, 7}, {8, 12}},
+		},
+		"characters": {
+			body:          "Zażółć gęślą jaźń",
+			q:             "jaźń",
+			expText:       "Zażółć gęślą jaźń",
+			expHighlights: []msgSearchRange{{13, 17}},
+		},
+		"no terms": {
+			body:    "Deploy is done",
+			q:       "tag:ops",
+			expText: "Deploy is done",
+		},
+		"cut around matches": {
+			body:          long,
+			q:             "deploy done",
+			expText:       "…thirteen fourteen fifteen sixteen seventeen eighteen nineteen twenty deploy done twenty-three twenty-four twenty-five twenty-six twenty-seven twenty-eight twenty-nine thirty thirty-one thirty-two deploy",
+			expHighlights: []msgSearchRange{{70, 76}, {77, 81}, {197, 203}},
+		},
+		"cut at the end": {
+			body:    long,
+			q:       "two",
+			expText: "one two three four five six seven eight nine ten eleven twelve thirteen fourteen fifteen sixteen seventeen eighteen nineteen twenty deploy done twenty-three twenty-four twenty-five twenty-six…",
+			// the other "two" of thirty-two is not in the snippet
+			expHighlights: []msgSearchRange{{4, 7}},
+		},
+	}
+
+	for sym, tc := range tests {
+		q, _, err := msgSearchParse(tc.q)
+		ar.NoError(t, err, "[%s] unexpected error", sym)
+
+		text, highlights := msgSearchSnippet(tc.body, q.Terms)
+		a.Equal(t, tc.expText, text, "[%s] mismatch on text", sym)
+		a.Equal(t, tc.expHighlights, highlights, "[%s] mismatch on highlights", sym)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import "regexp"
+
+var (
+	// rMsgHashtag matches hashtag: # at the beginning of a word followed by letters (with combining marks), digits, _ and -.
+	// # preceded by /, & or another # (e.g. URL anchors, HTML entities, ##) does not start a hashtag.
+	rMsgHashtag = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_/&#])#([\p{L}\p{M}\p{N}_\-]*[\p{L}\p{M}\p{N}_])`)
+
+	// rMsgMention matches mention: @ at the beginning of a word followed by name made of letters, digits, _, - and dots.
+	// Name ends with letter, digit or _, so trailing punctuation is not its part. E-mail addresses are not mentions.
+	rMsgMention = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_.@])@([\p{L}\p{M}\p{N}_\-.]*[\p{L}\p{M}\p{N}_])`)
+)
+
+// msgHashtags returns tags written as hashtags in the body, in order of appearance and without duplicates.
+// Hashtags which are not valid tags, e.g. too short, are skipped.
+func msgHashtags(body string) []Tag {
+	var out []Tag
+	for _, m := range rMsgHashtag.FindAllStringSubmatch(body, -1) {
+		if t := Tag(m[1]); t.Validate() == nil {
+			out = append(out, t)
+		}
+	}
+	return msgTagsMerge("", out)
+}
+
+// msgMentionedNames returns names mentioned in the body, in order of appearance and without duplicates.
+// Up to msgMentionsMax names are returned, the rest is skipped.
+func msgMentionedNames(body string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, m := range rMsgMention.FindAllStringSubmatch(body, -1) {
+		if seen[m[1]] {
+			continue
+		}
+		if len(out) == msgMentionsMax {
+			break
+		}
+		seen[m[1]] = true
+		out = append(out, m[1])
+	}
+	return out
+}
+
+// msgTagsWithHashtags returns tags followed by hashtags of the body which are not among them yet,
+// along with the hashtags attached. Hashtags are attached while there is room for them, up to msgTagsMax tags in total.
+func msgTagsWithHashtags(tags []Tag, body string) ([]Tag, []Tag) {
+	out := msgTagsMerge("", tags)
+	var attached []Tag
+	for _, t := range msgHashtags(body) {
+		if len(out) >= msgTagsMax {
+			break
+		}
+		if !tagsContain(out, t) {
+			out = append(out, t)
+			attached = append(attached, t)
+		}
+	}
+	return out, attached
+}
+
+// msgTagsWithoutHashtags returns tags which were not attached as hashtags, i.e. the ones given explicitly.
+// It's used to detach hashtags of the previous body when body of the message is changed.
+func msgTagsWithoutHashtags(tags, hashtags []Tag) []Tag {
+	var out []Tag
+	for _, t := range tags {
+		if !tagsContain(hashtags, t) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// tagsContain reports whether tag is on the list, regardless of normalisation and case.
+func tagsContain(tags []Tag, t Tag) bool {
+	for _, tt := range tags {
+		if tt.Is(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	a "github.com/stretchr/testify/assert"
+)
+
+// -- section: hashtags
+func Test_MsgText_Hashtags(t *testing.T) {
+	tests := map[string]struct {
+		body string
+		exp  []Tag
+	}{
+		"none":                 {"no tags here", nil},
+		"single":               {"#deploy", []Tag{"deploy"}},
+		"in order":             {"done #deploy on #prod-eu", []Tag{"deploy", "prod-eu"}},
+		"duplicates":           {"#go #perf #go", []Tag{"go", "perf"}},
+		"trailing punctuation": {"shipped #deploy. (#prod-) #ok!", []Tag{"deploy", "prod", "ok"}},
+		"unicode":              {"#zażółć #日本", []Tag{"zażółć", "日本"}},
+		"decomposed":           {"#Cafe\u0301 #café", []Tag{"Café"}},
+		"too short skipped":    {"#a #ab", []Tag{"ab"}},
+		"inside word":          {"C#sharp a#b", nil},
+		"URL anchor":           {"see http://example.com/page#section", nil},
+		"HTML entity":          {"&#123; entity", nil},
+		"double hash":          {"##heading", nil},
+		"hash alone":           {"# # #-", nil},
+	}
+
+	for sym, tc := range tests {
+		a.Equal(t, tc.exp, msgHashtags(tc.body), "[%s] mismatch", sym)
+	}
+}
+
+func Test_MsgText_TagsWithHashtags(t *testing.T) {
+	tests := map[string]struct {
+		tags        []Tag
+		body        string
+		exp         []Tag
+		expHashtags []Tag
+	}{
+		"no hashtags":    {[]Tag{tfTagA}, "body", []Tag{tfTagA}, nil},
+		"after tags":     {[]Tag{tfTagA}, "#tagC and #tagB", []Tag{tfTagA, tfTagC, tfTagB}, []Tag{tfTagC, tfTagB}},
+		"already tagged": {[]Tag{tfTagA, tfTagB}, "#tagB #tagA #tagC", []Tag{tfTagA, tfTagB, tfTagC}, []Tag{tfTagC}},
+		"limited": {[]Tag{"t0", "t1", "t2", "t3", "t4", "t5", "t6", "t7", "t8"}, "#tagA #tagB",
+			[]Tag{"t0", "t1", "t2", "t3", "t4", "t5", "t6", "t7", "t8", tfTagA}, []Tag{tfTagA}},
+	}
+
+	for sym, tc := range tests {
+		tags, hashtags := msgTagsWithHashtags(tc.tags, tc.body)
+		a.Equal(t, tc.exp, tags, "[%s] mismatch on tags", sym)
+		a.Equal(t, tc.expHashtags, hashtags, "[%s] mismatch on hashtags", sym)
+	}
+}
+
+func Test_MsgText_TagsWithoutHashtags(t *testing.T) {
+	tests := map[string]struct {
+		tags     []Tag
+		hashtags []Tag
+		exp      []Tag
+	}{
+		"no hashtags":            {[]Tag{tfTagA, tfTagB}, nil, []Tag{tfTagA, tfTagB}},
+		"detached":               {[]Tag{tfTagA, tfTagB, tfTagC}, []Tag{tfTagC, tfTagB}, []Tag{tfTagA}},
+		"given explicitly stays": {[]Tag{tfTagA, tfTagB, tfTagC}, []Tag{tfTagC}, []Tag{tfTagA, tfTagB}},
+		"all attached":           {[]Tag{tfTagA}, []Tag{"TAGA"}, nil},
+	}
+
+	for sym, tc := range tests {
+		a.Equal(t, tc.exp, msgTagsWithoutHashtags(tc.tags, tc.hashtags), "[%s] mismatch", sym)
+	}
+}
+
+// -- section: mentions
+func Test_MsgText_MentionedNames(t *testing.T) {
+	tests := map[string]struct {
+		body string
+		exp  []string
+	}{
+		"none":                 {"nobody here", nil},
+		"single":               {"@alice", []string{"alice"}},
+		"in order":             {"hi @bob and @alice", []string{"bob", "alice"}},
+		"duplicates":           {"@bob @alice @bob", []string{"bob", "alice"}},
+		"trailing punctuation": {"thanks @alice. @bob! (@carol) @dave-", []string{"alice", "bob", "carol", "dave"}},
+		"dots and dashes":      {"@john.doe @jane-doe_2", []string{"john.doe", "jane-doe_2"}},
+		"unicode":              {"@Łukasz", []string{"Łukasz"}},
+		"combining mark":       {"@Zoe\u0308!", []string{"Zoe\u0308"}},
+		"e-mail":               {"write to alice@example.com", nil},
+		"double at":            {"@@alice", nil},
+		"at alone":             {"meet @ 5", nil},
+	}
+
+	for sym, tc := range tests {
+		a.Equal(t, tc.exp, msgMentionedNames(tc.body), "[%s] mismatch", sym)
+	}
+}
+
+func Test_MsgText_MentionedNames_Limited(t *testing.T) {
+	// GIVEN
+	var names []string
+	for i := 0; i < msgMentionsMax+5; i++ {
+		names = append(names, fmt.Sprintf("user%d", i))
+	}
+	body := "@" + strings.Join(names, " @")
+
+	// WHEN
+	got := msgMentionedNames(body)
+
+	// THEN
+	a.Equal(t, names[:msgMentionsMax], got)
+}
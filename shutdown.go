@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/uber-go/zap"
+)
+
+// serveGraceful serves HTTP requests on the listener until signal is received.
+// New connections are refused once signal arrives and in-flight requests are given
+// up to timeout to complete, connections which are still open after it are closed forcibly.
+// Storage is closed after the server, so pending writes are flushed even if draining timed out.
+// Summary of the shutdown is logged as "shutdown:done".
+func serveGraceful(s *http.Server, ln net.Listener, st io.Closer, lgr zap.Logger, sigCh <-chan os.Signal, timeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Serve(ln)
+	}()
+
+	var sig os.Signal
+	select {
+	case err := <-errCh:
+		// server failed on its own, storage is still released
+		st.Close()
+		return err
+	case sig = <-sigCh:
+	}
+
+	lgr.Info("shutdown:started", zap.String("signal", sig.String()), zap.Float64("timeout:ms", timeout.Seconds()*1e3))
+
+	drainStarted := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	drainErr := s.Shutdown(ctx)
+	drainTimedOut := drainErr == context.DeadlineExceeded
+	if drainTimedOut {
+		s.Close()
+	}
+	drainDuration := time.Since(drainStarted)
+
+	stErr := st.Close()
+
+	fields := []zap.Field{
+		zap.String("signal", sig.String()),
+		zap.Float64("drain:duration:ms", drainDuration.Seconds()*1e3),
+		zap.Bool("drain:timedOut", drainTimedOut),
+		zap.Bool("storage:closed", stErr == nil),
+	}
+	if stErr != nil {
+		fields = append(fields, zap.String("storage:error", stErr.Error()))
+	}
+	if drainTimedOut || stErr != nil {
+		lgr.Warn("shutdown:done", fields...)
+	} else {
+		lgr.Info("shutdown:done", fields...)
+	}
+
+	if drainErr != nil && !drainTimedOut {
+		return drainErr
+	}
+	return stErr
+}
+
+// closers closes all of them in order. The first error is returned.
+type closers []io.Closer
+
+func (cs closers) Close() error {
+	var first error
+	for _, c := range cs {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
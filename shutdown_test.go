@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+	"github.com/uber-go/zap"
+	"github.com/uber-go/zap/spy"
+)
+
+type tsCloserStub struct {
+	closed bool
+	err    error
+}
+
+func (c *tsCloserStub) Close() error {
+	c.closed = true
+	return c.err
+}
+
+// tsServeGracefulSetup starts serveGraceful with handler blocked until release is closed.
+// Returned started channel receives value once the request reaches the handler.
+func tsServeGracefulSetup(t *testing.T, st *tsCloserStub, timeout time.Duration) (addr string, sigCh chan os.Signal, started chan struct{}, release chan struct{}, sink *spy.Sink, done chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	ar.NoError(t, err)
+
+	started = make(chan struct{}, 1)
+	release = make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	lgr, sink := spy.New()
+	sigCh = make(chan os.Signal, 1)
+	done = make(chan error, 1)
+	go func() {
+		done <- serveGraceful(&http.Server{Handler: h}, ln, st, lgr, sigCh, timeout)
+	}()
+
+	return ln.Addr().String(), sigCh, started, release, sink, done
+}
+
+func tsServeGracefulWait(t *testing.T, done chan error) error {
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+	return nil
+}
+
+func Test_ServeGraceful_Drain(t *testing.T) {
+	st := &tsCloserStub{}
+	addr, sigCh, started, release, sink, done := tsServeGracefulSetup(t, st, 5*time.Second)
+
+	// GIVEN: request in-flight
+	resCh := make(chan *http.Response, 1)
+	go func() {
+		res, err := http.Get("http://" + addr + "/")
+		if err != nil {
+			resCh <- nil
+			return
+		}
+		res.Body.Close()
+		resCh <- res
+	}()
+	<-started
+
+	// WHEN: signal is received
+	sigCh <- syscall.SIGTERM
+
+	// THEN: new connections are refused
+	refused := false
+	for i := 0; i < 100 && !refused; i++ {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			refused = true
+			break
+		}
+		c.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+	a.True(t, refused, "new connections should be refused")
+
+	// AND: storage is not closed before request completes
+	a.False(t, st.closed, "storage closed while request in-flight")
+
+	// WHEN: in-flight request completes
+	close(release)
+
+	// THEN: it gets its response
+	if res := <-resCh; a.NotNil(t, res, "in-flight request failed") {
+		a.Equal(t, http.StatusTeapot, res.StatusCode, "incorrect status of in-flight request")
+	}
+
+	// AND: server stops without error
+	a.NoError(t, tsServeGracefulWait(t, done))
+
+	// AND: storage is closed
+	a.True(t, st.closed, "storage not closed")
+
+	// AND: summary is logged
+	logs := sink.Logs()
+	if a.Len(t, logs, 2, "incorrect number of logs generated") {
+		a.Equal(t, "shutdown:started", logs[0].Msg)
+		a.Equal(t, "shutdown:done", logs[1].Msg)
+		a.Equal(t, zap.InfoLevel, logs[1].Level)
+		a.Contains(t, logs[1].Fields, zap.String("signal", syscall.SIGTERM.String()))
+		a.Contains(t, logs[1].Fields, zap.Bool("drain:timedOut", false))
+		a.Contains(t, logs[1].Fields, zap.Bool("storage:closed", true))
+	}
+}
+
+func Test_ServeGraceful_DrainTimeout(t *testing.T) {
+	st := &tsCloserStub{}
+	addr, sigCh, started, release, sink, done := tsServeGracefulSetup(t, st, 50*time.Millisecond)
+	defer close(release)
+
+	// GIVEN: request which never completes
+	go func() {
+		if res, err := http.Get("http://" + addr + "/"); err == nil {
+			res.Body.Close()
+		}
+	}()
+	<-started
+
+	// WHEN: signal is received
+	sigCh <- syscall.SIGINT
+
+	// THEN: server stops once timeout passes
+	a.NoError(t, tsServeGracefulWait(t, done))
+
+	// AND: storage is closed anyway
+	a.True(t, st.closed, "storage not closed")
+
+	// AND: timeout is reported
+	logs := sink.Logs()
+	if a.Len(t, logs, 2, "incorrect number of logs generated") {
+		a.Equal(t, "shutdown:done", logs[1].Msg)
+		a.Equal(t, zap.WarnLevel, logs[1].Level)
+		a.Contains(t, logs[1].Fields, zap.Bool("drain:timedOut", true))
+	}
+}
+
+func Test_ServeGraceful_StorageFailure(t *testing.T) {
+	st := &tsCloserStub{err: errors.New("flush failed")}
+	_, sigCh, _, release, sink, done := tsServeGracefulSetup(t, st, time.Second)
+	defer close(release)
+
+	sigCh <- syscall.SIGTERM
+
+	a.EqualError(t, tsServeGracefulWait(t, done), "flush failed")
+
+	logs := sink.Logs()
+	if a.Len(t, logs, 2, "incorrect number of logs generated") {
+		a.Equal(t, zap.WarnLevel, logs[1].Level)
+		a.Contains(t, logs[1].Fields, zap.Bool("storage:closed", false))
+		a.Contains(t, logs[1].Fields, zap.String("storage:error", "flush failed"))
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"io"
+)
+
+// UserStorerV1 is legacy, context unaware storage interface for User related operations.
+//
+// Deprecated: implement UserStorer instead.
+type UserStorerV1 interface {
+	UserSave(u *User) error
+	UserLoad(id string) (*User, error)
+	UserFindByName(name string) (*User, error)
+}
+
+// MsgStorerV1 is legacy, context unaware storage interface for Message related operations.
+//
+// Deprecated: implement MsgStorer instead.
+type MsgStorerV1 interface {
+	MsgSave(m *Message) error
+	MsgLoad(id string) (*Message, error)
+	MsgsIDsFindByTag(tag Tag) ([]string, error)
+}
+
+// StorerV1 is legacy, context unaware storage interface for users, messages and tags.
+//
+// Deprecated: implement Storer instead.
+type StorerV1 interface {
+	UserStorerV1
+	MsgStorerV1
+}
+
+// storerV1Adapter makes legacy StorerV1 usable as Storer.
+// Legacy calls can't be interrupted, so context is only checked before each call is made.
+type storerV1Adapter struct {
+	legacy StorerV1
+}
+
+// NewStorerV1Adapter wraps legacy storage so it satisfies Storer interface.
+func NewStorerV1Adapter(s StorerV1) Storer {
+	return &storerV1Adapter{legacy: s}
+}
+
+// Close closes legacy storage if it supports closing, it's no-op otherwise.
+func (s *storerV1Adapter) Close() error {
+	if c, ok := s.legacy.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Ping pings legacy storage if it supports pinging, it's reported as ready otherwise.
+func (s *storerV1Adapter) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if p, ok := s.legacy.(interface {
+		Ping() error
+	}); ok {
+		return p.Ping()
+	}
+	return nil
+}
+
+// UserSave checks if name is free before user is saved.
+// Legacy interface has no transactions, so name taken concurrently may end up duplicated.
+// Legacy lookup matches exact names only, so names differing by case or by look-alike characters
+// are not guarded against as in the other storages.
+func (s *storerV1Adapter) UserSave(ctx context.Context, u *User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	switch o, err := s.legacy.UserFindByName(u.Name); err {
+	case nil:
+		if o.ID != u.ID {
+			return ErrElementDuplicated
+		}
+	case ErrElementNotFound:
+	default:
+		return err
+	}
+	return s.legacy.UserSave(u)
+}
+
+func (s *storerV1Adapter) UserLoad(ctx context.Context, id string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.legacy.UserLoad(id)
+}
+
+// UserLoadMany loads users one by one as legacy interface has no batch operation.
+func (s *storerV1Adapter) UserLoadMany(ctx context.Context, ids []string) ([]*User, error) {
+	out := make([]*User, 0, len(ids))
+	for _, id := range ids {
+		u, err := s.UserLoad(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, nil
+}
+
+// UserFindByName finds user by the exact name, as legacy storage has no notion of name keys.
+func (s *storerV1Adapter) UserFindByName(ctx context.Context, name string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.legacy.UserFindByName(name)
+}
+
+// UserUpdate checks if user exists before it's saved over.
+// Legacy interface has no transactions, so user removed concurrently may be brought back.
+func (s *storerV1Adapter) UserUpdate(ctx context.Context, u *User) error {
+	if u.ID == "" {
+		return ErrElementIDNotSet
+	}
+	if _, err := s.UserLoad(ctx, u.ID); err != nil {
+		return err
+	}
+	return s.UserSave(ctx, u)
+}
+
+// UserDelete is not supported as legacy storage has no way to remove elements.
+func (s *storerV1Adapter) UserDelete(ctx context.Context, id string, policy UserDeletePolicy) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return ErrNotSupported
+}
+
+// UsersList is not supported as legacy storage has no way to enumerate users.
+func (s *storerV1Adapter) UsersList(ctx context.Context, after string, limit int) ([]*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrNotSupported
+}
+
+func (s *storerV1Adapter) MsgSave(ctx context.Context, m *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.legacy.MsgSave(m)
+}
+
+func (s *storerV1Adapter) MsgLoad(ctx context.Context, id string) (*Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.legacy.MsgLoad(id)
+}
+
+// MsgLoadMany loads messages one by one as legacy interface has no batch operation.
+func (s *storerV1Adapter) MsgLoadMany(ctx context.Context, ids []string) ([]*Message, error) {
+	out := make([]*Message, 0, len(ids))
+	for _, id := range ids {
+		m, err := s.MsgLoad(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// MsgUpdate checks if message exists before it's saved over.
+// Legacy interface has no transactions, so message removed concurrently may be brought back.
+func (s *storerV1Adapter) MsgUpdate(ctx context.Context, m *Message) error {
+	if m.ID == "" {
+		return ErrElementIDNotSet
+	}
+	if _, err := s.MsgLoad(ctx, m.ID); err != nil {
+		return err
+	}
+	return s.MsgSave(ctx, m)
+}
+
+// MsgDelete is not supported as legacy storage has no way to remove elements.
+func (s *storerV1Adapter) MsgDelete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return ErrNotSupported
+}
+
+// MsgRevisions is not supported as legacy storage keeps no history.
+func (s *storerV1Adapter) MsgRevisions(ctx context.Context, id string) ([]*Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrNotSupported
+}
+
+// MsgsIDsFindByTag orders messages on its own as legacy interface returns them in no particular order.
+// All messages associated with the tag are loaded on each call.
+func (s *storerV1Adapter) MsgsIDsFindByTag(ctx context.Context, tag Tag, after MsgCursor, limit int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return []string{}, err
+	}
+	ids, err := s.legacy.MsgsIDsFindByTag(tag)
+	if err != nil {
+		return []string{}, err
+	}
+
+	msgs, err := s.MsgLoadMany(ctx, ids)
+	if err != nil {
+		return []string{}, err
+	}
+	idx := &msgIndex{}
+	for _, m := range msgs {
+		idx.Add(MsgCursorOf(m))
+	}
+	return idx.IDsAfter(after, limit), nil
+}
+
+// MsgsIDsFindByTags matches and orders messages on its own, legacy interface finds messages of single tag only.
+// Messages of the least used of All tags, or of all Any tags, are loaded on each call.
+func (s *storerV1Adapter) MsgsIDsFindByTags(ctx context.Context, q MsgTagQuery, after MsgCursor, limit int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return []string{}, err
+	}
+	if q.IsEmpty() {
+		return []string{}, ErrElementNotFound
+	}
+
+	var ids []string
+	if len(q.All) > 0 {
+		// each of All tags must be known, messages of any of them are enough to be matched against the query
+		for i, t := range q.All {
+			tagIDs, err := s.legacy.MsgsIDsFindByTag(t)
+			if err != nil {
+				return []string{}, err
+			}
+			if i == 0 || len(tagIDs) < len(ids) {
+				ids = tagIDs
+			}
+		}
+	} else {
+		known := 0
+		for _, t := range q.Any {
+			tagIDs, err := s.legacy.MsgsIDsFindByTag(t)
+			switch err {
+			case nil:
+				known++
+				ids = append(ids, tagIDs...)
+			case ErrElementNotFound:
+			default:
+				return []string{}, err
+			}
+		}
+		if known == 0 {
+			return []string{}, ErrElementNotFound
+		}
+	}
+
+	msgs, err := s.MsgLoadMany(ctx, ids)
+	if err != nil {
+		return []string{}, err
+	}
+	idx := &msgIndex{}
+	for _, m := range msgs {
+		if q.Matches(m) {
+			idx.Add(MsgCursorOf(m))
+		}
+	}
+	return idx.IDsAfter(after, limit), nil
+}
+
+// MsgsIDsFindByMention is not supported as legacy storage has no way to find messages other than by tag.
+func (s *storerV1Adapter) MsgsIDsFindByMention(ctx context.Context, userID string, after MsgCursor, limit int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return []string{}, err
+	}
+	return []string{}, ErrNotSupported
+}
+
+// MsgsIDsFindByAuthor is not supported as legacy storage has no way to find messages other than by tag.
+func (s *storerV1Adapter) MsgsIDsFindByAuthor(ctx context.Context, authorID string, after MsgCursor, limit int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return []string{}, err
+	}
+	return []string{}, ErrNotSupported
+}
+
+// MsgsSearch is not supported as legacy storage has no way to find messages other than by tag.
+func (s *storerV1Adapter) MsgsSearch(ctx context.Context, q MsgSearchQuery, offset, limit int) ([]MsgSearchHit, error) {
+	if err := ctx.Err(); err != nil {
+		return []MsgSearchHit{}, err
+	}
+	return []MsgSearchHit{}, ErrNotSupported
+}
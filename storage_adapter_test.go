@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+// tmStorerV1 is a legacy, context unaware storage used to exercise the adapter.
+type tmStorerV1 struct {
+	mem *memoryStorage
+
+	// calls counts calls made to the storage, updated atomically
+	calls int32
+}
+
+func (s *tmStorerV1) UserSave(u *User) error {
+	atomic.AddInt32(&s.calls, 1)
+	return s.mem.UserSave(context.Background(), u)
+}
+
+func (s *tmStorerV1) UserLoad(id string) (*User, error) {
+	atomic.AddInt32(&s.calls, 1)
+	return s.mem.UserLoad(context.Background(), id)
+}
+
+func (s *tmStorerV1) UserFindByName(name string) (*User, error) {
+	atomic.AddInt32(&s.calls, 1)
+	return s.mem.UserFindByName(context.Background(), name)
+}
+
+func (s *tmStorerV1) MsgSave(m *Message) error {
+	atomic.AddInt32(&s.calls, 1)
+	return s.mem.MsgSave(context.Background(), m)
+}
+
+func (s *tmStorerV1) MsgLoad(id string) (*Message, error) {
+	atomic.AddInt32(&s.calls, 1)
+	return s.mem.MsgLoad(context.Background(), id)
+}
+
+func (s *tmStorerV1) MsgsIDsFindByTag(tag Tag) ([]string, error) {
+	atomic.AddInt32(&s.calls, 1)
+	return s.mem.MsgsIDsFindByTag(context.Background(), tag, MsgCursor{}, 0)
+}
+
+func Test_Storer_Conformance_StorerV1Adapter(t *testing.T) {
+	tsStorerConformance(t, func() Storer {
+		return NewStorerV1Adapter(&tmStorerV1{mem: NewMemoryStorage()})
+	})
+}
+
+func Test_StorerV1Adapter_Factory(t *testing.T) {
+	legacy := &tmStorerV1{mem: NewMemoryStorage()}
+	s := NewStorerV1Adapter(legacy)
+
+	ar.NotNil(t, s, "empty element returned")
+	ar.IsType(t, &storerV1Adapter{}, s)
+	a.Equal(t, legacy, s.(*storerV1Adapter).legacy, "legacy storage is not attached")
+}
+
+func Test_StorerV1Adapter_ContextCancelled(t *testing.T) {
+	legacy := &tmStorerV1{mem: NewMemoryStorage()}
+	s := NewStorerV1Adapter(legacy)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	user := tfUserA
+	msg := tfMsgAA
+	a.Equal(t, context.Canceled, s.UserSave(ctx, &user))
+	_, err := s.UserLoad(ctx, user.ID)
+	a.Equal(t, context.Canceled, err)
+	_, err = s.UserFindByName(ctx, user.Name)
+	a.Equal(t, context.Canceled, err)
+	a.Equal(t, context.Canceled, s.MsgSave(ctx, &msg))
+	_, err = s.MsgLoad(ctx, msg.ID)
+	a.Equal(t, context.Canceled, err)
+	_, err = s.MsgsIDsFindByTag(ctx, msg.Tags[0], MsgCursor{}, 0)
+	a.Equal(t, context.Canceled, err)
+
+	// THEN: legacy storage is never called
+	a.EqualValues(t, 0, atomic.LoadInt32(&legacy.calls), "legacy storage called with cancelled context")
+}
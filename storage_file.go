@@ -0,0 +1,631 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// fileStorageSnapshotName is a name of the file holding latest snapshot.
+	fileStorageSnapshotName = "snapshot.json"
+
+	// fileStorageWALName is a name of the file holding write-ahead log.
+	fileStorageWALName = "wal.log"
+)
+
+const (
+	walOpUserSave   = "user:save"
+	walOpUserDelete = "user:delete"
+	walOpMsgSave    = "msg:save"
+	walOpMsgDelete  = "msg:delete"
+
+	walOpWebhookSave   = "webhook:save"
+	walOpWebhookDelete = "webhook:delete"
+)
+
+// walRecord is a single entry in the write-ahead log.
+// Entries are stored as JSON, one per line.
+type walRecord struct {
+	// Seq is a number of the record, growing with each write.
+	// It's zero in logs written before records were numbered.
+	Seq uint64 `json:"seq,omitempty"`
+
+	Op   string   `json:"op"`
+	User *User    `json:"user,omitempty"`
+	Msg  *Message `json:"msg,omitempty"`
+	ID   string   `json:"id,omitempty"`
+
+	// Webhook is a webhook saved or updated.
+	Webhook *Webhook `json:"webhook,omitempty"`
+
+	// Policy is a policy of user removal.
+	Policy UserDeletePolicy `json:"policy,omitempty"`
+}
+
+// fileSnapshot is a point in time copy of the whole storage.
+type fileSnapshot struct {
+	// Seq is a number of the last log record contained in the snapshot.
+	Seq uint64 `json:"seq,omitempty"`
+
+	Users     []*User               `json:"users"`
+	Messages  []*Message            `json:"messages"`
+	Revisions map[string][]*Message `json:"revisions,omitempty"`
+	Webhooks  []*Webhook            `json:"webhooks,omitempty"`
+}
+
+// fileStorage provides durable storage for users, messages and webhooks.
+// Each write is appended to fsync'ed write-ahead log before it's applied to the in memory state.
+// Log is periodically compacted into a snapshot. Both are replayed on startup.
+// Reads are served by embedded memoryStorage.
+// All functions are thread safe.
+type fileStorage struct {
+	*memoryStorage
+
+	// dir is a directory holding snapshot and write-ahead log.
+	dir string
+
+	// snapshotEvery is a number of log records after which log is compacted into snapshot.
+	// Zero disables compaction.
+	snapshotEvery int
+
+	// mu serialises writes so order of records in log matches order in which they are applied.
+	mu sync.Mutex
+
+	// wal is write-ahead log opened for appending.
+	wal *os.File
+
+	// walRecords is a number of records in write-ahead log.
+	walRecords int
+
+	// seq is a number of the last record logged or restored.
+	seq uint64
+
+	// OnError is called with failures of compaction. They don't fail the write, as it's already logged and applied,
+	// compaction is retried on the next write instead. They are ignored when it's nil.
+	OnError func(err error)
+}
+
+// NewFileStorage returns file storage kept in given directory.
+// Directory is created if needed. Existing snapshot and write-ahead log are replayed.
+func NewFileStorage(dir string, snapshotEvery int) (*fileStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &fileStorage{
+		memoryStorage: NewMemoryStorage(),
+		dir:           dir,
+		snapshotEvery: snapshotEvery,
+	}
+
+	if err := s.snapshotLoad(); err != nil {
+		return nil, err
+	}
+
+	wal, err := os.OpenFile(s.path(fileStorageWALName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.walReplay(wal); err != nil {
+		wal.Close()
+		return nil, err
+	}
+	s.wal = wal
+
+	return s, nil
+}
+
+// UserSave persists single user.
+// ErrElementIDNotSet error is returned if user ID is not set.
+// ErrElementDuplicated error is returned if other user with the same name, up to the key (see userNameKey), exists.
+func (s *fileStorage) UserSave(ctx context.Context, u *User) error {
+	if u.ID == "" {
+		return ErrElementIDNotSet
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// all writes go through mu, so name can't be taken before it's logged
+	if err := s.memoryStorage.userNameCheck(u); err != nil {
+		return err
+	}
+	if err := s.walAppend(&walRecord{Op: walOpUserSave, User: u}); err != nil {
+		return err
+	}
+	// once logged, change must be applied regardless of the caller being gone
+	if err := s.memoryStorage.UserSave(context.Background(), u); err != nil {
+		return err
+	}
+
+	s.compactIfNeeded()
+	return nil
+}
+
+// UserUpdate replaces existing user.
+// ErrElementIDNotSet error is returned if user ID is not set.
+// ErrElementNotFound is returned if user could not be found.
+// ErrElementDuplicated error is returned if other user with the same name, up to the key (see userNameKey), exists.
+func (s *fileStorage) UserUpdate(ctx context.Context, u *User) error {
+	if u.ID == "" {
+		return ErrElementIDNotSet
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// all writes go through mu, so user can't disappear before it's logged
+	if _, err := s.memoryStorage.UserLoad(ctx, u.ID); err != nil {
+		return err
+	}
+	if err := s.memoryStorage.userNameCheck(u); err != nil {
+		return err
+	}
+	// update of existing user is replayed as plain save
+	if err := s.walAppend(&walRecord{Op: walOpUserSave, User: u}); err != nil {
+		return err
+	}
+	if err := s.memoryStorage.UserUpdate(context.Background(), u); err != nil {
+		return err
+	}
+
+	s.compactIfNeeded()
+	return nil
+}
+
+// UserDelete removes user. Authored messages are handled according to the policy.
+// ErrElementNotFound is returned if user could not be found.
+// ErrElementInUse is returned if user authored any message and policy is UserDeleteReject.
+func (s *fileStorage) UserDelete(ctx context.Context, id string, policy UserDeletePolicy) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// removal is only logged when it's going to succeed
+	s.usersMu.RLock()
+	s.messagesMu.RLock()
+	_, err := s.userDeleteCheck(id, policy)
+	s.messagesMu.RUnlock()
+	s.usersMu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	if err := s.walAppend(&walRecord{Op: walOpUserDelete, ID: id, Policy: policy}); err != nil {
+		return err
+	}
+	if err := s.memoryStorage.UserDelete(context.Background(), id, policy); err != nil {
+		return err
+	}
+
+	s.compactIfNeeded()
+	return nil
+}
+
+// MsgSave persists single message.
+// Error ErrElementIDNotSet is dispatched when message ID is not set.
+func (s *fileStorage) MsgSave(ctx context.Context, m *Message) error {
+	if m.ID == "" {
+		return ErrElementIDNotSet
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.walAppend(&walRecord{Op: walOpMsgSave, Msg: m}); err != nil {
+		return err
+	}
+	// once logged, change must be applied regardless of the caller being gone
+	if err := s.memoryStorage.MsgSave(context.Background(), m); err != nil {
+		return err
+	}
+
+	s.compactIfNeeded()
+	return nil
+}
+
+// MsgUpdate replaces existing message.
+// Error ErrElementIDNotSet is dispatched when message ID is not set.
+// ErrElementNotFound is returned if message could not be found.
+func (s *fileStorage) MsgUpdate(ctx context.Context, m *Message) error {
+	if m.ID == "" {
+		return ErrElementIDNotSet
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// all writes go through mu, so message can't disappear before it's logged
+	if _, err := s.memoryStorage.MsgLoad(ctx, m.ID); err != nil {
+		return err
+	}
+	// update of existing message is replayed as plain save
+	if err := s.walAppend(&walRecord{Op: walOpMsgSave, Msg: m}); err != nil {
+		return err
+	}
+	if err := s.memoryStorage.MsgUpdate(context.Background(), m); err != nil {
+		return err
+	}
+
+	s.compactIfNeeded()
+	return nil
+}
+
+// MsgDelete removes message along with its revisions and association to tag.
+// ErrElementNotFound is returned if message could not be found.
+func (s *fileStorage) MsgDelete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.memoryStorage.MsgLoad(ctx, id); err != nil {
+		return err
+	}
+	if err := s.walAppend(&walRecord{Op: walOpMsgDelete, ID: id}); err != nil {
+		return err
+	}
+	if err := s.memoryStorage.MsgDelete(context.Background(), id); err != nil {
+		return err
+	}
+
+	s.compactIfNeeded()
+	return nil
+}
+
+// WebhookSave persists single webhook.
+// ErrElementIDNotSet error is returned if webhook ID is not set.
+func (s *fileStorage) WebhookSave(ctx context.Context, wh *Webhook) error {
+	if wh.ID == "" {
+		return ErrElementIDNotSet
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.walAppend(&walRecord{Op: walOpWebhookSave, Webhook: wh}); err != nil {
+		return err
+	}
+	if err := s.memoryStorage.WebhookSave(context.Background(), wh); err != nil {
+		return err
+	}
+
+	s.compactIfNeeded()
+	return nil
+}
+
+// WebhookUpdate replaces existing webhook.
+// ErrElementIDNotSet error is returned if webhook ID is not set.
+// ErrElementNotFound is returned if webhook could not be found.
+func (s *fileStorage) WebhookUpdate(ctx context.Context, wh *Webhook) error {
+	if wh.ID == "" {
+		return ErrElementIDNotSet
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.memoryStorage.WebhookLoad(ctx, wh.ID); err != nil {
+		return err
+	}
+	// update of existing webhook is replayed as plain save
+	if err := s.walAppend(&walRecord{Op: walOpWebhookSave, Webhook: wh}); err != nil {
+		return err
+	}
+	if err := s.memoryStorage.WebhookUpdate(context.Background(), wh); err != nil {
+		return err
+	}
+
+	s.compactIfNeeded()
+	return nil
+}
+
+// WebhookDelete removes webhook.
+// ErrElementNotFound is returned if webhook could not be found.
+func (s *fileStorage) WebhookDelete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.memoryStorage.WebhookLoad(ctx, id); err != nil {
+		return err
+	}
+	if err := s.walAppend(&walRecord{Op: walOpWebhookDelete, ID: id}); err != nil {
+		return err
+	}
+	if err := s.memoryStorage.WebhookDelete(context.Background(), id); err != nil {
+		return err
+	}
+
+	s.compactIfNeeded()
+	return nil
+}
+
+// Close compacts the log into snapshot and releases the files.
+// Writes attempted after Close fail with ErrStorageClosed.
+func (s *fileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.wal == nil {
+		return nil
+	}
+	if s.walRecords > 0 {
+		if err := s.snapshotSave(); err != nil {
+			return err
+		}
+	}
+	err := s.wal.Close()
+	s.wal = nil
+	return err
+}
+
+// Ping reports ErrStorageClosed once the storage is closed, as writes would fail.
+func (s *fileStorage) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wal == nil {
+		return ErrStorageClosed
+	}
+	return nil
+}
+
+func (s *fileStorage) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// walAppend writes record at the end of the log and waits until it's flushed to disk.
+// Must be called with mu held.
+func (s *fileStorage) walAppend(rec *walRecord) error {
+	if s.wal == nil {
+		return ErrStorageClosed
+	}
+	rec.Seq = s.seq + 1
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := s.wal.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if err := s.wal.Sync(); err != nil {
+		return err
+	}
+	s.walRecords++
+	s.seq = rec.Seq
+	return nil
+}
+
+// walApply applies single log record to in memory state.
+func (s *fileStorage) walApply(rec *walRecord) error {
+	switch {
+	case rec.Op == walOpUserSave && rec.User != nil:
+		// log written before names were unique may hold duplicates
+		return s.memoryStorage.userRestore(rec.User)
+	case rec.Op == walOpUserDelete && rec.ID != "":
+		return walApplied(s.memoryStorage.UserDelete(context.Background(), rec.ID, rec.Policy))
+	case rec.Op == walOpMsgSave && rec.Msg != nil:
+		// log not numbered may repeat versions of messages contained in the snapshot
+		return s.memoryStorage.msgRestore(rec.Msg)
+	case rec.Op == walOpMsgDelete && rec.ID != "":
+		return walApplied(s.memoryStorage.MsgDelete(context.Background(), rec.ID))
+	case rec.Op == walOpWebhookSave && rec.Webhook != nil:
+		return s.memoryStorage.WebhookSave(context.Background(), rec.Webhook)
+	case rec.Op == walOpWebhookDelete && rec.ID != "":
+		return walApplied(s.memoryStorage.WebhookDelete(context.Background(), rec.ID))
+	}
+	return fmt.Errorf("Storage: unknown log record: %q", rec.Op)
+}
+
+// walApplied treats removal of element which is already gone as applied.
+// Records not numbered may be replayed over the snapshot which already contains them.
+func walApplied(err error) error {
+	if err == ErrElementNotFound {
+		return nil
+	}
+	return err
+}
+
+// walReplay applies all records from the log and leaves file positioned at its end.
+// Records already contained in the snapshot (e.g. after crash before the log was truncated) are skipped.
+// Partially written last record (e.g. after crash during append) is discarded.
+func (s *fileStorage) walReplay(f *os.File) error {
+	var offset int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// incomplete last line was never acknowledged to the caller
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var rec walRecord
+		if err := json.Unmarshal(bytes.TrimSpace(line), &rec); err != nil {
+			return fmt.Errorf("Storage: corrupted log at offset %d: %s", offset, err)
+		}
+		if rec.Seq == 0 || rec.Seq > s.seq {
+			if err := s.walApply(&rec); err != nil {
+				return err
+			}
+			if rec.Seq > s.seq {
+				s.seq = rec.Seq
+			}
+		}
+		offset += int64(len(line))
+		s.walRecords++
+	}
+
+	if err := f.Truncate(offset); err != nil {
+		return err
+	}
+	_, err := f.Seek(offset, io.SeekStart)
+	return err
+}
+
+// compactIfNeeded writes snapshot when log grew over the threshold.
+// Failure is reported to OnError, log keeps growing until snapshot is written on one of the next writes.
+// Must be called with mu held.
+func (s *fileStorage) compactIfNeeded() {
+	if s.snapshotEvery <= 0 || s.walRecords < s.snapshotEvery {
+		return
+	}
+	if err := s.snapshotSave(); err != nil && s.OnError != nil {
+		s.OnError(fmt.Errorf("Storage: compaction failed: %s", err))
+	}
+}
+
+// snapshotSave writes current state into snapshot and truncates the log.
+// Snapshot is written to temporary file and atomically renamed.
+// Crash between rename and truncation is safe as records contained in the snapshot are skipped on replay.
+// Must be called with mu held.
+func (s *fileStorage) snapshotSave() error {
+	snap := s.snapshotTake()
+
+	tmpPath := s.path(fileStorageSnapshotName + ".tmp")
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(snap); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, s.path(fileStorageSnapshotName)); err != nil {
+		return err
+	}
+	if err := s.dirSync(); err != nil {
+		return err
+	}
+
+	if err := s.wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.walRecords = 0
+
+	return s.wal.Sync()
+}
+
+// snapshotTake copies current state of in memory storage.
+func (s *fileStorage) snapshotTake() *fileSnapshot {
+	snap := &fileSnapshot{Seq: s.seq}
+
+	s.usersMu.RLock()
+	snap.Users = make([]*User, 0, len(s.users))
+	for _, u := range s.users {
+		snap.Users = append(snap.Users, u)
+	}
+	s.usersMu.RUnlock()
+
+	s.messagesMu.RLock()
+	snap.Messages = make([]*Message, 0, len(s.messages))
+	for _, m := range s.messages {
+		snap.Messages = append(snap.Messages, m)
+	}
+	snap.Revisions = make(map[string][]*Message, len(s.revisions))
+	for id, revs := range s.revisions {
+		snap.Revisions[id] = append([]*Message(nil), revs...)
+	}
+	s.messagesMu.RUnlock()
+
+	s.webhooksMu.RLock()
+	snap.Webhooks = make([]*Webhook, 0, len(s.webhooks))
+	for _, wh := range s.webhooks {
+		snap.Webhooks = append(snap.Webhooks, wh)
+	}
+	s.webhooksMu.RUnlock()
+
+	return snap
+}
+
+// snapshotLoad restores state from snapshot if there is one.
+func (s *fileStorage) snapshotLoad() error {
+	f, err := os.Open(s.path(fileStorageSnapshotName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var snap fileSnapshot
+	if err := json.NewDecoder(f).Decode(&snap); err != nil {
+		return fmt.Errorf("Storage: corrupted snapshot: %s", err)
+	}
+	s.seq = snap.Seq
+
+	for _, u := range snap.Users {
+		if err := s.memoryStorage.userRestore(u); err != nil {
+			return err
+		}
+	}
+	for _, m := range snap.Messages {
+		if err := s.memoryStorage.MsgSave(context.Background(), m); err != nil {
+			return err
+		}
+	}
+	// history is restored as is, saving messages above has not touched it as they were all new
+	s.messagesMu.Lock()
+	for id, revs := range snap.Revisions {
+		s.revisions[id] = revs
+	}
+	s.messagesMu.Unlock()
+
+	for _, wh := range snap.Webhooks {
+		if err := s.memoryStorage.WebhookSave(context.Background(), wh); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dirSync flushes directory entry so that rename survives a crash.
+func (s *fileStorage) dirSync() error {
+	d, err := os.Open(s.dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+func Test_FileStorage_Factory(t *testing.T) {
+	s, closer := tsFileStorageSetup(t, 0)
+	defer closer()
+
+	ar.NotNil(t, s, "empty element returned")
+	ar.IsType(t, &fileStorage{}, s)
+
+	a.NotNil(t, s.memoryStorage, "memory storage is not initialised")
+	a.NotNil(t, s.wal, "write-ahead log is not opened")
+	a.Equal(t, 0, s.walRecords, "write-ahead log should be empty on init")
+}
+
+func Test_FileStorage_Replay_WAL(t *testing.T) {
+	s, closer := tsFileStorageSetup(t, 0)
+	defer closer()
+
+	// GIVEN: users and messages are saved
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		ar.NoError(t, s.UserSave(context.Background(), &uC))
+	}
+	for _, m := range []Message{tfMsgAA, tfMsgAB, tfMsgBA, tfMsgBB} {
+		mC := m
+		ar.NoError(t, s.MsgSave(context.Background(), &mC))
+	}
+	a.Equal(t, 6, s.walRecords, "mismatch in number of log records")
+
+	// WHEN: storage is reopened without clean shutdown
+	ar.NoError(t, s.wal.Close())
+	sR, err := NewFileStorage(s.dir, 0)
+	ar.NoError(t, err, "unexpected error on reopen")
+	defer sR.Close()
+
+	// THEN: state is restored
+	tsFileStorageAssertState(t, sR)
+}
+
+func Test_FileStorage_Replay_SnapshotAndWAL(t *testing.T) {
+	// snapshot is taken after every 3 records
+	s, closer := tsFileStorageSetup(t, 3)
+	defer closer()
+
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		ar.NoError(t, s.UserSave(context.Background(), &uC))
+	}
+	for _, m := range []Message{tfMsgAA, tfMsgAB, tfMsgBA, tfMsgBB} {
+		mC := m
+		ar.NoError(t, s.MsgSave(context.Background(), &mC))
+	}
+
+	// THEN: log was compacted
+	_, err := os.Stat(filepath.Join(s.dir, fileStorageSnapshotName))
+	ar.NoError(t, err, "snapshot not written")
+	a.Equal(t, 0, s.walRecords, "log not truncated after snapshot")
+
+	// WHEN: more records are logged after snapshot
+	msg := tfMsgAA
+	msg.Body = "UserA_MessageA-Body-Edited"
+	ar.NoError(t, s.MsgSave(context.Background(), &msg))
+	a.Equal(t, 1, s.walRecords, "mismatch in number of log records")
+
+	// AND: storage is reopened
+	ar.NoError(t, s.wal.Close())
+	sR, err := NewFileStorage(s.dir, 3)
+	ar.NoError(t, err, "unexpected error on reopen")
+	defer sR.Close()
+
+	// THEN: snapshot and log are both applied
+	tsFileStorageAssertState(t, sR)
+	msgGot, err := sR.MsgLoad(context.Background(), tfMsgAA.ID)
+	ar.NoError(t, err)
+	a.Equal(t, "UserA_MessageA-Body-Edited", msgGot.Body, "record from log not applied over snapshot")
+}
+
+func Test_FileStorage_Replay_Revisions(t *testing.T) {
+	// snapshot is taken after every 2 records
+	s, closer := tsFileStorageSetup(t, 2)
+	defer closer()
+
+	// GIVEN: message is edited, before and after snapshot
+	msgV1 := tfMsgAA
+	ar.NoError(t, s.MsgSave(context.Background(), &msgV1))
+	msgV2 := tfMsgAA
+	msgV2.Body = "UserA_MessageA-Body-V2"
+	ar.NoError(t, s.MsgSave(context.Background(), &msgV2))
+	a.Equal(t, 0, s.walRecords, "log not truncated after snapshot")
+	msgV3 := tfMsgAA
+	msgV3.Body = "UserA_MessageA-Body-V3"
+	ar.NoError(t, s.MsgSave(context.Background(), &msgV3))
+
+	// WHEN: storage is reopened
+	ar.NoError(t, s.wal.Close())
+	sR, err := NewFileStorage(s.dir, 2)
+	ar.NoError(t, err, "unexpected error on reopen")
+	defer sR.Close()
+
+	// THEN: history is restored from both snapshot and log
+	revsGot, err := sR.MsgRevisions(context.Background(), tfMsgAA.ID)
+	ar.NoError(t, err)
+	a.Equal(t, []*Message{&msgV1, &msgV2}, revsGot)
+}
+
+func Test_FileStorage_Replay_Delete(t *testing.T) {
+	s, closer := tsFileStorageSetup(t, 0)
+	defer closer()
+
+	for _, m := range []Message{tfMsgAA, tfMsgAB} {
+		mC := m
+		ar.NoError(t, s.MsgSave(context.Background(), &mC))
+	}
+	ar.NoError(t, s.MsgDelete(context.Background(), tfMsgAA.ID))
+	a.Equal(t, 3, s.walRecords, "mismatch in number of log records")
+
+	// WHEN: storage is reopened without clean shutdown
+	ar.NoError(t, s.wal.Close())
+	sR, err := NewFileStorage(s.dir, 0)
+	ar.NoError(t, err, "unexpected error on reopen")
+	defer sR.Close()
+
+	// THEN: removal is replayed
+	_, err = sR.MsgLoad(context.Background(), tfMsgAA.ID)
+	a.Equal(t, ErrElementNotFound, err, "removed message restored")
+	idsGot, err := sR.MsgsIDsFindByTag(context.Background(), tfTagA, MsgCursor{}, 0)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgAB.ID}, idsGot, "mismatched ids returned")
+}
+
+func Test_FileStorage_Replay_Webhooks(t *testing.T) {
+	for _, snapshotEvery := range []int{0, 2} {
+		sym := fmt.Sprintf("snapshotEvery: %d", snapshotEvery)
+		s, closer := tsFileStorageSetup(t, snapshotEvery)
+
+		// GIVEN: webhooks are saved, updated and removed
+		for _, wh := range []Webhook{tfWebhookA, tfWebhookB} {
+			whC := wh
+			ar.NoError(t, s.WebhookSave(context.Background(), &whC))
+		}
+		whUpdated := tfWebhookB
+		whUpdated.URL = "https://hooks.example.com/b-moved"
+		ar.NoError(t, s.WebhookUpdate(context.Background(), &whUpdated))
+		ar.NoError(t, s.WebhookDelete(context.Background(), tfWebhookA.ID))
+
+		// WHEN: storage is reopened without clean shutdown
+		ar.NoError(t, s.wal.Close())
+		sR, err := NewFileStorage(s.dir, snapshotEvery)
+		ar.NoError(t, err, "[%s] unexpected error on reopen", sym)
+
+		// THEN: all changes are restored
+		hooks, err := sR.WebhooksList(context.Background())
+		ar.NoError(t, err)
+		a.Equal(t, []*Webhook{&whUpdated}, hooks, "[%s] mismatch on restored webhooks", sym)
+
+		sR.Close()
+		closer()
+	}
+}
+
+func Test_FileStorage_Replay_UserDelete(t *testing.T) {
+	s, closer := tsFileStorageSetup(t, 0)
+	defer closer()
+
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		ar.NoError(t, s.UserSave(context.Background(), &uC))
+	}
+	for _, m := range []Message{tfMsgAA, tfMsgBA} {
+		mC := m
+		ar.NoError(t, s.MsgSave(context.Background(), &mC))
+	}
+	ar.NoError(t, s.UserDelete(context.Background(), tfUserA.ID, UserDeleteCascade))
+	ar.NoError(t, s.UserDelete(context.Background(), tfUserB.ID, UserDeleteAnonymise))
+
+	// AND: refused removal is not logged
+	userC := User{ID: "UserC-ID", Name: "UserC-Name"}
+	ar.NoError(t, s.UserSave(context.Background(), &userC))
+	msgCA := Message{ID: "UserC_MessageA-ID", AuthorID: userC.ID, Body: "UserC_MessageA-Body", Tags: []Tag{tfTagB}}
+	ar.NoError(t, s.MsgSave(context.Background(), &msgCA))
+	a.Equal(t, ErrElementInUse, s.UserDelete(context.Background(), userC.ID, UserDeleteReject))
+	a.Equal(t, 8, s.walRecords, "mismatch in number of log records")
+
+	// WHEN: storage is reopened without clean shutdown
+	ar.NoError(t, s.wal.Close())
+	sR, err := NewFileStorage(s.dir, 0)
+	ar.NoError(t, err, "unexpected error on reopen")
+	defer sR.Close()
+
+	// THEN: removals are replayed with their policies
+	_, err = sR.UserLoad(context.Background(), tfUserA.ID)
+	a.Equal(t, ErrElementNotFound, err, "removed user restored")
+	_, err = sR.MsgLoad(context.Background(), tfMsgAA.ID)
+	a.Equal(t, ErrElementNotFound, err, "cascaded message restored")
+	msgGot, err := sR.MsgLoad(context.Background(), tfMsgBA.ID)
+	ar.NoError(t, err)
+	a.Equal(t, "", msgGot.AuthorID, "anonymised message restored with author")
+	_, err = sR.UserLoad(context.Background(), userC.ID)
+	a.NoError(t, err, "user removed on replay")
+}
+
+func Test_FileStorage_Replay_LogOverSnapshot(t *testing.T) {
+	for sym, numbered := range map[string]bool{"numbered": true, "not numbered": false} {
+		// snapshot is taken after every 2 records
+		s, closer := tsFileStorageSetup(t, 2)
+
+		// GIVEN: message is removed after snapshot
+		for _, m := range []Message{tfMsgAA, tfMsgAB} {
+			mC := m
+			ar.NoError(t, s.MsgSave(context.Background(), &mC))
+		}
+		ar.NoError(t, s.MsgDelete(context.Background(), tfMsgAA.ID))
+		a.Equal(t, 1, s.walRecords, "[%s] mismatch in number of log records", sym)
+
+		// WHEN: crash happens after snapshot is written, but before the log is truncated
+		tsFileStorageCrashAfterSnapshot(t, s, numbered)
+		sR, err := NewFileStorage(s.dir, 0)
+		ar.NoError(t, err, "[%s] unexpected error on reopen", sym)
+
+		// THEN: state matches the snapshot
+		_, err = sR.MsgLoad(context.Background(), tfMsgAA.ID)
+		a.Equal(t, ErrElementNotFound, err, "[%s] removed message restored", sym)
+		idsGot, err := sR.MsgsIDsFindByTag(context.Background(), tfTagA, MsgCursor{}, 0)
+		ar.NoError(t, err)
+		a.Equal(t, []string{tfMsgAB.ID}, idsGot, "[%s] mismatched ids returned", sym)
+
+		// AND: records logged afterwards are replayed next time
+		msgBA := tfMsgBA
+		ar.NoError(t, sR.MsgSave(context.Background(), &msgBA))
+		ar.NoError(t, sR.wal.Close())
+		sR, err = NewFileStorage(s.dir, 0)
+		ar.NoError(t, err, "[%s] unexpected error on second reopen", sym)
+		_, err = sR.MsgLoad(context.Background(), tfMsgBA.ID)
+		a.NoError(t, err, "[%s] record logged after replay not applied", sym)
+
+		sR.Close()
+		closer()
+	}
+}
+
+func Test_FileStorage_Replay_UserDeleteOverSnapshot(t *testing.T) {
+	for sym, numbered := range map[string]bool{"numbered": true, "not numbered": false} {
+		// snapshot is taken after every 3 records
+		s, closer := tsFileStorageSetup(t, 3)
+
+		// GIVEN: user is removed along with messages after snapshot
+		userA := tfUserA
+		ar.NoError(t, s.UserSave(context.Background(), &userA))
+		for _, m := range []Message{tfMsgAA, tfMsgAB} {
+			mC := m
+			ar.NoError(t, s.MsgSave(context.Background(), &mC))
+		}
+		ar.NoError(t, s.UserDelete(context.Background(), tfUserA.ID, UserDeleteCascade))
+		a.Equal(t, 1, s.walRecords, "[%s] mismatch in number of log records", sym)
+
+		// WHEN: crash happens after snapshot is written, but before the log is truncated
+		tsFileStorageCrashAfterSnapshot(t, s, numbered)
+		sR, err := NewFileStorage(s.dir, 3)
+		ar.NoError(t, err, "[%s] unexpected error on reopen", sym)
+
+		// THEN: user and messages stay removed
+		_, err = sR.UserLoad(context.Background(), tfUserA.ID)
+		a.Equal(t, ErrElementNotFound, err, "[%s] removed user restored", sym)
+		_, err = sR.MsgLoad(context.Background(), tfMsgAB.ID)
+		a.Equal(t, ErrElementNotFound, err, "[%s] cascaded message restored", sym)
+
+		sR.Close()
+		closer()
+	}
+}
+
+func Test_FileStorage_Replay_WebhookDeleteOverSnapshot(t *testing.T) {
+	for sym, numbered := range map[string]bool{"numbered": true, "not numbered": false} {
+		// snapshot is taken after every 2 records
+		s, closer := tsFileStorageSetup(t, 2)
+
+		// GIVEN: webhook is removed after snapshot
+		for _, wh := range []Webhook{tfWebhookA, tfWebhookB} {
+			whC := wh
+			ar.NoError(t, s.WebhookSave(context.Background(), &whC))
+		}
+		ar.NoError(t, s.WebhookDelete(context.Background(), tfWebhookA.ID))
+		a.Equal(t, 1, s.walRecords, "[%s] mismatch in number of log records", sym)
+
+		// WHEN: crash happens after snapshot is written, but before the log is truncated
+		tsFileStorageCrashAfterSnapshot(t, s, numbered)
+		sR, err := NewFileStorage(s.dir, 2)
+		ar.NoError(t, err, "[%s] unexpected error on reopen", sym)
+
+		// THEN: webhook stays removed
+		hooks, err := sR.WebhooksList(context.Background())
+		ar.NoError(t, err)
+		whB := tfWebhookB
+		a.Equal(t, []*Webhook{&whB}, hooks, "[%s] mismatch on restored webhooks", sym)
+
+		sR.Close()
+		closer()
+	}
+}
+
+func Test_FileStorage_Replay_RevisionsOverSnapshot(t *testing.T) {
+	for sym, numbered := range map[string]bool{"numbered": true, "not numbered": false} {
+		s, closer := tsFileStorageSetup(t, 0)
+
+		// GIVEN: message is edited
+		msgV1 := tfMsgAA
+		ar.NoError(t, s.MsgSave(context.Background(), &msgV1))
+		msgV2 := tfMsgAA
+		msgV2.Body = "UserA_MessageA-Body-V2"
+		msgV2.ModifiedAt = msgV1.ModifiedAt.Add(time.Minute)
+		ar.NoError(t, s.MsgUpdate(context.Background(), &msgV2))
+
+		// WHEN: crash happens after snapshot is written, but before the log is truncated
+		tsFileStorageCrashAfterSnapshot(t, s, numbered)
+		sR, err := NewFileStorage(s.dir, 0)
+		ar.NoError(t, err, "[%s] unexpected error on reopen", sym)
+
+		// THEN: history is not duplicated
+		msgGot, err := sR.MsgLoad(context.Background(), tfMsgAA.ID)
+		ar.NoError(t, err)
+		a.Equal(t, msgV2.Body, msgGot.Body, "[%s] mismatch on current version", sym)
+		revsGot, err := sR.MsgRevisions(context.Background(), tfMsgAA.ID)
+		ar.NoError(t, err)
+		if a.Len(t, revsGot, 1, "[%s] mismatch on number of revisions", sym) {
+			a.Equal(t, msgV1.Body, revsGot[0].Body, "[%s] mismatch on revision", sym)
+		}
+
+		sR.Close()
+		closer()
+	}
+}
+
+func Test_FileStorage_Replay_LegacyTag(t *testing.T) {
+	s, closer := tsFileStorageSetup(t, 0)
+	defer closer()
+	ar.NoError(t, s.wal.Close())
+
+	// GIVEN: log written before messages could have many tags
+	walPath := filepath.Join(s.dir, fileStorageWALName)
+	rec := `{"op":"msg:save","msg":{"ID":"UserA_MessageA-ID","AuthorID":"UserA-ID","Body":"UserA_MessageA-Body","Tag":"tagA"}}` + "\n"
+	ar.NoError(t, ioutil.WriteFile(walPath, []byte(rec), 0644))
+
+	sR, err := NewFileStorage(s.dir, 0)
+	ar.NoError(t, err, "unexpected error on reopen")
+	defer sR.Close()
+
+	// THEN: single tag becomes the only one
+	msgGot, err := sR.MsgLoad(context.Background(), tfMsgAA.ID)
+	ar.NoError(t, err)
+	a.Equal(t, []Tag{tfTagA}, msgGot.Tags, "tags mismatch")
+	idsGot, err := sR.MsgsIDsFindByTag(context.Background(), tfTagA, MsgCursor{}, 0)
+	ar.NoError(t, err, "tag not restored")
+	a.Equal(t, []string{tfMsgAA.ID}, idsGot, "mismatched ids returned")
+}
+
+func Test_FileStorage_Replay_LegacyNameDuplicated(t *testing.T) {
+	s, closer := tsFileStorageSetup(t, 0)
+	defer closer()
+	ar.NoError(t, s.wal.Close())
+
+	// GIVEN: log written before names were unique
+	walPath := filepath.Join(s.dir, fileStorageWALName)
+	rec := `{"op":"user:save","user":{"ID":"UserA-ID","Name":"UserA-Name"}}` + "\n" +
+		`{"op":"user:save","user":{"ID":"UserX-ID","Name":"usera-NAME"}}` + "\n"
+	ar.NoError(t, ioutil.WriteFile(walPath, []byte(rec), 0644))
+
+	sR, err := NewFileStorage(s.dir, 0)
+	ar.NoError(t, err, "unexpected error on reopen")
+	defer sR.Close()
+
+	// THEN: both users are restored
+	for _, id := range []string{tfUserA.ID, "UserX-ID"} {
+		_, err := sR.UserLoad(context.Background(), id)
+		a.NoError(t, err, "user not restored: %s", id)
+	}
+
+	// AND: name stays with the user saved first
+	userGot, err := sR.UserFindByName(context.Background(), tfUserA.Name)
+	ar.NoError(t, err)
+	a.Equal(t, tfUserA.ID, userGot.ID, "name taken by the later user")
+
+	// AND: name is not taken again, nor logged
+	a.Equal(t, ErrElementDuplicated, sR.UserSave(context.Background(), &User{ID: "UserY-ID", Name: tfUserA.Name}))
+	a.Equal(t, 2, sR.walRecords, "refused save logged")
+}
+
+func Test_FileStorage_Compaction_Failure(t *testing.T) {
+	// snapshot is taken after every 2 records
+	s, closer := tsFileStorageSetup(t, 2)
+	defer closer()
+	var errsGot []error
+	s.OnError = func(err error) {
+		errsGot = append(errsGot, err)
+	}
+
+	// GIVEN: snapshot can't be written, as its temporary file is taken by a directory
+	tmpPath := filepath.Join(s.dir, fileStorageSnapshotName+".tmp")
+	ar.NoError(t, os.Mkdir(tmpPath, 0755))
+
+	// WHEN: writes reaching the threshold are made
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		a.NoError(t, s.UserSave(context.Background(), &uC), "[%s] write failed along with compaction", u.ID)
+	}
+	mAA := tfMsgAA
+	a.NoError(t, s.MsgSave(context.Background(), &mAA), "write failed along with repeated compaction")
+
+	// THEN: writes are applied, failures are reported and the log is kept
+	a.Len(t, errsGot, 2, "compaction failures not reported on every write")
+	a.Equal(t, 3, s.walRecords, "log not kept")
+	_, err := s.MsgLoad(context.Background(), tfMsgAA.ID)
+	a.NoError(t, err, "write not applied")
+
+	// AND: compaction is retried on the next write once it's possible
+	ar.NoError(t, os.Remove(tmpPath))
+	mAB := tfMsgAB
+	ar.NoError(t, s.MsgSave(context.Background(), &mAB))
+	a.Len(t, errsGot, 2, "unexpected failure reported")
+	a.Equal(t, 0, s.walRecords, "log not compacted")
+
+	// AND: all writes are restored
+	ar.NoError(t, s.wal.Close())
+	sR, err := NewFileStorage(s.dir, 0)
+	ar.NoError(t, err, "unexpected error on reopen")
+	defer sR.Close()
+	for _, id := range []string{tfMsgAA.ID, tfMsgAB.ID} {
+		_, err := sR.MsgLoad(context.Background(), id)
+		a.NoError(t, err, "[%s] message not restored", id)
+	}
+}
+
+func Test_FileStorage_Close_Snapshot(t *testing.T) {
+	s, closer := tsFileStorageSetup(t, 0)
+	defer closer()
+
+	userExp := tfUserA
+	ar.NoError(t, s.UserSave(context.Background(), &userExp))
+	ar.NoError(t, s.Close())
+
+	// THEN: log is compacted into snapshot
+	walInfo, err := os.Stat(filepath.Join(s.dir, fileStorageWALName))
+	ar.NoError(t, err)
+	a.EqualValues(t, 0, walInfo.Size(), "log not truncated on close")
+
+	sR, err := NewFileStorage(s.dir, 0)
+	ar.NoError(t, err, "unexpected error on reopen")
+	defer sR.Close()
+
+	userGot, err := sR.UserLoad(context.Background(), userExp.ID)
+	ar.NoError(t, err)
+	a.Equal(t, &userExp, userGot, "User from storage does not match")
+}
+
+func Test_FileStorage_Close_WriteAfter(t *testing.T) {
+	s, closer := tsFileStorageSetup(t, 0)
+	defer closer()
+
+	ar.NoError(t, s.Close())
+
+	userExp := tfUserA
+	a.Equal(t, ErrStorageClosed, s.UserSave(context.Background(), &userExp), "write after close should fail")
+	a.NoError(t, s.Close(), "repeated close should be no-op")
+}
+
+func Test_FileStorage_Replay_PartialRecord(t *testing.T) {
+	s, closer := tsFileStorageSetup(t, 0)
+	defer closer()
+
+	userExp := tfUserA
+	ar.NoError(t, s.UserSave(context.Background(), &userExp))
+
+	// GIVEN: crash happened in the middle of the append
+	_, err := s.wal.Write([]byte(`{"op":"user:save","user":{"ID":"UserB-`))
+	ar.NoError(t, err)
+	ar.NoError(t, s.wal.Close())
+
+	sR, err := NewFileStorage(s.dir, 0)
+	ar.NoError(t, err, "unexpected error on reopen")
+	defer sR.Close()
+
+	// THEN: complete records are restored and partial one is dropped
+	_, err = sR.UserLoad(context.Background(), tfUserA.ID)
+	a.NoError(t, err, "complete record not restored")
+	_, err = sR.UserLoad(context.Background(), tfUserB.ID)
+	a.Equal(t, ErrElementNotFound, err, "partial record restored")
+	a.Equal(t, 1, sR.walRecords, "mismatch in number of log records")
+}
+
+func Test_FileStorage_Replay_Corrupted(t *testing.T) {
+	s, closer := tsFileStorageSetup(t, 0)
+	defer closer()
+	ar.NoError(t, s.wal.Close())
+
+	walPath := filepath.Join(s.dir, fileStorageWALName)
+	ar.NoError(t, ioutil.WriteFile(walPath, []byte("NotA-JSON\n"), 0644))
+
+	_, err := NewFileStorage(s.dir, 0)
+	a.Error(t, err, "corrupted log accepted")
+}
+
+func Test_FileStorage_Save_Failure_NoID(t *testing.T) {
+	s, closer := tsFileStorageSetup(t, 0)
+	defer closer()
+
+	a.EqualError(t, s.UserSave(context.Background(), &tfUserXA_NoID), ErrElementIDNotSet.Error())
+	a.EqualError(t, s.MsgSave(context.Background(), &tfMsgAXA_NoID), ErrElementIDNotSet.Error())
+	a.EqualError(t, s.MsgUpdate(context.Background(), &tfMsgAXA_NoID), ErrElementIDNotSet.Error())
+	a.Equal(t, ErrElementNotFound, s.MsgDelete(context.Background(), tfMsgAA.ID))
+	a.Equal(t, 0, s.walRecords, "invalid element logged")
+}
+
+// -- test helpers
+func tsFileStorageSetup(t *testing.T, snapshotEvery int) (*fileStorage, func()) {
+	dir, err := ioutil.TempDir("", "messenger-storage-")
+	ar.NoError(t, err, "unexpected error on temp dir creation")
+
+	s, err := NewFileStorage(dir, snapshotEvery)
+	ar.NoError(t, err, "unexpected error on storage creation")
+
+	closer := func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+	return s, closer
+}
+
+// tsFileStorageAssertState checks if storage contains fixture users and messages along with tags association.
+func tsFileStorageAssertState(t *testing.T, s *fileStorage) {
+	for _, uExp := range []User{tfUserA, tfUserB} {
+		uGot, err := s.UserLoad(context.Background(), uExp.ID)
+		if a.NoError(t, err, "user not restored: %s", uExp.ID) {
+			a.Equal(t, uExp.Name, uGot.Name, "User: mismatch in Name")
+		}
+	}
+	for _, mExp := range []Message{tfMsgAB, tfMsgBA, tfMsgBB} {
+		mGot, err := s.MsgLoad(context.Background(), mExp.ID)
+		if a.NoError(t, err, "message not restored: %s", mExp.ID) {
+			a.Equal(t, &mExp, mGot, "Message from storage does not match")
+		}
+	}
+
+	idsGot, err := s.MsgsIDsFindByTag(context.Background(), tfTagA, MsgCursor{}, 0)
+	ar.NoError(t, err, "tags not restored")
+	a.Len(t, idsGot, 3, "mismatched number of ids returned")
+	idsGot, err = s.MsgsIDsFindByTag(context.Background(), tfTagB, MsgCursor{}, 0)
+	ar.NoError(t, err, "tags not restored")
+	a.Equal(t, []string{tfMsgBB.ID}, idsGot, "mismatched ids returned")
+}
+
+// tsFileStorageCrashAfterSnapshot writes snapshot and closes the storage, leaving log as it was before the snapshot.
+// Numbers of the records are dropped, as in logs written before records were numbered, unless numbered is set.
+func tsFileStorageCrashAfterSnapshot(t *testing.T, s *fileStorage, numbered bool) {
+	walPath := filepath.Join(s.dir, fileStorageWALName)
+	b, err := ioutil.ReadFile(walPath)
+	ar.NoError(t, err, "unexpected error on log read")
+	if !numbered {
+		var lines [][]byte
+		for _, line := range bytes.Split(bytes.TrimSpace(b), []byte("\n")) {
+			var rec walRecord
+			ar.NoError(t, json.Unmarshal(line, &rec))
+			rec.Seq = 0
+			line, err = json.Marshal(&rec)
+			ar.NoError(t, err)
+			lines = append(lines, line)
+		}
+		b = append(bytes.Join(lines, []byte("\n")), '\n')
+	}
+
+	s.mu.Lock()
+	ar.NoError(t, s.snapshotSave(), "unexpected error on snapshot")
+	ar.NoError(t, s.wal.Close())
+	s.wal = nil
+	s.mu.Unlock()
+	ar.NoError(t, ioutil.WriteFile(walPath, b, 0644), "unexpected error on log write")
+}
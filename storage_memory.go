@@ -1,64 +1,216 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"reflect"
+	"sort"
 	"sync"
-
-	"github.com/fatih/set"
 )
 
 var (
 	ErrElementIDNotSet = errors.New("Storage: element ID not set")
 	ErrElementNotFound = errors.New("Storage: element not found")
+
+	// ErrElementDuplicated is returned when element violates uniqueness constraint, e.g. user name is taken.
+	ErrElementDuplicated = errors.New("Storage: element duplicated")
+
+	// ErrElementInUse is returned when element can't be removed as other elements depend on it.
+	ErrElementInUse = errors.New("Storage: element in use")
+
+	// ErrNotSupported is returned when operation is not available in the storage backend.
+	ErrNotSupported = errors.New("Storage: operation not supported")
+
+	// ErrStorageClosed is returned when storage is used after Close.
+	ErrStorageClosed = errors.New("Storage: closed")
 )
 
+// memoryStorageCtxCheckEvery is a number of elements visited in long running scans between checks of the context.
+const memoryStorageCtxCheckEvery = 1000
+
 // memoryStorage provides in memory storage for users
 // All functions are thread safe.
+// Cancelled or expired context makes functions return ctx.Err() without touching the data.
 type memoryStorage struct {
 	// users is a storage for a users.
 	// Keyed by User.ID
 	users map[string]*User
-	// usersMu is RW mutex protecting users map
+	// names indexes users by their names.
+	// Keyed by the key of name (see userNameKey). Protected by usersMu.
+	names map[string]*User
+	// usersMu is RW mutex protecting users and names maps
 	usersMu sync.RWMutex
 
 	// messages is a storage for messages.
 	// Keyed by Message.ID.
 	messages map[string]*Message
-	// messagesMu is RW mutex protecting messages map.
+	// revisions keeps prior versions of edited messages, from the oldest.
+	// Keyed by Message.ID. Protected by messagesMu.
+	revisions map[string][]*Message
+
+	// messagesMu is RW mutex protecting messages and revisions maps.
 	messagesMu sync.RWMutex
 
 	// tags keeps association between messages and tags
-	// Keyed by tag with sets of message.ID as value.
-	tags map[string]*set.Set
+	// Keyed by the key of tag (see Tag.Key) with messages ordered from the newest as value.
+	tags map[string]*msgIndex
 	// tagsMu is RW mutex protecting tags map.
 	tagsMu sync.RWMutex
+
+	// mentions keeps association between messages and users mentioned in them.
+	// Keyed by User.ID with messages ordered from the newest as value.
+	mentions map[string]*msgIndex
+	// mentionsMu is RW mutex protecting mentions map.
+	mentionsMu sync.RWMutex
+
+	// search is an inverted index of words of message bodies.
+	search *searchIndex
+	// searchMu is RW mutex protecting search index.
+	searchMu sync.RWMutex
+
+	// webhooks is a storage for webhooks.
+	// Keyed by Webhook.ID.
+	webhooks map[string]*Webhook
+	// webhooksMu is RW mutex protecting webhooks map.
+	webhooksMu sync.RWMutex
 }
 
 // NewMemoryStorage returns empty memory storage
 func NewMemoryStorage() *memoryStorage {
 	return &memoryStorage{
-		users:    make(map[string]*User),
-		messages: make(map[string]*Message),
-		tags:     make(map[string]*set.Set),
+		users:     make(map[string]*User),
+		names:     make(map[string]*User),
+		messages:  make(map[string]*Message),
+		revisions: make(map[string][]*Message),
+		tags:      make(map[string]*msgIndex),
+		mentions:  make(map[string]*msgIndex),
+		search:    newSearchIndex(),
+		webhooks:  make(map[string]*Webhook),
 	}
 }
 
+// Close does nothing as memory storage holds no external resources.
+// Content is lost together with the process.
+func (s *memoryStorage) Close() error {
+	return nil
+}
+
+// Ping reports memory storage as always ready, until context is done.
+func (s *memoryStorage) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// StorageStats is a snapshot of number of elements kept in the storage.
+type StorageStats struct {
+	Users    int
+	Messages int
+	// Tags is a number of distinct tags attached to at least one message.
+	Tags int
+}
+
+// Stats returns current number of users, messages and tags.
+// Each collection is counted under its own lock, so numbers may be off by concurrent writes.
+func (s *memoryStorage) Stats() StorageStats {
+	var st StorageStats
+
+	s.usersMu.RLock()
+	st.Users = len(s.users)
+	s.usersMu.RUnlock()
+
+	s.messagesMu.RLock()
+	st.Messages = len(s.messages)
+	s.messagesMu.RUnlock()
+
+	s.tagsMu.RLock()
+	st.Tags = len(s.tags)
+	s.tagsMu.RUnlock()
+
+	return st
+}
+
 // UserSave persists single user.
 // ErrElementIDNotSet error is returned if user ID is not set.
-func (s *memoryStorage) UserSave(u *User) error {
+// ErrElementDuplicated error is returned if other user with the same name, up to the key (see userNameKey), exists.
+func (s *memoryStorage) UserSave(ctx context.Context, u *User) error {
 	if u.ID == "" {
 		return ErrElementIDNotSet
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// key is computed before the lock is taken, it's the costly part
+	key := userNameKey(u.Name)
 	s.usersMu.Lock()
 	defer s.usersMu.Unlock()
+	if s.userNameTaken(u.ID, key) {
+		return ErrElementDuplicated
+	}
+	s.userPut(u, key)
+
+	return nil
+}
+
+// userNameCheck returns ErrElementDuplicated if name of the user, up to the key (see userNameKey), is taken by other user.
+// Result is only advisory unless writes of users are serialised by the caller.
+func (s *memoryStorage) userNameCheck(u *User) error {
+	key := userNameKey(u.Name)
+	s.usersMu.RLock()
+	defer s.usersMu.RUnlock()
+	if s.userNameTaken(u.ID, key) {
+		return ErrElementDuplicated
+	}
+	return nil
+}
+
+// userNameTaken reports if name with given key belongs to other user than the one with given ID.
+// Must be called with usersMu held.
+func (s *memoryStorage) userNameTaken(id, key string) bool {
+	o, found := s.names[key]
+	return found && o.ID != id
+}
+
+// userPut is a helper which stores user and moves its name in the index, so renamed user is not found by former name.
+// Name already taken by other user stays with that user.
+// Must be called with usersMu held.
+func (s *memoryStorage) userPut(u *User, key string) {
+	if prev, found := s.users[u.ID]; found {
+		s.userNameForget(prev)
+	}
 	s.users[u.ID] = u
+	if _, found := s.names[key]; !found {
+		s.names[key] = u
+	}
+}
 
+// userNameForget is a helper which removes name of the user from the index, unless it belongs to other user.
+// Must be called with usersMu held.
+func (s *memoryStorage) userNameForget(u *User) {
+	key := userNameKey(u.Name)
+	if o, found := s.names[key]; found && o.ID == u.ID {
+		delete(s.names, key)
+	}
+}
+
+// userRestore stores user read back from persistent storage without checking if the name is free.
+// Data written before names were unique may hold duplicates, name is then left with the user restored first.
+func (s *memoryStorage) userRestore(u *User) error {
+	if u.ID == "" {
+		return ErrElementIDNotSet
+	}
+	key := userNameKey(u.Name)
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+	s.userPut(u, key)
 	return nil
 }
 
 // UserLoad retrieves single user from storage by ID.
 // ErrElementNotFound is returned if element could not be found.
-func (s *memoryStorage) UserLoad(id string) (*User, error) {
+func (s *memoryStorage) UserLoad(ctx context.Context, id string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	s.usersMu.RLock()
 	defer s.usersMu.RUnlock()
 	u, found := s.users[id]
@@ -68,38 +220,285 @@ func (s *memoryStorage) UserLoad(id string) (*User, error) {
 	return u, nil
 }
 
-// UserFindByName retrieves single user entity from storage by its Name.
+// UserLoadMany retrieves users by IDs under single lock acquisition.
+// Users are returned in the order of ids. ErrElementNotFound is returned if any of them is missing.
+func (s *memoryStorage) UserLoadMany(ctx context.Context, ids []string) ([]*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.usersMu.RLock()
+	defer s.usersMu.RUnlock()
+	out := make([]*User, 0, len(ids))
+	for _, id := range ids {
+		u, found := s.users[id]
+		if !found {
+			return nil, ErrElementNotFound
+		}
+		out = append(out, u)
+	}
+	return out, nil
+}
+
+// UserUpdate replaces existing user.
+// ErrElementIDNotSet error is returned if user ID is not set.
+// ErrElementNotFound is returned if user could not be found.
+// ErrElementDuplicated error is returned if other user with the same name, up to the key (see userNameKey), exists.
+func (s *memoryStorage) UserUpdate(ctx context.Context, u *User) error {
+	if u.ID == "" {
+		return ErrElementIDNotSet
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	key := userNameKey(u.Name)
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+	if _, found := s.users[u.ID]; !found {
+		return ErrElementNotFound
+	}
+	if s.userNameTaken(u.ID, key) {
+		return ErrElementDuplicated
+	}
+	s.userPut(u, key)
+
+	return nil
+}
+
+// UserDelete removes user. Authored messages are handled according to the policy.
 // ErrElementNotFound is returned if user could not be found.
-// TODO: optimise me -> search is implemented as naive O(N) scan.
-func (s *memoryStorage) UserFindByName(name string) (*User, error) {
+// ErrElementInUse is returned if user authored any message and policy is UserDeleteReject.
+func (s *memoryStorage) UserDelete(ctx context.Context, id string, policy UserDeletePolicy) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+	s.messagesMu.Lock()
+	defer s.messagesMu.Unlock()
+
+	owned, err := s.userDeleteCheck(id, policy)
+	if err != nil {
+		return err
+	}
+
+	switch policy {
+	case UserDeleteCascade:
+		for _, m := range owned {
+			delete(s.messages, m.ID)
+			delete(s.revisions, m.ID)
+			s.tagRemoveMsg(m)
+			s.mentionRemoveMsg(m)
+			s.searchRemoveMsg(m)
+		}
+	case UserDeleteAnonymise:
+		// stored messages are shared, anonymised copies replace them
+		for _, m := range owned {
+			mA := *m
+			mA.AuthorID = ""
+			s.messages[m.ID] = &mA
+
+			revs := make([]*Message, 0, len(s.revisions[m.ID]))
+			for _, rev := range s.revisions[m.ID] {
+				revA := *rev
+				revA.AuthorID = ""
+				revs = append(revs, &revA)
+			}
+			if len(revs) > 0 {
+				s.revisions[m.ID] = revs
+			}
+		}
+	}
+	s.userNameForget(s.users[id])
+	delete(s.users, id)
+	s.mentionsMu.Lock()
+	delete(s.mentions, id)
+	s.mentionsMu.Unlock()
+
+	return nil
+}
+
+// userDeleteCheck verifies if user may be removed with given policy and returns messages authored by the user.
+// Must be called with both usersMu and messagesMu held.
+// TODO: optimise me -> search of authored messages is implemented as naive O(N) scan.
+func (s *memoryStorage) userDeleteCheck(id string, policy UserDeletePolicy) ([]*Message, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("Storage: %s", err)
+	}
+	if _, found := s.users[id]; !found {
+		return nil, ErrElementNotFound
+	}
+
+	var owned []*Message
+	for _, m := range s.messages {
+		if m.AuthorID == id {
+			owned = append(owned, m)
+		}
+	}
+	if policy == UserDeleteReject && len(owned) > 0 {
+		return nil, ErrElementInUse
+	}
+	return owned, nil
+}
+
+// UsersList returns up to limit users ordered by ID, starting after the one with given ID.
+// Empty after starts from the beginning. Non positive limit returns all of them.
+func (s *memoryStorage) UsersList(ctx context.Context, after string, limit int) ([]*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	s.usersMu.RLock()
 	defer s.usersMu.RUnlock()
-	for _, o := range s.users {
-		if o.Name == name {
-			return o, nil
+
+	out := make([]*User, 0)
+	for id, u := range s.users {
+		if id > after {
+			out = append(out, u)
 		}
 	}
-	return nil, ErrElementNotFound
+	sort.Sort(usersByID(out))
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// usersByID sorts users by ID.
+type usersByID []*User
+
+func (u usersByID) Len() int           { return len(u) }
+func (u usersByID) Less(i, j int) bool { return u[i].ID < u[j].ID }
+func (u usersByID) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+
+// UserFindByName retrieves single user entity from storage by its Name, compared by the key (see userNameKey).
+// ErrElementNotFound is returned if user could not be found.
+func (s *memoryStorage) UserFindByName(ctx context.Context, name string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// key is computed before the lock is taken, it's the costly part
+	key := userNameKey(name)
+	s.usersMu.RLock()
+	defer s.usersMu.RUnlock()
+	u, found := s.names[key]
+	if !found {
+		return nil, ErrElementNotFound
+	}
+	return u, nil
 }
 
 // MsgSave persists single message.
+// Previous version of the message is kept as its revision.
 // Error ErrElementIDNotSet is dispatched when message ID is not set.
-func (s *memoryStorage) MsgSave(m *Message) error {
+func (s *memoryStorage) MsgSave(ctx context.Context, m *Message) error {
 	if m.ID == "" {
 		return ErrElementIDNotSet
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	s.messagesMu.Lock()
 	defer s.messagesMu.Unlock()
-	s.messages[m.ID] = m
+	s.msgPut(m)
 
-	s.tagAddMsgID(m.Tag, m.ID)
+	return nil
+}
+
+// MsgUpdate replaces existing message.
+// Previous version of the message is kept as its revision.
+// Error ErrElementIDNotSet is dispatched when message ID is not set.
+// ErrElementNotFound is returned if message could not be found.
+func (s *memoryStorage) MsgUpdate(ctx context.Context, m *Message) error {
+	if m.ID == "" {
+		return ErrElementIDNotSet
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.messagesMu.Lock()
+	defer s.messagesMu.Unlock()
+	if _, found := s.messages[m.ID]; !found {
+		return ErrElementNotFound
+	}
+	s.msgPut(m)
+
+	return nil
+}
+
+// MsgDelete removes message along with its revisions and association to tags and mentioned users.
+// ErrElementNotFound is returned if message could not be found.
+func (s *memoryStorage) MsgDelete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.messagesMu.Lock()
+	defer s.messagesMu.Unlock()
+	m, found := s.messages[id]
+	if !found {
+		return ErrElementNotFound
+	}
+	delete(s.messages, id)
+	delete(s.revisions, id)
+	s.tagRemoveMsg(m)
+	s.mentionRemoveMsg(m)
+	s.searchRemoveMsg(m)
+
+	return nil
+}
+
+// msgRestore stores the message read back from the log, which may repeat version already restored from the snapshot.
+// Version which is already known, either current or archived, is skipped so that history is not duplicated.
+func (s *memoryStorage) msgRestore(m *Message) error {
+	if m.ID == "" {
+		return ErrElementIDNotSet
+	}
+	s.messagesMu.Lock()
+	defer s.messagesMu.Unlock()
+	if cur, found := s.messages[m.ID]; found {
+		if msgSameVersion(cur, m) {
+			return nil
+		}
+		for _, rev := range s.revisions[m.ID] {
+			if msgSameVersion(rev, m) {
+				return nil
+			}
+		}
+	}
+	s.msgPut(m)
 
 	return nil
 }
 
+// msgSameVersion reports whether messages are equal, with points in time compared regardless of their location.
+func msgSameVersion(a, b *Message) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) || !a.ModifiedAt.Equal(b.ModifiedAt) {
+		return false
+	}
+	aC := *a
+	aC.CreatedAt, aC.ModifiedAt = b.CreatedAt, b.ModifiedAt
+	return reflect.DeepEqual(&aC, b)
+}
+
+// msgPut stores the message archiving its previous version and moves it between tags, mentions and words if needed.
+// Must be called with messagesMu held.
+func (s *memoryStorage) msgPut(m *Message) {
+	if old, found := s.messages[m.ID]; found {
+		s.revisions[m.ID] = append(s.revisions[m.ID], old)
+		s.tagRemoveMsg(old)
+		s.mentionRemoveMsg(old)
+		s.searchRemoveMsg(old)
+	}
+	s.messages[m.ID] = m
+	s.tagAddMsg(m)
+	s.mentionAddMsg(m)
+	s.searchAddMsg(m)
+}
+
 // MsgLoad retrieves single message from storage by ID.
 // ErrElementNotFound is returned if message could not be found.
-func (s *memoryStorage) MsgLoad(id string) (*Message, error) {
+func (s *memoryStorage) MsgLoad(ctx context.Context, id string) (*Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	s.messagesMu.RLock()
 	defer s.messagesMu.RUnlock()
 	m, found := s.messages[id]
@@ -109,36 +508,411 @@ func (s *memoryStorage) MsgLoad(id string) (*Message, error) {
 	return m, nil
 }
 
-// tagAddMsgID is a helper which adds messageID to a tag
-func (s *memoryStorage) tagAddMsgID(tag Tag, mID string) {
+// MsgLoadMany retrieves messages by IDs under single lock acquisition.
+// Messages are returned in the order of ids. ErrElementNotFound is returned if any of them is missing.
+func (s *memoryStorage) MsgLoadMany(ctx context.Context, ids []string) ([]*Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.messagesMu.RLock()
+	defer s.messagesMu.RUnlock()
+	out := make([]*Message, 0, len(ids))
+	for _, id := range ids {
+		m, found := s.messages[id]
+		if !found {
+			return nil, ErrElementNotFound
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// MsgRevisions retrieves prior versions of the message, from the oldest.
+// Empty list is returned for message which was never edited.
+// ErrElementNotFound is returned if message could not be found.
+func (s *memoryStorage) MsgRevisions(ctx context.Context, id string) ([]*Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.messagesMu.RLock()
+	defer s.messagesMu.RUnlock()
+	if _, found := s.messages[id]; !found {
+		return nil, ErrElementNotFound
+	}
+	revs := s.revisions[id]
+	out := make([]*Message, len(revs))
+	copy(out, revs)
+	return out, nil
+}
+
+// tagAddMsg is a helper which adds message to the indexes of its tags
+func (s *memoryStorage) tagAddMsg(m *Message) {
 	s.tagsMu.Lock()
 	defer s.tagsMu.Unlock()
 
-	ts, found := s.tags[string(tag)]
-	if found {
-		ts.Add(mID)
-		return
+	for _, t := range m.Tags {
+		idx, found := s.tags[t.Key()]
+		if !found {
+			idx = &msgIndex{}
+			s.tags[t.Key()] = idx
+		}
+		idx.Add(MsgCursorOf(m))
 	}
+}
 
-	s.tags[string(tag)] = set.New(mID)
+// tagRemoveMsg is a helper which removes message from the indexes of its tags.
+// Tag without messages is forgotten.
+func (s *memoryStorage) tagRemoveMsg(m *Message) {
+	s.tagsMu.Lock()
+	defer s.tagsMu.Unlock()
+
+	for _, t := range m.Tags {
+		idx, found := s.tags[t.Key()]
+		if !found {
+			continue
+		}
+		idx.Remove(MsgCursorOf(m))
+		if idx.Len() == 0 {
+			delete(s.tags, t.Key())
+		}
+	}
 }
 
-// MsgsIDsFindByTag returns list of ids of messages associated with given tag.
+// mentionAddMsg is a helper which adds message to the indexes of users mentioned in it.
+func (s *memoryStorage) mentionAddMsg(m *Message) {
+	s.mentionsMu.Lock()
+	defer s.mentionsMu.Unlock()
+
+	for _, id := range m.Mentions {
+		idx, found := s.mentions[id]
+		if !found {
+			idx = &msgIndex{}
+			s.mentions[id] = idx
+		}
+		idx.Add(MsgCursorOf(m))
+	}
+}
+
+// mentionRemoveMsg is a helper which removes message from the indexes of users mentioned in it.
+// User who is not mentioned anymore is forgotten.
+func (s *memoryStorage) mentionRemoveMsg(m *Message) {
+	s.mentionsMu.Lock()
+	defer s.mentionsMu.Unlock()
+
+	for _, id := range m.Mentions {
+		idx, found := s.mentions[id]
+		if !found {
+			continue
+		}
+		idx.Remove(MsgCursorOf(m))
+		if idx.Len() == 0 {
+			delete(s.mentions, id)
+		}
+	}
+}
+
+// searchAddMsg is a helper which adds words of the message body to the search index.
+func (s *memoryStorage) searchAddMsg(m *Message) {
+	s.searchMu.Lock()
+	defer s.searchMu.Unlock()
+	s.search.Add(m)
+}
+
+// searchRemoveMsg is a helper which removes words of the message body from the search index.
+func (s *memoryStorage) searchRemoveMsg(m *Message) {
+	s.searchMu.Lock()
+	defer s.searchMu.Unlock()
+	s.search.Remove(m)
+}
+
+// MsgsIDsFindByTag returns up to limit ids of messages associated with given tag, starting after the cursor.
+// Messages are ordered from the newest to the oldest. Non positive limit returns all of them.
 // ErrElementNotFound is returned if tag is unknown (no message is associated)
-func (s *memoryStorage) MsgsIDsFindByTag(tag Tag) ([]string, error) {
+func (s *memoryStorage) MsgsIDsFindByTag(ctx context.Context, tag Tag, after MsgCursor, limit int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return []string{}, err
+	}
 	s.tagsMu.RLock()
 	defer s.tagsMu.RUnlock()
 
-	ts, found := s.tags[string(tag)]
+	idx, found := s.tags[tag.Key()]
 	if !found {
 		return []string{}, ErrElementNotFound
 	}
 
-	out := make([]string, 0, ts.Size())
-	ts.Each(func(item interface{}) bool {
-		out = append(out, item.(string))
-		return true
+	return idx.IDsAfter(after, limit), nil
+}
+
+// MsgsIDsFindByTags returns up to limit ids of messages matching the query, starting after the cursor.
+// Messages are ordered from the newest to the oldest. Non positive limit returns all of them.
+// The smallest index of All tags is scanned and the others are probed, union of Any indexes is scanned otherwise.
+// ErrElementNotFound is returned if any of All tags or each of Any tags is unknown, or query is empty.
+func (s *memoryStorage) MsgsIDsFindByTags(ctx context.Context, q MsgTagQuery, after MsgCursor, limit int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return []string{}, err
+	}
+	if q.IsEmpty() {
+		return []string{}, ErrElementNotFound
+	}
+	s.tagsMu.RLock()
+	defer s.tagsMu.RUnlock()
+
+	var all, some, none []*msgIndex
+	for _, t := range q.All {
+		idx, found := s.tags[t.Key()]
+		if !found {
+			return []string{}, ErrElementNotFound
+		}
+		all = append(all, idx)
+	}
+	for _, t := range q.Any {
+		if idx, found := s.tags[t.Key()]; found {
+			some = append(some, idx)
+		}
+	}
+	if len(q.Any) > 0 && len(some) == 0 {
+		return []string{}, ErrElementNotFound
+	}
+	for _, t := range q.Not {
+		if idx, found := s.tags[t.Key()]; found {
+			none = append(none, idx)
+		}
+	}
+
+	scanned := some
+	if len(all) > 0 {
+		smallest := 0
+		for i, idx := range all {
+			if idx.Len() < all[smallest].Len() {
+				smallest = i
+			}
+		}
+		scanned = all[smallest : smallest+1]
+		all = append(append([]*msgIndex(nil), all[:smallest]...), all[smallest+1:]...)
+	} else {
+		// scanned positions are known to be in one of them
+		some = nil
+	}
+
+	out := []string{}
+	var err error
+	i := 0
+	msgIndexesScan(scanned, after, func(c MsgCursor) bool {
+		// scan may be long, give up when the caller is gone
+		if i++; i%memoryStorageCtxCheckEvery == 0 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+		}
+		if msgIndexesContainAll(all, c) && (len(some) == 0 || msgIndexesContainAny(some, c)) && !msgIndexesContainAny(none, c) {
+			out = append(out, c.ID)
+		}
+		return limit <= 0 || len(out) < limit
 	})
+	if err != nil {
+		return []string{}, err
+	}
+	return out, nil
+}
+
+// msgIndexesContainAll reports whether position is in each of the indexes.
+func msgIndexesContainAll(idxs []*msgIndex, c MsgCursor) bool {
+	for _, idx := range idxs {
+		if !idx.Contains(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// msgIndexesContainAny reports whether position is in at least one of the indexes.
+func msgIndexesContainAny(idxs []*msgIndex, c MsgCursor) bool {
+	for _, idx := range idxs {
+		if idx.Contains(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// MsgsIDsFindByMention returns up to limit ids of messages mentioning the user, starting after the cursor.
+// Messages are ordered from the newest to the oldest. Non positive limit returns all of them.
+// Empty list is returned if the user is not mentioned in any message.
+func (s *memoryStorage) MsgsIDsFindByMention(ctx context.Context, userID string, after MsgCursor, limit int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return []string{}, err
+	}
+	s.mentionsMu.RLock()
+	defer s.mentionsMu.RUnlock()
+
+	idx, found := s.mentions[userID]
+	if !found {
+		return []string{}, nil
+	}
+	return idx.IDsAfter(after, limit), nil
+}
+
+// MsgsIDsFindByAuthor returns up to limit ids of messages authored by the user, starting after the cursor.
+// Messages are ordered from the newest to the oldest. Non positive limit returns all of them.
+// Empty list is returned if the user authored no message.
+// TODO: optimise me -> messages are not indexed by author, so they're found with naive O(N) scan.
+func (s *memoryStorage) MsgsIDsFindByAuthor(ctx context.Context, authorID string, after MsgCursor, limit int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return []string{}, err
+	}
+	s.messagesMu.RLock()
+	defer s.messagesMu.RUnlock()
+
+	idx := &msgIndex{}
+	for _, m := range s.messages {
+		if m.AuthorID == authorID {
+			idx.Add(MsgCursorOf(m))
+		}
+	}
+	return idx.IDsAfter(after, limit), nil
+}
+
+// MsgsSearch returns up to limit messages matching the query, skipping offset of them.
+// Messages are ordered by relevance to the terms (BM25 over the index of words), equally relevant ones from the newest.
+// Query without terms only filters messages, so they are all ordered from the newest. Non positive limit returns all of them.
+func (s *memoryStorage) MsgsSearch(ctx context.Context, q MsgSearchQuery, offset, limit int) ([]MsgSearchHit, error) {
+	if err := ctx.Err(); err != nil {
+		return []MsgSearchHit{}, err
+	}
+	s.messagesMu.RLock()
+	defer s.messagesMu.RUnlock()
+	s.searchMu.RLock()
+	defer s.searchMu.RUnlock()
+
+	var scores map[string]float64
+	if len(q.Terms) > 0 {
+		scores = s.search.Match(q.Terms)
+	} else {
+		scores = make(map[string]float64, len(s.messages))
+		for id := range s.messages {
+			scores[id] = 0
+		}
+	}
+
+	ranked := make(msgSearchRanking, 0, len(scores))
+	i := 0
+	for id, score := range scores {
+		// scan may be long, give up when the caller is gone
+		if i++; i%memoryStorageCtxCheckEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return []MsgSearchHit{}, err
+			}
+		}
+		m, found := s.messages[id]
+		if !found || !q.MatchesFilters(m) {
+			continue
+		}
+		ranked = append(ranked, msgSearchRanked{MsgSearchHit: MsgSearchHit{ID: id, Score: score}, at: MsgCursorOf(m)})
+	}
+	sort.Sort(ranked)
+
+	if offset > len(ranked) {
+		offset = len(ranked)
+	}
+	if offset > 0 {
+		ranked = ranked[offset:]
+	}
+	if limit > 0 && len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	out := make([]MsgSearchHit, 0, len(ranked))
+	for _, r := range ranked {
+		out = append(out, r.MsgSearchHit)
+	}
+	return out, nil
+}
 
+// WebhookSave persists single webhook.
+// ErrElementIDNotSet error is returned if webhook ID is not set.
+func (s *memoryStorage) WebhookSave(ctx context.Context, wh *Webhook) error {
+	if wh.ID == "" {
+		return ErrElementIDNotSet
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+	s.webhooks[wh.ID] = wh
+
+	return nil
+}
+
+// WebhookLoad retrieves single webhook from storage by ID.
+// ErrElementNotFound is returned if webhook could not be found.
+func (s *memoryStorage) WebhookLoad(ctx context.Context, id string) (*Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.webhooksMu.RLock()
+	defer s.webhooksMu.RUnlock()
+	wh, found := s.webhooks[id]
+	if !found {
+		return nil, ErrElementNotFound
+	}
+	return wh, nil
+}
+
+// WebhookUpdate replaces existing webhook.
+// ErrElementIDNotSet error is returned if webhook ID is not set.
+// ErrElementNotFound is returned if webhook could not be found.
+func (s *memoryStorage) WebhookUpdate(ctx context.Context, wh *Webhook) error {
+	if wh.ID == "" {
+		return ErrElementIDNotSet
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+	if _, found := s.webhooks[wh.ID]; !found {
+		return ErrElementNotFound
+	}
+	s.webhooks[wh.ID] = wh
+
+	return nil
+}
+
+// WebhookDelete removes webhook.
+// ErrElementNotFound is returned if webhook could not be found.
+func (s *memoryStorage) WebhookDelete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.webhooksMu.Lock()
+	defer s.webhooksMu.Unlock()
+	if _, found := s.webhooks[id]; !found {
+		return ErrElementNotFound
+	}
+	delete(s.webhooks, id)
+
+	return nil
+}
+
+// WebhooksList returns all webhooks ordered by ID.
+func (s *memoryStorage) WebhooksList(ctx context.Context) ([]*Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.webhooksMu.RLock()
+	defer s.webhooksMu.RUnlock()
+
+	out := make([]*Webhook, 0, len(s.webhooks))
+	for _, wh := range s.webhooks {
+		out = append(out, wh)
+	}
+	sort.Sort(webhooksByID(out))
 	return out, nil
 }
+
+// webhooksByID sorts webhooks by ID.
+type webhooksByID []*Webhook
+
+func (w webhooksByID) Len() int           { return len(w) }
+func (w webhooksByID) Less(i, j int) bool { return w[i].ID < w[j].ID }
+func (w webhooksByID) Swap(i, j int)      { w[i], w[j] = w[j], w[i] }
@@ -0,0 +1,119 @@
+package main
+
+import "sort"
+
+// msgIndex keeps positions of messages ordered from the newest to the oldest.
+// It allows for range scans starting at any cursor. Not thread safe.
+type msgIndex struct {
+	// items is a list of positions ordered with MsgCursor.Before.
+	items []MsgCursor
+}
+
+// search returns index of the first item not listed before c.
+func (idx *msgIndex) search(c MsgCursor) int {
+	return sort.Search(len(idx.items), func(i int) bool {
+		return !idx.items[i].Before(c)
+	})
+}
+
+// Add puts position into the index keeping it ordered.
+func (idx *msgIndex) Add(c MsgCursor) {
+	i := idx.search(c)
+	if i < len(idx.items) && msgCursorEqual(idx.items[i], c) {
+		return
+	}
+	idx.items = append(idx.items, MsgCursor{})
+	copy(idx.items[i+1:], idx.items[i:])
+	idx.items[i] = c
+}
+
+// Remove drops position from the index. Unknown positions are ignored.
+func (idx *msgIndex) Remove(c MsgCursor) {
+	i := idx.search(c)
+	if i == len(idx.items) || !msgCursorEqual(idx.items[i], c) {
+		return
+	}
+	idx.items = append(idx.items[:i], idx.items[i+1:]...)
+}
+
+// Len returns number of positions in the index.
+func (idx *msgIndex) Len() int {
+	return len(idx.items)
+}
+
+// Contains reports whether position is in the index.
+func (idx *msgIndex) Contains(c MsgCursor) bool {
+	i := idx.search(c)
+	return i < len(idx.items) && msgCursorEqual(idx.items[i], c)
+}
+
+// after returns positions listed after the cursor. Zero cursor starts from the newest message.
+// Returned slice is shared with the index.
+func (idx *msgIndex) after(c MsgCursor) []MsgCursor {
+	i := 0
+	if !c.IsZero() {
+		i = idx.search(c)
+		if i < len(idx.items) && msgCursorEqual(idx.items[i], c) {
+			i++
+		}
+	}
+	return idx.items[i:]
+}
+
+// IDsAfter returns up to limit message IDs listed after the cursor.
+// Zero cursor starts from the newest message. Non positive limit returns all of them.
+func (idx *msgIndex) IDsAfter(after MsgCursor, limit int) []string {
+	items := idx.after(after)
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+
+	out := make([]string, 0, len(items))
+	for _, c := range items {
+		out = append(out, c.ID)
+	}
+	return out
+}
+
+// msgCursorEqual checks if both cursors point at the same position.
+// Times are compared as instants as they may come with different locations.
+func msgCursorEqual(c, o MsgCursor) bool {
+	return c.ID == o.ID && c.CreatedAt.Equal(o.CreatedAt)
+}
+
+// msgIndexesScan visits positions held by any of the indexes and listed after the cursor, in order and once each.
+// Scan stops when visit returns false.
+func msgIndexesScan(idxs []*msgIndex, after MsgCursor, visit func(c MsgCursor) bool) {
+	heads := make([][]MsgCursor, 0, len(idxs))
+	for _, idx := range idxs {
+		if items := idx.after(after); len(items) > 0 {
+			heads = append(heads, items)
+		}
+	}
+
+	for len(heads) > 0 {
+		first := 0
+		for i := 1; i < len(heads); i++ {
+			if heads[i][0].Before(heads[first][0]) {
+				first = i
+			}
+		}
+		c := heads[first][0]
+		if !visit(c) {
+			return
+		}
+
+		// position is dropped from all heads holding it, exhausted ones are forgotten
+		n := 0
+		for _, h := range heads {
+			if msgCursorEqual(h[0], c) {
+				h = h[1:]
+			}
+			if len(h) > 0 {
+				heads[n] = h
+				n++
+			}
+		}
+		heads = heads[:n]
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	a "github.com/stretchr/testify/assert"
+)
+
+func Test_MsgIndex_Add_Duplicate(t *testing.T) {
+	idx := &msgIndex{}
+	idx.Add(MsgCursorOf(&tfMsgAA))
+	idx.Add(MsgCursorOf(&tfMsgAB))
+
+	// WHEN: same position is added again, with time in other location
+	c := MsgCursorOf(&tfMsgAA)
+	c.CreatedAt = c.CreatedAt.In(time.FixedZone("UTC+2", 2*60*60))
+	idx.Add(c)
+
+	a.Equal(t, 2, idx.Len(), "position duplicated")
+	a.Equal(t, []string{tfMsgAB.ID, tfMsgAA.ID}, idx.IDsAfter(MsgCursor{}, 0))
+}
+
+func Test_MsgIndex_Remove(t *testing.T) {
+	idx := &msgIndex{}
+	for _, m := range []Message{tfMsgAA, tfMsgAB, tfMsgBA} {
+		idx.Add(MsgCursorOf(&m))
+	}
+
+	// WHEN: existing and unknown positions are removed
+	idx.Remove(MsgCursorOf(&tfMsgAB))
+	idx.Remove(MsgCursorOf(&tfMsgBB))
+
+	a.Equal(t, []string{tfMsgBA.ID, tfMsgAA.ID}, idx.IDsAfter(MsgCursor{}, 0))
+}
+
+func Test_MsgIndex_IDsAfter_UnknownCursor(t *testing.T) {
+	idx := &msgIndex{}
+	for _, m := range []Message{tfMsgAA, tfMsgAB, tfMsgBA} {
+		idx.Add(MsgCursorOf(&m))
+	}
+
+	// WHEN: message pointed by cursor is gone in the meantime
+	after := MsgCursorOf(&tfMsgAB)
+	idx.Remove(after)
+
+	// THEN: listing continues from its former position
+	a.Equal(t, []string{tfMsgAA.ID}, idx.IDsAfter(after, 0))
+}
+
+func Test_MsgIndex_Contains(t *testing.T) {
+	idx := &msgIndex{}
+	idx.Add(MsgCursorOf(&tfMsgAA))
+
+	a.True(t, idx.Contains(MsgCursorOf(&tfMsgAA)), "added position not found")
+	a.False(t, idx.Contains(MsgCursorOf(&tfMsgAB)), "unknown position found")
+}
+
+func Test_MsgIndexesScan(t *testing.T) {
+	idxA, idxB := &msgIndex{}, &msgIndex{}
+	for _, m := range []Message{tfMsgAA, tfMsgAB, tfMsgBA, tfMsgAC} {
+		idxA.Add(MsgCursorOf(&m))
+	}
+	for _, m := range []Message{tfMsgBB, tfMsgAC} {
+		idxB.Add(MsgCursorOf(&m))
+	}
+	scan := func(after MsgCursor, limit int) []string {
+		var ids []string
+		msgIndexesScan([]*msgIndex{idxA, idxB, {}}, after, func(c MsgCursor) bool {
+			ids = append(ids, c.ID)
+			return len(ids) < limit
+		})
+		return ids
+	}
+
+	// THEN: positions from both indexes are merged, shared ones visited once
+	a.Equal(t, []string{tfMsgAC.ID, tfMsgBB.ID, tfMsgBA.ID, tfMsgAB.ID, tfMsgAA.ID}, scan(MsgCursor{}, 10), "all")
+
+	// AND: scan starts after cursor and stops when asked
+	a.Equal(t, []string{tfMsgBA.ID, tfMsgAB.ID}, scan(MsgCursorOf(&tfMsgBB), 2), "after cursor")
+}
@@ -1,80 +1,248 @@
 package main
 
+import (
+	"context"
+	"sync"
+)
+
 // tmMemoryStorageMock is a wrapped MemoryStorage with tracking/custom error capabilities for testing
 type tmMemoryStorageMock struct {
 	*memoryStorage
 
+	// mu protects call tracking flags from concurrent callers
+	mu sync.Mutex
+
 	inUserSaveCalled bool
 	outUserSaveErr   error
 
 	inUserLoadCalled bool
 	outUserLoadErr   error
 
+	inUserLoadManyCalled bool
+	outUserLoadManyErr   error
+
 	inUserFindCalled bool
 	outUserFindErr   error
 
+	inUserUpdateCalled bool
+	outUserUpdateErr   error
+
+	inUserDeleteCalled bool
+	outUserDeleteErr   error
+
+	inUsersListCalled bool
+	outUsersListErr   error
+
 	inMsgSaveCalled bool
 	outMsgSaveErr   error
 
 	inMsgLoadCalled bool
 	outMsgLoadErr   error
 
+	inMsgLoadManyCalled bool
+	outMsgLoadManyErr   error
+
+	inMsgUpdateCalled bool
+	outMsgUpdateErr   error
+
+	inMsgDeleteCalled bool
+	outMsgDeleteErr   error
+
+	inMsgRevisionsCalled bool
+	outMsgRevisionsErr   error
+
 	inMsgFindCalled bool
 	outMsgFindErr   error
+
+	inMsgFindByMentionCalled bool
+	outMsgFindByMentionErr   error
+
+	inMsgFindByAuthorCalled bool
+	outMsgFindByAuthorErr   error
+
+	inMsgsSearchCalled bool
+	outMsgsSearchErr   error
+
+	inPingCalled bool
+	outPingErr   error
 }
 
-func (s *tmMemoryStorageMock) UserSave(u *User) error {
-	s.inUserSaveCalled = true
+func (s *tmMemoryStorageMock) UserSave(ctx context.Context, u *User) error {
+	s.called(&s.inUserSaveCalled)
 
 	if s.outUserSaveErr != nil {
 		return s.outUserSaveErr
 	}
-	return s.memoryStorage.UserSave(u)
+	return s.memoryStorage.UserSave(ctx, u)
 }
 
-func (s *tmMemoryStorageMock) UserLoad(id string) (*User, error) {
-	s.inUserLoadCalled = true
+func (s *tmMemoryStorageMock) UserLoad(ctx context.Context, id string) (*User, error) {
+	s.called(&s.inUserLoadCalled)
 
 	if s.outUserLoadErr != nil {
 		return nil, s.outUserLoadErr
 	}
-	return s.memoryStorage.UserLoad(id)
+	return s.memoryStorage.UserLoad(ctx, id)
+}
+
+func (s *tmMemoryStorageMock) UserLoadMany(ctx context.Context, ids []string) ([]*User, error) {
+	s.called(&s.inUserLoadManyCalled)
+
+	if s.outUserLoadManyErr != nil {
+		return nil, s.outUserLoadManyErr
+	}
+	return s.memoryStorage.UserLoadMany(ctx, ids)
 }
 
-func (s *tmMemoryStorageMock) UserFindByName(name string) (*User, error) {
-	s.inUserFindCalled = true
+func (s *tmMemoryStorageMock) UserFindByName(ctx context.Context, name string) (*User, error) {
+	s.called(&s.inUserFindCalled)
 
 	if s.outUserFindErr != nil {
 		return nil, s.outUserFindErr
 	}
-	return s.memoryStorage.UserFindByName(name)
+	return s.memoryStorage.UserFindByName(ctx, name)
 }
 
-func (s *tmMemoryStorageMock) MsgSave(m *Message) error {
-	s.inMsgSaveCalled = true
+func (s *tmMemoryStorageMock) UserUpdate(ctx context.Context, u *User) error {
+	s.called(&s.inUserUpdateCalled)
+
+	if s.outUserUpdateErr != nil {
+		return s.outUserUpdateErr
+	}
+	return s.memoryStorage.UserUpdate(ctx, u)
+}
+
+func (s *tmMemoryStorageMock) UserDelete(ctx context.Context, id string, policy UserDeletePolicy) error {
+	s.called(&s.inUserDeleteCalled)
+
+	if s.outUserDeleteErr != nil {
+		return s.outUserDeleteErr
+	}
+	return s.memoryStorage.UserDelete(ctx, id, policy)
+}
+
+func (s *tmMemoryStorageMock) UsersList(ctx context.Context, after string, limit int) ([]*User, error) {
+	s.called(&s.inUsersListCalled)
+
+	if s.outUsersListErr != nil {
+		return nil, s.outUsersListErr
+	}
+	return s.memoryStorage.UsersList(ctx, after, limit)
+}
+
+func (s *tmMemoryStorageMock) MsgSave(ctx context.Context, m *Message) error {
+	s.called(&s.inMsgSaveCalled)
 
 	if s.outMsgSaveErr != nil {
 		return s.outMsgSaveErr
 	}
-	return s.memoryStorage.MsgSave(m)
+	return s.memoryStorage.MsgSave(ctx, m)
 }
 
-func (s *tmMemoryStorageMock) MsgLoad(id string) (*Message, error) {
-	s.inMsgLoadCalled = true
+func (s *tmMemoryStorageMock) MsgLoad(ctx context.Context, id string) (*Message, error) {
+	s.called(&s.inMsgLoadCalled)
 
 	if s.outMsgLoadErr != nil {
 		return nil, s.outMsgLoadErr
 	}
-	return s.memoryStorage.MsgLoad(id)
+	return s.memoryStorage.MsgLoad(ctx, id)
+}
+
+func (s *tmMemoryStorageMock) MsgLoadMany(ctx context.Context, ids []string) ([]*Message, error) {
+	s.called(&s.inMsgLoadManyCalled)
+
+	if s.outMsgLoadManyErr != nil {
+		return nil, s.outMsgLoadManyErr
+	}
+	return s.memoryStorage.MsgLoadMany(ctx, ids)
+}
+
+func (s *tmMemoryStorageMock) MsgUpdate(ctx context.Context, m *Message) error {
+	s.called(&s.inMsgUpdateCalled)
+
+	if s.outMsgUpdateErr != nil {
+		return s.outMsgUpdateErr
+	}
+	return s.memoryStorage.MsgUpdate(ctx, m)
+}
+
+func (s *tmMemoryStorageMock) MsgDelete(ctx context.Context, id string) error {
+	s.called(&s.inMsgDeleteCalled)
+
+	if s.outMsgDeleteErr != nil {
+		return s.outMsgDeleteErr
+	}
+	return s.memoryStorage.MsgDelete(ctx, id)
+}
+
+func (s *tmMemoryStorageMock) MsgRevisions(ctx context.Context, id string) ([]*Message, error) {
+	s.called(&s.inMsgRevisionsCalled)
+
+	if s.outMsgRevisionsErr != nil {
+		return nil, s.outMsgRevisionsErr
+	}
+	return s.memoryStorage.MsgRevisions(ctx, id)
 }
 
-func (s *tmMemoryStorageMock) MsgsIDsFindByTag(tag Tag) ([]string, error) {
-	s.inMsgFindCalled = true
+func (s *tmMemoryStorageMock) MsgsIDsFindByTag(ctx context.Context, tag Tag, after MsgCursor, limit int) ([]string, error) {
+	s.called(&s.inMsgFindCalled)
 
 	if s.outMsgFindErr != nil {
 		return []string{}, s.outMsgFindErr
 	}
-	return s.memoryStorage.MsgsIDsFindByTag(tag)
+	return s.memoryStorage.MsgsIDsFindByTag(ctx, tag, after, limit)
+}
+
+func (s *tmMemoryStorageMock) MsgsIDsFindByTags(ctx context.Context, q MsgTagQuery, after MsgCursor, limit int) ([]string, error) {
+	s.called(&s.inMsgFindCalled)
+
+	if s.outMsgFindErr != nil {
+		return []string{}, s.outMsgFindErr
+	}
+	return s.memoryStorage.MsgsIDsFindByTags(ctx, q, after, limit)
+}
+
+func (s *tmMemoryStorageMock) MsgsIDsFindByMention(ctx context.Context, userID string, after MsgCursor, limit int) ([]string, error) {
+	s.called(&s.inMsgFindByMentionCalled)
+
+	if s.outMsgFindByMentionErr != nil {
+		return []string{}, s.outMsgFindByMentionErr
+	}
+	return s.memoryStorage.MsgsIDsFindByMention(ctx, userID, after, limit)
+}
+
+func (s *tmMemoryStorageMock) MsgsIDsFindByAuthor(ctx context.Context, authorID string, after MsgCursor, limit int) ([]string, error) {
+	s.called(&s.inMsgFindByAuthorCalled)
+
+	if s.outMsgFindByAuthorErr != nil {
+		return []string{}, s.outMsgFindByAuthorErr
+	}
+	return s.memoryStorage.MsgsIDsFindByAuthor(ctx, authorID, after, limit)
+}
+
+func (s *tmMemoryStorageMock) MsgsSearch(ctx context.Context, q MsgSearchQuery, offset, limit int) ([]MsgSearchHit, error) {
+	s.called(&s.inMsgsSearchCalled)
+
+	if s.outMsgsSearchErr != nil {
+		return []MsgSearchHit{}, s.outMsgsSearchErr
+	}
+	return s.memoryStorage.MsgsSearch(ctx, q, offset, limit)
+}
+
+func (s *tmMemoryStorageMock) Ping(ctx context.Context) error {
+	s.called(&s.inPingCalled)
+
+	if s.outPingErr != nil {
+		return s.outPingErr
+	}
+	return s.memoryStorage.Ping(ctx)
+}
+
+// called marks tracking flag
+func (s *tmMemoryStorageMock) called(flag *bool) {
+	s.mu.Lock()
+	*flag = true
+	s.mu.Unlock()
 }
 
 func NewTmMemoryStorageMock() *tmMemoryStorageMock {
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// Parameters of BM25 ranking function, see https://en.wikipedia.org/wiki/Okapi_BM25.
+const (
+	// searchBM25K1 limits impact of the word repeated in the message.
+	searchBM25K1 = 1.2
+
+	// searchBM25B decides how much longer messages are penalised.
+	searchBM25B = 0.75
+)
+
+// searchIndex is an inverted index of words of message bodies. Messages matching the terms are ranked with BM25.
+// Not thread safe.
+type searchIndex struct {
+	// postings keeps positions of the words in messages, in increasing order.
+	// Keyed by the key of the word (see msgSearchTokens), then by Message.ID.
+	postings map[string]map[string][]int
+
+	// words lists keys of postings in order, so words starting with a prefix are next to each other.
+	words []string
+
+	// lengths keeps number of words of indexed messages.
+	// Keyed by Message.ID.
+	lengths map[string]int
+
+	// total is a number of words of all indexed messages.
+	total int
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[string][]int),
+		lengths:  make(map[string]int),
+	}
+}
+
+// Add indexes words of the message body. Message must not be indexed already.
+func (idx *searchIndex) Add(m *Message) {
+	toks := msgSearchTokens(m.Body)
+	idx.lengths[m.ID] = len(toks)
+	idx.total += len(toks)
+	for i, t := range toks {
+		p, found := idx.postings[t.Key]
+		if !found {
+			p = make(map[string][]int)
+			idx.postings[t.Key] = p
+			idx.wordAdd(t.Key)
+		}
+		p[m.ID] = append(p[m.ID], i)
+	}
+}
+
+// Remove drops words of the message from the index. Unknown messages are ignored.
+// Message must be the one which was indexed, as its words are found in its body. Word not used anymore is forgotten.
+func (idx *searchIndex) Remove(m *Message) {
+	n, found := idx.lengths[m.ID]
+	if !found {
+		return
+	}
+	delete(idx.lengths, m.ID)
+	idx.total -= n
+
+	for _, t := range msgSearchTokens(m.Body) {
+		p, found := idx.postings[t.Key]
+		if !found {
+			continue
+		}
+		delete(p, m.ID)
+		if len(p) == 0 {
+			delete(idx.postings, t.Key)
+			idx.wordRemove(t.Key)
+		}
+	}
+}
+
+// wordAdd puts new word into the ordered list of words.
+func (idx *searchIndex) wordAdd(w string) {
+	i := sort.SearchStrings(idx.words, w)
+	idx.words = append(idx.words, "")
+	copy(idx.words[i+1:], idx.words[i:])
+	idx.words[i] = w
+}
+
+// wordRemove drops word from the ordered list of words.
+func (idx *searchIndex) wordRemove(w string) {
+	i := sort.SearchStrings(idx.words, w)
+	if i < len(idx.words) && idx.words[i] == w {
+		idx.words = append(idx.words[:i], idx.words[i+1:]...)
+	}
+}
+
+// Len returns number of indexed messages.
+func (idx *searchIndex) Len() int {
+	return len(idx.lengths)
+}
+
+// Match returns BM25 scores of messages containing all of the terms, keyed by Message.ID.
+// Scores of the terms are summed up. Nil is returned if there are no terms.
+func (idx *searchIndex) Match(terms []MsgSearchTerm) map[string]float64 {
+	var scores map[string]float64
+	for _, t := range terms {
+		freqs := idx.termFreqs(t)
+		next := make(map[string]float64, len(freqs))
+		for id, tf := range freqs {
+			score, found := scores[id]
+			if scores != nil && !found {
+				continue
+			}
+			next[id] = score + idx.bm25(tf, len(freqs), idx.lengths[id])
+		}
+		scores = next
+		if len(scores) == 0 {
+			break
+		}
+	}
+	return scores
+}
+
+// termFreqs returns number of occurrences of the term in each message containing it, keyed by Message.ID.
+func (idx *searchIndex) termFreqs(t MsgSearchTerm) map[string]int {
+	positions := make([]map[string][]int, len(t.Words))
+	for i, w := range t.Words {
+		if t.Prefix && i == len(t.Words)-1 {
+			positions[i] = idx.prefixPostings(w)
+		} else {
+			positions[i] = idx.postings[w]
+		}
+		if len(positions[i]) == 0 {
+			return nil
+		}
+	}
+
+	out := make(map[string]int)
+	for id, first := range positions[0] {
+		tf := 0
+		for _, p := range first {
+			if searchPhraseAt(positions, id, p) {
+				tf++
+			}
+		}
+		if tf > 0 {
+			out[id] = tf
+		}
+	}
+	return out
+}
+
+// prefixPostings returns positions of all words starting with the prefix, as if they were a single word.
+func (idx *searchIndex) prefixPostings(prefix string) map[string][]int {
+	out := make(map[string][]int)
+	merged := make(map[string]bool)
+	for i := sort.SearchStrings(idx.words, prefix); i < len(idx.words) && strings.HasPrefix(idx.words[i], prefix); i++ {
+		for id, ps := range idx.postings[idx.words[i]] {
+			if _, found := out[id]; found {
+				merged[id] = true
+			}
+			out[id] = append(out[id], ps...)
+		}
+	}
+	for id := range merged {
+		sort.Ints(out[id])
+	}
+	return out
+}
+
+// searchPhraseAt reports whether phrase with the first word at position p is in the message,
+// i.e. each next word is at the next position.
+func searchPhraseAt(positions []map[string][]int, id string, p int) bool {
+	for i := 1; i < len(positions); i++ {
+		ps := positions[i][id]
+		j := sort.SearchInts(ps, p+i)
+		if j == len(ps) || ps[j] != p+i {
+			return false
+		}
+	}
+	return true
+}
+
+// bm25 scores message of given length containing the term tf times, where df messages contain the term.
+func (idx *searchIndex) bm25(tf, df, length int) float64 {
+	n := float64(len(idx.lengths))
+	avg := float64(idx.total) / n
+	idf := math.Log(1 + (n-float64(df)+0.5)/(float64(df)+0.5))
+	f := float64(tf)
+	return idf * f * (searchBM25K1 + 1) / (f + searchBM25K1*(1-searchBM25B+searchBM25B*float64(length)/avg))
+}
+
+// msgSearchRanking orders hits by their score, from the highest. Hits scored equally are ordered from the newest.
+type msgSearchRanking []msgSearchRanked
+
+// msgSearchRanked is a hit along with the position of the message.
+type msgSearchRanked struct {
+	MsgSearchHit
+	at MsgCursor
+}
+
+func (r msgSearchRanking) Len() int      { return len(r) }
+func (r msgSearchRanking) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r msgSearchRanking) Less(i, j int) bool {
+	if r[i].Score != r[j].Score {
+		return r[i].Score > r[j].Score
+	}
+	return r[i].at.Before(r[j].at)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sort"
+	"testing"
+
+	a "github.com/stretchr/testify/assert"
+)
+
+func tsSearchIndexSetup(bodies map[string]string) *searchIndex {
+	idx := newSearchIndex()
+	for id, body := range bodies {
+		idx.Add(&Message{ID: id, Body: body})
+	}
+	return idx
+}
+
+func tsSearchIndexMatchIDs(idx *searchIndex, q string) []string {
+	sq, _, _ := msgSearchParse(q)
+	var ids []string
+	for id := range idx.Match(sq.Terms) {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func Test_SearchIndex_Add(t *testing.T) {
+	idx := tsSearchIndexSetup(map[string]string{
+		"A": "Deploy is done",
+		"B": "deploy again, DEPLOY",
+	})
+
+	a.Equal(t, 2, idx.Len())
+	a.Equal(t, 6, idx.total, "mismatch on total words")
+	a.Equal(t, []string{"again", "deploy", "done", "is"}, idx.words, "words are not in order")
+	a.Equal(t, map[string][]int{"A": {0}, "B": {0, 2}}, idx.postings["deploy"], "mismatch on positions")
+}
+
+func Test_SearchIndex_Remove(t *testing.T) {
+	msgA := Message{ID: "A", Body: "Deploy is done"}
+	idx := tsSearchIndexSetup(map[string]string{"B": "deploy again"})
+	idx.Add(&msgA)
+
+	// WHEN: indexed and unknown messages are removed
+	idx.Remove(&msgA)
+	idx.Remove(&Message{ID: "X", Body: "again"})
+
+	// THEN: words used only by the removed message are forgotten
+	a.Equal(t, 1, idx.Len())
+	a.Equal(t, 2, idx.total, "mismatch on total words")
+	a.Equal(t, []string{"again", "deploy"}, idx.words)
+	a.Equal(t, map[string][]int{"B": {0}}, idx.postings["deploy"])
+	a.NotContains(t, idx.postings, "done")
+}
+
+func Test_SearchIndex_Match(t *testing.T) {
+	idx := tsSearchIndexSetup(map[string]string{
+		"A": "Deploy is done",
+		"B": "done is deploy, deploying",
+		"C": "nothing here",
+	})
+
+	tests := map[string]struct {
+		q   string
+		exp []string
+	}{
+		"word":                  {"deploy", []string{"A", "B"}},
+		"all words":             {"deploy done", []string{"A", "B"}},
+		"word missing":          {"deploy nothing", nil},
+		"unknown word":          {"rollback", nil},
+		"phrase":                {`"is done"`, []string{"A"}},
+		"phrase in other order": {`"done is deploy"`, []string{"B"}},
+		"prefix":                {"deployi*", []string{"B"}},
+		"prefix of phrase":      {`"is depl*"`, []string{"B"}},
+		"prefix matches word":   {"deploy*", []string{"A", "B"}},
+	}
+
+	for sym, tc := range tests {
+		a.Equal(t, tc.exp, tsSearchIndexMatchIDs(idx, tc.q), "[%s] mismatch", sym)
+	}
+}
+
+func Test_SearchIndex_Match_Ranking(t *testing.T) {
+	idx := tsSearchIndexSetup(map[string]string{
+		"once":         "deploy of the service to the cluster",
+		"twice":        "deploy of the service, deploy to the cluster",
+		"once, short":  "deploy done",
+		"rare word":    "deploy of the rollback",
+		"without word": "service of the cluster",
+	})
+
+	scores := idx.Match([]MsgSearchTerm{{Words: []string{"deploy"}}})
+	a.True(t, scores["twice"] > scores["once"], "repeated word should rank higher")
+	a.True(t, scores["once, short"] > scores["once"], "shorter message should rank higher")
+
+	scores = idx.Match([]MsgSearchTerm{{Words: []string{"deploy"}}, {Words: []string{"rollback"}}})
+	a.Len(t, scores, 1)
+	a.True(t, scores["rare word"] > idx.Match([]MsgSearchTerm{{Words: []string{"deploy"}}})["rare word"], "scores of terms should be summed up")
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	a "github.com/stretchr/testify/assert"
 	ar "github.com/stretchr/testify/require"
@@ -17,6 +21,9 @@ func Test_MemoryStorage_Factory(t *testing.T) {
 	a.NotZero(t, s.users, "users map is not initialised")
 	a.Len(t, s.users, 0, "users map should be empty on init")
 
+	a.NotZero(t, s.names, "names map is not initialised")
+	a.Len(t, s.names, 0, "names map should be empty on init")
+
 	a.NotZero(t, s.messages, "messages map is not initialised")
 	a.Len(t, s.messages, 0, "messages map should be empty on init")
 
@@ -30,7 +37,7 @@ func Test_MemoryStorage_UserSave_Success(t *testing.T) {
 	defer closer()
 
 	elExp := tfUserA
-	ar.NoError(t, s.UserSave(&elExp))
+	ar.NoError(t, s.UserSave(context.Background(), &elExp))
 	s.usersMu.RLock()
 	defer s.usersMu.RUnlock()
 	ar.Contains(t, s.users, elExp.ID, "User with requested ID is not in storage")
@@ -41,58 +48,51 @@ func Test_MemoryStorage_UserSave_Failure_NoID(t *testing.T) {
 	s, closer := tsMemoryStorageSetup()
 	defer closer()
 
-	ar.EqualError(t, s.UserSave(&tfUserXA_NoID), ErrElementIDNotSet.Error())
+	ar.EqualError(t, s.UserSave(context.Background(), &tfUserXA_NoID), ErrElementIDNotSet.Error())
 	s.usersMu.RLock()
 	defer s.usersMu.RUnlock()
 	ar.Len(t, s.users, 0, "unexpected element stored")
 }
 
-func Test_MemoryStorage_UserLoad_Exists(t *testing.T) {
+func Test_MemoryStorage_UserNames_Index(t *testing.T) {
 	s, closer := tsMemoryStorageSetup()
 	defer closer()
 
-	elExp := tfUserA
-
-	// GIVEN: expected user is in storage
-	ar.NoError(t, s.UserSave(&elExp))
-
-	elGot, err := s.UserLoad(elExp.ID)
-	ar.NoError(t, err)
-	a.Equal(t, &elExp, elGot, "User from storage does not match")
-}
-
-func Test_MemoryStorage_UserLoad_NotFound(t *testing.T) {
-	s, closer := tsMemoryStorageSetup()
-	defer closer()
+	// GIVEN: users are in storage
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		ar.NoError(t, s.UserSave(context.Background(), &uC))
+	}
 
-	// GIVEN: expected user is NOT in storage
+	// WHEN: user is renamed and the other one is removed
+	userA := tfUserA
+	userA.Name = "UserA-Name-Renamed"
+	ar.NoError(t, s.UserUpdate(context.Background(), &userA))
+	ar.NoError(t, s.UserDelete(context.Background(), tfUserB.ID, UserDeleteReject))
 
-	_, err := s.UserLoad(tfUserA.ID)
-	ar.Equal(t, ErrElementNotFound, err)
+	// THEN: only current name is indexed
+	s.usersMu.RLock()
+	defer s.usersMu.RUnlock()
+	a.Equal(t, map[string]*User{userNameKey(userA.Name): &userA}, s.names, "names index mismatch")
 }
 
-func Test_MemoryStorage_UserFindByName_Exists(t *testing.T) {
+func Test_MemoryStorage_UserRestore_NameDuplicated(t *testing.T) {
 	s, closer := tsMemoryStorageSetup()
 	defer closer()
 
-	elExp := tfUserA
+	// GIVEN: users with the same name are restored
+	userA := tfUserA
+	userX := User{ID: "UserX-ID", Name: tfUserA.Name}
+	ar.NoError(t, s.userRestore(&userA))
+	ar.NoError(t, s.userRestore(&userX))
 
-	// GIVEN: expected user is in storage
-	ar.NoError(t, s.UserSave(&elExp))
+	// WHEN: the other user is removed
+	ar.NoError(t, s.UserDelete(context.Background(), userX.ID, UserDeleteReject))
 
-	elGot, err := s.UserFindByName(elExp.Name)
+	// THEN: name is left with the user restored first
+	userGot, err := s.UserFindByName(context.Background(), tfUserA.Name)
 	ar.NoError(t, err)
-	a.Equal(t, &elExp, elGot, "User from storage does not match")
-}
-
-func Test_MemoryStorage_UserFindByName_NotFound(t *testing.T) {
-	s, closer := tsMemoryStorageSetup()
-	defer closer()
-
-	// GIVEN: expected user is NOT in storage
-
-	_, err := s.UserFindByName(tfUserA.Name)
-	ar.Equal(t, ErrElementNotFound, err)
+	a.Equal(t, &userA, userGot, "User found by name does not match")
 }
 
 // -- section: Message
@@ -102,10 +102,10 @@ func Test_MemoryStorage_MessageSave_Success(t *testing.T) {
 
 	// GIVEN: expected user is in storage
 	userExp := tfUserA
-	ar.NoError(t, s.UserSave(&userExp))
+	ar.NoError(t, s.UserSave(context.Background(), &userExp))
 
 	msgExp := tfMsgAA
-	ar.NoError(t, s.MsgSave(&msgExp))
+	ar.NoError(t, s.MsgSave(context.Background(), &msgExp))
 
 	// THEN: messages storage has message
 	s.messagesMu.RLock()
@@ -116,121 +116,291 @@ func Test_MemoryStorage_MessageSave_Success(t *testing.T) {
 	// AND: tag is mapped
 	s.tagsMu.RLock()
 	defer s.tagsMu.RUnlock()
-	ar.Contains(t, s.tags, string(msgExp.Tag), "Tags storage is not initiated for requested tag")
-	a.True(t, s.tags[string(msgExp.Tag)].Has(msgExp.ID), "Message.ID is not assigned to tag")
+	ar.Contains(t, s.tags, msgExp.Tags[0].Key(), "Tags storage is not initiated for requested tag")
+	a.Equal(t, []string{msgExp.ID}, s.tags[msgExp.Tags[0].Key()].IDsAfter(MsgCursor{}, 0), "Message.ID is not assigned to tag")
 }
 
-func Test_MemoryStorage_MessageSave_Failure_NoID(t *testing.T) {
+func Test_MemoryStorage_Stats(t *testing.T) {
 	s, closer := tsMemoryStorageSetup()
 	defer closer()
 
-	ar.EqualError(t, s.MsgSave(&tfMsgAXA_NoID), ErrElementIDNotSet.Error())
-	s.usersMu.RLock()
-	defer s.usersMu.RUnlock()
-	ar.Len(t, s.messages, 0, "unexpected element stored")
-}
+	a.Equal(t, StorageStats{}, s.Stats(), "empty storage should have no elements")
 
-func Test_MemoryStorage_MessageLoad_Exists(t *testing.T) {
-	s, closer := tsMemoryStorageSetup()
-	defer closer()
-
-	msgExp := tfMsgAA
+	for _, u := range []User{tfUserA, tfUserB} {
+		u := u
+		ar.NoError(t, s.UserSave(context.Background(), &u))
+	}
+	for _, m := range []Message{tfMsgAA, tfMsgAB, tfMsgBA, tfMsgBB} {
+		m := m
+		ar.NoError(t, s.MsgSave(context.Background(), &m))
+	}
 
-	// GIVEN: expected message is in storage
-	ar.NoError(t, s.MsgSave(&msgExp))
+	a.Equal(t, StorageStats{Users: 2, Messages: 4, Tags: 2}, s.Stats())
 
-	msgGot, err := s.MsgLoad(msgExp.ID)
-	ar.NoError(t, err)
-	a.Equal(t, &msgExp, msgGot, "Message from storage does not match")
+	ar.NoError(t, s.MsgDelete(context.Background(), tfMsgBB.ID))
+	a.Equal(t, StorageStats{Users: 2, Messages: 3, Tags: 1}, s.Stats(), "tag without messages should not be counted")
 }
 
-func Test_MemoryStorage_MessageLoad_NotFound(t *testing.T) {
+func Test_MemoryStorage_MessageSave_Failure_NoID(t *testing.T) {
 	s, closer := tsMemoryStorageSetup()
 	defer closer()
 
-	// GIVEN: expected message is NOT in storage
-
-	_, err := s.MsgLoad(tfMsgAA.ID)
-	ar.Equal(t, ErrElementNotFound, err)
+	ar.EqualError(t, s.MsgSave(context.Background(), &tfMsgAXA_NoID), ErrElementIDNotSet.Error())
+	s.usersMu.RLock()
+	defer s.usersMu.RUnlock()
+	ar.Len(t, s.messages, 0, "unexpected element stored")
 }
 
 // -- section: Tag
-func Test_MemoryStorage_TagAddMsgID_First(t *testing.T) {
+func Test_MemoryStorage_TagAddMsg_First(t *testing.T) {
 	s, closer := tsMemoryStorageSetup()
 	defer closer()
 
-	tag := Tag("ABC")
-	mID := "mID-1"
-	s.tagAddMsgID(tag, mID)
+	msg := &Message{ID: "mID-1", Tags: []Tag{"ABC"}, CreatedAt: tfTimeBase}
+	s.tagAddMsg(msg)
 
 	s.tagsMu.RLock()
 	defer s.tagsMu.RUnlock()
 
-	tagSet, found := s.tags[string(tag)]
+	tagIdx, found := s.tags[msg.Tags[0].Key()]
 	ar.True(t, found, "no messages associated with tag")
 
-	ar.Equal(t, tagSet.Size(), 1, "mismatch in number of assocaited tags")
-	a.True(t, tagSet.Has(mID), "messageID not in set")
+	ar.Equal(t, tagIdx.Len(), 1, "mismatch in number of assocaited tags")
+	a.Equal(t, []string{msg.ID}, tagIdx.IDsAfter(MsgCursor{}, 0), "messageID not in index")
 }
 
-func Test_MemoryStorage_TagAddMsgID_Next(t *testing.T) {
+func Test_MemoryStorage_TagAddMsg_Next(t *testing.T) {
 	s, closer := tsMemoryStorageSetup()
 	defer closer()
 
-	tag := Tag("ABC")
-	mID1 := "mID-1"
-	mID2 := "mID-2"
-	s.tagAddMsgID(tag, mID1)
-	s.tagAddMsgID(tag, mID2)
+	msg1 := &Message{ID: "mID-1", Tags: []Tag{"ABC"}, CreatedAt: tfTimeBase}
+	msg2 := &Message{ID: "mID-2", Tags: []Tag{"ABC"}, CreatedAt: tfTimeBase.Add(time.Second)}
+	s.tagAddMsg(msg1)
+	s.tagAddMsg(msg2)
 
 	s.tagsMu.RLock()
-	tagSet, found := s.tags[string(tag)]
+	tagIdx, found := s.tags[msg1.Tags[0].Key()]
 	s.tagsMu.RUnlock()
 
 	ar.True(t, found, "no messages associated with tag")
 
-	ar.Equal(t, tagSet.Size(), 2, "mismatch in number of assocaited tags")
-	a.True(t, tagSet.Has(mID1), "messageID not in set")
-	a.True(t, tagSet.Has(mID2), "messageID not in set")
+	ar.Equal(t, tagIdx.Len(), 2, "mismatch in number of assocaited tags")
+	a.Equal(t, []string{msg2.ID, msg1.ID}, tagIdx.IDsAfter(MsgCursor{}, 0), "messageIDs not in index")
 }
 
-func Test_MemoryStorage_TagFindMessagesIDs_Exists(t *testing.T) {
+func Test_MemoryStorage_TagRemoveMsg_Last(t *testing.T) {
 	s, closer := tsMemoryStorageSetup()
 	defer closer()
 
-	// GIVEN: expected message is in storage
-	msgsExp := []Message{tfMsgAA, tfMsgAB, tfMsgBA, tfMsgBB}
-	for _, m := range msgsExp {
-		mC := m
-		ar.NoError(t, s.MsgSave(&mC))
+	msg := &Message{ID: "mID-1", Tags: []Tag{"ABC"}, CreatedAt: tfTimeBase}
+	s.tagAddMsg(msg)
+	s.tagRemoveMsg(msg)
+
+	s.tagsMu.RLock()
+	defer s.tagsMu.RUnlock()
+	a.NotContains(t, s.tags, msg.Tags[0].Key(), "tag without messages is kept")
+}
+
+// -- section: Benchmarks
+
+// Benchmark_MemoryStorage_Load_OneByOne loads messages for a tag along with authors one call at a time (N+1).
+func Benchmark_MemoryStorage_Load_OneByOne(b *testing.B) {
+	s, ids := tbMemoryStorageTagSetup(b, 1000, 50)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, id := range ids {
+			m, err := s.MsgLoad(ctx, id)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := s.UserLoad(ctx, m.AuthorID); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// Benchmark_MemoryStorage_Load_Many loads messages for a tag along with authors in two batch calls.
+func Benchmark_MemoryStorage_Load_Many(b *testing.B) {
+	s, ids := tbMemoryStorageTagSetup(b, 1000, 50)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		msgs, err := s.MsgLoadMany(ctx, ids)
+		if err != nil {
+			b.Fatal(err)
+		}
+		seen := make(map[string]bool)
+		var authorsIDs []string
+		for _, m := range msgs {
+			if !seen[m.AuthorID] {
+				seen[m.AuthorID] = true
+				authorsIDs = append(authorsIDs, m.AuthorID)
+			}
+		}
+		if _, err := s.UserLoadMany(ctx, authorsIDs); err != nil {
+			b.Fatal(err)
+		}
 	}
+}
 
-	idsGot, err := s.MsgsIDsFindByTag(tfTagA)
-	ar.NoError(t, err)
-	ar.Len(t, idsGot, len(msgsExp)-1, "mismatched number of ids returned")
-	for _, mExp := range msgsExp {
-		if mExp.Tag == tfTagA {
-			a.Contains(t, idsGot, mExp.ID, "User from storage does not match")
+// Benchmark_MemoryStorage_Load_OneByOne_Parallel shows lock contention of N+1 loading under concurrent readers.
+func Benchmark_MemoryStorage_Load_OneByOne_Parallel(b *testing.B) {
+	s, ids := tbMemoryStorageTagSetup(b, 1000, 50)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			for _, id := range ids {
+				m, _ := s.MsgLoad(ctx, id)
+				s.UserLoad(ctx, m.AuthorID)
+			}
+		}
+	})
+}
+
+// Benchmark_MemoryStorage_Load_Many_Parallel shows lock contention of batch loading under concurrent readers.
+func Benchmark_MemoryStorage_Load_Many_Parallel(b *testing.B) {
+	s, ids := tbMemoryStorageTagSetup(b, 1000, 50)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			msgs, _ := s.MsgLoadMany(ctx, ids)
+			authorsIDs := make([]string, 0, len(msgs))
+			for _, m := range msgs {
+				authorsIDs = append(authorsIDs, m.AuthorID)
+			}
+			s.UserLoadMany(ctx, authorsIDs)
+		}
+	})
+}
+
+// Benchmark_MemoryStorage_UserFindByName finds users by name among 1M of them.
+func Benchmark_MemoryStorage_UserFindByName(b *testing.B) {
+	s := tbMemoryStorageUsers(b)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.UserFindByName(ctx, fmt.Sprintf("User-%d-Name", i%tbMemoryStorageUsersCount)); err != nil {
+			b.Fatal(err)
 		}
 	}
 }
 
-func Test_MemoryStorage_TagFindMessagesIDs_NotFound(t *testing.T) {
-	s, closer := tsMemoryStorageSetup()
-	defer closer()
+// Benchmark_MemoryStorage_UserFindByName_Parallel shows lock contention of name lookups under concurrent readers.
+func Benchmark_MemoryStorage_UserFindByName_Parallel(b *testing.B) {
+	s := tbMemoryStorageUsers(b)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			s.UserFindByName(ctx, fmt.Sprintf("User-%d-Name", i%tbMemoryStorageUsersCount))
+			i++
+		}
+	})
+}
 
-	// GIVEN: expected message is in storage
-	msgsExp := []Message{tfMsgAA, tfMsgAB, tfMsgBA, tfMsgBB}
-	for _, m := range msgsExp {
-		mC := m
-		ar.NoError(t, s.MsgSave(&mC))
+// Benchmark_MemoryStorage_UserFindByName_NotFound looks up name which is not taken among 1M users.
+func Benchmark_MemoryStorage_UserFindByName_NotFound(b *testing.B) {
+	s := tbMemoryStorageUsers(b)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.UserFindByName(ctx, "UserX-Name"); err != ErrElementNotFound {
+			b.Fatal(err)
+		}
 	}
+}
 
-	_, err := s.MsgsIDsFindByTag(tfTagC)
-	a.Equal(t, ErrElementNotFound, err)
+// Benchmark_MemoryStorage_UserUpdate_Rename renames user among 1M of them back and forth, moving name in the index.
+func Benchmark_MemoryStorage_UserUpdate_Rename(b *testing.B) {
+	s := tbMemoryStorageUsers(b)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n := i % tbMemoryStorageUsersCount
+		u := User{ID: fmt.Sprintf("User-%d-ID", n), Name: fmt.Sprintf("User-%d-Name-Renamed", n)}
+		if err := s.UserUpdate(ctx, &u); err != nil {
+			b.Fatal(err)
+		}
+		u.Name = fmt.Sprintf("User-%d-Name", n)
+		if err := s.UserUpdate(ctx, &u); err != nil {
+			b.Fatal(err)
+		}
+	}
 }
 
 // -- test helpers
+
+// tbMemoryStorageUsersCount is a number of users in storage shared by user benchmarks.
+const tbMemoryStorageUsersCount = 1000000
+
+var (
+	tbMemoryStorageUsersOnce sync.Once
+	tbMemoryStorageUsersS    *memoryStorage
+)
+
+// tbMemoryStorageUsers returns storage with 1M users, named after their numbers.
+// Storage is created once and shared by benchmarks, which must leave users as they found them.
+func tbMemoryStorageUsers(b *testing.B) *memoryStorage {
+	tbMemoryStorageUsersOnce.Do(func() {
+		s := NewMemoryStorage()
+		ctx := context.Background()
+		for i := 0; i < tbMemoryStorageUsersCount; i++ {
+			u := &User{ID: fmt.Sprintf("User-%d-ID", i), Name: fmt.Sprintf("User-%d-Name", i)}
+			if err := s.UserSave(ctx, u); err != nil {
+				b.Fatal(err)
+			}
+		}
+		tbMemoryStorageUsersS = s
+	})
+	if tbMemoryStorageUsersS == nil {
+		b.Fatal("storage with users not created")
+	}
+	return tbMemoryStorageUsersS
+}
+
+// tbMemoryStorageTagSetup creates storage with nMsgs messages written by nUsers users, all sharing a tag.
+// IDs of the messages associated with the tag are returned.
+func tbMemoryStorageTagSetup(b *testing.B, nMsgs, nUsers int) (*memoryStorage, []string) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+	for i := 0; i < nUsers; i++ {
+		u := &User{ID: fmt.Sprintf("User-%d-ID", i), Name: fmt.Sprintf("User-%d-Name", i)}
+		if err := s.UserSave(ctx, u); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for i := 0; i < nMsgs; i++ {
+		m := &Message{
+			ID:       fmt.Sprintf("Message-%d-ID", i),
+			Body:     fmt.Sprintf("Message-%d-Body", i),
+			AuthorID: fmt.Sprintf("User-%d-ID", i%nUsers),
+			Tags:     []Tag{tfTagA},
+		}
+		if err := s.MsgSave(ctx, m); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	ids, err := s.MsgsIDsFindByTag(ctx, tfTagA, MsgCursor{}, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return s, ids
+}
+
 func tsMemoryStorageSetup() (*memoryStorage, func()) {
 	s := NewMemoryStorage()
 	closer := func() {}
@@ -0,0 +1,1501 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	sqlDriverSQLite   = "sqlite3"
+	sqlDriverPostgres = "postgres"
+)
+
+// sqlBatchSize is a maximum number of IDs passed to single "IN (...)" query.
+// It keeps the number of bound parameters below the limits of supported databases.
+const sqlBatchSize = 500
+
+// sqlMigration is a single, versioned step in evolution of the database schema.
+// Applied migrations are recorded in schema_migrations table and never run again.
+type sqlMigration struct {
+	version int
+	stmts   []string
+
+	// fn runs after the statements, within the same transaction, e.g. to fill in columns computed in Go
+	fn func(s *sqlStorage, tx *sql.Tx) error
+}
+
+// sqlMigrations is an ordered list of all schema migrations.
+// New migrations shall only be appended. Once released, migration shall never be changed.
+var sqlMigrations = []sqlMigration{
+	{
+		version: 1,
+		stmts: []string{
+			`CREATE TABLE users (
+				id   VARCHAR(64) PRIMARY KEY,
+				name TEXT NOT NULL,
+				CONSTRAINT users_name_unique UNIQUE (name)
+			)`,
+			`CREATE TABLE messages (
+				id        VARCHAR(64) PRIMARY KEY,
+				author_id VARCHAR(64) NOT NULL,
+				body      TEXT NOT NULL
+			)`,
+			`CREATE TABLE message_tags (
+				message_id VARCHAR(64) NOT NULL,
+				tag        TEXT NOT NULL,
+				PRIMARY KEY (message_id, tag)
+			)`,
+			`CREATE INDEX message_tags_tag ON message_tags (tag)`,
+		},
+	},
+	{
+		// creation time (unix nanoseconds) is copied into tags association so listing is served by single index
+		version: 2,
+		stmts: []string{
+			`ALTER TABLE messages ADD COLUMN created_at BIGINT NOT NULL DEFAULT 0`,
+			`ALTER TABLE message_tags ADD COLUMN created_at BIGINT NOT NULL DEFAULT 0`,
+			`DROP INDEX message_tags_tag`,
+			`CREATE INDEX message_tags_tag_created ON message_tags (tag, created_at, message_id)`,
+		},
+	},
+	{
+		version: 3,
+		stmts: []string{
+			`ALTER TABLE messages ADD COLUMN modified_at BIGINT NOT NULL DEFAULT 0`,
+			`UPDATE messages SET modified_at = created_at`,
+			`CREATE TABLE message_revisions (
+				message_id  VARCHAR(64) NOT NULL,
+				revision    INTEGER NOT NULL,
+				author_id   VARCHAR(64) NOT NULL,
+				body        TEXT NOT NULL,
+				tag         TEXT NOT NULL,
+				created_at  BIGINT NOT NULL,
+				modified_at BIGINT NOT NULL,
+				PRIMARY KEY (message_id, revision)
+			)`,
+		},
+	},
+	{
+		// messages are looked up by author on user removal
+		version: 4,
+		stmts: []string{
+			`CREATE INDEX messages_author ON messages (author_id)`,
+		},
+	},
+	{
+		// roles are kept comma separated, in order of granting
+		version: 5,
+		stmts: []string{
+			`ALTER TABLE users ADD COLUMN roles TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		// tags and authors are kept as JSON arrays, as tags may contain commas
+		version: 6,
+		stmts: []string{
+			`CREATE TABLE webhooks (
+				id         VARCHAR(64) PRIMARY KEY,
+				url        TEXT NOT NULL,
+				secret     TEXT NOT NULL,
+				tags       TEXT NOT NULL,
+				authors    TEXT NOT NULL,
+				created_at BIGINT NOT NULL
+			)`,
+		},
+	},
+	{
+		// messages carry many tags, position keeps them in order given by the author
+		// revisions keep tags as JSON array, tag column of older ones holds their only tag
+		version: 7,
+		stmts: []string{
+			`ALTER TABLE message_tags ADD COLUMN position INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE message_revisions ADD COLUMN tags TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		// creation time of the message is copied into mentions as well, so listing is served by single index
+		// revisions keep IDs of mentioned users as JSON array
+		version: 8,
+		stmts: []string{
+			`CREATE TABLE message_mentions (
+				message_id VARCHAR(64) NOT NULL,
+				user_id    VARCHAR(64) NOT NULL,
+				created_at BIGINT NOT NULL,
+				position   INTEGER NOT NULL,
+				PRIMARY KEY (message_id, user_id)
+			)`,
+			`CREATE INDEX message_mentions_user_created ON message_mentions (user_id, created_at, message_id)`,
+			`ALTER TABLE message_revisions ADD COLUMN mentions TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		// tags are looked up by their case-folded keys and users by keys of their names, see Tag.Key and userNameKey
+		// SQL is not able to compute the keys, so existing ones are filled in by Go
+		version: 9,
+		stmts: []string{
+			`ALTER TABLE message_tags ADD COLUMN tag_key TEXT NOT NULL DEFAULT ''`,
+			`DROP INDEX message_tags_tag_created`,
+			`CREATE INDEX message_tags_key_created ON message_tags (tag_key, created_at, message_id)`,
+			`ALTER TABLE users ADD COLUMN name_key TEXT NOT NULL DEFAULT ''`,
+		},
+		fn: (*sqlStorage).migrateKeys,
+	},
+	{
+		version: 10,
+		stmts: []string{
+			`CREATE UNIQUE INDEX users_name_key ON users (name_key)`,
+		},
+	},
+	{
+		// tags attached from hashtags of the body are flagged, so they're told apart from tags given explicitly
+		// revisions keep hashtags as JSON array
+		version: 11,
+		stmts: []string{
+			`ALTER TABLE message_tags ADD COLUMN hashtag INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE message_revisions ADD COLUMN hashtags TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		// keys of names are computed again, as capital I had other key than small i
+		// unique index is dropped meanwhile, as new key of one user may be old key of the other
+		version: 12,
+		stmts: []string{
+			`DROP INDEX users_name_key`,
+		},
+		fn: (*sqlStorage).migrateNameKeys,
+	},
+	{
+		version: 13,
+		stmts: []string{
+			`CREATE UNIQUE INDEX users_name_key ON users (name_key)`,
+		},
+	},
+}
+
+// sqlStorage provides storage for users, messages, tags and webhooks in relational database.
+// SQLite and PostgreSQL are supported.
+// All functions are thread safe.
+type sqlStorage struct {
+	db *sql.DB
+
+	// driver is a name of database/sql driver in use.
+	driver string
+}
+
+// NewSQLStorage connects to the database and brings its schema up to date.
+func NewSQLStorage(driver, dsn string) (*sqlStorage, error) {
+	switch driver {
+	case sqlDriverSQLite, sqlDriverPostgres:
+	default:
+		return nil, fmt.Errorf("Storage: unsupported SQL driver: %s", driver)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == sqlDriverSQLite {
+		// SQLite allows only single writer, in memory database also lives in single connection.
+		db.SetMaxOpenConns(1)
+	}
+
+	s := &sqlStorage{
+		db:     db,
+		driver: driver,
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Ping checks connection to the database.
+func (s *sqlStorage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close closes the database.
+func (s *sqlStorage) Close() error {
+	return s.db.Close()
+}
+
+// migrate applies all pending migrations, each in separate transaction.
+func (s *sqlStorage) migrate() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return err
+	}
+
+	current, err := s.schemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range sqlMigrations {
+		if m.version <= current {
+			continue
+		}
+
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, stmt := range m.stmts {
+			if _, err := tx.Exec(stmt); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("Storage: migration %d failed: %s", m.version, err)
+			}
+		}
+		if m.fn != nil {
+			if err := m.fn(s, tx); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("Storage: migration %d failed: %s", m.version, err)
+			}
+		}
+		if _, err := tx.Exec(s.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), m.version); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateKeys fills in keys of tags and user names of existing rows.
+func (s *sqlStorage) migrateKeys(tx *sql.Tx) error {
+	tags, err := sqlStrings(tx.Query(`SELECT DISTINCT tag FROM message_tags`))
+	if err != nil {
+		return err
+	}
+	for _, t := range tags {
+		if _, err := tx.Exec(s.rebind(`UPDATE message_tags SET tag_key = ? WHERE tag = ?`), Tag(t).Key(), t); err != nil {
+			return err
+		}
+	}
+	return s.migrateNameKeys(tx)
+}
+
+// migrateNameKeys fills in keys of user names of existing rows.
+// Users whose names have the same key keep them, all but the first one by ID get the key suffixed with their ID.
+// Suffix is separated by new line, which is not allowed in names, so it never clashes with key of valid name.
+func (s *sqlStorage) migrateNameKeys(tx *sql.Tx) error {
+	// all names are read before any update, as SQLite has single connection
+	ids, err := sqlStrings(tx.Query(`SELECT id FROM users ORDER BY id`))
+	if err != nil {
+		return err
+	}
+	names, err := sqlStrings(tx.Query(`SELECT name FROM users ORDER BY id`))
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(ids))
+	for i, id := range ids {
+		key := userNameKey(names[i])
+		if seen[key] {
+			key += "\n" + id
+		}
+		seen[key] = true
+		if _, err := tx.Exec(s.rebind(`UPDATE users SET name_key = ? WHERE id = ?`), key, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqlStrings reads single column of all rows.
+func sqlStrings(rows *sql.Rows, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+// schemaVersion returns version of the last applied migration.
+func (s *sqlStorage) schemaVersion() (int, error) {
+	var v sql.NullInt64
+	if err := s.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&v); err != nil {
+		return 0, err
+	}
+	return int(v.Int64), nil
+}
+
+// rebind converts query using '?' placeholders into the one understood by the driver.
+func (s *sqlStorage) rebind(query string) string {
+	if s.driver != sqlDriverPostgres {
+		return query
+	}
+
+	var b bytes.Buffer
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// isUniqueViolation checks if error is caused by violation of unique constraint.
+func (s *sqlStorage) isUniqueViolation(err error) bool {
+	switch e := err.(type) {
+	case sqlite3.Error:
+		return e.ExtendedCode == sqlite3.ErrConstraintUnique
+	case *pq.Error:
+		return e.Code == "23505"
+	}
+	return false
+}
+
+// UserSave persists single user.
+// ErrElementIDNotSet error is returned if user ID is not set.
+// ErrElementDuplicated error is returned if other user with the same name, up to the key (see userNameKey), exists.
+func (s *sqlStorage) UserSave(ctx context.Context, u *User) error {
+	if u.ID == "" {
+		return ErrElementIDNotSet
+	}
+
+	_, err := s.db.ExecContext(
+		ctx,
+		s.rebind(`INSERT INTO users (id, name, name_key, roles) VALUES (?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET name = excluded.name, name_key = excluded.name_key, roles = excluded.roles`),
+		u.ID, u.Name, userNameKey(u.Name), sqlRolesTo(u.Roles),
+	)
+	if s.isUniqueViolation(err) {
+		return ErrElementDuplicated
+	}
+	return err
+}
+
+// UserLoad retrieves single user from storage by ID.
+// ErrElementNotFound is returned if element could not be found.
+func (s *sqlStorage) UserLoad(ctx context.Context, id string) (*User, error) {
+	return s.userScan(s.db.QueryRowContext(ctx, s.rebind(`SELECT id, name, roles FROM users WHERE id = ?`), id))
+}
+
+// UserFindByName retrieves single user entity from storage by its Name, compared by the key (see userNameKey).
+// ErrElementNotFound is returned if user could not be found.
+func (s *sqlStorage) UserFindByName(ctx context.Context, name string) (*User, error) {
+	return s.userScan(s.db.QueryRowContext(ctx, s.rebind(`SELECT id, name, roles FROM users WHERE name_key = ?`), userNameKey(name)))
+}
+
+// UserLoadMany retrieves users by IDs, querying them in batches.
+// Users are returned in the order of ids. ErrElementNotFound is returned if any of them is missing.
+func (s *sqlStorage) UserLoadMany(ctx context.Context, ids []string) ([]*User, error) {
+	found := make(map[string]*User, len(ids))
+	err := sqlInBatches(ids, func(batch []string) error {
+		rows, err := s.db.QueryContext(
+			ctx,
+			s.rebind(`SELECT id, name, roles FROM users WHERE id IN (`+sqlPlaceholders(len(batch))+`)`),
+			sqlArgs(batch)...,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			u, err := sqlUserScan(rows)
+			if err != nil {
+				return err
+			}
+			found[u.ID] = u
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*User, 0, len(ids))
+	for _, id := range ids {
+		u, ok := found[id]
+		if !ok {
+			return nil, ErrElementNotFound
+		}
+		out = append(out, u)
+	}
+	return out, nil
+}
+
+// UserUpdate replaces existing user.
+// ErrElementIDNotSet error is returned if user ID is not set.
+// ErrElementNotFound is returned if user could not be found.
+// ErrElementDuplicated error is returned if other user with the same name exists.
+func (s *sqlStorage) UserUpdate(ctx context.Context, u *User) error {
+	if u.ID == "" {
+		return ErrElementIDNotSet
+	}
+
+	res, err := s.db.ExecContext(
+		ctx,
+		s.rebind(`UPDATE users SET name = ?, name_key = ?, roles = ? WHERE id = ?`),
+		u.Name, userNameKey(u.Name), sqlRolesTo(u.Roles), u.ID,
+	)
+	if s.isUniqueViolation(err) {
+		return ErrElementDuplicated
+	}
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrElementNotFound
+	}
+	return nil
+}
+
+// UserDelete removes user in single transaction. Authored messages are handled according to the policy.
+// ErrElementNotFound is returned if user could not be found.
+// ErrElementInUse is returned if user authored any message and policy is UserDeleteReject.
+func (s *sqlStorage) UserDelete(ctx context.Context, id string, policy UserDeletePolicy) error {
+	if err := policy.Validate(); err != nil {
+		return fmt.Errorf("Storage: %s", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM users WHERE id = ?`), id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n == 0 {
+		tx.Rollback()
+		return ErrElementNotFound
+	}
+
+	// mentions of the user are forgotten, messages mentioning the user are kept as they are
+	stmts := []string{`DELETE FROM message_mentions WHERE user_id = ?`}
+	switch policy {
+	case UserDeleteReject:
+		var owned int
+		err := tx.QueryRowContext(ctx, s.rebind(`SELECT COUNT(*) FROM messages WHERE author_id = ?`), id).Scan(&owned)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if owned > 0 {
+			tx.Rollback()
+			return ErrElementInUse
+		}
+	case UserDeleteCascade:
+		stmts = append(stmts,
+			`DELETE FROM message_tags WHERE message_id IN (SELECT id FROM messages WHERE author_id = ?)`,
+			`DELETE FROM message_mentions WHERE message_id IN (SELECT id FROM messages WHERE author_id = ?)`,
+			`DELETE FROM message_revisions WHERE message_id IN (SELECT id FROM messages WHERE author_id = ?)`,
+			`DELETE FROM messages WHERE author_id = ?`,
+		)
+	case UserDeleteAnonymise:
+		// revisions are anonymised first, while messages still point at the author
+		stmts = append(stmts,
+			`UPDATE message_revisions SET author_id = '' WHERE message_id IN (SELECT id FROM messages WHERE author_id = ?)`,
+			`UPDATE messages SET author_id = '' WHERE author_id = ?`,
+		)
+	}
+	for _, q := range stmts {
+		if _, err := tx.ExecContext(ctx, s.rebind(q), id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UsersList returns up to limit users ordered by ID, starting after the one with given ID.
+// Empty after starts from the beginning. Non positive limit returns all of them.
+func (s *sqlStorage) UsersList(ctx context.Context, after string, limit int) ([]*User, error) {
+	query := `SELECT id, name, roles FROM users WHERE id > ? ORDER BY id`
+	args := []interface{}{after}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []*User{}
+	for rows.Next() {
+		u, err := sqlUserScan(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *sqlStorage) userScan(row *sql.Row) (*User, error) {
+	switch u, err := sqlUserScan(row); err {
+	case nil:
+		return u, nil
+	case sql.ErrNoRows:
+		return nil, ErrElementNotFound
+	default:
+		return nil, err
+	}
+}
+
+// sqlUserScan reads user from single row of "SELECT id, name, roles" query.
+func sqlUserScan(row interface {
+	Scan(dest ...interface{}) error
+}) (*User, error) {
+	var u User
+	var roles string
+	if err := row.Scan(&u.ID, &u.Name, &roles); err != nil {
+		return nil, err
+	}
+	u.Roles = sqlRolesFrom(roles)
+	return &u, nil
+}
+
+// MsgSave persists single message along with its association to tags and mentioned users.
+// Previous version of the message is kept as its revision.
+// Error ErrElementIDNotSet is dispatched when message ID is not set.
+func (s *sqlStorage) MsgSave(ctx context.Context, m *Message) error {
+	return s.msgPut(ctx, m, false)
+}
+
+// MsgUpdate replaces existing message.
+// Previous version of the message is kept as its revision.
+// Error ErrElementIDNotSet is dispatched when message ID is not set.
+// ErrElementNotFound is returned if message could not be found.
+func (s *sqlStorage) MsgUpdate(ctx context.Context, m *Message) error {
+	return s.msgPut(ctx, m, true)
+}
+
+// msgPut stores the message in single transaction, archiving its previous version.
+// ErrElementNotFound is returned if mustExist is set and message could not be found.
+func (s *sqlStorage) msgPut(ctx context.Context, m *Message, mustExist bool) error {
+	if m.ID == "" {
+		return ErrElementIDNotSet
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// no-op update locks the row so that concurrent saves get consecutive revision numbers
+	res, err := tx.ExecContext(ctx, s.rebind(`UPDATE messages SET id = id WHERE id = ?`), m.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if mustExist {
+		n, err := res.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if n == 0 {
+			tx.Rollback()
+			return ErrElementNotFound
+		}
+	}
+	prevTags, prevHashtags, err := s.msgTags(ctx, tx, m.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	prevTag, prevTagsEnc, err := sqlTagsTo(prevTags)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	prevHashtagsEnc, err := sqlHashtagsTo(prevHashtags)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	prevMentions, err := s.msgMentions(ctx, tx, m.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	prevMentionsEnc, err := sqlMentionsTo(prevMentions)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(
+		ctx,
+		s.rebind(`INSERT INTO message_revisions (message_id, revision, author_id, body, tag, tags, hashtags, mentions, created_at, modified_at)
+			SELECT m.id, (SELECT COUNT(*) FROM message_revisions r WHERE r.message_id = m.id) + 1,
+				m.author_id, m.body, ?, ?, ?, ?, m.created_at, m.modified_at
+			FROM messages m
+			WHERE m.id = ?`),
+		prevTag, prevTagsEnc, prevHashtagsEnc, prevMentionsEnc, m.ID,
+	); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(
+		ctx,
+		s.rebind(`INSERT INTO messages (id, author_id, body, created_at, modified_at) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET author_id = excluded.author_id, body = excluded.body,
+				created_at = excluded.created_at, modified_at = excluded.modified_at`),
+		m.ID, m.AuthorID, m.Body, sqlTimeTo(m.CreatedAt), sqlTimeTo(m.ModifiedAt),
+	); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM message_tags WHERE message_id = ?`), m.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	seen := make(map[string]bool, len(m.Tags))
+	for i, t := range m.Tags {
+		if seen[t.Key()] {
+			continue
+		}
+		seen[t.Key()] = true
+		hashtag := 0
+		if tagsContain(m.Hashtags, t) {
+			hashtag = 1
+		}
+		if _, err := tx.ExecContext(
+			ctx,
+			s.rebind(`INSERT INTO message_tags (message_id, tag, tag_key, created_at, position, hashtag) VALUES (?, ?, ?, ?, ?, ?)`),
+			m.ID, string(t), t.Key(), sqlTimeTo(m.CreatedAt), i, hashtag,
+		); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM message_mentions WHERE message_id = ?`), m.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	seenUsers := make(map[string]bool, len(m.Mentions))
+	for i, id := range m.Mentions {
+		if seenUsers[id] {
+			continue
+		}
+		seenUsers[id] = true
+		if _, err := tx.ExecContext(
+			ctx,
+			s.rebind(`INSERT INTO message_mentions (message_id, user_id, created_at, position) VALUES (?, ?, ?, ?)`),
+			m.ID, id, sqlTimeTo(m.CreatedAt), i,
+		); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// msgTags retrieves tags of the message within the transaction, in order given by the author,
+// along with the ones attached as hashtags.
+func (s *sqlStorage) msgTags(ctx context.Context, tx *sql.Tx, id string) ([]Tag, []Tag, error) {
+	rows, err := tx.QueryContext(ctx, s.rebind(`SELECT tag, hashtag FROM message_tags WHERE message_id = ? ORDER BY position`), id)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var out, hashtags []Tag
+	for rows.Next() {
+		var t string
+		var hashtag int
+		if err := rows.Scan(&t, &hashtag); err != nil {
+			return nil, nil, err
+		}
+		out = append(out, Tag(t))
+		if hashtag != 0 {
+			hashtags = append(hashtags, Tag(t))
+		}
+	}
+	return out, hashtags, rows.Err()
+}
+
+// msgMentions retrieves IDs of users mentioned in the message within the transaction, in order of the first mention.
+func (s *sqlStorage) msgMentions(ctx context.Context, tx *sql.Tx, id string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, s.rebind(`SELECT user_id FROM message_mentions WHERE message_id = ? ORDER BY position`), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		out = append(out, userID)
+	}
+	return out, rows.Err()
+}
+
+// MsgDelete removes message along with its revisions and association to tags and mentioned users.
+// ErrElementNotFound is returned if message could not be found.
+func (s *sqlStorage) MsgDelete(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM messages WHERE id = ?`), id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n == 0 {
+		tx.Rollback()
+		return ErrElementNotFound
+	}
+	for _, q := range []string{
+		`DELETE FROM message_tags WHERE message_id = ?`,
+		`DELETE FROM message_mentions WHERE message_id = ?`,
+		`DELETE FROM message_revisions WHERE message_id = ?`,
+	} {
+		if _, err := tx.ExecContext(ctx, s.rebind(q), id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// MsgLoad retrieves single message from storage by ID.
+// ErrElementNotFound is returned if message could not be found.
+func (s *sqlStorage) MsgLoad(ctx context.Context, id string) (*Message, error) {
+	msgs, err := s.MsgLoadMany(ctx, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	return msgs[0], nil
+}
+
+// MsgLoadMany retrieves messages by IDs, querying them in batches.
+// Message is read from as many rows as it has tags, they come in order given by the author.
+// Mentioned users are queried separately, so rows are not multiplied.
+// Messages are returned in the order of ids. ErrElementNotFound is returned if any of them is missing.
+func (s *sqlStorage) MsgLoadMany(ctx context.Context, ids []string) ([]*Message, error) {
+	found := make(map[string]*Message, len(ids))
+	err := sqlInBatches(ids, func(batch []string) error {
+		rows, err := s.db.QueryContext(
+			ctx,
+			s.rebind(`SELECT m.id, m.author_id, m.body, m.created_at, m.modified_at, t.tag, t.hashtag
+				FROM messages m LEFT JOIN message_tags t ON t.message_id = m.id
+				WHERE m.id IN (`+sqlPlaceholders(len(batch))+`)
+				ORDER BY t.position`),
+			sqlArgs(batch)...,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var m Message
+			var tag sql.NullString
+			var hashtag sql.NullInt64
+			var createdAt, modifiedAt int64
+			if err := rows.Scan(&m.ID, &m.AuthorID, &m.Body, &createdAt, &modifiedAt, &tag, &hashtag); err != nil {
+				return err
+			}
+			msg := &m
+			if prev, ok := found[m.ID]; ok {
+				msg = prev
+			} else {
+				m.CreatedAt = sqlTimeFrom(createdAt)
+				m.ModifiedAt = sqlTimeFrom(modifiedAt)
+				found[m.ID] = &m
+			}
+			if !tag.Valid {
+				continue
+			}
+			msg.Tags = append(msg.Tags, Tag(tag.String))
+			if hashtag.Int64 != 0 {
+				msg.Hashtags = append(msg.Hashtags, Tag(tag.String))
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return s.msgsMentionsLoad(ctx, batch, found)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*Message, 0, len(ids))
+	for _, id := range ids {
+		m, ok := found[id]
+		if !ok {
+			return nil, ErrElementNotFound
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// msgsMentionsLoad fills in users mentioned in messages of the batch. Messages are keyed by ID.
+func (s *sqlStorage) msgsMentionsLoad(ctx context.Context, batch []string, msgs map[string]*Message) error {
+	rows, err := s.db.QueryContext(
+		ctx,
+		s.rebind(`SELECT message_id, user_id FROM message_mentions
+			WHERE message_id IN (`+sqlPlaceholders(len(batch))+`)
+			ORDER BY position`),
+		sqlArgs(batch)...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msgID, userID string
+		if err := rows.Scan(&msgID, &userID); err != nil {
+			return err
+		}
+		if m, ok := msgs[msgID]; ok {
+			m.Mentions = append(m.Mentions, userID)
+		}
+	}
+	return rows.Err()
+}
+
+// MsgRevisions retrieves prior versions of the message, from the oldest.
+// Empty list is returned for message which was never edited.
+// ErrElementNotFound is returned if message could not be found.
+func (s *sqlStorage) MsgRevisions(ctx context.Context, id string) ([]*Message, error) {
+	var n int
+	if err := s.db.QueryRowContext(ctx, s.rebind(`SELECT COUNT(*) FROM messages WHERE id = ?`), id).Scan(&n); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrElementNotFound
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		s.rebind(`SELECT message_id, author_id, body, tag, tags, hashtags, mentions, created_at, modified_at
+			FROM message_revisions WHERE message_id = ? ORDER BY revision`),
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []*Message{}
+	for rows.Next() {
+		var m Message
+		var tag, tags, hashtags, mentions string
+		var createdAt, modifiedAt int64
+		if err := rows.Scan(&m.ID, &m.AuthorID, &m.Body, &tag, &tags, &hashtags, &mentions, &createdAt, &modifiedAt); err != nil {
+			return nil, err
+		}
+		if m.Tags, err = sqlTagsFrom(tag, tags); err != nil {
+			return nil, err
+		}
+		if m.Hashtags, err = sqlHashtagsFrom(hashtags); err != nil {
+			return nil, err
+		}
+		if m.Mentions, err = sqlMentionsFrom(mentions); err != nil {
+			return nil, err
+		}
+		m.CreatedAt = sqlTimeFrom(createdAt)
+		m.ModifiedAt = sqlTimeFrom(modifiedAt)
+		out = append(out, &m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MsgsIDsFindByTag returns up to limit ids of messages associated with given tag, starting after the cursor.
+// Messages are ordered from the newest to the oldest. Non positive limit returns all of them.
+// ErrElementNotFound is returned if tag is unknown (no message is associated)
+func (s *sqlStorage) MsgsIDsFindByTag(ctx context.Context, tag Tag, after MsgCursor, limit int) ([]string, error) {
+	query := `SELECT message_id FROM message_tags WHERE tag_key = ?`
+	args := []interface{}{tag.Key()}
+	if !after.IsZero() {
+		at := sqlTimeTo(after.CreatedAt)
+		query += ` AND (created_at < ? OR (created_at = ? AND message_id < ?))`
+		args = append(args, at, at, after.ID)
+	}
+	query += ` ORDER BY created_at DESC, message_id DESC`
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return []string{}, err
+	}
+	defer rows.Close()
+
+	out := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return []string{}, err
+		}
+		out = append(out, id)
+	}
+	if err := rows.Err(); err != nil {
+		return []string{}, err
+	}
+
+	if len(out) == 0 {
+		// page past the end of known tag is empty, not missing
+		if !after.IsZero() {
+			var n int
+			err := s.db.QueryRowContext(ctx, s.rebind(`SELECT COUNT(*) FROM message_tags WHERE tag_key = ?`), tag.Key()).Scan(&n)
+			if err != nil {
+				return []string{}, err
+			}
+			if n > 0 {
+				return out, nil
+			}
+		}
+		return out, ErrElementNotFound
+	}
+	return out, nil
+}
+
+// MsgsIDsFindByTags returns up to limit ids of messages matching the query, starting after the cursor.
+// Messages are ordered from the newest to the oldest. Non positive limit returns all of them.
+// Associations of the first of All tags, or of any of Any tags, are scanned and the rest of the query is probed.
+// ErrElementNotFound is returned if any of All tags or each of Any tags is unknown, or query is empty.
+func (s *sqlStorage) MsgsIDsFindByTags(ctx context.Context, q MsgTagQuery, after MsgCursor, limit int) ([]string, error) {
+	if q.IsEmpty() {
+		return []string{}, ErrElementNotFound
+	}
+
+	var query string
+	var args []interface{}
+	if len(q.All) > 0 {
+		query = `SELECT t.message_id, t.created_at FROM message_tags t WHERE t.tag_key = ?`
+		args = append(args, q.All[0].Key())
+		for _, tag := range q.All[1:] {
+			query += ` AND EXISTS (SELECT 1 FROM message_tags o WHERE o.message_id = t.message_id AND o.tag_key = ?)`
+			args = append(args, tag.Key())
+		}
+		if len(q.Any) > 0 {
+			query += ` AND EXISTS (SELECT 1 FROM message_tags o WHERE o.message_id = t.message_id AND o.tag_key IN (` + sqlPlaceholders(len(q.Any)) + `))`
+			args = append(args, sqlTagArgs(q.Any)...)
+		}
+	} else {
+		// message associated with many of Any tags is listed once
+		query = `SELECT DISTINCT t.message_id, t.created_at FROM message_tags t WHERE t.tag_key IN (` + sqlPlaceholders(len(q.Any)) + `)`
+		args = append(args, sqlTagArgs(q.Any)...)
+	}
+	if len(q.Not) > 0 {
+		query += ` AND NOT EXISTS (SELECT 1 FROM message_tags o WHERE o.message_id = t.message_id AND o.tag_key IN (` + sqlPlaceholders(len(q.Not)) + `))`
+		args = append(args, sqlTagArgs(q.Not)...)
+	}
+	if !after.IsZero() {
+		at := sqlTimeTo(after.CreatedAt)
+		query += ` AND (t.created_at < ? OR (t.created_at = ? AND t.message_id < ?))`
+		args = append(args, at, at, after.ID)
+	}
+	query += ` ORDER BY t.created_at DESC, t.message_id DESC`
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return []string{}, err
+	}
+	defer rows.Close()
+
+	out := []string{}
+	for rows.Next() {
+		var id string
+		var createdAt int64
+		if err := rows.Scan(&id, &createdAt); err != nil {
+			return []string{}, err
+		}
+		out = append(out, id)
+	}
+	if err := rows.Err(); err != nil {
+		return []string{}, err
+	}
+
+	if len(out) == 0 {
+		// no match for known tags is empty, not missing
+		known, err := s.tagsKnown(ctx, append(append([]Tag(nil), q.All...), q.Any...))
+		if err != nil {
+			return []string{}, err
+		}
+		for _, t := range q.All {
+			if !known[t.Key()] {
+				return out, ErrElementNotFound
+			}
+		}
+		if len(q.Any) > 0 {
+			for _, t := range q.Any {
+				if known[t.Key()] {
+					return out, nil
+				}
+			}
+			return out, ErrElementNotFound
+		}
+	}
+	return out, nil
+}
+
+// MsgsSearch is not supported as full-text index is kept only by memory storage.
+func (s *sqlStorage) MsgsSearch(ctx context.Context, q MsgSearchQuery, offset, limit int) ([]MsgSearchHit, error) {
+	if err := ctx.Err(); err != nil {
+		return []MsgSearchHit{}, err
+	}
+	return []MsgSearchHit{}, ErrNotSupported
+}
+
+// MsgsIDsFindByMention returns up to limit ids of messages mentioning the user, starting after the cursor.
+// Messages are ordered from the newest to the oldest. Non positive limit returns all of them.
+// Empty list is returned if the user is not mentioned in any message.
+func (s *sqlStorage) MsgsIDsFindByMention(ctx context.Context, userID string, after MsgCursor, limit int) ([]string, error) {
+	query := `SELECT message_id FROM message_mentions WHERE user_id = ?`
+	args := []interface{}{userID}
+	if !after.IsZero() {
+		at := sqlTimeTo(after.CreatedAt)
+		query += ` AND (created_at < ? OR (created_at = ? AND message_id < ?))`
+		args = append(args, at, at, after.ID)
+	}
+	query += ` ORDER BY created_at DESC, message_id DESC`
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return []string{}, err
+	}
+	defer rows.Close()
+
+	out := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return []string{}, err
+		}
+		out = append(out, id)
+	}
+	if err := rows.Err(); err != nil {
+		return []string{}, err
+	}
+	return out, nil
+}
+
+// MsgsIDsFindByAuthor returns up to limit ids of messages authored by the user, starting after the cursor.
+// Messages are ordered from the newest to the oldest. Non positive limit returns all of them.
+// Empty list is returned if the user authored no message.
+func (s *sqlStorage) MsgsIDsFindByAuthor(ctx context.Context, authorID string, after MsgCursor, limit int) ([]string, error) {
+	query := `SELECT id FROM messages WHERE author_id = ?`
+	args := []interface{}{authorID}
+	if !after.IsZero() {
+		at := sqlTimeTo(after.CreatedAt)
+		query += ` AND (created_at < ? OR (created_at = ? AND id < ?))`
+		args = append(args, at, at, after.ID)
+	}
+	query += ` ORDER BY created_at DESC, id DESC`
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return []string{}, err
+	}
+	defer rows.Close()
+
+	out := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return []string{}, err
+		}
+		out = append(out, id)
+	}
+	if err := rows.Err(); err != nil {
+		return []string{}, err
+	}
+	return out, nil
+}
+
+// tagsKnown tells which of the tags are associated with at least one message. Result is keyed by keys of the tags.
+func (s *sqlStorage) tagsKnown(ctx context.Context, tags []Tag) (map[string]bool, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		s.rebind(`SELECT DISTINCT tag_key FROM message_tags WHERE tag_key IN (`+sqlPlaceholders(len(tags))+`)`),
+		sqlTagArgs(tags)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]bool, len(tags))
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		out[key] = true
+	}
+	return out, rows.Err()
+}
+
+// WebhookSave persists single webhook.
+// ErrElementIDNotSet error is returned if webhook ID is not set.
+func (s *sqlStorage) WebhookSave(ctx context.Context, wh *Webhook) error {
+	if wh.ID == "" {
+		return ErrElementIDNotSet
+	}
+	tags, authors, err := sqlWebhookFiltersTo(wh)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(
+		ctx,
+		s.rebind(`INSERT INTO webhooks (id, url, secret, tags, authors, created_at) VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET url = excluded.url, secret = excluded.secret, tags = excluded.tags,
+			authors = excluded.authors, created_at = excluded.created_at`),
+		wh.ID, wh.URL, wh.Secret, tags, authors, sqlTimeTo(wh.CreatedAt),
+	)
+	return err
+}
+
+// WebhookLoad retrieves single webhook from storage by ID.
+// ErrElementNotFound is returned if webhook could not be found.
+func (s *sqlStorage) WebhookLoad(ctx context.Context, id string) (*Webhook, error) {
+	row := s.db.QueryRowContext(ctx, s.rebind(`SELECT id, url, secret, tags, authors, created_at FROM webhooks WHERE id = ?`), id)
+	switch wh, err := sqlWebhookScan(row); err {
+	case nil:
+		return wh, nil
+	case sql.ErrNoRows:
+		return nil, ErrElementNotFound
+	default:
+		return nil, err
+	}
+}
+
+// WebhookUpdate replaces existing webhook.
+// ErrElementIDNotSet error is returned if webhook ID is not set.
+// ErrElementNotFound is returned if webhook could not be found.
+func (s *sqlStorage) WebhookUpdate(ctx context.Context, wh *Webhook) error {
+	if wh.ID == "" {
+		return ErrElementIDNotSet
+	}
+	tags, authors, err := sqlWebhookFiltersTo(wh)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.ExecContext(
+		ctx,
+		s.rebind(`UPDATE webhooks SET url = ?, secret = ?, tags = ?, authors = ?, created_at = ? WHERE id = ?`),
+		wh.URL, wh.Secret, tags, authors, sqlTimeTo(wh.CreatedAt), wh.ID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrElementNotFound
+	}
+	return nil
+}
+
+// WebhookDelete removes webhook.
+// ErrElementNotFound is returned if webhook could not be found.
+func (s *sqlStorage) WebhookDelete(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM webhooks WHERE id = ?`), id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrElementNotFound
+	}
+	return nil
+}
+
+// WebhooksList returns all webhooks ordered by ID.
+func (s *sqlStorage) WebhooksList(ctx context.Context) ([]*Webhook, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, url, secret, tags, authors, created_at FROM webhooks ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []*Webhook{}
+	for rows.Next() {
+		wh, err := sqlWebhookScan(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, wh)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// sqlWebhookScan reads webhook from single row of "SELECT id, url, secret, tags, authors, created_at" query.
+func sqlWebhookScan(row interface {
+	Scan(dest ...interface{}) error
+}) (*Webhook, error) {
+	var wh Webhook
+	var tags, authors string
+	var createdAt int64
+	if err := row.Scan(&wh.ID, &wh.URL, &wh.Secret, &tags, &authors, &createdAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(tags), &wh.Tags); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(authors), &wh.Authors); err != nil {
+		return nil, err
+	}
+	wh.CreatedAt = sqlTimeFrom(createdAt)
+	return &wh, nil
+}
+
+// sqlWebhookFiltersTo encodes tags and authors of the webhook as JSON arrays. Empty lists are encoded as null.
+func sqlWebhookFiltersTo(wh *Webhook) (string, string, error) {
+	tags, err := json.Marshal(wh.Tags)
+	if err != nil {
+		return "", "", err
+	}
+	authors, err := json.Marshal(wh.Authors)
+	if err != nil {
+		return "", "", err
+	}
+	return string(tags), string(authors), nil
+}
+
+// sqlInBatches calls fn for consecutive, at most sqlBatchSize long, parts of ids.
+func sqlInBatches(ids []string, fn func(batch []string) error) error {
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > sqlBatchSize {
+			n = sqlBatchSize
+		}
+		if err := fn(ids[:n]); err != nil {
+			return err
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
+
+// sqlPlaceholders returns list of n placeholders for "IN (...)" clause.
+func sqlPlaceholders(n int) string {
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteByte('?')
+	}
+	return b.String()
+}
+
+// sqlArgs converts list of strings into query arguments.
+func sqlArgs(vals []string) []interface{} {
+	out := make([]interface{}, len(vals))
+	for i, v := range vals {
+		out[i] = v
+	}
+	return out
+}
+
+// sqlTagArgs converts list of tags into query arguments, tags are looked up by their keys.
+func sqlTagArgs(tags []Tag) []interface{} {
+	out := make([]interface{}, len(tags))
+	for i, t := range tags {
+		out[i] = t.Key()
+	}
+	return out
+}
+
+// sqlTagsTo encodes tags of message revision as the first tag and JSON array of all of them, as tags may contain commas.
+func sqlTagsTo(tags []Tag) (string, string, error) {
+	enc, err := json.Marshal(tags)
+	if err != nil {
+		return "", "", err
+	}
+	if len(tags) == 0 {
+		return "", string(enc), nil
+	}
+	return string(tags[0]), string(enc), nil
+}
+
+// sqlTagsFrom decodes tags of message revision. Revisions stored before messages could have many tags have single tag only.
+func sqlTagsFrom(tag, tags string) ([]Tag, error) {
+	if tags == "" {
+		if tag == "" {
+			return nil, nil
+		}
+		return []Tag{Tag(tag)}, nil
+	}
+	var out []Tag
+	if err := json.Unmarshal([]byte(tags), &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// sqlHashtagsTo encodes tags of message revision attached as hashtags as JSON array. No hashtags are kept as empty string.
+func sqlHashtagsTo(tags []Tag) (string, error) {
+	if len(tags) == 0 {
+		return "", nil
+	}
+	enc, err := json.Marshal(tags)
+	if err != nil {
+		return "", err
+	}
+	return string(enc), nil
+}
+
+// sqlHashtagsFrom decodes tags of message revision attached as hashtags.
+func sqlHashtagsFrom(enc string) ([]Tag, error) {
+	if enc == "" {
+		return nil, nil
+	}
+	var out []Tag
+	if err := json.Unmarshal([]byte(enc), &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// sqlMentionsTo encodes IDs of users mentioned in message revision as JSON array. No mentions are kept as empty string.
+func sqlMentionsTo(ids []string) (string, error) {
+	if len(ids) == 0 {
+		return "", nil
+	}
+	enc, err := json.Marshal(ids)
+	if err != nil {
+		return "", err
+	}
+	return string(enc), nil
+}
+
+// sqlMentionsFrom decodes IDs of users mentioned in message revision.
+func sqlMentionsFrom(enc string) ([]string, error) {
+	if enc == "" {
+		return nil, nil
+	}
+	var out []string
+	if err := json.Unmarshal([]byte(enc), &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// sqlRolesTo encodes roles as comma separated list.
+func sqlRolesTo(roles []Role) string {
+	ss := make([]string, len(roles))
+	for i, r := range roles {
+		ss[i] = string(r)
+	}
+	return strings.Join(ss, ",")
+}
+
+// sqlRolesFrom decodes comma separated list of roles. Empty list is decoded as nil.
+func sqlRolesFrom(v string) []Role {
+	if v == "" {
+		return nil
+	}
+	var roles []Role
+	for _, r := range strings.Split(v, ",") {
+		roles = append(roles, Role(r))
+	}
+	return roles
+}
+
+// sqlTimeTo converts time into unix nanoseconds kept in the database.
+// Zero time is stored as 0 so that it's still ordered before any other.
+func sqlTimeTo(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// sqlTimeFrom converts unix nanoseconds kept in the database into UTC time.
+func sqlTimeFrom(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
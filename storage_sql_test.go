@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+func Test_SQLStorage_Factory(t *testing.T) {
+	s, closer := tsSQLStorageSetup(t)
+	defer closer()
+
+	ar.NotNil(t, s, "empty element returned")
+	ar.IsType(t, &sqlStorage{}, s)
+
+	v, err := s.schemaVersion()
+	ar.NoError(t, err)
+	a.Equal(t, sqlMigrations[len(sqlMigrations)-1].version, v, "schema not migrated to latest version")
+}
+
+func Test_SQLStorage_Factory_UnknownDriver(t *testing.T) {
+	_, err := NewSQLStorage("unknown", "")
+	a.Error(t, err, "unknown driver accepted")
+}
+
+func Test_SQLStorage_Migrate_Reopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "messenger-sql-")
+	ar.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dsn := filepath.Join(dir, "test.db")
+
+	s, err := NewSQLStorage(sqlDriverSQLite, dsn)
+	ar.NoError(t, err)
+	userExp := tfUserA
+	ar.NoError(t, s.UserSave(context.Background(), &userExp))
+	ar.NoError(t, s.Close())
+
+	// WHEN: database is opened again migrations are not repeated
+	s, err = NewSQLStorage(sqlDriverSQLite, dsn)
+	ar.NoError(t, err, "unexpected error on reopen")
+	defer s.Close()
+
+	userGot, err := s.UserLoad(context.Background(), userExp.ID)
+	ar.NoError(t, err)
+	a.Equal(t, &userExp, userGot, "User from storage does not match")
+}
+
+func Test_SQLStorage_Migrate_Keys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "messenger-sql-")
+	ar.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dsn := filepath.Join(dir, "test.db")
+
+	// GIVEN: database from before tags and names had keys
+	migrations := sqlMigrations
+	sqlMigrations = sqlMigrations[:8]
+	s, err := NewSQLStorage(sqlDriverSQLite, dsn)
+	sqlMigrations = migrations
+	ar.NoError(t, err)
+	for _, stmt := range []string{
+		`INSERT INTO users (id, name) VALUES ('UserA-ID', 'Bob'), ('UserB-ID', 'bob')`,
+		`INSERT INTO messages (id, author_id, body, created_at, modified_at) VALUES ('Msg-ID', 'UserA-ID', 'Body', 1, 1)`,
+		`INSERT INTO message_tags (message_id, tag, created_at, position) VALUES ('Msg-ID', 'Go', 1, 0)`,
+	} {
+		_, err := s.db.Exec(stmt)
+		ar.NoError(t, err, "unexpected error on legacy data insert")
+	}
+	ar.NoError(t, s.Close())
+
+	// WHEN: it's migrated
+	s, err = NewSQLStorage(sqlDriverSQLite, dsn)
+	ar.NoError(t, err, "unexpected error on migration")
+	defer s.Close()
+
+	// THEN: tag is found by its key
+	idsGot, err := s.MsgsIDsFindByTag(context.Background(), "GO", MsgCursor{}, 0)
+	ar.NoError(t, err)
+	a.Equal(t, []string{"Msg-ID"}, idsGot, "message not found by key of the tag")
+
+	// AND: both users sharing the key are kept, the first one is found by name
+	userGot, err := s.UserFindByName(context.Background(), "BOB")
+	ar.NoError(t, err)
+	a.Equal(t, "UserA-ID", userGot.ID, "user found by name mismatch")
+	userGot, err = s.UserLoad(context.Background(), "UserB-ID")
+	ar.NoError(t, err)
+	a.Equal(t, "bob", userGot.Name, "user sharing the key changed")
+
+	// AND: new user is not able to take the key
+	a.Equal(t, ErrElementDuplicated, s.UserSave(context.Background(), &User{ID: "UserC-ID", Name: "BOB"}))
+}
+
+func Test_SQLStorage_Migrate_NameKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "messenger-sql-")
+	ar.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dsn := filepath.Join(dir, "test.db")
+
+	// GIVEN: database where capital I had other key than small i
+	migrations := sqlMigrations
+	sqlMigrations = sqlMigrations[:11]
+	s, err := NewSQLStorage(sqlDriverSQLite, dsn)
+	sqlMigrations = migrations
+	ar.NoError(t, err)
+	for _, stmt := range []string{
+		`INSERT INTO users (id, name, name_key) VALUES ('UserA-ID', 'ian', 'lan'), ('UserB-ID', 'Ian', 'ian')`,
+	} {
+		_, err := s.db.Exec(stmt)
+		ar.NoError(t, err, "unexpected error on legacy data insert")
+	}
+	ar.NoError(t, s.Close())
+
+	// WHEN: it's migrated
+	s, err = NewSQLStorage(sqlDriverSQLite, dsn)
+	ar.NoError(t, err, "unexpected error on migration")
+	defer s.Close()
+
+	// THEN: both users sharing the key are kept, the first one is found by name in any case
+	for _, name := range []string{"ian", "IAN"} {
+		userGot, err := s.UserFindByName(context.Background(), name)
+		ar.NoError(t, err, "[%s] unexpected error", name)
+		a.Equal(t, "UserA-ID", userGot.ID, "[%s] user found by name mismatch", name)
+	}
+	userGot, err := s.UserLoad(context.Background(), "UserB-ID")
+	ar.NoError(t, err)
+	a.Equal(t, "Ian", userGot.Name, "user sharing the key changed")
+
+	// AND: new user is not able to take the key
+	a.Equal(t, ErrElementDuplicated, s.UserSave(context.Background(), &User{ID: "UserC-ID", Name: "IAN"}))
+}
+
+func Test_SQLStorage_Rebind(t *testing.T) {
+	s := &sqlStorage{driver: sqlDriverPostgres}
+	a.Equal(t, "SELECT a FROM b WHERE c = $1 AND d = $2", s.rebind("SELECT a FROM b WHERE c = ? AND d = ?"))
+
+	s = &sqlStorage{driver: sqlDriverSQLite}
+	a.Equal(t, "SELECT a FROM b WHERE c = ?", s.rebind("SELECT a FROM b WHERE c = ?"))
+}
+
+// -- section: User
+func Test_SQLStorage_UserSave_Failure_NameDuplicated(t *testing.T) {
+	s, closer := tsSQLStorageSetup(t)
+	defer closer()
+
+	// GIVEN: user is in storage
+	userA := tfUserA
+	ar.NoError(t, s.UserSave(context.Background(), &userA))
+
+	// WHEN: other user with the same name is saved
+	userB := tfUserB
+	userB.Name = userA.Name
+	a.Equal(t, ErrElementDuplicated, s.UserSave(context.Background(), &userB))
+
+	_, err := s.UserLoad(context.Background(), userB.ID)
+	a.Equal(t, ErrElementNotFound, err, "duplicated user stored")
+}
+
+func Test_SQLStorage_UserSave_Failure_NameKeyDuplicated(t *testing.T) {
+	s, closer := tsSQLStorageSetup(t)
+	defer closer()
+
+	// GIVEN: user is in storage
+	userA := tfUserA
+	ar.NoError(t, s.UserSave(context.Background(), &userA))
+
+	tests := map[string]string{
+		"case":       "usera-name",
+		"look-alike": "UserА-Nаme", // Cyrillic А and а
+	}
+	for sym, name := range tests {
+		// WHEN: other user with name sharing the key is saved or renamed
+		userB := tfUserB
+		userB.Name = name
+		a.Equal(t, ErrElementDuplicated, s.UserSave(context.Background(), &userB), "[%s] mismatch on save error", sym)
+
+		userB.Name = tfUserB.Name
+		ar.NoError(t, s.UserSave(context.Background(), &userB), "[%s] unexpected error on save", sym)
+		userB.Name = name
+		a.Equal(t, ErrElementDuplicated, s.UserUpdate(context.Background(), &userB), "[%s] mismatch on update error", sym)
+		ar.NoError(t, s.UserDelete(context.Background(), userB.ID, UserDeleteReject), "[%s] unexpected error on cleanup", sym)
+	}
+
+	// AND: user may change case of own name
+	userA.Name = "USERA-NAME"
+	a.NoError(t, s.UserUpdate(context.Background(), &userA), "own name rejected")
+}
+
+func Test_SQLStorage_UserSave_Rename(t *testing.T) {
+	s, closer := tsSQLStorageSetup(t)
+	defer closer()
+
+	user := tfUserA
+	ar.NoError(t, s.UserSave(context.Background(), &user))
+	user.Name = "UserA-NameChanged"
+	ar.NoError(t, s.UserSave(context.Background(), &user))
+
+	elGot, err := s.UserFindByName(context.Background(), user.Name)
+	ar.NoError(t, err)
+	a.Equal(t, &user, elGot, "User from storage does not match")
+
+	_, err = s.UserFindByName(context.Background(), tfUserA.Name)
+	a.Equal(t, ErrElementNotFound, err, "old name still matches")
+}
+
+// -- section: Message
+func Test_SQLStorage_MsgRevisions_Tags(t *testing.T) {
+	s, closer := tsSQLStorageSetup(t)
+	defer closer()
+
+	// GIVEN: message with many tags is edited twice
+	msgV1 := tfMsgAC
+	ar.NoError(t, s.MsgSave(context.Background(), &msgV1))
+	msgV2 := tfMsgAC
+	msgV2.Tags = []Tag{tfTagA}
+	ar.NoError(t, s.MsgUpdate(context.Background(), &msgV2))
+	msgV3 := tfMsgAC
+	ar.NoError(t, s.MsgUpdate(context.Background(), &msgV3))
+
+	// AND: first revision is stored the way it was before messages could have many tags
+	_, err := s.db.Exec(s.rebind(`UPDATE message_revisions SET tags = '' WHERE message_id = ? AND revision = 1`), tfMsgAC.ID)
+	ar.NoError(t, err)
+
+	// THEN: all tags of each revision are restored, in order
+	revsGot, err := s.MsgRevisions(context.Background(), tfMsgAC.ID)
+	ar.NoError(t, err)
+	ar.Len(t, revsGot, 2, "mismatch on number of revisions")
+	a.Equal(t, []Tag{tfTagB}, revsGot[0].Tags, "legacy revision tags mismatch")
+	a.Equal(t, []Tag{tfTagA}, revsGot[1].Tags, "revision tags mismatch")
+
+	msgGot, err := s.MsgLoad(context.Background(), tfMsgAC.ID)
+	ar.NoError(t, err)
+	a.Equal(t, tfMsgAC.Tags, msgGot.Tags, "current tags mismatch")
+}
+
+// -- test helpers
+func tsSQLStorageSetup(t *testing.T) (*sqlStorage, func()) {
+	s, err := NewSQLStorage(sqlDriverSQLite, ":memory:")
+	ar.NoError(t, err, "unexpected error on storage creation")
+
+	closer := func() {
+		s.Close()
+	}
+	return s, closer
+}
+
+func Test_SQLStorage_MsgRevisions_Mentions(t *testing.T) {
+	s, closer := tsSQLStorageSetup(t)
+	defer closer()
+
+	// GIVEN: message mentioning both users is edited to mention none of them
+	msgV1 := tfMsgAA
+	msgV1.Mentions = []string{tfUserB.ID, tfUserA.ID}
+	ar.NoError(t, s.MsgSave(context.Background(), &msgV1))
+	msgV2 := tfMsgAA
+	ar.NoError(t, s.MsgUpdate(context.Background(), &msgV2))
+
+	// THEN: revision keeps mentions, in order
+	revsGot, err := s.MsgRevisions(context.Background(), tfMsgAA.ID)
+	ar.NoError(t, err)
+	ar.Len(t, revsGot, 1, "mismatch on number of revisions")
+	a.Equal(t, []string{tfUserB.ID, tfUserA.ID}, revsGot[0].Mentions, "revision mentions mismatch")
+
+	msgGot, err := s.MsgLoad(context.Background(), tfMsgAA.ID)
+	ar.NoError(t, err)
+	a.Empty(t, msgGot.Mentions, "current mentions mismatch")
+}
@@ -0,0 +1,1647 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+// -- section: conformance runs
+func Test_Storer_Conformance_MemoryStorage(t *testing.T) {
+	tsStorerConformance(t, func() Storer {
+		return NewMemoryStorage()
+	})
+}
+
+func Test_Storer_Conformance_MemoryStorageMock(t *testing.T) {
+	tsStorerConformance(t, func() Storer {
+		return NewTmMemoryStorageMock()
+	})
+}
+
+func Test_Storer_Conformance_TracingStorer(t *testing.T) {
+	tsStorerConformance(t, func() Storer {
+		return NewTracingStorer(NewMemoryStorage())
+	})
+}
+
+func Test_Storer_Conformance_FileStorage(t *testing.T) {
+	var dirs []string
+	defer func() {
+		for _, d := range dirs {
+			os.RemoveAll(d)
+		}
+	}()
+
+	tsStorerConformance(t, func() Storer {
+		dir, err := ioutil.TempDir("", "messenger-storage-")
+		ar.NoError(t, err, "unexpected error on temp dir creation")
+		dirs = append(dirs, dir)
+
+		s, err := NewFileStorage(dir, 5)
+		ar.NoError(t, err, "unexpected error on storage creation")
+		return s
+	})
+}
+
+func Test_Storer_Conformance_SQLStorage(t *testing.T) {
+	tsStorerConformance(t, func() Storer {
+		s, err := NewSQLStorage(sqlDriverSQLite, ":memory:")
+		ar.NoError(t, err, "unexpected error on storage creation")
+		return s
+	})
+}
+
+// -- section: conformance suite
+
+// tsStorerConformance runs behavioural tests common for all Storer implementations.
+// Factory shall return new, empty storage on each call. Storage is closed after each test.
+func tsStorerConformance(t *testing.T, factory func() Storer) {
+	tests := map[string]func(t *testing.T, s Storer){
+		"UserSave: success":             tsStorerUserSaveSuccess,
+		"UserSave: failure, no ID":      tsStorerUserSaveFailureNoID,
+		"UserSave: name taken":          tsStorerUserSaveNameTaken,
+		"UserSave: same name, parallel": tsStorerUserSaveSameNameParallel,
+		"UserLoad: exists":              tsStorerUserLoadExists,
+		"UserLoad: not found":           tsStorerUserLoadNotFound,
+		"UserLoadMany: exists":          tsStorerUserLoadManyExists,
+		"UserLoadMany: not found":       tsStorerUserLoadManyNotFound,
+		"UserFindByName: exists":        tsStorerUserFindByNameExists,
+		"UserFindByName: not found":     tsStorerUserFindByNameNotFound,
+		"UserFindByName: by key":        tsStorerUserFindByNameByKey,
+		"UserUpdate: success":           tsStorerUserUpdateSuccess,
+		"UserUpdate: failure, no ID":    tsStorerUserUpdateFailureNoID,
+		"UserUpdate: not found":         tsStorerUserUpdateNotFound,
+		"UserUpdate: roles":             tsStorerUserUpdateRoles,
+		"UserUpdate: name taken":        tsStorerUserUpdateNameTaken,
+		"UserDelete: reject":            tsStorerUserDeleteReject,
+		"UserDelete: cascade":           tsStorerUserDeleteCascade,
+		"UserDelete: anonymise":         tsStorerUserDeleteAnonymise,
+		"UserDelete: not found":         tsStorerUserDeleteNotFound,
+		"UserDelete: name released":     tsStorerUserDeleteNameReleased,
+		"UserDelete: mentions":          tsStorerUserDeleteMentions,
+		"UserDelete: published":         tsStorerUserDeletePublished,
+		"UsersList: paging":             tsStorerUsersListPaging,
+		"MsgSave: success":              tsStorerMsgSaveSuccess,
+		"MsgSave: failure, no ID":       tsStorerMsgSaveFailureNoID,
+		"MsgLoad: exists":               tsStorerMsgLoadExists,
+		"MsgLoad: not found":            tsStorerMsgLoadNotFound,
+		"MsgLoadMany: exists":           tsStorerMsgLoadManyExists,
+		"MsgLoadMany: not found":        tsStorerMsgLoadManyNotFound,
+		"MsgLoadMany: empty":            tsStorerMsgLoadManyEmpty,
+		"MsgUpdate: success":            tsStorerMsgUpdateSuccess,
+		"MsgUpdate: failure, no ID":     tsStorerMsgUpdateFailureNoID,
+		"MsgUpdate: not found":          tsStorerMsgUpdateNotFound,
+		"MsgDelete: success":            tsStorerMsgDeleteSuccess,
+		"MsgDelete: not found":          tsStorerMsgDeleteNotFound,
+		"MsgRevisions: edited":          tsStorerMsgRevisionsEdited,
+		"MsgRevisions: never edited":    tsStorerMsgRevisionsNeverEdited,
+		"MsgRevisions: hashtags":        tsStorerMsgRevisionsHashtags,
+		"MsgRevisions: not found":       tsStorerMsgRevisionsNotFound,
+		"MsgsIDsFindByTag: exists":      tsStorerMsgsIDsFindByTagExists,
+		"MsgsIDsFindByTag: not found":   tsStorerMsgsIDsFindByTagNotFound,
+		"MsgsIDsFindByTag: consistency": tsStorerMsgsIDsFindByTagConsistency,
+		"MsgsIDsFindByTag: ordered":     tsStorerMsgsIDsFindByTagOrdered,
+		"MsgsIDsFindByTag: same time":   tsStorerMsgsIDsFindByTagSameTime,
+		"MsgsIDsFindByTag: paging":      tsStorerMsgsIDsFindByTagPaging,
+		"MsgsIDsFindByTag: re-tagged":   tsStorerMsgsIDsFindByTagRetagged,
+		"MsgsIDsFindByTags: all":        tsStorerMsgsIDsFindByTagsAll,
+		"MsgsIDsFindByTags: any":        tsStorerMsgsIDsFindByTagsAny,
+		"MsgsIDsFindByTags: not":        tsStorerMsgsIDsFindByTagsNot,
+		"MsgsIDsFindByTags: not found":  tsStorerMsgsIDsFindByTagsNotFound,
+		"MsgsIDsFindByTags: paging":     tsStorerMsgsIDsFindByTagsPaging,
+		"MsgsIDsFindByTags: by key":     tsStorerMsgsIDsFindByTagsByKey,
+		"MsgsIDsFindByMention: ordered": tsStorerMsgsIDsFindByMentionOrdered,
+		"MsgsIDsFindByMention: paging":  tsStorerMsgsIDsFindByMentionPaging,
+		"MsgsIDsFindByMention: updated": tsStorerMsgsIDsFindByMentionUpdated,
+		"MsgsIDsFindByMention: deleted": tsStorerMsgsIDsFindByMentionDeleted,
+		"MsgsIDsFindByMention: none":    tsStorerMsgsIDsFindByMentionNone,
+		"MsgsIDsFindByAuthor: paging":   tsStorerMsgsIDsFindByAuthorPaging,
+		"MsgsIDsFindByAuthor: none":     tsStorerMsgsIDsFindByAuthorNone,
+		"MsgsSearch: words":             tsStorerMsgsSearchWords,
+		"MsgsSearch: phrase":            tsStorerMsgsSearchPhrase,
+		"MsgsSearch: prefix":            tsStorerMsgsSearchPrefix,
+		"MsgsSearch: filters":           tsStorerMsgsSearchFilters,
+		"MsgsSearch: no terms":          tsStorerMsgsSearchNoTerms,
+		"MsgsSearch: same score":        tsStorerMsgsSearchSameScore,
+		"MsgsSearch: paging":            tsStorerMsgsSearchPaging,
+		"MsgsSearch: updated":           tsStorerMsgsSearchUpdated,
+		"MsgsSearch: deleted":           tsStorerMsgsSearchDeleted,
+		"concurrent writers":            tsStorerConcurrentWriters,
+		"context: cancelled":            tsStorerContextCancelled,
+		"Ping":                          tsStorerPing,
+		"Webhook: save and load":        tsStorerWebhookSaveLoad,
+		"Webhook: failure, no ID":       tsStorerWebhookFailureNoID,
+		"Webhook: not found":            tsStorerWebhookNotFound,
+		"Webhook: update":               tsStorerWebhookUpdate,
+		"Webhook: delete":               tsStorerWebhookDelete,
+	}
+
+	for name, tFn := range tests {
+		tFn := tFn
+		t.Run(name, func(t *testing.T) {
+			s := factory()
+			defer s.Close()
+			tFn(t, s)
+		})
+	}
+}
+
+// -- section: User
+func tsStorerUserSaveSuccess(t *testing.T, s Storer) {
+	elExp := tfUserA
+	ar.NoError(t, s.UserSave(context.Background(), &elExp))
+
+	elGot, err := s.UserLoad(context.Background(), elExp.ID)
+	ar.NoError(t, err)
+	a.Equal(t, &elExp, elGot, "User from storage does not match")
+}
+
+func tsStorerUserSaveFailureNoID(t *testing.T, s Storer) {
+	ar.Equal(t, ErrElementIDNotSet, s.UserSave(context.Background(), &tfUserXA_NoID))
+
+	_, err := s.UserFindByName(context.Background(), tfUserXA_NoID.Name)
+	a.Equal(t, ErrElementNotFound, err, "unexpected element stored")
+}
+
+func tsStorerUserSaveNameTaken(t *testing.T, s Storer) {
+	// GIVEN: user is in storage
+	user := tfUserA
+	ar.NoError(t, s.UserSave(context.Background(), &user))
+
+	tests := map[string]string{
+		"same":       tfUserA.Name,
+		"case":       "usera-NAME",
+		"look-alike": "UserА-Nаme", // Cyrillic А and а
+	}
+	for sym, name := range tests {
+		// WHEN: other user with the same name is saved
+		other := User{ID: "UserX-ID", Name: name}
+		a.Equal(t, ErrElementDuplicated, s.UserSave(context.Background(), &other), "[%s] mismatch on save error", sym)
+
+		// THEN: other user is not stored
+		_, err := s.UserLoad(context.Background(), other.ID)
+		a.Equal(t, ErrElementNotFound, err, "[%s] duplicated user stored", sym)
+	}
+
+	// AND: user holding the name may be saved again
+	ar.NoError(t, s.UserSave(context.Background(), &user), "user saved over")
+	userGot, err := s.UserFindByName(context.Background(), tfUserA.Name)
+	ar.NoError(t, err)
+	a.Equal(t, &user, userGot, "User found by name does not match")
+}
+
+func tsStorerUserSaveSameNameParallel(t *testing.T, s Storer) {
+	const writers = 8
+
+	// WHEN: different users with the same name are saved concurrently
+	var wg sync.WaitGroup
+	errCh := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			errCh <- s.UserSave(context.Background(), &User{ID: fmt.Sprintf("User-%d-ID", w), Name: "UserX-Name"})
+		}(w)
+	}
+	wg.Wait()
+	close(errCh)
+
+	// THEN: only one of them is stored
+	saved := 0
+	for err := range errCh {
+		switch err {
+		case nil:
+			saved++
+		case ErrElementDuplicated:
+		default:
+			t.Errorf("unexpected error on concurrent save: %s", err)
+		}
+	}
+	a.Equal(t, 1, saved, "mismatch on number of users saved")
+
+	users, err := s.UsersList(context.Background(), "", 0)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+	ar.Len(t, users, 1, "mismatch on number of users stored")
+	userGot, err := s.UserFindByName(context.Background(), "UserX-Name")
+	ar.NoError(t, err)
+	a.Equal(t, users[0], userGot, "User found by name does not match")
+}
+
+func tsStorerUserLoadExists(t *testing.T, s Storer) {
+	elExp := tfUserA
+
+	// GIVEN: expected user is in storage
+	ar.NoError(t, s.UserSave(context.Background(), &elExp))
+
+	elGot, err := s.UserLoad(context.Background(), elExp.ID)
+	ar.NoError(t, err)
+	a.Equal(t, &elExp, elGot, "User from storage does not match")
+}
+
+func tsStorerUserLoadNotFound(t *testing.T, s Storer) {
+	// GIVEN: expected user is NOT in storage
+
+	_, err := s.UserLoad(context.Background(), tfUserA.ID)
+	ar.Equal(t, ErrElementNotFound, err)
+}
+
+func tsStorerUserLoadManyExists(t *testing.T, s Storer) {
+	// GIVEN: expected users are in storage
+	usersExp := []User{tfUserA, tfUserB}
+	for _, u := range usersExp {
+		uC := u
+		ar.NoError(t, s.UserSave(context.Background(), &uC))
+	}
+
+	// WHEN: users are requested in order different than saved, with duplicates
+	usersGot, err := s.UserLoadMany(context.Background(), []string{tfUserB.ID, tfUserA.ID, tfUserB.ID})
+	ar.NoError(t, err)
+
+	// THEN: users are returned in requested order
+	ar.Len(t, usersGot, 3, "mismatched number of users returned")
+	a.Equal(t, &usersExp[1], usersGot[0], "User from storage does not match")
+	a.Equal(t, &usersExp[0], usersGot[1], "User from storage does not match")
+	a.Equal(t, &usersExp[1], usersGot[2], "User from storage does not match")
+}
+
+func tsStorerUserLoadManyNotFound(t *testing.T, s Storer) {
+	// GIVEN: only one of requested users is in storage
+	user := tfUserA
+	ar.NoError(t, s.UserSave(context.Background(), &user))
+
+	_, err := s.UserLoadMany(context.Background(), []string{tfUserA.ID, tfUserB.ID})
+	ar.Equal(t, ErrElementNotFound, err)
+}
+
+func tsStorerUserFindByNameExists(t *testing.T, s Storer) {
+	elExp := tfUserA
+
+	// GIVEN: expected user is in storage
+	ar.NoError(t, s.UserSave(context.Background(), &elExp))
+
+	elGot, err := s.UserFindByName(context.Background(), elExp.Name)
+	ar.NoError(t, err)
+	a.Equal(t, &elExp, elGot, "User from storage does not match")
+}
+
+func tsStorerUserFindByNameNotFound(t *testing.T, s Storer) {
+	// GIVEN: expected user is NOT in storage
+
+	_, err := s.UserFindByName(context.Background(), tfUserA.Name)
+	ar.Equal(t, ErrElementNotFound, err)
+}
+
+func tsStorerUserFindByNameByKey(t *testing.T, s Storer) {
+	// GIVEN: user with name in normalisation form C is in storage
+	elExp := User{ID: "UserZ-ID", Name: "Zoë-Name"}
+	ar.NoError(t, s.UserSave(context.Background(), &elExp))
+
+	tests := map[string]string{
+		"exact":       "Zoë-Name",
+		"decomposed":  "Zoe\u0308-Name",
+		"case":        "ZOË-name",
+		"look-alike":  "Zоё-Nаme", // Cyrillic о, ё and а
+		"digit for o": "Z0ë-Name",
+		"fullwidth":   "Ｚoë-Name",
+	}
+	for sym, name := range tests {
+		elGot, err := s.UserFindByName(context.Background(), name)
+		if a.NoError(t, err, "[%s] unexpected error", sym) {
+			a.Equal(t, &elExp, elGot, "[%s] User from storage does not match", sym)
+		}
+	}
+
+	// AND: different name is not found
+	_, err := s.UserFindByName(context.Background(), "Zoe-Name")
+	a.Equal(t, ErrElementNotFound, err, "name without diaeresis")
+
+	// AND: capital I is taken for l, as they look alike
+	elI := User{ID: "UserI-ID", Name: "Il-Name"}
+	ar.NoError(t, s.UserSave(context.Background(), &elI))
+	for sym, name := range map[string]string{"l for capital I": "ll-Name", "digit for l": "I1-Name"} {
+		elGot, err := s.UserFindByName(context.Background(), name)
+		if a.NoError(t, err, "[%s] unexpected error", sym) {
+			a.Equal(t, &elI, elGot, "[%s] User from storage does not match", sym)
+		}
+	}
+
+	// AND: name with capital I is found in any case and not taken again
+	elIan := User{ID: "UserIan-ID", Name: "Ian"}
+	ar.NoError(t, s.UserSave(context.Background(), &elIan))
+	for _, name := range []string{"Ian", "ian", "IAN"} {
+		elGot, err := s.UserFindByName(context.Background(), name)
+		if a.NoError(t, err, "[%s] unexpected error", name) {
+			a.Equal(t, &elIan, elGot, "[%s] User from storage does not match", name)
+		}
+	}
+	a.Equal(t, ErrElementDuplicated, s.UserSave(context.Background(), &User{ID: "UserIan2-ID", Name: "ian"}), "name differing by case taken")
+}
+
+func tsStorerUserUpdateSuccess(t *testing.T, s Storer) {
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		ar.NoError(t, s.UserSave(context.Background(), &uC))
+	}
+
+	// WHEN: user is renamed
+	userExp := tfUserA
+	userExp.Name = "UserA-Name-Renamed"
+	ar.NoError(t, s.UserUpdate(context.Background(), &userExp))
+
+	// THEN: user is changed, ID is kept
+	userGot, err := s.UserLoad(context.Background(), tfUserA.ID)
+	ar.NoError(t, err)
+	a.Equal(t, &userExp, userGot, "User from storage does not match")
+
+	// AND: user is found by new name only
+	userGot, err = s.UserFindByName(context.Background(), userExp.Name)
+	ar.NoError(t, err)
+	a.Equal(t, &userExp, userGot, "User not found by new name")
+	_, err = s.UserFindByName(context.Background(), tfUserA.Name)
+	a.Equal(t, ErrElementNotFound, err, "User found by old name")
+}
+
+func tsStorerUserUpdateFailureNoID(t *testing.T, s Storer) {
+	a.Equal(t, ErrElementIDNotSet, s.UserUpdate(context.Background(), &tfUserXA_NoID))
+}
+
+func tsStorerUserUpdateNotFound(t *testing.T, s Storer) {
+	user := tfUserA
+	a.Equal(t, ErrElementNotFound, s.UserUpdate(context.Background(), &user))
+
+	// THEN: user is not created
+	_, err := s.UserLoad(context.Background(), user.ID)
+	a.Equal(t, ErrElementNotFound, err, "user created on update")
+}
+
+func tsStorerUserUpdateRoles(t *testing.T, s Storer) {
+	// GIVEN: user with roles
+	userExp := tfUserA.WithRole(RoleModerator).WithRole(RoleAdmin)
+	ar.NoError(t, s.UserSave(context.Background(), &userExp))
+
+	// THEN: roles are kept in order of granting
+	userGot, err := s.UserLoad(context.Background(), userExp.ID)
+	ar.NoError(t, err)
+	a.Equal(t, &userExp, userGot, "User from storage does not match")
+	userGot, err = s.UserFindByName(context.Background(), userExp.Name)
+	ar.NoError(t, err)
+	a.Equal(t, &userExp, userGot, "User found by name does not match")
+	usersGot, err := s.UserLoadMany(context.Background(), []string{userExp.ID})
+	ar.NoError(t, err)
+	a.Equal(t, []*User{&userExp}, usersGot, "Users loaded in batch do not match")
+
+	// WHEN: role is revoked
+	userExp = userExp.WithoutRole(RoleModerator)
+	ar.NoError(t, s.UserUpdate(context.Background(), &userExp))
+
+	// THEN: remaining roles are kept
+	userGot, err = s.UserLoad(context.Background(), userExp.ID)
+	ar.NoError(t, err)
+	a.Equal(t, []Role{RoleAdmin}, userGot.Roles, "roles mismatch after revoke")
+
+	// WHEN: the last role is revoked
+	userExp = userExp.WithoutRole(RoleAdmin)
+	ar.NoError(t, s.UserUpdate(context.Background(), &userExp))
+
+	// THEN: user holds no roles
+	userGot, err = s.UserLoad(context.Background(), userExp.ID)
+	ar.NoError(t, err)
+	a.Empty(t, userGot.Roles, "roles left after revoke")
+}
+
+func tsStorerUserUpdateNameTaken(t *testing.T, s Storer) {
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		ar.NoError(t, s.UserSave(context.Background(), &uC))
+	}
+
+	// WHEN: user is renamed to the name of other user
+	user := tfUserB
+	user.Name = "USERA-name"
+	a.Equal(t, ErrElementDuplicated, s.UserUpdate(context.Background(), &user), "mismatch on update error")
+
+	// THEN: both users are kept as they were
+	for _, uExp := range []User{tfUserA, tfUserB} {
+		uC := uExp
+		userGot, err := s.UserFindByName(context.Background(), uExp.Name)
+		ar.NoError(t, err)
+		a.Equal(t, &uC, userGot, "User found by name does not match")
+	}
+
+	// AND: user may be renamed to the variant of own name
+	user = tfUserA
+	user.Name = "USERA-name"
+	ar.NoError(t, s.UserUpdate(context.Background(), &user), "unexpected error on rename to own name")
+	userGot, err := s.UserFindByName(context.Background(), tfUserA.Name)
+	ar.NoError(t, err)
+	a.Equal(t, &user, userGot, "User found by name does not match")
+}
+
+// tsStorerUserDeleteSetup stores users A and B along with their messages.
+// Message AA is edited, so it also has revision.
+func tsStorerUserDeleteSetup(t *testing.T, s Storer) {
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		ar.NoError(t, s.UserSave(context.Background(), &uC))
+	}
+	for _, m := range []Message{tfMsgAA, tfMsgAB, tfMsgBA} {
+		mC := m
+		ar.NoError(t, s.MsgSave(context.Background(), &mC))
+	}
+	msgEdited := tfMsgAA
+	msgEdited.Body = "UserA_MessageA-Body-Edited"
+	ar.NoError(t, s.MsgSave(context.Background(), &msgEdited))
+}
+
+func tsStorerUserDeleteReject(t *testing.T, s Storer) {
+	tsStorerUserDeleteSetup(t, s)
+	userC := User{ID: "UserC-ID", Name: "UserC-Name"}
+	ar.NoError(t, s.UserSave(context.Background(), &userC))
+
+	// WHEN: user with messages is removed
+	err := s.UserDelete(context.Background(), tfUserA.ID, UserDeleteReject)
+	tsStorerSkipNotSupported(t, err)
+
+	// THEN: removal is refused
+	a.Equal(t, ErrElementInUse, err)
+	_, err = s.UserLoad(context.Background(), tfUserA.ID)
+	a.NoError(t, err, "user removed")
+	_, err = s.MsgLoad(context.Background(), tfMsgAA.ID)
+	a.NoError(t, err, "message removed")
+
+	// AND: user without messages is removed
+	ar.NoError(t, s.UserDelete(context.Background(), userC.ID, UserDeleteReject))
+	_, err = s.UserLoad(context.Background(), userC.ID)
+	a.Equal(t, ErrElementNotFound, err, "user not removed")
+}
+
+func tsStorerUserDeleteCascade(t *testing.T, s Storer) {
+	tsStorerUserDeleteSetup(t, s)
+
+	err := s.UserDelete(context.Background(), tfUserA.ID, UserDeleteCascade)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+
+	// THEN: user and its messages are gone
+	_, err = s.UserLoad(context.Background(), tfUserA.ID)
+	a.Equal(t, ErrElementNotFound, err, "user not removed")
+	for _, id := range []string{tfMsgAA.ID, tfMsgAB.ID} {
+		_, err = s.MsgLoad(context.Background(), id)
+		a.Equal(t, ErrElementNotFound, err, "message not removed: %s", id)
+		_, err = s.MsgRevisions(context.Background(), id)
+		a.Equal(t, ErrElementNotFound, err, "history not removed: %s", id)
+	}
+
+	// AND: messages of other users are left intact
+	idsGot, err := s.MsgsIDsFindByTag(context.Background(), tfTagA, MsgCursor{}, 0)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgBA.ID}, idsGot, "message still associated with tag")
+}
+
+func tsStorerUserDeleteAnonymise(t *testing.T, s Storer) {
+	tsStorerUserDeleteSetup(t, s)
+
+	err := s.UserDelete(context.Background(), tfUserA.ID, UserDeleteAnonymise)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+
+	// THEN: user is gone
+	_, err = s.UserLoad(context.Background(), tfUserA.ID)
+	a.Equal(t, ErrElementNotFound, err, "user not removed")
+
+	// AND: messages are kept without author
+	msgExp := tfMsgAB
+	msgExp.AuthorID = ""
+	msgGot, err := s.MsgLoad(context.Background(), tfMsgAB.ID)
+	ar.NoError(t, err)
+	a.Equal(t, &msgExp, msgGot, "message not anonymised")
+
+	revExp := tfMsgAA
+	revExp.AuthorID = ""
+	revsGot, err := s.MsgRevisions(context.Background(), tfMsgAA.ID)
+	ar.NoError(t, err)
+	a.Equal(t, []*Message{&revExp}, revsGot, "revision not anonymised")
+
+	idsGot, err := s.MsgsIDsFindByTag(context.Background(), tfTagA, MsgCursor{}, 0)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgBA.ID, tfMsgAB.ID, tfMsgAA.ID}, idsGot, "messages not associated with tag")
+
+	// AND: messages of other users are left intact
+	msgGot, err = s.MsgLoad(context.Background(), tfMsgBA.ID)
+	ar.NoError(t, err)
+	a.Equal(t, &tfMsgBA, msgGot, "message of other user changed")
+}
+
+func tsStorerUserDeleteNotFound(t *testing.T, s Storer) {
+	err := s.UserDelete(context.Background(), tfUserA.ID, UserDeleteCascade)
+	tsStorerSkipNotSupported(t, err)
+	a.Equal(t, ErrElementNotFound, err)
+}
+
+func tsStorerUserDeleteMentions(t *testing.T, s Storer) {
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		ar.NoError(t, s.UserSave(context.Background(), &uC))
+	}
+	tsStorerMsgsMentioning(t, s)
+
+	// WHEN: mentioned user is removed
+	err := s.UserDelete(context.Background(), tfUserB.ID, UserDeleteAnonymise)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+
+	// THEN: messages mentioning the user are not listed anymore
+	idsGot, err := s.MsgsIDsFindByMention(context.Background(), tfUserB.ID, MsgCursor{}, 0)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+	a.Len(t, idsGot, 0, "mentions of removed user listed")
+
+	// AND: mentions of other users are left intact
+	idsGot, err = s.MsgsIDsFindByMention(context.Background(), tfUserA.ID, MsgCursor{}, 0)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgBB.ID, tfMsgAB.ID}, idsGot, "mentions of other user changed")
+}
+
+func tsStorerUserDeletePublished(t *testing.T, s Storer) {
+	tsStorerUserDeleteSetup(t, s)
+	_, err := s.MsgsIDsFindByAuthor(context.Background(), tfUserA.ID, MsgCursor{}, 0)
+	tsStorerSkipNotSupported(t, err)
+	msgsA, err := s.MsgLoadMany(context.Background(), []string{tfMsgAB.ID, tfMsgAA.ID})
+	ar.NoError(t, err)
+
+	bus := NewMsgBus()
+	sub := bus.SubscribeAll()
+	pst := NewPublishingStorer(s, bus)
+
+	// WHEN: one user is removed along with messages and the other one is anonymised
+	err = pst.UserDelete(context.Background(), tfUserA.ID, UserDeleteCascade)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+	ar.NoError(t, pst.UserDelete(context.Background(), tfUserB.ID, UserDeleteAnonymise))
+
+	// THEN: removal and anonymisation of each message is published
+	msgBA := tfMsgBA
+	msgBA.AuthorID = ""
+	a.Equal(t, []MsgEvent{
+		{Type: MsgEventDeleted, Msg: msgsA[0]},
+		{Type: MsgEventDeleted, Msg: msgsA[1]},
+		{Type: MsgEventUpdated, Msg: &msgBA},
+	}, tsMsgBusDrain(sub), "published events mismatch")
+}
+
+func tsStorerUserDeleteNameReleased(t *testing.T, s Storer) {
+	// GIVEN: user is in storage
+	user := tfUserA
+	ar.NoError(t, s.UserSave(context.Background(), &user))
+
+	// WHEN: user is removed
+	err := s.UserDelete(context.Background(), user.ID, UserDeleteReject)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+
+	// THEN: name is not found anymore
+	_, err = s.UserFindByName(context.Background(), user.Name)
+	a.Equal(t, ErrElementNotFound, err, "removed user found by name")
+
+	// AND: name may be taken by other user
+	other := User{ID: "UserX-ID", Name: tfUserA.Name}
+	ar.NoError(t, s.UserSave(context.Background(), &other), "released name not available")
+	userGot, err := s.UserFindByName(context.Background(), tfUserA.Name)
+	ar.NoError(t, err)
+	a.Equal(t, &other, userGot, "User found by name does not match")
+}
+
+func tsStorerUsersListPaging(t *testing.T, s Storer) {
+	// GIVEN: users are saved out of order
+	usersExp := []User{tfUserB, {ID: "UserC-ID", Name: "UserC-Name"}, tfUserA}
+	for _, u := range usersExp {
+		uC := u
+		ar.NoError(t, s.UserSave(context.Background(), &uC))
+	}
+
+	// WHEN: first page is requested
+	pageGot, err := s.UsersList(context.Background(), "", 2)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+
+	// THEN: users are ordered by ID
+	ar.Len(t, pageGot, 2, "mismatched number of users returned")
+	a.Equal(t, &tfUserA, pageGot[0], "first user mismatch")
+	a.Equal(t, &tfUserB, pageGot[1], "second user mismatch")
+
+	// AND: next pages follow the last user
+	pageGot, err = s.UsersList(context.Background(), pageGot[1].ID, 2)
+	ar.NoError(t, err)
+	a.Equal(t, []*User{&usersExp[1]}, pageGot, "last page mismatch")
+
+	pageGot, err = s.UsersList(context.Background(), usersExp[1].ID, 2)
+	ar.NoError(t, err)
+	a.Len(t, pageGot, 0, "page past the end is not empty")
+
+	// AND: non positive limit returns all of them
+	pageGot, err = s.UsersList(context.Background(), "", 0)
+	ar.NoError(t, err)
+	a.Len(t, pageGot, 3, "mismatched number of users returned")
+}
+
+// -- section: Message
+func tsStorerMsgSaveSuccess(t *testing.T, s Storer) {
+	// GIVEN: expected user is in storage
+	userExp := tfUserA
+	ar.NoError(t, s.UserSave(context.Background(), &userExp))
+
+	msgExp := tfMsgAA
+	ar.NoError(t, s.MsgSave(context.Background(), &msgExp))
+
+	// THEN: message is stored
+	msgGot, err := s.MsgLoad(context.Background(), msgExp.ID)
+	ar.NoError(t, err)
+	a.Equal(t, &msgExp, msgGot, "Message from storage does not match")
+
+	// AND: tag is mapped
+	idsGot, err := s.MsgsIDsFindByTag(context.Background(), msgExp.Tags[0], MsgCursor{}, 0)
+	ar.NoError(t, err)
+	a.Equal(t, []string{msgExp.ID}, idsGot, "Message.ID is not assigned to tag")
+}
+
+func tsStorerMsgSaveFailureNoID(t *testing.T, s Storer) {
+	ar.Equal(t, ErrElementIDNotSet, s.MsgSave(context.Background(), &tfMsgAXA_NoID))
+
+	_, err := s.MsgsIDsFindByTag(context.Background(), tfMsgAXA_NoID.Tags[0], MsgCursor{}, 0)
+	a.Equal(t, ErrElementNotFound, err, "unexpected element stored")
+}
+
+func tsStorerMsgLoadExists(t *testing.T, s Storer) {
+	msgExp := tfMsgAA
+
+	// GIVEN: expected message is in storage
+	ar.NoError(t, s.MsgSave(context.Background(), &msgExp))
+
+	msgGot, err := s.MsgLoad(context.Background(), msgExp.ID)
+	ar.NoError(t, err)
+	a.Equal(t, &msgExp, msgGot, "Message from storage does not match")
+}
+
+func tsStorerMsgLoadNotFound(t *testing.T, s Storer) {
+	// GIVEN: expected message is NOT in storage
+
+	_, err := s.MsgLoad(context.Background(), tfMsgAA.ID)
+	ar.Equal(t, ErrElementNotFound, err)
+}
+
+func tsStorerMsgLoadManyExists(t *testing.T, s Storer) {
+	// GIVEN: expected messages are in storage
+	msgsExp := []Message{tfMsgAA, tfMsgAB, tfMsgBA, tfMsgBB}
+	for _, m := range msgsExp {
+		mC := m
+		ar.NoError(t, s.MsgSave(context.Background(), &mC))
+	}
+
+	msgsGot, err := s.MsgLoadMany(context.Background(), []string{tfMsgBB.ID, tfMsgAA.ID, tfMsgBA.ID})
+	ar.NoError(t, err)
+
+	// THEN: messages are returned in requested order
+	ar.Len(t, msgsGot, 3, "mismatched number of messages returned")
+	a.Equal(t, &msgsExp[3], msgsGot[0], "Message from storage does not match")
+	a.Equal(t, &msgsExp[0], msgsGot[1], "Message from storage does not match")
+	a.Equal(t, &msgsExp[2], msgsGot[2], "Message from storage does not match")
+}
+
+func tsStorerMsgLoadManyNotFound(t *testing.T, s Storer) {
+	// GIVEN: only one of requested messages is in storage
+	msg := tfMsgAA
+	ar.NoError(t, s.MsgSave(context.Background(), &msg))
+
+	_, err := s.MsgLoadMany(context.Background(), []string{tfMsgAA.ID, tfMsgAB.ID})
+	ar.Equal(t, ErrElementNotFound, err)
+}
+
+func tsStorerMsgLoadManyEmpty(t *testing.T, s Storer) {
+	msgsGot, err := s.MsgLoadMany(context.Background(), []string{})
+	ar.NoError(t, err)
+	a.Len(t, msgsGot, 0, "unexpected messages returned")
+}
+
+func tsStorerMsgUpdateSuccess(t *testing.T, s Storer) {
+	for _, m := range []Message{tfMsgAA, tfMsgAB} {
+		mC := m
+		ar.NoError(t, s.MsgSave(context.Background(), &mC))
+	}
+
+	// WHEN: message is moved to other tag
+	msgExp := tfMsgAA
+	msgExp.Body = "UserA_MessageA-Body-Edited"
+	msgExp.Tags = []Tag{tfTagB}
+	msgExp.ModifiedAt = tfTimeBase.Add(time.Hour)
+	ar.NoError(t, s.MsgUpdate(context.Background(), &msgExp))
+
+	// THEN: message is changed
+	msgGot, err := s.MsgLoad(context.Background(), tfMsgAA.ID)
+	ar.NoError(t, err)
+	a.Equal(t, &msgExp, msgGot, "Message from storage does not match")
+
+	// AND: tags association follows
+	idsGot, err := s.MsgsIDsFindByTag(context.Background(), tfTagA, MsgCursor{}, 0)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgAB.ID}, idsGot, "old tag")
+	idsGot, err = s.MsgsIDsFindByTag(context.Background(), tfTagB, MsgCursor{}, 0)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgAA.ID}, idsGot, "new tag")
+
+	// AND: previous version is kept
+	revsGot, err := s.MsgRevisions(context.Background(), tfMsgAA.ID)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+	a.Equal(t, []*Message{&tfMsgAA}, revsGot)
+}
+
+func tsStorerMsgUpdateFailureNoID(t *testing.T, s Storer) {
+	a.EqualError(t, s.MsgUpdate(context.Background(), &tfMsgAXA_NoID), ErrElementIDNotSet.Error())
+}
+
+func tsStorerMsgUpdateNotFound(t *testing.T, s Storer) {
+	msg := tfMsgAA
+	a.Equal(t, ErrElementNotFound, s.MsgUpdate(context.Background(), &msg))
+
+	// THEN: message is not created
+	_, err := s.MsgLoad(context.Background(), msg.ID)
+	a.Equal(t, ErrElementNotFound, err, "message created on update")
+}
+
+func tsStorerMsgDeleteSuccess(t *testing.T, s Storer) {
+	for _, m := range []Message{tfMsgAA, tfMsgAB, tfMsgBB} {
+		mC := m
+		ar.NoError(t, s.MsgSave(context.Background(), &mC))
+	}
+	msgEdited := tfMsgBB
+	msgEdited.Body = "UserB_MessageB-Body-Edited"
+	ar.NoError(t, s.MsgSave(context.Background(), &msgEdited))
+
+	err := s.MsgDelete(context.Background(), tfMsgAA.ID)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+	ar.NoError(t, s.MsgDelete(context.Background(), tfMsgBB.ID))
+
+	// THEN: messages are gone
+	_, err = s.MsgLoad(context.Background(), tfMsgAA.ID)
+	a.Equal(t, ErrElementNotFound, err, "message not removed")
+	_, err = s.MsgRevisions(context.Background(), tfMsgBB.ID)
+	a.Equal(t, ErrElementNotFound, err, "history not removed")
+
+	// AND: tags association follows, tag left without messages is unknown
+	idsGot, err := s.MsgsIDsFindByTag(context.Background(), tfTagA, MsgCursor{}, 0)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgAB.ID}, idsGot, "message still associated with tag")
+	_, err = s.MsgsIDsFindByTag(context.Background(), tfTagB, MsgCursor{}, 0)
+	a.Equal(t, ErrElementNotFound, err, "empty tag")
+}
+
+func tsStorerMsgDeleteNotFound(t *testing.T, s Storer) {
+	err := s.MsgDelete(context.Background(), tfMsgAA.ID)
+	tsStorerSkipNotSupported(t, err)
+	a.Equal(t, ErrElementNotFound, err)
+}
+
+func tsStorerMsgRevisionsEdited(t *testing.T, s Storer) {
+	// GIVEN: message is edited twice
+	msgV1 := tfMsgAA
+	ar.NoError(t, s.MsgSave(context.Background(), &msgV1))
+	msgV2 := tfMsgAA
+	msgV2.Body = "UserA_MessageA-Body-V2"
+	msgV2.ModifiedAt = tfTimeBase.Add(time.Hour)
+	ar.NoError(t, s.MsgSave(context.Background(), &msgV2))
+	msgV3 := msgV2
+	msgV3.Body = "UserA_MessageA-Body-V3"
+	msgV3.Tags = []Tag{tfTagB}
+	msgV3.ModifiedAt = tfTimeBase.Add(2 * time.Hour)
+	ar.NoError(t, s.MsgSave(context.Background(), &msgV3))
+
+	revsGot, err := s.MsgRevisions(context.Background(), tfMsgAA.ID)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+
+	// THEN: prior versions are returned, from the oldest
+	a.Equal(t, []*Message{&msgV1, &msgV2}, revsGot)
+
+	// AND: message holds the current version
+	msgGot, err := s.MsgLoad(context.Background(), tfMsgAA.ID)
+	ar.NoError(t, err)
+	a.Equal(t, &msgV3, msgGot, "Message from storage does not match")
+}
+
+func tsStorerMsgRevisionsHashtags(t *testing.T, s Storer) {
+	// GIVEN: message has tags attached as hashtags along with the ones given explicitly
+	msgV1 := tfMsgAA
+	msgV1.Body = "#tagC #tagB"
+	msgV1.Tags = []Tag{tfTagA, tfTagB, tfTagC}
+	msgV1.Hashtags = []Tag{tfTagC}
+	ar.NoError(t, s.MsgSave(context.Background(), &msgV1))
+
+	// THEN: they're told apart once loaded
+	msgGot, err := s.MsgLoad(context.Background(), tfMsgAA.ID)
+	ar.NoError(t, err)
+	a.Equal(t, &msgV1, msgGot, "Message from storage does not match")
+	msgsGot, err := s.MsgLoadMany(context.Background(), []string{tfMsgAA.ID})
+	ar.NoError(t, err)
+	a.Equal(t, []*Message{&msgV1}, msgsGot, "Message loaded in batch does not match")
+
+	// AND: revision keeps them too
+	msgV2 := msgV1
+	msgV2.Body = "plain"
+	msgV2.Tags = []Tag{tfTagA, tfTagB}
+	msgV2.Hashtags = nil
+	msgV2.ModifiedAt = tfTimeBase.Add(time.Hour)
+	ar.NoError(t, s.MsgSave(context.Background(), &msgV2))
+	revsGot, err := s.MsgRevisions(context.Background(), tfMsgAA.ID)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+	a.Equal(t, []*Message{&msgV1}, revsGot)
+}
+
+func tsStorerMsgRevisionsNeverEdited(t *testing.T, s Storer) {
+	msg := tfMsgAA
+	ar.NoError(t, s.MsgSave(context.Background(), &msg))
+
+	revsGot, err := s.MsgRevisions(context.Background(), tfMsgAA.ID)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+	a.Len(t, revsGot, 0, "unexpected revisions returned")
+}
+
+func tsStorerMsgRevisionsNotFound(t *testing.T, s Storer) {
+	_, err := s.MsgRevisions(context.Background(), tfMsgAA.ID)
+	tsStorerSkipNotSupported(t, err)
+	a.Equal(t, ErrElementNotFound, err)
+}
+
+// -- section: Tag
+func tsStorerMsgsIDsFindByTagExists(t *testing.T, s Storer) {
+	// GIVEN: expected messages are in storage
+	msgsExp := []Message{tfMsgAA, tfMsgAB, tfMsgBA, tfMsgBB}
+	for _, m := range msgsExp {
+		mC := m
+		ar.NoError(t, s.MsgSave(context.Background(), &mC))
+	}
+
+	idsGot, err := s.MsgsIDsFindByTag(context.Background(), tfTagA, MsgCursor{}, 0)
+	ar.NoError(t, err)
+	ar.Len(t, idsGot, len(msgsExp)-1, "mismatched number of ids returned")
+	for _, mExp := range msgsExp {
+		if mExp.HasTag(tfTagA) {
+			a.Contains(t, idsGot, mExp.ID, "Message from storage does not match")
+		}
+	}
+}
+
+func tsStorerMsgsIDsFindByTagNotFound(t *testing.T, s Storer) {
+	// GIVEN: expected messages are in storage
+	msgsExp := []Message{tfMsgAA, tfMsgAB, tfMsgBA, tfMsgBB}
+	for _, m := range msgsExp {
+		mC := m
+		ar.NoError(t, s.MsgSave(context.Background(), &mC))
+	}
+
+	_, err := s.MsgsIDsFindByTag(context.Background(), tfTagC, MsgCursor{}, 0)
+	a.Equal(t, ErrElementNotFound, err)
+}
+
+func tsStorerMsgsIDsFindByTagConsistency(t *testing.T, s Storer) {
+	// GIVEN: messages are saved, some of them more than once
+	msgsExp := []Message{tfMsgAA, tfMsgAB, tfMsgBA, tfMsgBB, tfMsgAA, tfMsgBB}
+	for _, m := range msgsExp {
+		mC := m
+		ar.NoError(t, s.MsgSave(context.Background(), &mC))
+	}
+
+	// THEN: each message is associated with its tag exactly once
+	for tag, nExp := range map[Tag]int{tfTagA: 3, tfTagB: 1} {
+		idsGot, err := s.MsgsIDsFindByTag(context.Background(), tag, MsgCursor{}, 0)
+		ar.NoError(t, err, "tag: %s", tag)
+		a.Len(t, idsGot, nExp, "tag %s: mismatched number of ids returned", tag)
+
+		// AND: each associated message carries the tag
+		seen := map[string]bool{}
+		for _, id := range idsGot {
+			a.False(t, seen[id], "tag %s: duplicated id: %s", tag, id)
+			seen[id] = true
+
+			m, err := s.MsgLoad(context.Background(), id)
+			if a.NoError(t, err, "tag %s: associated message not found: %s", tag, id) {
+				a.True(t, m.HasTag(tag), "tag %s: message associated with wrong tag: %s", tag, id)
+			}
+		}
+	}
+}
+
+func tsStorerMsgsIDsFindByTagOrdered(t *testing.T, s Storer) {
+	// GIVEN: messages are saved in order different than creation
+	for _, m := range []Message{tfMsgBA, tfMsgAA, tfMsgBB, tfMsgAB} {
+		mC := m
+		ar.NoError(t, s.MsgSave(context.Background(), &mC))
+	}
+
+	idsGot, err := s.MsgsIDsFindByTag(context.Background(), tfTagA, MsgCursor{}, 0)
+	ar.NoError(t, err)
+
+	// THEN: newest message goes first
+	a.Equal(t, []string{tfMsgBA.ID, tfMsgAB.ID, tfMsgAA.ID}, idsGot)
+}
+
+func tsStorerMsgsIDsFindByTagSameTime(t *testing.T, s Storer) {
+	// GIVEN: messages are created at the same time
+	for _, m := range []Message{tfMsgAB, tfMsgAA, tfMsgBA} {
+		mC := m
+		mC.CreatedAt = tfTimeBase
+		ar.NoError(t, s.MsgSave(context.Background(), &mC))
+	}
+
+	idsGot, err := s.MsgsIDsFindByTag(context.Background(), tfTagA, MsgCursor{}, 0)
+	ar.NoError(t, err)
+
+	// THEN: ties are ordered by ID, descending
+	a.Equal(t, []string{tfMsgBA.ID, tfMsgAB.ID, tfMsgAA.ID}, idsGot)
+
+	// AND: cursor resolves ties
+	idsGot, err = s.MsgsIDsFindByTag(context.Background(), tfTagA, MsgCursor{CreatedAt: tfTimeBase, ID: tfMsgAB.ID}, 0)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgAA.ID}, idsGot)
+}
+
+func tsStorerMsgsIDsFindByTagPaging(t *testing.T, s Storer) {
+	msgs := []Message{tfMsgAA, tfMsgAB, tfMsgBA, tfMsgBB}
+	for _, m := range msgs {
+		mC := m
+		ar.NoError(t, s.MsgSave(context.Background(), &mC))
+	}
+
+	// WHEN: first page is requested
+	idsGot, err := s.MsgsIDsFindByTag(context.Background(), tfTagA, MsgCursor{}, 2)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgBA.ID, tfMsgAB.ID}, idsGot, "first page")
+
+	// AND: next one starting after the last message
+	idsGot, err = s.MsgsIDsFindByTag(context.Background(), tfTagA, MsgCursorOf(&tfMsgAB), 2)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgAA.ID}, idsGot, "second page")
+
+	// AND: past the end
+	idsGot, err = s.MsgsIDsFindByTag(context.Background(), tfTagA, MsgCursorOf(&tfMsgAA), 2)
+	ar.NoError(t, err, "page past the end of known tag")
+	a.Len(t, idsGot, 0, "page past the end")
+}
+
+func tsStorerMsgsIDsFindByTagRetagged(t *testing.T, s Storer) {
+	for _, m := range []Message{tfMsgAA, tfMsgAB} {
+		mC := m
+		ar.NoError(t, s.MsgSave(context.Background(), &mC))
+	}
+
+	// WHEN: message is saved again with other tag and time
+	msg := tfMsgAB
+	msg.Tags = []Tag{tfTagB}
+	msg.CreatedAt = tfTimeBase.Add(-time.Hour)
+	ar.NoError(t, s.MsgSave(context.Background(), &msg))
+
+	// THEN: message is only associated with the new tag
+	idsGot, err := s.MsgsIDsFindByTag(context.Background(), tfTagA, MsgCursor{}, 0)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgAA.ID}, idsGot, "old tag")
+	idsGot, err = s.MsgsIDsFindByTag(context.Background(), tfTagB, MsgCursor{}, 0)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgAB.ID}, idsGot, "new tag")
+
+	// AND: tag left without messages is unknown
+	msgC := msg
+	msgC.Tags = []Tag{tfTagC}
+	ar.NoError(t, s.MsgSave(context.Background(), &msgC))
+	_, err = s.MsgsIDsFindByTag(context.Background(), tfTagB, MsgCursor{}, 0)
+	a.Equal(t, ErrElementNotFound, err, "empty tag")
+}
+
+// tsStorerMsgsTagged stores all messages including the one with many tags.
+func tsStorerMsgsTagged(t *testing.T, s Storer) {
+	for _, m := range []Message{tfMsgAA, tfMsgAB, tfMsgBA, tfMsgBB, tfMsgAC} {
+		mC := m
+		ar.NoError(t, s.MsgSave(context.Background(), &mC))
+	}
+}
+
+func tsStorerMsgsIDsFindByTagsAll(t *testing.T, s Storer) {
+	tsStorerMsgsTagged(t, s)
+
+	tests := map[string]struct {
+		query MsgTagQuery
+		exp   []string
+	}{
+		"single":   {MsgTagQuery{All: []Tag{tfTagB}}, []string{tfMsgAC.ID, tfMsgBB.ID}},
+		"both":     {MsgTagQuery{All: []Tag{tfTagB, tfTagC}}, []string{tfMsgAC.ID}},
+		"reversed": {MsgTagQuery{All: []Tag{tfTagC, tfTagB}}, []string{tfMsgAC.ID}},
+		"disjoint": {MsgTagQuery{All: []Tag{tfTagA, tfTagB}}, []string{}},
+	}
+
+	for sym, tc := range tests {
+		idsGot, err := s.MsgsIDsFindByTags(context.Background(), tc.query, MsgCursor{}, 0)
+		ar.NoError(t, err, "[%s] unexpected error", sym)
+		a.Equal(t, tc.exp, idsGot, "[%s] mismatch on ids returned", sym)
+	}
+}
+
+func tsStorerMsgsIDsFindByTagsAny(t *testing.T, s Storer) {
+	tsStorerMsgsTagged(t, s)
+
+	tests := map[string]struct {
+		query MsgTagQuery
+		exp   []string
+	}{
+		"single":    {MsgTagQuery{Any: []Tag{tfTagC}}, []string{tfMsgAC.ID}},
+		"union":     {MsgTagQuery{Any: []Tag{tfTagA, tfTagC}}, []string{tfMsgAC.ID, tfMsgBA.ID, tfMsgAB.ID, tfMsgAA.ID}},
+		"overlap":   {MsgTagQuery{Any: []Tag{tfTagB, tfTagC}}, []string{tfMsgAC.ID, tfMsgBB.ID}},
+		"with all":  {MsgTagQuery{All: []Tag{tfTagB}, Any: []Tag{tfTagA, tfTagC}}, []string{tfMsgAC.ID}},
+		"one known": {MsgTagQuery{Any: []Tag{tfTagA, "tagX"}}, []string{tfMsgBA.ID, tfMsgAB.ID, tfMsgAA.ID}},
+	}
+
+	for sym, tc := range tests {
+		idsGot, err := s.MsgsIDsFindByTags(context.Background(), tc.query, MsgCursor{}, 0)
+		ar.NoError(t, err, "[%s] unexpected error", sym)
+		a.Equal(t, tc.exp, idsGot, "[%s] mismatch on ids returned", sym)
+	}
+}
+
+func tsStorerMsgsIDsFindByTagsNot(t *testing.T, s Storer) {
+	tsStorerMsgsTagged(t, s)
+
+	tests := map[string]struct {
+		query MsgTagQuery
+		exp   []string
+	}{
+		"all":     {MsgTagQuery{All: []Tag{tfTagB}, Not: []Tag{tfTagC}}, []string{tfMsgBB.ID}},
+		"any":     {MsgTagQuery{Any: []Tag{tfTagA, tfTagB}, Not: []Tag{tfTagC}}, []string{tfMsgBB.ID, tfMsgBA.ID, tfMsgAB.ID, tfMsgAA.ID}},
+		"unknown": {MsgTagQuery{All: []Tag{tfTagB}, Not: []Tag{"tagX"}}, []string{tfMsgAC.ID, tfMsgBB.ID}},
+		"every":   {MsgTagQuery{All: []Tag{tfTagC}, Not: []Tag{tfTagB}}, []string{}},
+	}
+
+	for sym, tc := range tests {
+		idsGot, err := s.MsgsIDsFindByTags(context.Background(), tc.query, MsgCursor{}, 0)
+		ar.NoError(t, err, "[%s] unexpected error", sym)
+		a.Equal(t, tc.exp, idsGot, "[%s] mismatch on ids returned", sym)
+	}
+}
+
+func tsStorerMsgsIDsFindByTagsNotFound(t *testing.T, s Storer) {
+	tsStorerMsgsTagged(t, s)
+
+	tests := map[string]MsgTagQuery{
+		"all, one unknown": {All: []Tag{tfTagA, "tagX"}},
+		"any, all unknown": {Any: []Tag{"tagX", "tagY"}},
+		"not only":         {Not: []Tag{tfTagA}},
+	}
+
+	for sym, q := range tests {
+		_, err := s.MsgsIDsFindByTags(context.Background(), q, MsgCursor{}, 0)
+		a.Equal(t, ErrElementNotFound, err, "[%s] mismatch on error", sym)
+	}
+}
+
+func tsStorerMsgsIDsFindByTagsPaging(t *testing.T, s Storer) {
+	tsStorerMsgsTagged(t, s)
+	q := MsgTagQuery{Any: []Tag{tfTagA, tfTagB}}
+
+	// WHEN: first page is requested
+	idsGot, err := s.MsgsIDsFindByTags(context.Background(), q, MsgCursor{}, 2)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgAC.ID, tfMsgBB.ID}, idsGot, "first page")
+
+	// AND: next ones starting after the last message
+	idsGot, err = s.MsgsIDsFindByTags(context.Background(), q, MsgCursorOf(&tfMsgBB), 2)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgBA.ID, tfMsgAB.ID}, idsGot, "second page")
+	idsGot, err = s.MsgsIDsFindByTags(context.Background(), q, MsgCursorOf(&tfMsgAB), 2)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgAA.ID}, idsGot, "third page")
+
+	// AND: past the end
+	idsGot, err = s.MsgsIDsFindByTags(context.Background(), q, MsgCursorOf(&tfMsgAA), 2)
+	ar.NoError(t, err, "page past the end of known tags")
+	a.Len(t, idsGot, 0, "page past the end")
+}
+
+// tsStorerMsgsMentioning stores messages mentioning users A and B, the order of mentions differs from the order of users.
+func tsStorerMsgsMentioning(t *testing.T, s Storer) {
+	mentions := map[string][]string{
+		tfMsgAA.ID: {tfUserB.ID},
+		tfMsgAB.ID: {tfUserB.ID, tfUserA.ID},
+		tfMsgBA.ID: nil,
+		tfMsgBB.ID: {tfUserA.ID},
+		tfMsgAC.ID: {tfUserB.ID},
+	}
+	for _, m := range []Message{tfMsgAA, tfMsgAB, tfMsgBA, tfMsgBB, tfMsgAC} {
+		mC := m
+		mC.Mentions = mentions[m.ID]
+		ar.NoError(t, s.MsgSave(context.Background(), &mC))
+	}
+}
+
+func tsStorerMsgsIDsFindByMentionOrdered(t *testing.T, s Storer) {
+	tsStorerMsgsMentioning(t, s)
+
+	idsGot, err := s.MsgsIDsFindByMention(context.Background(), tfUserB.ID, MsgCursor{}, 0)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgAC.ID, tfMsgAB.ID, tfMsgAA.ID}, idsGot, "user B")
+
+	idsGot, err = s.MsgsIDsFindByMention(context.Background(), tfUserA.ID, MsgCursor{}, 0)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgBB.ID, tfMsgAB.ID}, idsGot, "user A")
+
+	// AND: mentions are loaded in order of appearance
+	msgGot, err := s.MsgLoad(context.Background(), tfMsgAB.ID)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfUserB.ID, tfUserA.ID}, msgGot.Mentions, "mismatch on mentions of loaded message")
+	msgsGot, err := s.MsgLoadMany(context.Background(), []string{tfMsgBA.ID, tfMsgBB.ID})
+	ar.NoError(t, err)
+	ar.Len(t, msgsGot, 2)
+	a.Empty(t, msgsGot[0].Mentions, "mismatch on mentions of message without them")
+	a.Equal(t, []string{tfUserA.ID}, msgsGot[1].Mentions, "mismatch on mentions of message loaded in batch")
+}
+
+func tsStorerMsgsIDsFindByMentionPaging(t *testing.T, s Storer) {
+	tsStorerMsgsMentioning(t, s)
+
+	// WHEN: first page is requested
+	idsGot, err := s.MsgsIDsFindByMention(context.Background(), tfUserB.ID, MsgCursor{}, 2)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgAC.ID, tfMsgAB.ID}, idsGot, "first page")
+
+	// AND: next one starting after the last message
+	idsGot, err = s.MsgsIDsFindByMention(context.Background(), tfUserB.ID, MsgCursorOf(&tfMsgAB), 2)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgAA.ID}, idsGot, "second page")
+
+	// AND: past the end
+	idsGot, err = s.MsgsIDsFindByMention(context.Background(), tfUserB.ID, MsgCursorOf(&tfMsgAA), 2)
+	ar.NoError(t, err)
+	a.Len(t, idsGot, 0, "page past the end")
+}
+
+func tsStorerMsgsIDsFindByAuthorPaging(t *testing.T, s Storer) {
+	tsStorerUserDeleteSetup(t, s)
+
+	// WHEN: first page is requested
+	idsGot, err := s.MsgsIDsFindByAuthor(context.Background(), tfUserA.ID, MsgCursor{}, 1)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgAB.ID}, idsGot, "first page")
+
+	// AND: next one starting after the last message
+	idsGot, err = s.MsgsIDsFindByAuthor(context.Background(), tfUserA.ID, MsgCursorOf(&tfMsgAB), 1)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgAA.ID}, idsGot, "second page")
+
+	// AND: all of them, from the newest
+	idsGot, err = s.MsgsIDsFindByAuthor(context.Background(), tfUserA.ID, MsgCursor{}, 0)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgAB.ID, tfMsgAA.ID}, idsGot, "all messages")
+}
+
+func tsStorerMsgsIDsFindByAuthorNone(t *testing.T, s Storer) {
+	tsStorerUserDeleteSetup(t, s)
+
+	idsGot, err := s.MsgsIDsFindByAuthor(context.Background(), "UserX-ID", MsgCursor{}, 0)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+	a.Equal(t, []string{}, idsGot)
+}
+
+func tsStorerMsgsIDsFindByMentionUpdated(t *testing.T, s Storer) {
+	tsStorerMsgsMentioning(t, s)
+
+	// WHEN: user B is not mentioned anymore and user A is mentioned instead
+	m := tfMsgAA
+	m.Body = "Message-AA-Body-Changed"
+	m.Mentions = []string{tfUserA.ID}
+	err := s.MsgUpdate(context.Background(), &m)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+
+	// THEN
+	idsGot, err := s.MsgsIDsFindByMention(context.Background(), tfUserB.ID, MsgCursor{}, 0)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgAC.ID, tfMsgAB.ID}, idsGot, "user B")
+
+	idsGot, err = s.MsgsIDsFindByMention(context.Background(), tfUserA.ID, MsgCursor{}, 0)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgBB.ID, tfMsgAB.ID, tfMsgAA.ID}, idsGot, "user A")
+}
+
+func tsStorerMsgsIDsFindByMentionDeleted(t *testing.T, s Storer) {
+	tsStorerMsgsMentioning(t, s)
+
+	err := s.MsgDelete(context.Background(), tfMsgAB.ID)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+
+	idsGot, err := s.MsgsIDsFindByMention(context.Background(), tfUserB.ID, MsgCursor{}, 0)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgAC.ID, tfMsgAA.ID}, idsGot, "user B")
+
+	idsGot, err = s.MsgsIDsFindByMention(context.Background(), tfUserA.ID, MsgCursor{}, 0)
+	ar.NoError(t, err)
+	a.Equal(t, []string{tfMsgBB.ID}, idsGot, "user A")
+}
+
+func tsStorerMsgsIDsFindByMentionNone(t *testing.T, s Storer) {
+	tsStorerMsgsMentioning(t, s)
+
+	idsGot, err := s.MsgsIDsFindByMention(context.Background(), "UserX-ID", MsgCursor{}, 0)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+	a.Equal(t, []string{}, idsGot)
+}
+
+// tsStorerMsgsSearchable stores messages with bodies worth searching through.
+func tsStorerMsgsSearchable(t *testing.T, s Storer) {
+	bodies := map[string]string{
+		tfMsgAA.ID: "Deploy of the billing service is done",
+		tfMsgAB.ID: "deploy failed, rolling back the deploy",
+		tfMsgBA.ID: "Rollback is done",
+		tfMsgBB.ID: "deploying again tomorrow",
+		tfMsgAC.ID: "the billing service is slow",
+	}
+	for _, m := range []Message{tfMsgAA, tfMsgAB, tfMsgBA, tfMsgBB, tfMsgAC} {
+		mC := m
+		mC.Body = bodies[m.ID]
+		ar.NoError(t, s.MsgSave(context.Background(), &mC))
+	}
+}
+
+// tsStorerMsgsSearchIDs returns ids of messages found, making sure all of them are scored.
+func tsStorerMsgsSearchIDs(t *testing.T, s Storer, q MsgSearchQuery, offset, limit int) []string {
+	hits, err := s.MsgsSearch(context.Background(), q, offset, limit)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+
+	ids := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		if len(q.Terms) > 0 {
+			a.True(t, hit.Score > 0, "message not scored: %s", hit.ID)
+		} else {
+			a.Zero(t, hit.Score, "message scored without terms: %s", hit.ID)
+		}
+		ids = append(ids, hit.ID)
+	}
+	return ids
+}
+
+// tsStorerMsgsSearchTerms parses the query, which is expected to have terms only.
+func tsStorerMsgsSearchTerms(t *testing.T, q string) MsgSearchQuery {
+	sq, _, err := msgSearchParse(q)
+	ar.NoError(t, err, "query: %s", q)
+	return sq
+}
+
+func tsStorerMsgsSearchWords(t *testing.T, s Storer) {
+	tsStorerMsgsSearchable(t, s)
+
+	// THEN: message with the word repeated is the most relevant
+	a.Equal(t, []string{tfMsgAB.ID, tfMsgAA.ID}, tsStorerMsgsSearchIDs(t, s, tsStorerMsgsSearchTerms(t, "DEPLOY"), 0, 0), "single word")
+
+	// AND: all of the words are required
+	a.Equal(t, []string{tfMsgAA.ID}, tsStorerMsgsSearchIDs(t, s, tsStorerMsgsSearchTerms(t, "deploy done"), 0, 0), "all words")
+	a.Equal(t, []string{}, tsStorerMsgsSearchIDs(t, s, tsStorerMsgsSearchTerms(t, "deploy unknown"), 0, 0), "unknown word")
+}
+
+func tsStorerMsgsSearchPhrase(t *testing.T, s Storer) {
+	tsStorerMsgsSearchable(t, s)
+
+	// THEN: shorter message is more relevant
+	a.Equal(t, []string{tfMsgAC.ID, tfMsgAA.ID}, tsStorerMsgsSearchIDs(t, s, tsStorerMsgsSearchTerms(t, `"billing service"`), 0, 0), "phrase")
+	a.Equal(t, []string{}, tsStorerMsgsSearchIDs(t, s, tsStorerMsgsSearchTerms(t, `"service billing"`), 0, 0), "words in other order")
+}
+
+func tsStorerMsgsSearchPrefix(t *testing.T, s Storer) {
+	tsStorerMsgsSearchable(t, s)
+
+	a.Equal(t, []string{tfMsgAB.ID, tfMsgBB.ID, tfMsgAA.ID}, tsStorerMsgsSearchIDs(t, s, tsStorerMsgsSearchTerms(t, "depl*"), 0, 0), "prefix")
+	a.Equal(t, []string{tfMsgAA.ID}, tsStorerMsgsSearchIDs(t, s, tsStorerMsgsSearchTerms(t, `"the bill*" done`), 0, 0), "prefix of phrase")
+}
+
+func tsStorerMsgsSearchFilters(t *testing.T, s Storer) {
+	tsStorerMsgsSearchable(t, s)
+
+	tests := map[string]struct {
+		q   MsgSearchQuery
+		exp []string
+	}{
+		"tag":    {MsgSearchQuery{Tags: []Tag{"TAGA"}}, []string{tfMsgAB.ID, tfMsgAA.ID}},
+		"author": {MsgSearchQuery{AuthorID: tfUserB.ID}, []string{tfMsgBB.ID}},
+		"time": {MsgSearchQuery{After: tfMsgAB.CreatedAt, Before: tfMsgBB.CreatedAt},
+			[]string{tfMsgAB.ID}},
+	}
+	for sym, tc := range tests {
+		tc.q.Terms = tsStorerMsgsSearchTerms(t, "depl*").Terms
+		a.Equal(t, tc.exp, tsStorerMsgsSearchIDs(t, s, tc.q, 0, 0), "[%s] mismatch", sym)
+	}
+}
+
+func tsStorerMsgsSearchNoTerms(t *testing.T, s Storer) {
+	tsStorerMsgsSearchable(t, s)
+
+	// THEN: messages are only filtered, from the newest
+	idsGot := tsStorerMsgsSearchIDs(t, s, MsgSearchQuery{Tags: []Tag{tfTagA}}, 0, 0)
+	a.Equal(t, []string{tfMsgBA.ID, tfMsgAB.ID, tfMsgAA.ID}, idsGot)
+}
+
+func tsStorerMsgsSearchSameScore(t *testing.T, s Storer) {
+	// GIVEN: messages differ only by time of creation
+	for _, m := range []Message{tfMsgBA, tfMsgAA, tfMsgAB} {
+		mC := m
+		mC.Body = "deploy is done"
+		ar.NoError(t, s.MsgSave(context.Background(), &mC))
+	}
+
+	idsGot := tsStorerMsgsSearchIDs(t, s, tsStorerMsgsSearchTerms(t, "deploy"), 0, 0)
+	a.Equal(t, []string{tfMsgBA.ID, tfMsgAB.ID, tfMsgAA.ID}, idsGot, "equally relevant should be ordered from the newest")
+}
+
+func tsStorerMsgsSearchPaging(t *testing.T, s Storer) {
+	tsStorerMsgsSearchable(t, s)
+	q := tsStorerMsgsSearchTerms(t, "depl*")
+
+	a.Equal(t, []string{tfMsgAB.ID, tfMsgBB.ID}, tsStorerMsgsSearchIDs(t, s, q, 0, 2), "first page")
+	a.Equal(t, []string{tfMsgAA.ID}, tsStorerMsgsSearchIDs(t, s, q, 2, 2), "last page")
+	a.Equal(t, []string{}, tsStorerMsgsSearchIDs(t, s, q, 3, 2), "after the last page")
+}
+
+func tsStorerMsgsSearchUpdated(t *testing.T, s Storer) {
+	tsStorerMsgsSearchable(t, s)
+
+	// WHEN: body is edited
+	m := tfMsgAA
+	m.Body = "Deploy of the payments service is done"
+	ar.NoError(t, s.MsgSave(context.Background(), &m))
+
+	// THEN: words of the new body are found, the old ones are not
+	a.Equal(t, []string{tfMsgAC.ID}, tsStorerMsgsSearchIDs(t, s, tsStorerMsgsSearchTerms(t, "billing"), 0, 0), "old word")
+	a.Equal(t, []string{tfMsgAA.ID}, tsStorerMsgsSearchIDs(t, s, tsStorerMsgsSearchTerms(t, "payments"), 0, 0), "new word")
+	a.Equal(t, []string{tfMsgAB.ID, tfMsgAA.ID}, tsStorerMsgsSearchIDs(t, s, tsStorerMsgsSearchTerms(t, "deploy"), 0, 0), "word kept")
+}
+
+func tsStorerMsgsSearchDeleted(t *testing.T, s Storer) {
+	tsStorerMsgsSearchable(t, s)
+	// make sure search is supported before anything is deleted
+	tsStorerMsgsSearchIDs(t, s, tsStorerMsgsSearchTerms(t, "billing"), 0, 0)
+
+	err := s.MsgDelete(context.Background(), tfMsgAC.ID)
+	tsStorerSkipNotSupported(t, err)
+	ar.NoError(t, err)
+	user := tfUserB
+	ar.NoError(t, s.UserSave(context.Background(), &user))
+	ar.NoError(t, s.UserDelete(context.Background(), user.ID, UserDeleteCascade))
+
+	a.Equal(t, []string{tfMsgAA.ID}, tsStorerMsgsSearchIDs(t, s, tsStorerMsgsSearchTerms(t, "billing"), 0, 0), "deleted message")
+	a.Equal(t, []string{tfMsgAB.ID, tfMsgAA.ID}, tsStorerMsgsSearchIDs(t, s, tsStorerMsgsSearchTerms(t, "depl*"), 0, 0), "messages of deleted user")
+}
+
+func tsStorerMsgsIDsFindByTagsByKey(t *testing.T, s Storer) {
+	// GIVEN: message is tagged with mixed case tag in normalisation form C
+	m := tfMsgAA
+	m.Tags = []Tag{"Café-Go"}
+	ar.NoError(t, s.MsgSave(context.Background(), &m))
+
+	tests := map[string]Tag{
+		"exact":      "Café-Go",
+		"case":       "CAFÉ-go",
+		"decomposed": "Cafe\u0301-Go",
+	}
+	for sym, tag := range tests {
+		idsGot, err := s.MsgsIDsFindByTag(context.Background(), tag, MsgCursor{}, 0)
+		ar.NoError(t, err, "[%s] unexpected error", sym)
+		a.Equal(t, []string{m.ID}, idsGot, "[%s] mismatch on ids returned by tag", sym)
+
+		idsGot, err = s.MsgsIDsFindByTags(context.Background(), MsgTagQuery{Any: []Tag{tag}}, MsgCursor{}, 0)
+		tsStorerSkipNotSupported(t, err)
+		ar.NoError(t, err, "[%s] unexpected error", sym)
+		a.Equal(t, []string{m.ID}, idsGot, "[%s] mismatch on ids returned by query", sym)
+	}
+
+	// AND: tag keeps form given by the author
+	msgGot, err := s.MsgLoad(context.Background(), m.ID)
+	ar.NoError(t, err)
+	a.Equal(t, m.Tags, msgGot.Tags, "mismatch on tags of loaded message")
+}
+
+func tsStorerConcurrentWriters(t *testing.T, s Storer) {
+	const writers = 8
+	const perWriter = 25
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, writers*perWriter*2)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				u := &User{
+					ID:   fmt.Sprintf("User-%d-%d-ID", w, i),
+					Name: fmt.Sprintf("User-%d-%d-Name", w, i),
+				}
+				errCh <- s.UserSave(context.Background(), u)
+
+				m := &Message{
+					ID:       fmt.Sprintf("Message-%d-%d-ID", w, i),
+					Body:     fmt.Sprintf("Message-%d-%d-Body", w, i),
+					AuthorID: u.ID,
+					Tags:     []Tag{Tag(fmt.Sprintf("tag-%d", i%2))},
+				}
+				errCh <- s.MsgSave(context.Background(), m)
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		ar.NoError(t, err, "unexpected error on concurrent write")
+	}
+
+	// THEN: all elements are stored
+	for w := 0; w < writers; w++ {
+		for i := 0; i < perWriter; i++ {
+			_, err := s.UserLoad(context.Background(), fmt.Sprintf("User-%d-%d-ID", w, i))
+			a.NoError(t, err, "user missing: %d-%d", w, i)
+			_, err = s.MsgLoad(context.Background(), fmt.Sprintf("Message-%d-%d-ID", w, i))
+			a.NoError(t, err, "message missing: %d-%d", w, i)
+		}
+	}
+
+	// AND: tag index is complete
+	nTotal := 0
+	for _, tag := range []Tag{"tag-0", "tag-1"} {
+		idsGot, err := s.MsgsIDsFindByTag(context.Background(), tag, MsgCursor{}, 0)
+		ar.NoError(t, err, "tag: %s", tag)
+		nTotal += len(idsGot)
+	}
+	a.Equal(t, writers*perWriter, nTotal, "mismatched number of messages in tag index")
+}
+
+// -- section: Context
+func tsStorerContextCancelled(t *testing.T, s Storer) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// WHEN: writes are attempted with cancelled context
+	user := tfUserA
+	a.Equal(t, context.Canceled, s.UserSave(ctx, &user), "UserSave")
+	a.Equal(t, context.Canceled, s.UserUpdate(ctx, &user), "UserUpdate")
+	a.Equal(t, context.Canceled, s.UserDelete(ctx, user.ID, UserDeleteCascade), "UserDelete")
+	msg := tfMsgAA
+	a.Equal(t, context.Canceled, s.MsgSave(ctx, &msg), "MsgSave")
+	a.Equal(t, context.Canceled, s.MsgUpdate(ctx, &msg), "MsgUpdate")
+	a.Equal(t, context.Canceled, s.MsgDelete(ctx, msg.ID), "MsgDelete")
+
+	// THEN: nothing is stored
+	_, err := s.UserLoad(context.Background(), user.ID)
+	a.Equal(t, ErrElementNotFound, err, "user stored with cancelled context")
+	_, err = s.MsgLoad(context.Background(), msg.ID)
+	a.Equal(t, ErrElementNotFound, err, "message stored with cancelled context")
+
+	// AND: reads fail as well
+	_, err = s.UserLoad(ctx, user.ID)
+	a.Equal(t, context.Canceled, err, "UserLoad")
+	_, err = s.UserLoadMany(ctx, []string{user.ID})
+	a.Equal(t, context.Canceled, err, "UserLoadMany")
+	_, err = s.UserFindByName(ctx, user.Name)
+	a.Equal(t, context.Canceled, err, "UserFindByName")
+	_, err = s.UsersList(ctx, "", 0)
+	a.Equal(t, context.Canceled, err, "UsersList")
+	_, err = s.MsgLoad(ctx, msg.ID)
+	a.Equal(t, context.Canceled, err, "MsgLoad")
+	_, err = s.MsgLoadMany(ctx, []string{msg.ID})
+	a.Equal(t, context.Canceled, err, "MsgLoadMany")
+	_, err = s.MsgRevisions(ctx, msg.ID)
+	a.Equal(t, context.Canceled, err, "MsgRevisions")
+	_, err = s.MsgsIDsFindByTag(ctx, msg.Tags[0], MsgCursor{}, 0)
+	a.Equal(t, context.Canceled, err, "MsgsIDsFindByTag")
+	_, err = s.MsgsSearch(ctx, MsgSearchQuery{Tags: msg.Tags}, 0, 0)
+	a.Equal(t, context.Canceled, err, "MsgsSearch")
+	a.Equal(t, context.Canceled, s.Ping(ctx), "Ping")
+}
+
+// -- section: health
+func tsStorerPing(t *testing.T, s Storer) {
+	a.NoError(t, s.Ping(context.Background()), "open storage should be ready")
+}
+
+// -- section: Webhook
+func tsStorerWebhookSaveLoad(t *testing.T, s Storer) {
+	ws := tsStorerWebhooks(t, s)
+
+	// GIVEN: webhooks are saved in reverse order of IDs
+	hooksExp := []Webhook{tfWebhookA, tfWebhookB}
+	for i := len(hooksExp) - 1; i >= 0; i-- {
+		whC := hooksExp[i]
+		ar.NoError(t, ws.WebhookSave(context.Background(), &whC))
+	}
+
+	for _, whExp := range hooksExp {
+		whGot, err := ws.WebhookLoad(context.Background(), whExp.ID)
+		ar.NoError(t, err)
+		a.Equal(t, &whExp, whGot, "Webhook from storage does not match")
+	}
+
+	// THEN: list is ordered by ID
+	hooksGot, err := ws.WebhooksList(context.Background())
+	ar.NoError(t, err)
+	if a.Len(t, hooksGot, 2, "mismatch on number of webhooks") {
+		a.Equal(t, &hooksExp[0], hooksGot[0], "mismatch on first webhook")
+		a.Equal(t, &hooksExp[1], hooksGot[1], "mismatch on second webhook")
+	}
+}
+
+func tsStorerWebhookFailureNoID(t *testing.T, s Storer) {
+	ws := tsStorerWebhooks(t, s)
+	wh := tfWebhookA
+	wh.ID = ""
+
+	a.Equal(t, ErrElementIDNotSet, ws.WebhookSave(context.Background(), &wh), "WebhookSave")
+	a.Equal(t, ErrElementIDNotSet, ws.WebhookUpdate(context.Background(), &wh), "WebhookUpdate")
+
+	hooks, err := ws.WebhooksList(context.Background())
+	ar.NoError(t, err)
+	a.Empty(t, hooks, "unexpected element stored")
+}
+
+func tsStorerWebhookNotFound(t *testing.T, s Storer) {
+	ws := tsStorerWebhooks(t, s)
+	wh := tfWebhookA
+
+	_, err := ws.WebhookLoad(context.Background(), wh.ID)
+	a.Equal(t, ErrElementNotFound, err, "WebhookLoad")
+	a.Equal(t, ErrElementNotFound, ws.WebhookUpdate(context.Background(), &wh), "WebhookUpdate")
+	a.Equal(t, ErrElementNotFound, ws.WebhookDelete(context.Background(), wh.ID), "WebhookDelete")
+
+	_, err = ws.WebhookLoad(context.Background(), wh.ID)
+	a.Equal(t, ErrElementNotFound, err, "webhook created by update")
+}
+
+func tsStorerWebhookUpdate(t *testing.T, s Storer) {
+	ws := tsStorerWebhooks(t, s)
+	wh := tfWebhookA
+	ar.NoError(t, ws.WebhookSave(context.Background(), &wh))
+
+	whNew := tfWebhookB
+	whNew.ID = wh.ID
+	ar.NoError(t, ws.WebhookUpdate(context.Background(), &whNew))
+
+	whGot, err := ws.WebhookLoad(context.Background(), wh.ID)
+	ar.NoError(t, err)
+	a.Equal(t, &whNew, whGot, "Webhook from storage does not match")
+}
+
+func tsStorerWebhookDelete(t *testing.T, s Storer) {
+	ws := tsStorerWebhooks(t, s)
+	for _, wh := range []Webhook{tfWebhookA, tfWebhookB} {
+		whC := wh
+		ar.NoError(t, ws.WebhookSave(context.Background(), &whC))
+	}
+
+	ar.NoError(t, ws.WebhookDelete(context.Background(), tfWebhookA.ID))
+
+	_, err := ws.WebhookLoad(context.Background(), tfWebhookA.ID)
+	a.Equal(t, ErrElementNotFound, err, "removed webhook found")
+	hooks, err := ws.WebhooksList(context.Background())
+	ar.NoError(t, err)
+	if a.Len(t, hooks, 1, "mismatch on number of webhooks") {
+		a.Equal(t, tfWebhookB.ID, hooks[0].ID, "other webhook removed")
+	}
+}
+
+// -- test helpers
+
+// tsStorerWebhooks skips the test when storage does not keep webhooks, see WebhookStorer.
+func tsStorerWebhooks(t *testing.T, s Storer) WebhookStorer {
+	ws, ok := s.(WebhookStorer)
+	if !ok {
+		t.Skip("webhooks not supported")
+	}
+	return ws
+}
+
+// tsStorerSkipNotSupported skips the test when optional operation is not available in the storage.
+func tsStorerSkipNotSupported(t *testing.T, err error) {
+	if err == ErrNotSupported {
+		t.Skip("operation not supported")
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import "context"
+
+// tracingStorer wraps storage and records span for each call.
+// Calls made with context which is not traced pass through without overhead of spans.
+type tracingStorer struct {
+	st Storer
+}
+
+// NewTracingStorer wraps storage so its calls are traced as children of the span attached to the context.
+func NewTracingStorer(st Storer) Storer {
+	return &tracingStorer{st: st}
+}
+
+// start starts span of the storage operation.
+func (s *tracingStorer) start(ctx context.Context, op string) (context.Context, *Span) {
+	return StartSpan(ctx, "Storer."+op, SpanKindInternal)
+}
+
+// end records outcome of the operation and finishes its span.
+func (s *tracingStorer) end(span *Span, err error) {
+	span.SetError(err)
+	span.End()
+}
+
+func (s *tracingStorer) UserSave(ctx context.Context, u *User) (err error) {
+	ctx, span := s.start(ctx, "UserSave")
+	defer func() { s.end(span, err) }()
+	return s.st.UserSave(ctx, u)
+}
+
+func (s *tracingStorer) UserLoad(ctx context.Context, id string) (u *User, err error) {
+	ctx, span := s.start(ctx, "UserLoad")
+	defer func() { s.end(span, err) }()
+	return s.st.UserLoad(ctx, id)
+}
+
+func (s *tracingStorer) UserFindByName(ctx context.Context, name string) (u *User, err error) {
+	ctx, span := s.start(ctx, "UserFindByName")
+	defer func() { s.end(span, err) }()
+	return s.st.UserFindByName(ctx, name)
+}
+
+func (s *tracingStorer) UserLoadMany(ctx context.Context, ids []string) (us []*User, err error) {
+	ctx, span := s.start(ctx, "UserLoadMany")
+	span.SetAttr("ids", len(ids))
+	defer func() { s.end(span, err) }()
+	return s.st.UserLoadMany(ctx, ids)
+}
+
+func (s *tracingStorer) UserUpdate(ctx context.Context, u *User) (err error) {
+	ctx, span := s.start(ctx, "UserUpdate")
+	defer func() { s.end(span, err) }()
+	return s.st.UserUpdate(ctx, u)
+}
+
+func (s *tracingStorer) UserDelete(ctx context.Context, id string, policy UserDeletePolicy) (err error) {
+	ctx, span := s.start(ctx, "UserDelete")
+	span.SetAttr("policy", string(policy))
+	defer func() { s.end(span, err) }()
+	return s.st.UserDelete(ctx, id, policy)
+}
+
+func (s *tracingStorer) UsersList(ctx context.Context, after string, limit int) (us []*User, err error) {
+	ctx, span := s.start(ctx, "UsersList")
+	span.SetAttr("limit", limit)
+	defer func() { s.end(span, err) }()
+	return s.st.UsersList(ctx, after, limit)
+}
+
+func (s *tracingStorer) MsgSave(ctx context.Context, m *Message) (err error) {
+	ctx, span := s.start(ctx, "MsgSave")
+	defer func() { s.end(span, err) }()
+	return s.st.MsgSave(ctx, m)
+}
+
+func (s *tracingStorer) MsgLoad(ctx context.Context, id string) (m *Message, err error) {
+	ctx, span := s.start(ctx, "MsgLoad")
+	defer func() { s.end(span, err) }()
+	return s.st.MsgLoad(ctx, id)
+}
+
+func (s *tracingStorer) MsgLoadMany(ctx context.Context, ids []string) (ms []*Message, err error) {
+	ctx, span := s.start(ctx, "MsgLoadMany")
+	span.SetAttr("ids", len(ids))
+	defer func() { s.end(span, err) }()
+	return s.st.MsgLoadMany(ctx, ids)
+}
+
+func (s *tracingStorer) MsgUpdate(ctx context.Context, m *Message) (err error) {
+	ctx, span := s.start(ctx, "MsgUpdate")
+	defer func() { s.end(span, err) }()
+	return s.st.MsgUpdate(ctx, m)
+}
+
+func (s *tracingStorer) MsgDelete(ctx context.Context, id string) (err error) {
+	ctx, span := s.start(ctx, "MsgDelete")
+	defer func() { s.end(span, err) }()
+	return s.st.MsgDelete(ctx, id)
+}
+
+func (s *tracingStorer) MsgRevisions(ctx context.Context, id string) (ms []*Message, err error) {
+	ctx, span := s.start(ctx, "MsgRevisions")
+	defer func() { s.end(span, err) }()
+	return s.st.MsgRevisions(ctx, id)
+}
+
+func (s *tracingStorer) MsgsIDsFindByTag(ctx context.Context, tag Tag, after MsgCursor, limit int) (ids []string, err error) {
+	ctx, span := s.start(ctx, "MsgsIDsFindByTag")
+	span.SetAttr("tag", string(tag))
+	span.SetAttr("limit", limit)
+	defer func() { s.end(span, err) }()
+	return s.st.MsgsIDsFindByTag(ctx, tag, after, limit)
+}
+
+func (s *tracingStorer) MsgsIDsFindByTags(ctx context.Context, q MsgTagQuery, after MsgCursor, limit int) (ids []string, err error) {
+	ctx, span := s.start(ctx, "MsgsIDsFindByTags")
+	span.SetAttr("tags.all", len(q.All))
+	span.SetAttr("tags.any", len(q.Any))
+	span.SetAttr("tags.not", len(q.Not))
+	span.SetAttr("limit", limit)
+	defer func() { s.end(span, err) }()
+	return s.st.MsgsIDsFindByTags(ctx, q, after, limit)
+}
+
+func (s *tracingStorer) MsgsIDsFindByMention(ctx context.Context, userID string, after MsgCursor, limit int) (ids []string, err error) {
+	ctx, span := s.start(ctx, "MsgsIDsFindByMention")
+	span.SetAttr("limit", limit)
+	defer func() { s.end(span, err) }()
+	return s.st.MsgsIDsFindByMention(ctx, userID, after, limit)
+}
+
+func (s *tracingStorer) MsgsIDsFindByAuthor(ctx context.Context, authorID string, after MsgCursor, limit int) (ids []string, err error) {
+	ctx, span := s.start(ctx, "MsgsIDsFindByAuthor")
+	span.SetAttr("limit", limit)
+	defer func() { s.end(span, err) }()
+	return s.st.MsgsIDsFindByAuthor(ctx, authorID, after, limit)
+}
+
+func (s *tracingStorer) MsgsSearch(ctx context.Context, q MsgSearchQuery, offset, limit int) (hits []MsgSearchHit, err error) {
+	ctx, span := s.start(ctx, "MsgsSearch")
+	span.SetAttr("terms", len(q.Terms))
+	span.SetAttr("offset", offset)
+	span.SetAttr("limit", limit)
+	defer func() { s.end(span, err) }()
+	return s.st.MsgsSearch(ctx, q, offset, limit)
+}
+
+func (s *tracingStorer) Ping(ctx context.Context) (err error) {
+	ctx, span := s.start(ctx, "Ping")
+	defer func() { s.end(span, err) }()
+	return s.st.Ping(ctx)
+}
+
+// Close closes wrapped storage. It's not traced as it's not a part of any request.
+func (s *tracingStorer) Close() error {
+	return s.st.Close()
+}
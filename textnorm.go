@@ -0,0 +1,111 @@
+package main
+
+import (
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// textZWJ is zero width joiner, it glues emoji into single sequence, e.g. family or profession.
+	textZWJ = '\u200d'
+)
+
+// textNormalize brings text into Unicode normalisation form C, so canonically equivalent texts are equal.
+func textNormalize(s string) string {
+	return norm.NFC.String(s)
+}
+
+// textKey returns case-folded, normalised form of the text. Texts differing only by normalisation or case share the key.
+func textKey(s string) string {
+	// Caser holds state, so it's not shared between goroutines
+	return norm.NFC.String(cases.Fold().String(norm.NFC.String(s)))
+}
+
+// textLen returns number of user-perceived characters in the text.
+// Combining marks, variation selectors and emoji modifiers are counted along with the character they follow,
+// emoji joined with ZWJ and pairs of regional indicators (flags) are counted as single character.
+func textLen(s string) int {
+	n := 0
+	joined, flag := false, false
+	for _, r := range s {
+		switch {
+		case joined:
+			joined = false
+		case r == textZWJ:
+			joined = n > 0
+		case n > 0 && textIsExtending(r):
+		case unicode.Is(unicode.Regional_Indicator, r):
+			if !flag {
+				n++
+			}
+			flag = !flag
+			continue
+		default:
+			n++
+		}
+		flag = false
+	}
+	return n
+}
+
+// textIsExtending reports whether rune extends the character it follows instead of starting a new one.
+func textIsExtending(r rune) bool {
+	return unicode.Is(unicode.M, r) || unicode.Is(unicode.Variation_Selector, r) || (r >= 0x1F3FB && r <= 0x1F3FF)
+}
+
+// textInvalidRune returns the first control or invisible character of the text.
+// ZWJ is allowed only inside of emoji sequence, so it's not able to split a word into visually identical parts.
+func textInvalidRune(s string) (rune, bool) {
+	var prev rune
+	for _, r := range s {
+		switch {
+		case r == textZWJ:
+			if !unicode.Is(unicode.So, prev) && !textIsExtending(prev) {
+				return r, true
+			}
+		case unicode.Is(unicode.Cc, r), unicode.Is(unicode.Cf, r), unicode.Is(unicode.Zl, r), unicode.Is(unicode.Zp, r):
+			return r, true
+		case unicode.Is(unicode.Zs, r) && r != ' ':
+			return r, true
+		case textInvisible[r]:
+			return r, true
+		}
+		prev = r
+	}
+	return 0, false
+}
+
+// textInvisible lists characters rendered as blank which are not classified as formatting ones.
+var textInvisible = map[rune]bool{
+	'\u115f': true, // Hangul choseong filler
+	'\u1160': true, // Hangul jungseong filler
+	'\u2800': true, // Braille pattern blank
+	'\u3164': true, // Hangul filler
+	'\uffa0': true, // halfwidth Hangul filler
+}
+
+// textSkeleton returns form of the text shared by texts which look alike, after UTS #39 skeleton.
+// Compatibility characters (e.g. fullwidth), case and Latin look-alikes from Cyrillic, Greek and digits are collapsed,
+// e.g. "PayPal", "paypaI" and "раураl" (Cyrillic) have the same skeleton.
+// Case is folded first, so both cases of a letter share the skeleton, e.g. "Ian" and "ian".
+func textSkeleton(s string) string {
+	rs := []rune(norm.NFKD.String(cases.Fold().String(norm.NFKD.String(s))))
+	for i, r := range rs {
+		if c, found := textConfusables[r]; found {
+			rs[i] = c
+		}
+	}
+	return norm.NFC.String(string(rs))
+}
+
+// textConfusables maps case-folded characters into Latin letters they or their capitals look like.
+// Letters i and l are collapsed, as capital I looks like l.
+var textConfusables = map[rune]rune{
+	'0': 'o', '1': 'l', '|': 'l', 'i': 'l', 'ı': 'l', 'ɑ': 'a', 'ɡ': 'g',
+	'α': 'a', 'β': 'b', 'ε': 'e', 'ζ': 'z', 'η': 'h', 'ι': 'l', 'κ': 'k', 'μ': 'm', 'ν': 'v', // Greek
+	'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x',
+	'а': 'a', 'в': 'b', 'ԁ': 'd', 'е': 'e', 'һ': 'h', 'н': 'h', 'і': 'l', 'ј': 'j', 'к': 'k', 'ӏ': 'l', // Cyrillic
+	'м': 'm', 'о': 'o', 'р': 'p', 'ԛ': 'q', 'ѕ': 's', 'т': 't', 'у': 'y', 'ԝ': 'w', 'х': 'x', 'с': 'c', 'ү': 'y',
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	a "github.com/stretchr/testify/assert"
+)
+
+func Test_TextNorm_Len(t *testing.T) {
+	tests := map[string]struct {
+		s   string
+		exp int
+	}{
+		"empty":                  {"", 0},
+		"ASCII":                  {"tagA", 4},
+		"Polish":                 {"zażółć", 6},
+		"combining marks":        {"e\u0301e\u0301\u0323", 2},
+		"emoji":                  {"🚀🚀", 2},
+		"emoji with modifier":    {"👍🏽", 1},
+		"emoji sequence":         {"👩\u200d💻", 1},
+		"family":                 {"👨\u200d👩\u200d👧\u200d👦", 1},
+		"variation selector":     {"❤\ufe0f", 1},
+		"flags":                  {"🇵🇱🇪🇺", 2},
+		"unpaired indicator":     {"🇵a", 2},
+		"leading combining mark": {"\u0301a", 2},
+	}
+
+	for sym, tc := range tests {
+		a.Equal(t, tc.exp, textLen(tc.s), "[%s] mismatch", sym)
+	}
+}
+
+func Test_TextNorm_InvalidRune(t *testing.T) {
+	tests := map[string]struct {
+		s     string
+		exp   rune
+		found bool
+	}{
+		"plain":               {"Zoë user", 0, false},
+		"emoji sequence":      {"👩\u200d💻 and 👍🏽\u200d", 0, false},
+		"tab":                 {"a\tb", '\t', true},
+		"DEL":                 {"a\u007fb", '\u007f', true},
+		"zero width space":    {"a\u200bb", '\u200b', true},
+		"ZWJ between letters": {"a\u200db", '\u200d', true},
+		"ZWJ at the start":    {"\u200d👩", '\u200d', true},
+		"LTR mark":            {"a\u200eb", '\u200e', true},
+		"byte order mark":     {"\ufeffab", '\ufeff', true},
+		"line separator":      {"a\u2028b", '\u2028', true},
+		"ideographic space":   {"a\u3000b", '\u3000', true},
+		"Braille blank":       {"a\u2800b", '\u2800', true},
+	}
+
+	for sym, tc := range tests {
+		r, found := textInvalidRune(tc.s)
+		a.Equal(t, tc.found, found, "[%s] mismatch on found", sym)
+		a.Equal(t, tc.exp, r, "[%s] mismatch on rune", sym)
+	}
+}
+
+func Test_TextNorm_Key(t *testing.T) {
+	a.Equal(t, textKey("Zoë"), textKey("ZOE\u0308"), "normalisation and case")
+	a.Equal(t, "zoë", textKey("ZOE\u0308"), "key is in normalisation form C")
+	a.NotEqual(t, textKey("Zoë"), textKey("Zoe"), "diacritic")
+	a.Equal(t, "Zoë", textNormalize("Zoe\u0308"), "normalisation keeps case")
+}
+
+func Test_TextNorm_Skeleton(t *testing.T) {
+	a.Equal(t, "paypal", textSkeleton("PayPal"))
+	a.Equal(t, "paypal", textSkeleton("paypaI"))
+	a.Equal(t, "paypal", textSkeleton("раураl"), "Cyrillic")
+	a.Equal(t, "paypal", textSkeleton("ＰａｙＰａｌ"), "fullwidth")
+	a.Equal(t, "bobo", textSkeleton("B0bO"), "digit zero")
+	a.Equal(t, textSkeleton("Ian"), textSkeleton("ian"), "capital I and small i")
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies single trace, as defined in W3C Trace Context.
+type TraceID [16]byte
+
+// IsValid reports whether ID is set. All zero ID is invalid.
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanID identifies single span within the trace.
+type SpanID [8]byte
+
+// IsValid reports whether ID is set. All zero ID is invalid.
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanContext is a part of the span propagated to its children, also across process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// Sampled is set when spans of the trace are recorded and exported.
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are set.
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+
+// SpanKind describes relationship of the span to the remote side, values follow OTLP.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// SpanAttr is a single key-value annotation of the span. Value is a string, bool, int or float64.
+type SpanAttr struct {
+	Key   string
+	Value interface{}
+}
+
+// SpanData is an immutable copy of finished span passed to exporters.
+type SpanData struct {
+	Name         string
+	Kind         SpanKind
+	SpanContext  SpanContext
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attrs        []SpanAttr
+	// Err is a description of the error which failed the operation, empty on success.
+	Err string
+}
+
+// Span represents single timed operation within the trace.
+// All methods are safe to be called on nil span, which does nothing. It's used when tracing is disabled.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// SpanContext returns identifiers of the span. Zero value is returned for nil span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetAttr annotates span with key-value pair. Ended span is not changed.
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.data.Attrs = append(s.data.Attrs, SpanAttr{Key: key, Value: value})
+}
+
+// SetError marks the span as failed. Nil error is ignored and ended span is not changed.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.data.Err = err.Error()
+}
+
+// End finishes the span and hands it to the exporter if it's sampled. Only the first call has effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = s.tracer.TimeNow()
+	d := s.data
+	s.mu.Unlock()
+
+	if d.SpanContext.Sampled {
+		s.tracer.export(d)
+	}
+}
+
+type spanCtxKey struct{}
+type remoteSpanCtxKey struct{}
+
+// SpanFromContext returns span attached to the context or nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanCtxKey{}).(*Span)
+	return s
+}
+
+// ContextWithRemoteSpanContext attaches span context received from the remote side.
+// Spans started from returned context are its children.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteSpanCtxKey{}, sc)
+}
+
+// StartSpan starts child of the span attached to the context, using the same tracer.
+// Context without span is not traced and nil span is returned.
+func StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, kind)
+}
+
+// tracerQueueSize is a number of finished spans waiting for export. Spans ending when it's full are dropped.
+const tracerQueueSize = 2048
+
+// tracerBatchSize is a maximum number of spans passed to exporter in a single call.
+const tracerBatchSize = 256
+
+// tracerFlushEvery is an interval after which not full batch of spans is exported.
+const tracerFlushEvery = time.Second
+
+// SpanExporter sends finished spans to tracing backend.
+type SpanExporter interface {
+	// ExportSpans is called from a single goroutine, so implementations don't need to be thread safe.
+	ExportSpans(spans []SpanData) error
+
+	// Close releases resources of the exporter. No spans are passed after it.
+	Close() error
+}
+
+// Tracer creates spans and exports them in batches, in background.
+// Methods are safe to be called on nil tracer, which creates no spans.
+type Tracer struct {
+	// TimeNow is testing helper for time sensitive tests. It defaults to time.Now function.
+	TimeNow func() time.Time
+
+	// OnError is called with errors returned by exporter. It defaults to no-op.
+	OnError func(err error)
+
+	exporter SpanExporter
+	queue    chan SpanData
+	done     chan struct{}
+
+	closeOnce sync.Once
+	mu        sync.RWMutex
+	closed    bool
+	dropped   int
+}
+
+// NewTracer creates tracer which passes finished spans to exporter.
+func NewTracer(exp SpanExporter) *Tracer {
+	t := &Tracer{
+		TimeNow:  time.Now,
+		OnError:  func(error) {},
+		exporter: exp,
+		queue:    make(chan SpanData, tracerQueueSize),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start creates span which is a child of the span, local or remote, attached to the context.
+// New trace is started if there is no parent.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	var parent SpanContext
+	if p := SpanFromContext(ctx); p != nil {
+		parent = p.SpanContext()
+	} else if rsc, ok := ctx.Value(remoteSpanCtxKey{}).(SpanContext); ok {
+		parent = rsc
+	}
+
+	sc := SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled}
+	if !parent.IsValid() {
+		sc.TraceID = newTraceID()
+		sc.Sampled = true
+	}
+	sc.SpanID = newSpanID()
+
+	s := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:         name,
+			Kind:         kind,
+			SpanContext:  sc,
+			ParentSpanID: parent.SpanID,
+			Start:        t.TimeNow(),
+		},
+	}
+	return context.WithValue(ctx, spanCtxKey{}, s), s
+}
+
+// Close exports all pending spans and closes the exporter.
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	var err error
+	t.closeOnce.Do(func() {
+		t.mu.Lock()
+		t.closed = true
+		close(t.queue)
+		t.mu.Unlock()
+
+		<-t.done
+		err = t.exporter.Close()
+	})
+	return err
+}
+
+// Dropped returns number of spans which were not exported as the queue was full.
+func (t *Tracer) Dropped() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.dropped
+}
+
+// export queues finished span, without blocking the caller.
+func (t *Tracer) export(d SpanData) {
+	t.mu.RLock()
+	if t.closed {
+		t.mu.RUnlock()
+		return
+	}
+	select {
+	case t.queue <- d:
+		t.mu.RUnlock()
+	default:
+		t.mu.RUnlock()
+		t.mu.Lock()
+		t.dropped++
+		t.mu.Unlock()
+	}
+}
+
+// run collects spans into batches and passes them to exporter, until queue is closed.
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(tracerFlushEvery)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, tracerBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.ExportSpans(batch); err != nil {
+			t.OnError(err)
+		}
+		batch = make([]SpanData, 0, tracerBatchSize)
+	}
+
+	for {
+		select {
+		case d, ok := <-t.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, d)
+			if len(batch) >= tracerBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+// -- section: W3C Trace Context propagation
+
+// traceparentHeader is a name of the header carrying span context, as defined in W3C Trace Context.
+const traceparentHeader = "Traceparent"
+
+// parseTraceparent decodes header value in format: version-traceid-spanid-flags.
+// Unknown future versions are accepted as long as they start with known fields.
+func parseTraceparent(v string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 {
+		return SpanContext{}, false
+	}
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || len(version) != 1 || version[0] == 0xff {
+		return SpanContext{}, false
+	}
+	if version[0] == 0 && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	if len(parts[1]) != 32 || strings.ToLower(parts[1]) != parts[1] {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if len(parts[2]) != 16 || strings.ToLower(parts[2]) != parts[2] {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&0x01 == 0x01
+
+	if !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+// formatTraceparent encodes span context as version 00 header value.
+func formatTraceparent(sc SpanContext) string {
+	var flags byte
+	if sc.Sampled {
+		flags = 0x01
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, flags)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tracingServiceName identifies the service in exported traces.
+const tracingServiceName = "example-go-messenger"
+
+// otlpSpan is a span encoded in OTLP/JSON format.
+// IDs are hex encoded, as required by OTLP/HTTP, and times are nanoseconds since epoch as strings.
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// otlpStatus codes: 0 - unset, 2 - error.
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// otlpSpanOf converts finished span into its OTLP/JSON representation.
+func otlpSpanOf(d SpanData) otlpSpan {
+	s := otlpSpan{
+		TraceID:           d.SpanContext.TraceID.String(),
+		SpanID:            d.SpanContext.SpanID.String(),
+		Name:              d.Name,
+		Kind:              d.Kind,
+		StartTimeUnixNano: strconv.FormatInt(d.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(d.End.UnixNano(), 10),
+	}
+	if d.ParentSpanID.IsValid() {
+		s.ParentSpanID = d.ParentSpanID.String()
+	}
+	for _, a := range d.Attrs {
+		s.Attributes = append(s.Attributes, otlpAttribute{Key: a.Key, Value: otlpValue(a.Value)})
+	}
+	if d.Err != "" {
+		s.Status = otlpStatus{Code: 2, Message: d.Err}
+	}
+	return s
+}
+
+// otlpValue wraps attribute value into OTLP AnyValue. Unknown types are formatted as strings.
+func otlpValue(v interface{}) map[string]interface{} {
+	switch vt := v.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": vt}
+	case bool:
+		return map[string]interface{}{"boolValue": vt}
+	case int:
+		return map[string]interface{}{"intValue": strconv.Itoa(vt)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(vt, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": vt}
+	}
+	return map[string]interface{}{"stringValue": fmt.Sprint(v)}
+}
+
+// -- section: writer exporter
+
+// writerExporter writes spans as JSON lines, one OTLP/JSON span per line.
+// It's meant for offline use, output can be inspected with jq.
+type writerExporter struct {
+	w io.Writer
+	// c is closed along with the exporter, if set.
+	c io.Closer
+}
+
+// NewStdoutExporter creates exporter writing spans to standard output.
+func NewStdoutExporter() SpanExporter {
+	return &writerExporter{w: os.Stdout}
+}
+
+// NewFileExporter creates exporter appending spans to file, which is created if missing.
+func NewFileExporter(path string) (SpanExporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &writerExporter{w: f, c: f}, nil
+}
+
+func (e *writerExporter) ExportSpans(spans []SpanData) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, d := range spans {
+		if err := enc.Encode(otlpSpanOf(d)); err != nil {
+			return err
+		}
+	}
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+func (e *writerExporter) Close() error {
+	if e.c == nil {
+		return nil
+	}
+	return e.c.Close()
+}
+
+// -- section: OTLP exporter
+
+// otlpExporterTimeout limits time of single export request.
+const otlpExporterTimeout = 10 * time.Second
+
+// otlpExporter sends spans to OTLP/HTTP collector, using JSON encoding.
+type otlpExporter struct {
+	url    string
+	client *http.Client
+}
+
+// NewOTLPExporter creates exporter sending spans to collector at endpoint, e.g. http://localhost:4318.
+func NewOTLPExporter(endpoint string) SpanExporter {
+	return &otlpExporter{
+		url:    strings.TrimRight(endpoint, "/") + "/v1/traces",
+		client: &http.Client{Timeout: otlpExporterTimeout},
+	}
+}
+
+// otlpTracesRequest is a body of OTLP/HTTP export request.
+type otlpTracesRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+func (e *otlpExporter) ExportSpans(spans []SpanData) error {
+	ss := otlpScopeSpans{Scope: otlpScope{Name: tracingServiceName}}
+	for _, d := range spans {
+		ss.Spans = append(ss.Spans, otlpSpanOf(d))
+	}
+	req := otlpTracesRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{Attributes: []otlpAttribute{
+				{Key: "service.name", Value: otlpValue(tracingServiceName)},
+			}},
+			ScopeSpans: []otlpScopeSpans{ss},
+		}},
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	res, err := e.client.Post(e.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("otlp: export failed with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (e *otlpExporter) Close() error {
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+var tfSpanData = SpanData{
+	Name:         "GET /v1/messages/{id}",
+	Kind:         SpanKindServer,
+	SpanContext:  SpanContext{TraceID: TraceID{0x4b, 0xf9}, SpanID: SpanID{0x01}, Sampled: true},
+	ParentSpanID: SpanID{0x02},
+	Start:        time.Unix(0, 1000),
+	End:          time.Unix(0, 3000),
+	Attrs:        []SpanAttr{{"http.route", "/v1/messages/{id}"}, {"http.status_code", 500}},
+	Err:          "500 Internal Server Error",
+}
+
+const tfSpanDataJSON = `{"traceId":"4bf90000000000000000000000000000","spanId":"0100000000000000",` +
+	`"parentSpanId":"0200000000000000","name":"GET /v1/messages/{id}","kind":2,` +
+	`"startTimeUnixNano":"1000","endTimeUnixNano":"3000",` +
+	`"attributes":[{"key":"http.route","value":{"stringValue":"/v1/messages/{id}"}},` +
+	`{"key":"http.status_code","value":{"intValue":"500"}}],` +
+	`"status":{"code":2,"message":"500 Internal Server Error"}}`
+
+func Test_TraceExport_Writer(t *testing.T) {
+	var buf bytes.Buffer
+	e := &writerExporter{w: &buf}
+
+	ar.NoError(t, e.ExportSpans([]SpanData{tfSpanData, tfSpanData}))
+	a.Equal(t, tfSpanDataJSON+"\n"+tfSpanDataJSON+"\n", buf.String())
+	a.NoError(t, e.Close())
+}
+
+func Test_TraceExport_OTLP(t *testing.T) {
+	var gotPath, gotType string
+	var got otlpTracesRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotType = r.Header.Get("Content-Type")
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	e := NewOTLPExporter(srv.URL + "/")
+	ar.NoError(t, e.ExportSpans([]SpanData{tfSpanData}))
+
+	a.Equal(t, "/v1/traces", gotPath)
+	a.Equal(t, "application/json", gotType)
+	ar.Len(t, got.ResourceSpans, 1)
+	a.Equal(t, "service.name", got.ResourceSpans[0].Resource.Attributes[0].Key)
+	ar.Len(t, got.ResourceSpans[0].ScopeSpans, 1)
+	ar.Len(t, got.ResourceSpans[0].ScopeSpans[0].Spans, 1)
+	b, _ := json.Marshal(got.ResourceSpans[0].ScopeSpans[0].Spans[0])
+	a.JSONEq(t, tfSpanDataJSON, string(b))
+}
+
+func Test_TraceExport_OTLP_Failure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	err := NewOTLPExporter(srv.URL).ExportSpans([]SpanData{tfSpanData})
+	if a.Error(t, err) {
+		a.True(t, strings.Contains(err.Error(), "503"), "status not reported: %s", err)
+	}
+}
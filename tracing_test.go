@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+// tsSpanRecorder is an exporter keeping spans in memory.
+type tsSpanRecorder struct {
+	mu     sync.Mutex
+	spans  []SpanData
+	closed bool
+}
+
+func (r *tsSpanRecorder) ExportSpans(spans []SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *tsSpanRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return nil
+}
+
+// Spans returns exported spans keyed by name.
+func (r *tsSpanRecorder) Spans() map[string]SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make(map[string]SpanData)
+	for _, s := range r.spans {
+		out[s.Name] = s
+	}
+	return out
+}
+
+func tsTracerSetup() (*Tracer, *tsSpanRecorder) {
+	rec := &tsSpanRecorder{}
+	return NewTracer(rec), rec
+}
+
+func Test_Tracer_ParentChild(t *testing.T) {
+	tr, rec := tsTracerSetup()
+
+	ctx, root := tr.Start(context.Background(), "root", SpanKindServer)
+	_, child := StartSpan(ctx, "child", SpanKindInternal)
+	child.SetAttr("k", "v")
+	child.SetError(errors.New("failed"))
+	child.End()
+	root.End()
+
+	ar.NoError(t, tr.Close())
+	a.True(t, rec.closed, "exporter not closed")
+
+	spans := rec.Spans()
+	ar.Len(t, spans, 2, "incorrect number of spans exported")
+	r, c := spans["root"], spans["child"]
+
+	a.True(t, r.SpanContext.IsValid(), "root span context invalid")
+	a.True(t, r.SpanContext.Sampled, "root span should be sampled")
+	a.False(t, r.ParentSpanID.IsValid(), "root span should have no parent")
+	a.Equal(t, SpanKindServer, r.Kind)
+
+	a.Equal(t, r.SpanContext.TraceID, c.SpanContext.TraceID, "child should share trace")
+	a.Equal(t, r.SpanContext.SpanID, c.ParentSpanID, "incorrect parent of child")
+	a.NotEqual(t, r.SpanContext.SpanID, c.SpanContext.SpanID, "child should have own ID")
+	a.Equal(t, []SpanAttr{{Key: "k", Value: "v"}}, c.Attrs)
+	a.Equal(t, "failed", c.Err)
+}
+
+func Test_Tracer_RemoteParent(t *testing.T) {
+	tr, rec := tsTracerSetup()
+
+	tests := map[string]struct {
+		sampled bool
+		exp     int
+	}{
+		"sampled":     {true, 1},
+		"not sampled": {false, 0},
+	}
+	for s, tc := range tests {
+		remote := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}, Sampled: tc.sampled}
+		_, span := tr.Start(ContextWithRemoteSpanContext(context.Background(), remote), "server", SpanKindServer)
+		a.Equal(t, remote.TraceID, span.SpanContext().TraceID, "[%s] remote trace not continued", s)
+		a.Equal(t, tc.sampled, span.SpanContext().Sampled, "[%s] sampling decision not followed", s)
+		span.End()
+	}
+	ar.NoError(t, tr.Close())
+	a.Len(t, rec.spans, 1, "only sampled span should be exported")
+	a.Equal(t, SpanID{2}, rec.spans[0].ParentSpanID, "incorrect remote parent")
+}
+
+func Test_Tracer_Disabled(t *testing.T) {
+	var tr *Tracer
+
+	ctx, span := tr.Start(context.Background(), "root", SpanKindServer)
+	a.Nil(t, span)
+
+	// nil span is safe to use
+	_, child := StartSpan(ctx, "child", SpanKindInternal)
+	a.Nil(t, child)
+	child.SetAttr("k", "v")
+	child.SetError(errors.New("failed"))
+	child.End()
+	a.False(t, child.SpanContext().IsValid())
+	a.NoError(t, tr.Close())
+}
+
+func Test_Tracer_EndOnce(t *testing.T) {
+	tr, rec := tsTracerSetup()
+	timeBase := time.Date(2016, time.May, 29, 10, 11, 12, 0, time.UTC)
+	tr.TimeNow = func() time.Time { return timeBase }
+
+	_, span := tr.Start(context.Background(), "root", SpanKindInternal)
+	span.End()
+	span.SetAttr("late", true)
+	span.End()
+
+	ar.NoError(t, tr.Close())
+	ar.Len(t, rec.spans, 1, "span should be exported once")
+	a.Empty(t, rec.spans[0].Attrs, "ended span should not be changed")
+	a.Equal(t, timeBase, rec.spans[0].Start)
+	a.Equal(t, timeBase, rec.spans[0].End)
+}
+
+func Test_Traceparent_Parse(t *testing.T) {
+	valid := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := parseTraceparent(valid)
+	ar.True(t, ok, "valid header rejected")
+	a.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	a.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	a.True(t, sc.Sampled)
+	a.Equal(t, valid, formatTraceparent(sc), "round trip failed")
+
+	sc, ok = parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	a.True(t, ok)
+	a.False(t, sc.Sampled, "sampled flag not honoured")
+
+	_, ok = parseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future")
+	a.True(t, ok, "future version should be accepted")
+
+	invalid := map[string]string{
+		"empty":          "",
+		"too few parts":  "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"version ff":     "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"v00 extra part": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-x",
+		"short trace":    "00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"upper case":     "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"not hex":        "00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+		"zero trace":     "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"zero span":      "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"invalid flags":  "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1",
+	}
+	for s, v := range invalid {
+		_, ok := parseTraceparent(v)
+		a.False(t, ok, "[%s] invalid header accepted", s)
+	}
+}
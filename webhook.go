@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+const (
+	// webhookAttemptsDefault is a number of attempts of delivery before it's moved to dead letters.
+	webhookAttemptsDefault = 5
+
+	// webhookBackoffDefault is a delay before the first retry. It doubles with each following one.
+	webhookBackoffDefault = time.Second
+
+	// webhookBackoffMaxDefault caps the delay between retries.
+	webhookBackoffMaxDefault = 5 * time.Minute
+
+	// webhookTimeoutDefault is a time in which receiver must respond before attempt fails.
+	webhookTimeoutDefault = 10 * time.Second
+
+	// webhookQueueMax is a number of deliveries waiting for single webhook before new ones go straight to dead letters.
+	webhookQueueMax = 1000
+
+	// webhookAttemptsLogMax is a number of the latest attempts kept for each webhook.
+	webhookAttemptsLogMax = 100
+
+	// webhookDeadLettersMax is a number of the latest dead letters kept for each webhook.
+	webhookDeadLettersMax = 100
+)
+
+// Headers of requests sent to webhooks.
+const (
+	webhookHeaderEvent     = "X-Messenger-Event"
+	webhookHeaderDelivery  = "X-Messenger-Delivery"
+	webhookHeaderSignature = "X-Messenger-Signature"
+)
+
+// WebhookStorer is storage interface for webhooks.
+// It's optional, webhooks are not supported with storage which does not implement it.
+type WebhookStorer interface {
+	WebhookSave(ctx context.Context, wh *Webhook) error
+	WebhookLoad(ctx context.Context, id string) (*Webhook, error)
+
+	// WebhookUpdate replaces existing webhook. ErrElementNotFound is returned if webhook is missing.
+	WebhookUpdate(ctx context.Context, wh *Webhook) error
+
+	// WebhookDelete removes webhook. ErrElementNotFound is returned if webhook is missing.
+	WebhookDelete(ctx context.Context, id string) error
+
+	// WebhooksList returns all webhooks ordered by ID.
+	WebhooksList(ctx context.Context) ([]*Webhook, error)
+}
+
+// WebhookOutcome tells how single attempt of delivery ended.
+type WebhookOutcome string
+
+const (
+	WebhookDelivered WebhookOutcome = "delivered"
+	WebhookRetrying  WebhookOutcome = "retrying"
+	WebhookFailed    WebhookOutcome = "failed"
+)
+
+// WebhookAttempt is a record of single attempt of delivery.
+type WebhookAttempt struct {
+	// DeliveryID identifies the delivery, it's the same for all its attempts.
+	DeliveryID string
+
+	Event     MsgEventType
+	MessageID string
+
+	// Attempt is a number of the attempt, starting from 1.
+	Attempt int
+
+	At       time.Time
+	Duration time.Duration
+
+	// StatusCode of the response. It's zero when no response was received.
+	StatusCode int
+
+	// Error describes the failure. It's empty for delivered requests.
+	Error string
+
+	Outcome WebhookOutcome
+}
+
+// WebhookDeadLetter is a delivery given up on, kept for inspection.
+type WebhookDeadLetter struct {
+	DeliveryID string
+	Event      MsgEventType
+	MessageID  string
+
+	// Attempts is a number of attempts made.
+	Attempts int
+
+	// Error describes the last failure.
+	Error string
+
+	FailedAt time.Time
+
+	// Payload is a body of the request which was not accepted.
+	Payload []byte
+}
+
+// webhookDelivery is a change of the message on the way to single webhook.
+type webhookDelivery struct {
+	id        string
+	webhookID string
+	event     MsgEventType
+	msgID     string
+	payload   []byte
+}
+
+// webhookWorker delivers changes to single webhook, one by one.
+type webhookWorker struct {
+	queue  chan *webhookDelivery
+	cancel context.CancelFunc
+}
+
+// WebhookDispatcher delivers changes of messages published on the bus to matching webhooks.
+//
+// Each change is sent as JSON payload (WebhookEventOut) in POST request signed with HMAC-SHA256 of the secret
+// of the webhook, given in X-Messenger-Signature header as "sha256=<hex>". Changes are delivered to each webhook
+// in order of publishing. Delivery which failed with network error, timeout, 408, 429 or 5xx response is retried
+// with exponential backoff, delivery which failed otherwise or ran out of attempts is moved to dead letters.
+// Recent attempts and dead letters are kept in memory, so they are lost on restart.
+type WebhookDispatcher struct {
+	Storer WebhookStorer
+
+	// Users provides names of authors put into payloads.
+	Users UserStorer
+
+	// Client sends requests. Its timeout limits time of single attempt.
+	Client *http.Client
+
+	// Attempts is a number of attempts of delivery before it's moved to dead letters.
+	Attempts int
+
+	// Backoff is a delay before the first retry. It doubles with each following one, up to BackoffMax.
+	Backoff    time.Duration
+	BackoffMax time.Duration
+
+	// OnError is called with failures not related to any delivery, e.g. of the storage. They are ignored when it's nil.
+	OnError func(err error)
+
+	// TimeNow is testing helper for time sensitive tests. It defaults to time.Now function.
+	TimeNow func() time.Time
+
+	bus    *MsgBus
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// mu guards workers, attempts and deadLetters, all keyed by Webhook.ID
+	mu          sync.Mutex
+	workers     map[string]*webhookWorker
+	attempts    map[string][]WebhookAttempt
+	deadLetters map[string][]WebhookDeadLetter
+}
+
+// NewWebhookDispatcher returns dispatcher of changes published on the bus to webhooks kept in the storage.
+// Fields shall be tuned before Start.
+func NewWebhookDispatcher(ws WebhookStorer, us UserStorer, bus *MsgBus) *WebhookDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &WebhookDispatcher{
+		Storer:      ws,
+		Users:       us,
+		Client:      &http.Client{Timeout: webhookTimeoutDefault},
+		Attempts:    webhookAttemptsDefault,
+		Backoff:     webhookBackoffDefault,
+		BackoffMax:  webhookBackoffMaxDefault,
+		TimeNow:     time.Now,
+		bus:         bus,
+		ctx:         ctx,
+		cancel:      cancel,
+		workers:     make(map[string]*webhookWorker),
+		attempts:    make(map[string][]WebhookAttempt),
+		deadLetters: make(map[string][]WebhookDeadLetter),
+	}
+}
+
+// Start subscribes to the bus and delivers changes published from now on, until Close.
+func (d *WebhookDispatcher) Start() {
+	sub := d.bus.SubscribeAll()
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.run(sub)
+	}()
+}
+
+// Close stops delivery and waits for requests in flight. Pending deliveries and retries are abandoned.
+func (d *WebhookDispatcher) Close() error {
+	d.cancel()
+	d.wg.Wait()
+	return nil
+}
+
+// Forget stops delivery to removed webhook and drops its attempts and dead letters.
+func (d *WebhookDispatcher) Forget(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if w, found := d.workers[id]; found {
+		w.cancel()
+		delete(d.workers, id)
+	}
+	delete(d.attempts, id)
+	delete(d.deadLetters, id)
+}
+
+// AttemptsOf returns the latest attempts of deliveries to the webhook, from the newest.
+func (d *WebhookDispatcher) AttemptsOf(id string) []WebhookAttempt {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	log := d.attempts[id]
+	out := make([]WebhookAttempt, 0, len(log))
+	for i := len(log) - 1; i >= 0; i-- {
+		out = append(out, log[i])
+	}
+	return out
+}
+
+// DeadLettersOf returns the latest deliveries to the webhook given up on, from the newest.
+func (d *WebhookDispatcher) DeadLettersOf(id string) []WebhookDeadLetter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	log := d.deadLetters[id]
+	out := make([]WebhookDeadLetter, 0, len(log))
+	for i := len(log) - 1; i >= 0; i-- {
+		out = append(out, log[i])
+	}
+	return out
+}
+
+// run dispatches events until Close or closing of the bus.
+// Subscription dropped for lagging behind is renewed, changes published meanwhile are lost.
+func (d *WebhookDispatcher) run(sub *MsgSubscription) {
+	for {
+		select {
+		case <-d.ctx.Done():
+			sub.Close()
+			return
+		case ev, ok := <-sub.C:
+			if !ok {
+				if sub.Err() != ErrSubscriptionLagged {
+					return
+				}
+				d.onError(fmt.Errorf("Webhooks: %s, changes were lost", sub.Err()))
+				sub = d.bus.SubscribeAll()
+				continue
+			}
+			d.dispatch(ev)
+		}
+	}
+}
+
+// dispatch queues the change for all matching webhooks.
+func (d *WebhookDispatcher) dispatch(ev MsgEvent) {
+	hooks, err := d.Storer.WebhooksList(d.ctx)
+	if err != nil {
+		d.onError(fmt.Errorf("Webhooks: list failed: %s", err))
+		return
+	}
+
+	var trMsg *MessageOut
+	for _, wh := range hooks {
+		if !wh.Matches(ev) {
+			continue
+		}
+		if trMsg == nil {
+			if trMsg, err = d.message(ev.Msg); err != nil {
+				d.onError(fmt.Errorf("Webhooks: author load failed: %s", err))
+				return
+			}
+		}
+
+		dl := &webhookDelivery{
+			id:        uuid.NewV1().String(),
+			webhookID: wh.ID,
+			event:     ev.Type,
+			msgID:     ev.Msg.ID,
+		}
+		dl.payload, err = json.Marshal(WebhookEventOut{
+			ID:         dl.id,
+			Type:       string(ev.Type),
+			OccurredAt: d.TimeNow().UTC(),
+			Message:    *trMsg,
+			PrevTags:   ev.PrevTags,
+		})
+		if err != nil {
+			d.onError(fmt.Errorf("Webhooks: payload encoding failed: %s", err))
+			return
+		}
+		d.enqueue(dl)
+	}
+}
+
+// message converts the message into its transport model, along with the name of its author.
+func (d *WebhookDispatcher) message(m *Message) (*MessageOut, error) {
+	var author *User
+	if m.AuthorID != "" {
+		var err error
+		author, err = d.Users.UserLoad(d.ctx, m.AuthorID)
+		if err != nil && err != ErrElementNotFound {
+			return nil, err
+		}
+	}
+	trOut := msgToTransport(m, author)
+	return &trOut, nil
+}
+
+// enqueue passes delivery to the worker of its webhook, starting it if needed.
+// Delivery goes straight to dead letters when the queue is full.
+func (d *WebhookDispatcher) enqueue(dl *webhookDelivery) {
+	d.mu.Lock()
+	w, found := d.workers[dl.webhookID]
+	if !found {
+		ctx, cancel := context.WithCancel(d.ctx)
+		w = &webhookWorker{
+			queue:  make(chan *webhookDelivery, webhookQueueMax),
+			cancel: cancel,
+		}
+		d.workers[dl.webhookID] = w
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.work(ctx, w)
+		}()
+	}
+	d.mu.Unlock()
+
+	select {
+	case w.queue <- dl:
+	default:
+		d.deadLetter(dl, 0, "delivery queue is full")
+	}
+}
+
+// work delivers queued changes one by one, until webhook is forgotten or dispatcher closed.
+func (d *WebhookDispatcher) work(ctx context.Context, w *webhookWorker) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case dl := <-w.queue:
+			d.deliver(ctx, dl)
+		}
+	}
+}
+
+// deliver sends the change until it's accepted, rejected or attempts run out.
+// Webhook is loaded before each attempt, so changes of its URL and secret apply to retries.
+func (d *WebhookDispatcher) deliver(ctx context.Context, dl *webhookDelivery) {
+	for attempt := 1; ; attempt++ {
+		rec := WebhookAttempt{
+			DeliveryID: dl.id,
+			Event:      dl.event,
+			MessageID:  dl.msgID,
+			Attempt:    attempt,
+			At:         d.TimeNow().UTC(),
+		}
+
+		retry := true
+		wh, err := d.Storer.WebhookLoad(ctx, dl.webhookID)
+		switch {
+		case err == ErrElementNotFound:
+			// webhook was removed meanwhile
+			return
+		case err == nil:
+			rec.StatusCode, err = d.post(ctx, wh, dl)
+			retry = err != nil || webhookRetryable(rec.StatusCode)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		rec.Duration = d.TimeNow().Sub(rec.At)
+
+		switch {
+		case err == nil && rec.StatusCode/100 == 2:
+			rec.Outcome = WebhookDelivered
+		case err == nil:
+			rec.Error = fmt.Sprintf("unexpected response: %d %s", rec.StatusCode, http.StatusText(rec.StatusCode))
+		default:
+			rec.Error = err.Error()
+		}
+		if rec.Outcome == "" {
+			rec.Outcome = WebhookFailed
+			if retry && attempt < d.Attempts {
+				rec.Outcome = WebhookRetrying
+			}
+		}
+		d.record(dl.webhookID, rec)
+
+		switch rec.Outcome {
+		case WebhookDelivered:
+			return
+		case WebhookFailed:
+			d.deadLetter(dl, attempt, rec.Error)
+			return
+		}
+
+		t := time.NewTimer(d.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// post sends signed payload to the webhook and returns status code of the response.
+func (d *WebhookDispatcher) post(ctx context.Context, wh *Webhook, dl *webhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, wh.URL, bytes.NewReader(dl.payload))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookHeaderEvent, string(dl.event))
+	req.Header.Set(webhookHeaderDelivery, dl.id)
+	req.Header.Set(webhookHeaderSignature, webhookSignature(wh.Secret, dl.payload))
+
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	// body is drained so that connection is reused
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64<<10))
+	res.Body.Close()
+	return res.StatusCode, nil
+}
+
+// backoff returns delay before retry following given attempt.
+func (d *WebhookDispatcher) backoff(attempt int) time.Duration {
+	delay := d.Backoff
+	for i := 1; i < attempt && delay < d.BackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > d.BackoffMax {
+		delay = d.BackoffMax
+	}
+	return delay
+}
+
+// record appends attempt to the log of the webhook, dropping the oldest ones over the limit.
+func (d *WebhookDispatcher) record(id string, rec WebhookAttempt) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, found := d.workers[id]; !found {
+		// webhook was forgotten meanwhile
+		return
+	}
+	log := append(d.attempts[id], rec)
+	if len(log) > webhookAttemptsLogMax {
+		log = log[len(log)-webhookAttemptsLogMax:]
+	}
+	d.attempts[id] = log
+}
+
+// deadLetter keeps delivery given up on, dropping the oldest ones over the limit.
+func (d *WebhookDispatcher) deadLetter(dl *webhookDelivery, attempts int, reason string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, found := d.workers[dl.webhookID]; !found {
+		return
+	}
+	log := append(d.deadLetters[dl.webhookID], WebhookDeadLetter{
+		DeliveryID: dl.id,
+		Event:      dl.event,
+		MessageID:  dl.msgID,
+		Attempts:   attempts,
+		Error:      reason,
+		FailedAt:   d.TimeNow().UTC(),
+		Payload:    dl.payload,
+	})
+	if len(log) > webhookDeadLettersMax {
+		log = log[len(log)-webhookDeadLettersMax:]
+	}
+	d.deadLetters[dl.webhookID] = log
+}
+
+func (d *WebhookDispatcher) onError(err error) {
+	if d.OnError != nil {
+		d.OnError(err)
+	}
+}
+
+// webhookRetryable reports whether request failed with given status code may succeed when repeated.
+func webhookRetryable(status int) bool {
+	return status >= 500 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+}
+
+// webhookSignature signs the payload with the secret, in format sent in X-Messenger-Signature header.
+func webhookSignature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+// tsWebhookRequest is a request received by test webhook receiver.
+type tsWebhookRequest struct {
+	Header http.Header
+	Body   []byte
+}
+
+// tsWebhookReceiver starts webhook receiver responding with given statuses in turn, the last one is repeated.
+// Received requests are passed on the channel.
+func tsWebhookReceiver(statuses ...int) (*httptest.Server, <-chan tsWebhookRequest) {
+	reqs := make(chan tsWebhookRequest, 100)
+	var mu sync.Mutex
+	n := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		reqs <- tsWebhookRequest{Header: r.Header, Body: body}
+
+		mu.Lock()
+		status := statuses[len(statuses)-1]
+		if n < len(statuses) {
+			status = statuses[n]
+		}
+		n++
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	return ts, reqs
+}
+
+// tsWebhookRecv waits for the next request received by the receiver.
+func tsWebhookRecv(t *testing.T, reqs <-chan tsWebhookRequest) tsWebhookRequest {
+	select {
+	case req := <-reqs:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook request not received")
+	}
+	return tsWebhookRequest{}
+}
+
+// tsWaitFor polls until condition is met.
+func tsWaitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for: %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// tsWebhookDispatcherSetup creates dispatcher over memory storage with authors saved and quick retries.
+// Webhooks are saved with URL of given receiver.
+func tsWebhookDispatcherSetup(t *testing.T, url string, hooks ...Webhook) (*WebhookDispatcher, *MsgBus) {
+	st := NewMemoryStorage()
+	for _, u := range []User{tfUserA, tfUserB} {
+		uC := u
+		ar.NoError(t, st.UserSave(context.Background(), &uC))
+	}
+	for _, wh := range hooks {
+		whC := wh
+		whC.URL = url
+		ar.NoError(t, st.WebhookSave(context.Background(), &whC))
+	}
+
+	bus := NewMsgBus()
+	d := NewWebhookDispatcher(st, st, bus)
+	d.Backoff = time.Millisecond
+	d.BackoffMax = 4 * time.Millisecond
+	return d, bus
+}
+
+func Test_WebhookDispatcher_Deliver(t *testing.T) {
+	ts, reqs := tsWebhookReceiver(http.StatusNoContent)
+	defer ts.Close()
+	// GIVEN: webhook A follows tagA, webhook B messages of UserB in tagA
+	d, bus := tsWebhookDispatcherSetup(t, ts.URL, tfWebhookA, tfWebhookB)
+	d.Start()
+	defer d.Close()
+
+	// WHEN: message of UserA is created
+	bus.Publish(MsgEvent{Type: MsgEventCreated, Msg: &tfMsgAA})
+
+	// THEN: it's delivered to webhook A only
+	req := tsWebhookRecv(t, reqs)
+	a.Equal(t, "application/json", req.Header.Get("Content-Type"), "mismatch on content type")
+	a.Equal(t, string(MsgEventCreated), req.Header.Get(webhookHeaderEvent), "mismatch on event header")
+	a.Equal(t, webhookSignature(tfWebhookA.Secret, req.Body), req.Header.Get(webhookHeaderSignature), "mismatch on signature")
+
+	var ev WebhookEventOut
+	ar.NoError(t, json.Unmarshal(req.Body, &ev), "unexpected error on payload decode")
+	a.Equal(t, req.Header.Get(webhookHeaderDelivery), ev.ID, "mismatch on delivery ID")
+	a.Equal(t, string(MsgEventCreated), ev.Type, "mismatch on event type")
+	a.Equal(t, msgToTransport(&tfMsgAA, &tfUserA), ev.Message, "mismatch on message")
+
+	// AND: attempt is logged
+	tsWaitFor(t, "attempt logged", func() bool { return len(d.AttemptsOf(tfWebhookA.ID)) == 1 })
+	at := d.AttemptsOf(tfWebhookA.ID)[0]
+	a.Equal(t, ev.ID, at.DeliveryID, "mismatch on logged delivery ID")
+	a.Equal(t, tfMsgAA.ID, at.MessageID, "mismatch on logged message ID")
+	a.Equal(t, 1, at.Attempt, "mismatch on logged attempt")
+	a.Equal(t, http.StatusNoContent, at.StatusCode, "mismatch on logged status code")
+	a.Equal(t, WebhookDelivered, at.Outcome, "mismatch on logged outcome")
+	a.Empty(t, at.Error, "error logged")
+
+	// WHEN: message of UserB is moved away from tagA
+	bus.Publish(MsgEvent{Type: MsgEventUpdated, Msg: &tfMsgBB, PrevTags: []Tag{tfTagA}})
+
+	// THEN: it's delivered to both, signed with their secrets
+	for i := 0; i < 2; i++ {
+		req := tsWebhookRecv(t, reqs)
+		ar.NoError(t, json.Unmarshal(req.Body, &ev), "unexpected error on payload decode")
+		a.Equal(t, string(MsgEventUpdated), ev.Type, "mismatch on event type")
+		a.Equal(t, []Tag{tfTagA}, ev.PrevTags, "mismatch on previous tags")
+		sig := req.Header.Get(webhookHeaderSignature)
+		a.True(t, sig == webhookSignature(tfWebhookA.Secret, req.Body) || sig == webhookSignature(tfWebhookB.Secret, req.Body), "invalid signature")
+	}
+	a.Empty(t, reqs, "unexpected delivery")
+}
+
+func Test_WebhookDispatcher_Retry(t *testing.T) {
+	ts, reqs := tsWebhookReceiver(http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+	defer ts.Close()
+	d, bus := tsWebhookDispatcherSetup(t, ts.URL, tfWebhookA)
+	d.Start()
+	defer d.Close()
+
+	// WHEN: receiver fails twice on the first of two messages
+	bus.Publish(MsgEvent{Type: MsgEventCreated, Msg: &tfMsgAA})
+	bus.Publish(MsgEvent{Type: MsgEventCreated, Msg: &tfMsgAB})
+
+	// THEN: the first one is retried before the second one is sent
+	var deliveries []string
+	for _, msgIDExp := range []string{tfMsgAA.ID, tfMsgAA.ID, tfMsgAA.ID, tfMsgAB.ID} {
+		var ev WebhookEventOut
+		ar.NoError(t, json.Unmarshal(tsWebhookRecv(t, reqs).Body, &ev))
+		a.Equal(t, msgIDExp, ev.Message.ID, "mismatch on order of deliveries")
+		deliveries = append(deliveries, ev.ID)
+	}
+	a.Equal(t, deliveries[0], deliveries[2], "retry under new delivery ID")
+	a.NotEqual(t, deliveries[0], deliveries[3], "delivery ID reused")
+
+	// AND: attempts are logged
+	tsWaitFor(t, "attempts logged", func() bool { return len(d.AttemptsOf(tfWebhookA.ID)) == 4 })
+	attempts := d.AttemptsOf(tfWebhookA.ID)
+	for i, exp := range []struct {
+		attempt int
+		status  int
+		outcome WebhookOutcome
+	}{
+		{1, http.StatusOK, WebhookDelivered},
+		{3, http.StatusOK, WebhookDelivered},
+		{2, http.StatusTooManyRequests, WebhookRetrying},
+		{1, http.StatusServiceUnavailable, WebhookRetrying},
+	} {
+		a.Equal(t, exp.attempt, attempts[i].Attempt, "[%d] mismatch on attempt", i)
+		a.Equal(t, exp.status, attempts[i].StatusCode, "[%d] mismatch on status code", i)
+		a.Equal(t, exp.outcome, attempts[i].Outcome, "[%d] mismatch on outcome", i)
+	}
+	a.Equal(t, "unexpected response: 503 Service Unavailable", attempts[3].Error, "mismatch on error")
+	a.Empty(t, d.DeadLettersOf(tfWebhookA.ID), "unexpected dead letter")
+}
+
+func Test_WebhookDispatcher_DeadLetter(t *testing.T) {
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	tests := map[string]struct {
+		status      int
+		unreachable bool
+		attempts    int
+		errPrefix   string
+	}{
+		"rejected": {
+			status:    http.StatusBadRequest,
+			attempts:  1,
+			errPrefix: "unexpected response: 400",
+		},
+		"gone": {
+			status:    http.StatusGone,
+			attempts:  1,
+			errPrefix: "unexpected response: 410",
+		},
+		"attempts exhausted": {
+			status:    http.StatusInternalServerError,
+			attempts:  3,
+			errPrefix: "unexpected response: 500",
+		},
+		"unreachable": {
+			unreachable: true,
+			attempts:    3,
+			errPrefix:   "Post",
+		},
+	}
+
+	for sym, tc := range tests {
+		ts, _ := tsWebhookReceiver(tc.status)
+		url := ts.URL
+		if tc.unreachable {
+			url = unreachable.URL
+		}
+		d, bus := tsWebhookDispatcherSetup(t, url, tfWebhookA)
+		d.Attempts = 3
+		d.Start()
+
+		bus.Publish(MsgEvent{Type: MsgEventDeleted, Msg: &tfMsgAA})
+
+		// THEN:
+		tsWaitFor(t, sym+": dead letter", func() bool { return len(d.DeadLettersOf(tfWebhookA.ID)) == 1 })
+		dl := d.DeadLettersOf(tfWebhookA.ID)[0]
+		a.Equal(t, tc.attempts, dl.Attempts, "[%s] mismatch on attempts", sym)
+		a.Equal(t, MsgEventDeleted, dl.Event, "[%s] mismatch on event", sym)
+		a.Equal(t, tfMsgAA.ID, dl.MessageID, "[%s] mismatch on message ID", sym)
+		a.Contains(t, dl.Error, tc.errPrefix, "[%s] mismatch on error", sym)
+
+		var ev WebhookEventOut
+		if a.NoError(t, json.Unmarshal(dl.Payload, &ev), "[%s] unexpected error on payload decode", sym) {
+			a.Equal(t, dl.DeliveryID, ev.ID, "[%s] mismatch on payload", sym)
+		}
+
+		attempts := d.AttemptsOf(tfWebhookA.ID)
+		if a.Len(t, attempts, tc.attempts, "[%s] mismatch on logged attempts", sym) {
+			a.Equal(t, WebhookFailed, attempts[0].Outcome, "[%s] mismatch on outcome of the last attempt", sym)
+		}
+
+		d.Close()
+		ts.Close()
+	}
+}
+
+func Test_WebhookDispatcher_Backoff(t *testing.T) {
+	d := NewWebhookDispatcher(NewMemoryStorage(), NewMemoryStorage(), NewMsgBus())
+	d.Backoff = time.Second
+	d.BackoffMax = 5 * time.Second
+
+	for attempt, exp := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		a.Equal(t, exp, d.backoff(attempt+1), "mismatch on backoff after attempt %d", attempt+1)
+	}
+}
+
+func Test_WebhookDispatcher_Forget(t *testing.T) {
+	ts, reqs := tsWebhookReceiver(http.StatusBadRequest)
+	defer ts.Close()
+	d, bus := tsWebhookDispatcherSetup(t, ts.URL, tfWebhookA)
+	d.Start()
+	defer d.Close()
+
+	bus.Publish(MsgEvent{Type: MsgEventCreated, Msg: &tfMsgAA})
+	tsWebhookRecv(t, reqs)
+	tsWaitFor(t, "dead letter", func() bool { return len(d.DeadLettersOf(tfWebhookA.ID)) == 1 })
+
+	// WHEN:
+	d.Forget(tfWebhookA.ID)
+
+	// THEN:
+	a.Empty(t, d.AttemptsOf(tfWebhookA.ID), "attempts kept")
+	a.Empty(t, d.DeadLettersOf(tfWebhookA.ID), "dead letters kept")
+}
+
+func Test_WebhookDispatcher_Close(t *testing.T) {
+	ts, reqs := tsWebhookReceiver(http.StatusServiceUnavailable)
+	defer ts.Close()
+	d, bus := tsWebhookDispatcherSetup(t, ts.URL, tfWebhookA)
+	d.Backoff = time.Hour
+	d.BackoffMax = time.Hour
+	d.Start()
+
+	// GIVEN: delivery waits for retry
+	bus.Publish(MsgEvent{Type: MsgEventCreated, Msg: &tfMsgAA})
+	tsWebhookRecv(t, reqs)
+	tsWaitFor(t, "attempt logged", func() bool { return len(d.AttemptsOf(tfWebhookA.ID)) == 1 })
+
+	// THEN: retry is abandoned on close
+	done := make(chan struct{})
+	go func() {
+		d.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("close blocked by pending retry")
+	}
+	a.Empty(t, d.DeadLettersOf(tfWebhookA.ID), "abandoned delivery moved to dead letters")
+}
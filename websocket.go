@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// wsGUID is appended to the key of the client when accept value of the handshake is computed, see RFC 6455.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket frame opcodes.
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// WebSocket close codes.
+const (
+	wsCloseNormal        = 1000
+	wsCloseGoingAway     = 1001
+	wsCloseProtocolError = 1002
+	wsCloseInvalidData   = 1007
+	wsClosePolicy        = 1008
+	wsCloseTooBig        = 1009
+	wsCloseInternal      = 1011
+)
+
+var (
+	// errWSHandshake is returned when request is not a valid WebSocket opening handshake.
+	errWSHandshake = errors.New("WebSocket: invalid handshake")
+
+	// errWSClosed is returned when connection was closed by the peer with close frame.
+	errWSClosed = errors.New("WebSocket: closed by peer")
+)
+
+// wsProtocolError is returned when peer violated the protocol. Connection is closed with its code.
+type wsProtocolError struct {
+	Code   int
+	Reason string
+}
+
+func (e *wsProtocolError) Error() string {
+	return "WebSocket: " + e.Reason
+}
+
+// wsConn is server side of WebSocket connection. Frames are limited to what RFC 6455 requires,
+// no extensions nor subprotocols are negotiated.
+//
+// Messages are read by single goroutine. Writes may be done concurrently.
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	// MaxMessage is a maximum size of the message, in bytes. Connection is closed on larger ones.
+	MaxMessage int
+
+	// ReadTimeout is a time in which next frame must arrive. Pings sent by server are answered with pongs,
+	// so idle but healthy client keeps the connection alive. Zero means no timeout.
+	ReadTimeout time.Duration
+
+	// WriteTimeout is a time in which frame must be sent. Zero means no timeout.
+	WriteTimeout time.Duration
+
+	// wmu serialises frames written by the reader (pongs, close replies) and other writers
+	wmu       sync.Mutex
+	closeSent bool
+}
+
+// wsUpgrade validates opening handshake and takes over the connection.
+// errWSHandshake is returned, before anything is written, if request is not a valid handshake.
+func wsUpgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if r.Method != http.MethodGet ||
+		!wsHeaderHasToken(r.Header, "Connection", "upgrade") ||
+		!wsHeaderHasToken(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, errWSHandshake
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if raw, err := base64.StdEncoding.DecodeString(key); err != nil || len(raw) != 16 {
+		return nil, errWSHandshake
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errHijackNotSupported
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &wsConn{conn: conn, br: rw.Reader}, nil
+}
+
+// wsAcceptKey computes Sec-WebSocket-Accept value for the key sent by the client.
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	io.WriteString(h, key+wsGUID)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// wsHeaderHasToken reports whether comma separated header contains token, case insensitive.
+func wsHeaderHasToken(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadMessage returns payload of the next text or binary message, reassembled from fragments.
+// Control frames are handled on the way: pings are answered and close frame is confirmed, errWSClosed is returned then.
+// Protocol violations are returned as *wsProtocolError, connection should be closed with its code.
+func (c *wsConn) ReadMessage() (op int, payload []byte, err error) {
+	op = -1
+	for {
+		fin, fop, data, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch fop {
+		case wsOpPing:
+			if err := c.WriteFrame(wsOpPong, data); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			code := wsCloseNormal
+			if len(data) >= 2 {
+				code = int(binary.BigEndian.Uint16(data))
+			}
+			c.WriteClose(code, "")
+			return 0, nil, errWSClosed
+		case wsOpText, wsOpBinary:
+			if op != -1 {
+				return 0, nil, &wsProtocolError{Code: wsCloseProtocolError, Reason: "new message started before previous one was finished"}
+			}
+			op = fop
+		case wsOpContinuation:
+			if op == -1 {
+				return 0, nil, &wsProtocolError{Code: wsCloseProtocolError, Reason: "continuation of no message"}
+			}
+		default:
+			return 0, nil, &wsProtocolError{Code: wsCloseProtocolError, Reason: "unknown opcode"}
+		}
+
+		if c.MaxMessage > 0 && len(payload)+len(data) > c.MaxMessage {
+			return 0, nil, &wsProtocolError{Code: wsCloseTooBig, Reason: "message too big"}
+		}
+		payload = append(payload, data...)
+		if !fin {
+			continue
+		}
+		if op == wsOpText && !utf8.Valid(payload) {
+			return 0, nil, &wsProtocolError{Code: wsCloseInvalidData, Reason: "text message is not valid UTF-8"}
+		}
+		return op, payload, nil
+	}
+}
+
+// readFrame reads single frame sent by the client and unmasks its payload.
+func (c *wsConn) readFrame() (fin bool, op int, payload []byte, err error) {
+	if c.ReadTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.ReadTimeout))
+	}
+
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = hdr[0]&0x80 != 0
+	op = int(hdr[0] & 0x0F)
+	masked := hdr[1]&0x80 != 0
+
+	if hdr[0]&0x70 != 0 {
+		return false, 0, nil, &wsProtocolError{Code: wsCloseProtocolError, Reason: "reserved bits set"}
+	}
+	if !masked {
+		return false, 0, nil, &wsProtocolError{Code: wsCloseProtocolError, Reason: "frame of the client not masked"}
+	}
+
+	n := uint64(hdr[1] & 0x7F)
+	isControl := op&0x8 != 0
+	if isControl && (n > 125 || !fin) {
+		return false, 0, nil, &wsProtocolError{Code: wsCloseProtocolError, Reason: "invalid control frame"}
+	}
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if c.MaxMessage > 0 && n > uint64(c.MaxMessage) {
+		return false, 0, nil, &wsProtocolError{Code: wsCloseTooBig, Reason: "message too big"}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// WriteFrame sends single, unfragmented frame. Nothing is sent once close frame was sent.
+func (c *wsConn) WriteFrame(op int, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.writeFrame(op, payload)
+}
+
+// WriteClose sends close frame with given code and reason. Following calls are no-op.
+func (c *wsConn) WriteClose(code int, reason string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return nil
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	err := c.writeFrame(wsOpClose, payload)
+	c.closeSent = true
+	return err
+}
+
+// writeFrame sends the frame. Caller must hold wmu.
+func (c *wsConn) writeFrame(op int, payload []byte) error {
+	if c.closeSent {
+		return errWSClosed
+	}
+
+	buf := make([]byte, 0, 10+len(payload))
+	buf = append(buf, 0x80|byte(op))
+	switch n := len(payload); {
+	case n <= 125:
+		buf = append(buf, byte(n))
+	case n <= 0xFFFF:
+		buf = append(buf, 126, 0, 0)
+		binary.BigEndian.PutUint16(buf[2:], uint16(n))
+	default:
+		buf = append(buf, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(buf[2:], uint64(n))
+	}
+	buf = append(buf, payload...)
+
+	if c.WriteTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.WriteTimeout))
+	}
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// Close closes underlying connection without closing handshake.
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	a "github.com/stretchr/testify/assert"
+	ar "github.com/stretchr/testify/require"
+)
+
+// tsWSKey is the key of the client from RFC 6455 example.
+const tsWSKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+// tsWSClient is client side of WebSocket connection used in tests.
+type tsWSClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// tsWSDial connects to the server and makes opening handshake.
+func tsWSDial(t *testing.T, serverURL, path string, header map[string]string) *tsWSClient {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(serverURL, "http://"))
+	ar.NoError(t, err, "unexpected error on dial")
+
+	req := "GET " + path + " HTTP/1.1\r\n" +
+		"Host: " + conn.RemoteAddr().String() + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + tsWSKey + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n"
+	for k, v := range header {
+		req += k + ": " + v + "\r\n"
+	}
+	_, err = conn.Write([]byte(req + "\r\n"))
+	ar.NoError(t, err, "unexpected error on handshake write")
+
+	c := &tsWSClient{conn: conn, br: bufio.NewReader(conn)}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	res, err := http.ReadResponse(c.br, nil)
+	ar.NoError(t, err, "unexpected error on handshake read")
+	ar.Equal(t, http.StatusSwitchingProtocols, res.StatusCode, "mismatch on response code")
+	a.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", res.Header.Get("Sec-WebSocket-Accept"), "mismatch on accept key")
+	return c
+}
+
+// writeFrame sends masked frame, as client must.
+func (c *tsWSClient) writeFrame(fin bool, op int, payload []byte) error {
+	b0 := byte(op)
+	if fin {
+		b0 |= 0x80
+	}
+	buf := []byte{b0}
+	switch n := len(payload); {
+	case n <= 125:
+		buf = append(buf, 0x80|byte(n))
+	case n <= 0xFFFF:
+		buf = append(buf, 0x80|126, byte(n>>8), byte(n))
+	default:
+		ext := make([]byte, 8)
+		binary.BigEndian.PutUint64(ext, uint64(n))
+		buf = append(append(buf, 0x80|127), ext...)
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	buf = append(buf, mask...)
+	for i, b := range payload {
+		buf = append(buf, b^mask[i%4])
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// readFrame receives single frame sent by the server. Error is returned when connection ends.
+func (c *tsWSClient) readFrame() (op int, payload []byte, err error) {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	if hdr[1]&0x80 != 0 {
+		return 0, nil, fmt.Errorf("frame of the server masked")
+	}
+	n := int(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		return 0, nil, fmt.Errorf("unexpected frame size")
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return 0, nil, err
+	}
+	return int(hdr[0] & 0x0F), payload, nil
+}
+
+// readClose reads frames until close frame and returns its code.
+func (c *tsWSClient) readClose(t *testing.T) int {
+	for {
+		op, payload, err := c.readFrame()
+		ar.NoError(t, err, "connection ended without close frame")
+		if op == wsOpClose {
+			ar.True(t, len(payload) >= 2, "close frame without code")
+			return int(binary.BigEndian.Uint16(payload))
+		}
+	}
+}
+
+// tsWSPipe creates server side of the connection talking to test client over in-memory pipe.
+func tsWSPipe() (*wsConn, *tsWSClient) {
+	server, client := net.Pipe()
+	return &wsConn{conn: server, br: bufio.NewReader(server)}, &tsWSClient{conn: client, br: bufio.NewReader(client)}
+}
+
+func Test_WebSocket_AcceptKey(t *testing.T) {
+	a.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", wsAcceptKey(tsWSKey), "accept key mismatch")
+}
+
+func Test_WebSocket_Upgrade_Failure(t *testing.T) {
+	valid := map[string]string{
+		"Connection":            "keep-alive, Upgrade",
+		"Upgrade":               "websocket",
+		"Sec-WebSocket-Version": "13",
+		"Sec-WebSocket-Key":     tsWSKey,
+	}
+
+	tests := map[string]struct {
+		method string
+		change map[string]string
+	}{
+		"not GET":           {method: http.MethodPost},
+		"no Connection":     {change: map[string]string{"Connection": ""}},
+		"no Upgrade":        {change: map[string]string{"Upgrade": ""}},
+		"other Upgrade":     {change: map[string]string{"Upgrade": "h2c"}},
+		"other version":     {change: map[string]string{"Sec-WebSocket-Version": "8"}},
+		"no key":            {change: map[string]string{"Sec-WebSocket-Key": ""}},
+		"key of wrong size": {change: map[string]string{"Sec-WebSocket-Key": "c2hvcnQ="}},
+	}
+
+	for sym, tc := range tests {
+		method := tc.method
+		if method == "" {
+			method = http.MethodGet
+		}
+		req, err := http.NewRequest(method, "/v1/messages/ws", nil)
+		ar.NoError(t, err)
+		for k, v := range valid {
+			req.Header.Set(k, v)
+		}
+		for k, v := range tc.change {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+
+		_, err = wsUpgrade(rr, req)
+
+		// THEN:
+		a.Equal(t, errWSHandshake, err, "[%s] mismatch on error", sym)
+		a.False(t, rr.Flushed, "[%s] response written", sym)
+	}
+}
+
+func Test_WebSocket_ReadMessage(t *testing.T) {
+	sc, cc := tsWSPipe()
+	defer sc.Close()
+
+	// GIVEN: client sends message in fragments, with ping in between
+	go func() {
+		cc.writeFrame(false, wsOpText, []byte("Hello, "))
+		cc.writeFrame(true, wsOpPing, []byte("p1"))
+		cc.writeFrame(true, wsOpContinuation, []byte("World"))
+		cc.writeFrame(true, wsOpBinary, []byte{0, 1, 2})
+		cc.writeFrame(true, wsOpClose, []byte{0x03, 0xE8})
+	}()
+	pong := make(chan []byte, 2)
+	go func() {
+		for {
+			op, payload, err := cc.readFrame()
+			if err != nil {
+				return
+			}
+			if op == wsOpPong || op == wsOpClose {
+				pong <- payload
+			}
+		}
+	}()
+
+	// THEN: message is reassembled
+	op, payload, err := sc.ReadMessage()
+	ar.NoError(t, err, "unexpected error on read")
+	a.Equal(t, wsOpText, op, "opcode mismatch")
+	a.Equal(t, "Hello, World", string(payload), "payload mismatch")
+
+	// AND: ping is answered
+	a.Equal(t, []byte("p1"), <-pong, "pong mismatch")
+
+	op, payload, err = sc.ReadMessage()
+	ar.NoError(t, err, "unexpected error on read")
+	a.Equal(t, wsOpBinary, op, "opcode mismatch")
+	a.Equal(t, []byte{0, 1, 2}, payload, "payload mismatch")
+
+	// AND: close is confirmed
+	_, _, err = sc.ReadMessage()
+	a.Equal(t, errWSClosed, err, "close not reported")
+	a.Equal(t, []byte{0x03, 0xE8}, <-pong, "close confirmation mismatch")
+	a.Error(t, sc.WriteFrame(wsOpText, []byte("late")), "frame written after close")
+}
+
+func Test_WebSocket_ReadMessage_Failure(t *testing.T) {
+	tests := map[string]struct {
+		raw  []byte
+		send func(cc *tsWSClient)
+		code int
+	}{
+		"not masked": {
+			raw:  []byte{0x81, 0x02, 'h', 'i'},
+			code: wsCloseProtocolError,
+		},
+		"reserved bits": {
+			send: func(cc *tsWSClient) { cc.writeFrame(true, 0x40|wsOpText, []byte("hi")) },
+			code: wsCloseProtocolError,
+		},
+		"unknown opcode": {
+			send: func(cc *tsWSClient) { cc.writeFrame(true, 0x3, []byte("hi")) },
+			code: wsCloseProtocolError,
+		},
+		"fragmented ping": {
+			send: func(cc *tsWSClient) { cc.writeFrame(false, wsOpPing, nil) },
+			code: wsCloseProtocolError,
+		},
+		"continuation of nothing": {
+			send: func(cc *tsWSClient) { cc.writeFrame(true, wsOpContinuation, []byte("hi")) },
+			code: wsCloseProtocolError,
+		},
+		"interleaved messages": {
+			send: func(cc *tsWSClient) {
+				cc.writeFrame(false, wsOpText, []byte("a"))
+				cc.writeFrame(true, wsOpText, []byte("b"))
+			},
+			code: wsCloseProtocolError,
+		},
+		"invalid UTF-8": {
+			send: func(cc *tsWSClient) { cc.writeFrame(true, wsOpText, []byte{0xff, 0xfe}) },
+			code: wsCloseInvalidData,
+		},
+		"too big frame": {
+			send: func(cc *tsWSClient) { cc.writeFrame(true, wsOpText, make([]byte, 200)) },
+			code: wsCloseTooBig,
+		},
+		"too big message": {
+			send: func(cc *tsWSClient) {
+				cc.writeFrame(false, wsOpText, make([]byte, 80))
+				cc.writeFrame(true, wsOpContinuation, make([]byte, 80))
+			},
+			code: wsCloseTooBig,
+		},
+	}
+
+	for sym, tc := range tests {
+		sc, cc := tsWSPipe()
+		sc.MaxMessage = 128
+		go func() {
+			if tc.raw != nil {
+				cc.conn.Write(tc.raw)
+				return
+			}
+			tc.send(cc)
+		}()
+
+		_, _, err := sc.ReadMessage()
+
+		// THEN:
+		pe, ok := err.(*wsProtocolError)
+		if a.True(t, ok, "[%s] protocol error expected, got: %v", sym, err) {
+			a.Equal(t, tc.code, pe.Code, "[%s] close code mismatch", sym)
+		}
+		sc.Close()
+		cc.conn.Close()
+	}
+}
+
+func Test_WebSocket_WriteFrame(t *testing.T) {
+	sc, cc := tsWSPipe()
+	defer sc.Close()
+
+	payloads := [][]byte{[]byte("short"), make([]byte, 300)}
+	go func() {
+		for _, p := range payloads {
+			sc.WriteFrame(wsOpText, p)
+		}
+		sc.WriteClose(wsCloseGoingAway, "bye")
+		sc.WriteClose(wsCloseNormal, "")
+	}()
+
+	// THEN:
+	for _, exp := range payloads {
+		op, payload, err := cc.readFrame()
+		ar.NoError(t, err, "unexpected error on read")
+		a.Equal(t, wsOpText, op, "opcode mismatch")
+		a.Equal(t, exp, payload, "payload mismatch")
+	}
+	op, payload, err := cc.readFrame()
+	ar.NoError(t, err, "unexpected error on read")
+	a.Equal(t, wsOpClose, op, "opcode mismatch")
+	a.Equal(t, append([]byte{0x03, 0xE9}, "bye"...), payload, "close payload mismatch")
+}